@@ -2,6 +2,9 @@ package auth
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/bloom42/stdx/db"
@@ -9,15 +12,82 @@ import (
 )
 
 type Account struct {
-	ID        uuid.UUID
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID        uuid.UUID `db:"id"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
 }
 
+// CreateAccount creates an account with the given ID, which is usually the ID of the user in the service.
+// If password is empty, the account has no password (e.g. for accounts using only single sign-on).
+// CreateAccount should be called within a transaction.
 func CreateAccount(ctx context.Context, db db.Queryer, accountID uuid.UUID, password string) (err error) {
-	panic("TODO")
+	var passwordHash string
+	if password != "" {
+		passwordHash, err = hashPassword(password)
+		if err != nil {
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	result, err := db.Exec(ctx, `INSERT INTO auth_accounts (id, created_at, updated_at) VALUES ($1, $2, $2)
+		ON CONFLICT (id) DO NOTHING`, accountID, now)
+	if err != nil {
+		err = fmt.Errorf("auth: creating account: %w", err)
+		return
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		err = fmt.Errorf("auth: creating account: %w", err)
+		return
+	}
+	if rowsAffected == 0 {
+		err = ErrAccountAlreadyExists
+		return
+	}
+
+	if passwordHash != "" {
+		err = savePasswordHash(ctx, db, accountID, passwordHash, now)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// GetAccount returns the account with the given ID, or ErrAccountNotFound
+func GetAccount(ctx context.Context, db db.Queryer, accountID uuid.UUID) (account Account, err error) {
+	err = db.Get(ctx, &account, "SELECT id, created_at, updated_at FROM auth_accounts WHERE id = $1", accountID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrAccountNotFound
+		} else {
+			err = fmt.Errorf("auth: getting account: %w", err)
+		}
+		return
+	}
+
+	return
 }
 
+// DeleteAccount deletes an account, along with its password, sessions and API keys
 func DeleteAccount(ctx context.Context, db db.Queryer, accountID uuid.UUID) (err error) {
-	panic("TODO")
+	result, err := db.Exec(ctx, "DELETE FROM auth_accounts WHERE id = $1", accountID)
+	if err != nil {
+		err = fmt.Errorf("auth: deleting account: %w", err)
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		err = fmt.Errorf("auth: deleting account: %w", err)
+		return
+	}
+	if rowsAffected == 0 {
+		err = ErrAccountNotFound
+		return
+	}
+
+	return
 }
@@ -1,16 +1,294 @@
 package auth
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/bloom42/stdx/db"
+	"github.com/bloom42/stdx/guid"
+	"github.com/bloom42/stdx/token"
 	"github.com/bloom42/stdx/uuid"
 )
 
+const (
+	ApiKeyTokenPrefix = "key_"
+
+	ApiKeyNameMaxLength = 128
+	ScopeMaxLength      = 128
+
+	// apiKeyLastUsedUpdateInterval throttles the updates of last_used_at
+	apiKeyLastUsedUpdateInterval = 5 * time.Minute
+)
+
+var (
+	ErrApiKeyNotFound       = errors.New("auth: API key not found")
+	ErrApiKeyExpired        = errors.New("auth: API key has expired")
+	ErrApiKeyNameIsNotValid = errors.New("auth: API key name is not valid")
+	ErrScopeIsNotValid      = errors.New("auth: scope is not valid")
+	ErrExpiresAtIsInThePast = errors.New("auth: expiry is in the past")
+)
+
 type ApiKey struct {
-	ID        uuid.UUID
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID        uuid.UUID `db:"id"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+
+	AccountID uuid.UUID `db:"account_id"`
+	Name      string    `db:"name"`
+	Scopes    Scopes    `db:"scopes"`
+	// ExpiresAt is nil if the key never expires
+	ExpiresAt *time.Time `db:"expires_at"`
+	// LastUsedAt is updated by VerifyApiKey at most every few minutes. It's nil if the key has never been used.
+	LastUsedAt *time.Time `db:"last_used_at"`
+}
+
+type apiKeyRow struct {
+	ApiKey
+	TokenHash []byte `db:"token_hash"`
+}
+
+const apiKeyColumns = "id, created_at, updated_at, account_id, name, token_hash, scopes, expires_at, last_used_at"
+
+// Scopes are the permissions granted to an API key, e.g. "projects:read".
+// They are stored as a JSON array.
+type Scopes []string
+
+// Contains returns true if scope is one of the scopes
+func (scopes Scopes) Contains(scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Scan implements sql.Scanner so Scopes can be read from databases transparently.
+func (scopes *Scopes) Scan(src interface{}) (err error) {
+	var data []byte
+
+	switch src := src.(type) {
+	case nil:
+		*scopes = Scopes{}
+		return
+	case []byte:
+		data = src
+	case string:
+		data = []byte(src)
+	default:
+		return fmt.Errorf("Scan: unable to scan type %T into Scopes", src)
+	}
+
+	var value []string
+	err = json.Unmarshal(data, &value)
+	if err != nil {
+		return fmt.Errorf("Scan: decoding scopes: %w", err)
+	}
+	if value == nil {
+		value = []string{}
+	}
+
+	*scopes = value
+	return
+}
+
+// Value implements sql.Valuer so that Scopes can be written to databases
+func (scopes Scopes) Value() (driver.Value, error) {
+	if scopes == nil {
+		return "[]", nil
+	}
+
+	data, err := json.Marshal([]string(scopes))
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+// HasScope returns true if the API key has been granted scope
+func (apiKey *ApiKey) HasScope(scope string) bool {
+	return apiKey.Scopes.Contains(scope)
+}
+
+// CreateApiKey creates an API key for an account. The returned token is the only way to use the key and can't
+// be retrieved later, as only its hash is stored.
+// If expiresAt is the zero time, the key never expires.
+func CreateApiKey(ctx context.Context, db db.Queryer, accountID uuid.UUID, name string, scopes []string, expiresAt time.Time) (apiKey ApiKey, apiKeyToken string, err error) {
+	name = strings.TrimSpace(name)
+	err = validateApiKeyName(name)
+	if err != nil {
+		return
+	}
+
+	scopes, err = normalizeScopes(scopes)
+	if err != nil {
+		return
+	}
+
+	now := time.Now().UTC()
+	if !expiresAt.IsZero() && !expiresAt.After(now) {
+		err = ErrExpiresAtIsInThePast
+		return
+	}
+
+	apiKeyID, err := uuid.NewRandom()
+	if err != nil {
+		err = fmt.Errorf("auth: generating API key ID: %w", err)
+		return
+	}
+
+	tokenOptions := token.Options{ID: guid.GUID(apiKeyID), Checksum: true}
+	if !expiresAt.IsZero() {
+		// the expiry is embedded in the token so expired keys are rejected without a database lookup
+		expiresAt = expiresAt.UTC().Truncate(time.Second)
+		tokenOptions.ExpiresAt = expiresAt
+	}
+	newToken, err := token.NewWithOptions(ApiKeyTokenPrefix, tokenOptions)
+	if err != nil {
+		err = fmt.Errorf("auth: generating API key token: %w", err)
+		return
+	}
+
+	apiKey = ApiKey{
+		ID:        apiKeyID,
+		CreatedAt: now,
+		UpdatedAt: now,
+		AccountID: accountID,
+		Name:      name,
+		Scopes:    scopes,
+	}
+	if !expiresAt.IsZero() {
+		apiKey.ExpiresAt = &expiresAt
+	}
+
+	_, err = db.Exec(ctx, `INSERT INTO auth_api_keys (`+apiKeyColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		apiKey.ID, apiKey.CreatedAt, apiKey.UpdatedAt, apiKey.AccountID, apiKey.Name, newToken.Hash(),
+		apiKey.Scopes, apiKey.ExpiresAt, apiKey.LastUsedAt,
+	)
+	if err != nil {
+		err = fmt.Errorf("auth: creating API key: %w", err)
+		return
+	}
+
+	apiKeyToken = newToken.String()
+	return
+}
+
+// VerifyApiKey verifies an API key token and returns the corresponding API key. Use ApiKey.HasScope to check
+// its permissions.
+func VerifyApiKey(ctx context.Context, db db.Queryer, apiKeyToken string) (apiKey ApiKey, err error) {
+	parsedToken, err := token.Parse(ApiKeyTokenPrefix, apiKeyToken)
+	if err != nil {
+		if errors.Is(err, token.ErrTokenExpired) {
+			err = ErrApiKeyExpired
+		} else {
+			err = ErrApiKeyNotFound
+		}
+		return
+	}
+
+	var row apiKeyRow
+	err = db.Get(ctx, &row, "SELECT "+apiKeyColumns+" FROM auth_api_keys WHERE id = $1", uuid.UUID(parsedToken.ID()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrApiKeyNotFound
+		} else {
+			err = fmt.Errorf("auth: getting API key: %w", err)
+		}
+		return
+	}
+
+	if parsedToken.Verify(row.TokenHash) != nil {
+		err = ErrApiKeyNotFound
+		return
+	}
+
+	now := time.Now().UTC()
+	if row.ExpiresAt != nil && !now.Before(*row.ExpiresAt) {
+		err = ErrApiKeyExpired
+		return
+	}
+
+	apiKey = row.ApiKey
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= apiKeyLastUsedUpdateInterval {
+		apiKey.LastUsedAt = &now
+		_, err = db.Exec(ctx, "UPDATE auth_api_keys SET last_used_at = $1 WHERE id = $2", now, apiKey.ID)
+		if err != nil {
+			err = fmt.Errorf("auth: updating API key last used at: %w", err)
+			return
+		}
+	}
+
+	return
+}
+
+// GetApiKeysForAccount returns the API keys of an account, including the expired ones, most recent first
+func GetApiKeysForAccount(ctx context.Context, db db.Queryer, accountID uuid.UUID) (apiKeys []ApiKey, err error) {
+	rows := make([]apiKeyRow, 0)
+	err = db.Select(ctx, &rows, "SELECT "+apiKeyColumns+" FROM auth_api_keys WHERE account_id = $1 ORDER BY created_at DESC",
+		accountID)
+	if err != nil {
+		err = fmt.Errorf("auth: getting API keys: %w", err)
+		return
+	}
+
+	apiKeys = make([]ApiKey, len(rows))
+	for i, row := range rows {
+		apiKeys[i] = row.ApiKey
+	}
+	return
+}
+
+// RevokeApiKey deletes an API key of an account. Its token can no longer be used.
+func RevokeApiKey(ctx context.Context, db db.Queryer, accountID, apiKeyID uuid.UUID) (err error) {
+	result, err := db.Exec(ctx, "DELETE FROM auth_api_keys WHERE id = $1 AND account_id = $2", apiKeyID, accountID)
+	if err != nil {
+		err = fmt.Errorf("auth: deleting API key: %w", err)
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		err = fmt.Errorf("auth: deleting API key: %w", err)
+		return
+	}
+	if rowsAffected == 0 {
+		err = ErrApiKeyNotFound
+		return
+	}
+
+	return
+}
+
+func validateApiKeyName(name string) error {
+	if name == "" || utf8.RuneCountInString(name) > ApiKeyNameMaxLength || !utf8.ValidString(name) {
+		return ErrApiKeyNameIsNotValid
+	}
+	return nil
+}
+
+// normalizeScopes validates scopes and removes duplicates
+func normalizeScopes(scopes []string) (normalized Scopes, err error) {
+	normalized = make(Scopes, 0, len(scopes))
+	for _, scope := range scopes {
+		if scope == "" || len(scope) > ScopeMaxLength || strings.IndexFunc(scope, unicode.IsSpace) != -1 {
+			err = ErrScopeIsNotValid
+			return
+		}
+		if !normalized.Contains(scope) {
+			normalized = append(normalized, scope)
+		}
+	}
 
-	Name string
-	// ExpiresAt *time.Time
+	return
 }
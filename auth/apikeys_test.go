@@ -0,0 +1,216 @@
+package auth_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bloom42/stdx/auth"
+	"github.com/bloom42/stdx/guid"
+	"github.com/bloom42/stdx/token"
+	"github.com/bloom42/stdx/uuid"
+)
+
+func TestScopesSQL(t *testing.T) {
+	scopes := auth.Scopes{"projects:read", "projects:write"}
+
+	value, err := scopes.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var scanned auth.Scopes
+	err = scanned.Scan([]byte(value.(string)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scanned) != 2 || !scanned.Contains("projects:read") || !scanned.Contains("projects:write") {
+		t.Errorf("scanned scopes. expected: %v | got: %v", scopes, scanned)
+	}
+
+	value, err = auth.Scopes(nil).Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.(string) != "[]" {
+		t.Errorf("nil scopes value. expected: [] | got: %v", value)
+	}
+
+	err = scanned.Scan(int64(1))
+	if err == nil {
+		t.Error("scanning an int64 should fail")
+	}
+}
+
+func TestCreateApiKeyValidation(t *testing.T) {
+	ctx := context.Background()
+	accountID := uuid.New()
+
+	// inputs are validated before querying the database
+	tests := []struct {
+		name      string
+		scopes    []string
+		expiresAt time.Time
+		expected  error
+	}{
+		{"", nil, time.Time{}, auth.ErrApiKeyNameIsNotValid},
+		{"   ", nil, time.Time{}, auth.ErrApiKeyNameIsNotValid},
+		{strings.Repeat("a", auth.ApiKeyNameMaxLength+1), nil, time.Time{}, auth.ErrApiKeyNameIsNotValid},
+		{"CI", []string{""}, time.Time{}, auth.ErrScopeIsNotValid},
+		{"CI", []string{"projects read"}, time.Time{}, auth.ErrScopeIsNotValid},
+		{"CI", []string{"projects:read"}, time.Now().Add(-time.Hour), auth.ErrExpiresAtIsInThePast},
+	}
+
+	for _, test := range tests {
+		_, _, err := auth.CreateApiKey(ctx, nil, accountID, test.name, test.scopes, test.expiresAt)
+		if err != test.expected {
+			t.Errorf("CreateApiKey(%q, %v). expected: %v | got: %v", test.name, test.scopes, test.expected, err)
+		}
+	}
+}
+
+func TestVerifyApiKeyWithoutDatabase(t *testing.T) {
+	ctx := context.Background()
+
+	// expired keys and tokens which are not API keys are rejected before querying the database
+	expiredToken, err := token.NewWithOptions(auth.ApiKeyTokenPrefix, token.Options{
+		Checksum:  true,
+		ExpiresAt: time.Now().Add(-time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = auth.VerifyApiKey(ctx, nil, expiredToken.String())
+	if err != auth.ErrApiKeyExpired {
+		t.Errorf("expired key. expected: %v | got: %v", auth.ErrApiKeyExpired, err)
+	}
+
+	sessionToken, err := token.New(auth.SessionTokenPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = auth.VerifyApiKey(ctx, nil, sessionToken.String())
+	if err != auth.ErrApiKeyNotFound {
+		t.Errorf("session token. expected: %v | got: %v", auth.ErrApiKeyNotFound, err)
+	}
+}
+
+func TestApiKeysWithDatabase(t *testing.T) {
+	database := newTestDatabase(t)
+	ctx := context.Background()
+	accountID := uuid.New()
+
+	err := auth.CreateAccount(ctx, database, accountID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apiKey, apiKeyToken, err := auth.CreateApiKey(ctx, database, accountID, " CI ", []string{"projects:write", "projects:read"}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if apiKey.Name != "CI" || apiKey.ExpiresAt != nil || apiKey.LastUsedAt != nil {
+		t.Errorf("created API key is not valid: %+v", apiKey)
+	}
+
+	verifiedApiKey, err := auth.VerifyApiKey(ctx, database, apiKeyToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verifiedApiKey.ID != apiKey.ID || verifiedApiKey.AccountID != accountID || verifiedApiKey.Name != "CI" ||
+		!verifiedApiKey.HasScope("projects:read") || !verifiedApiKey.HasScope("projects:write") || verifiedApiKey.HasScope("billing:read") {
+		t.Errorf("verified API key. expected: %+v | got: %+v", apiKey, verifiedApiKey)
+	}
+
+	// a token with the ID of the key but another secret is rejected
+	otherToken, err := token.NewWithOptions(auth.ApiKeyTokenPrefix, token.Options{ID: guid.GUID(apiKey.ID), Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = auth.VerifyApiKey(ctx, database, otherToken.String())
+	if err != auth.ErrApiKeyNotFound {
+		t.Errorf("other secret. expected: %v | got: %v", auth.ErrApiKeyNotFound, err)
+	}
+
+	// last_used_at is only updated every few minutes
+	getLastUsedAt := func() (lastUsedAt time.Time) {
+		t.Helper()
+
+		err := database.Get(ctx, &lastUsedAt, "SELECT last_used_at FROM auth_api_keys WHERE id = $1", apiKey.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	firstLastUsedAt := getLastUsedAt()
+	if verifiedApiKey.LastUsedAt == nil || time.Since(firstLastUsedAt) > time.Minute {
+		t.Errorf("last used at is not set: %v", firstLastUsedAt)
+	}
+	_, err = auth.VerifyApiKey(ctx, database, apiKeyToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastUsedAt := getLastUsedAt(); !lastUsedAt.Equal(firstLastUsedAt) {
+		t.Errorf("last used at has been updated again. expected: %v | got: %v", firstLastUsedAt, lastUsedAt)
+	}
+
+	_, err = database.Exec(ctx, "UPDATE auth_api_keys SET last_used_at = $1 WHERE id = $2", time.Now().UTC().Add(-time.Hour), apiKey.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = auth.VerifyApiKey(ctx, database, apiKeyToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastUsedAt := getLastUsedAt(); time.Since(lastUsedAt) > time.Minute {
+		t.Errorf("last used at has not been updated: %v", lastUsedAt)
+	}
+
+	// the expiry stored in the database is checked, in addition to the one embedded in the token
+	expiringApiKey, expiringApiKeyToken, err := auth.CreateApiKey(ctx, database, accountID, "deploy", nil, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expiringApiKey.ExpiresAt == nil {
+		t.Fatal("expires at is not set")
+	}
+	_, err = auth.VerifyApiKey(ctx, database, expiringApiKeyToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = database.Exec(ctx, "UPDATE auth_api_keys SET expires_at = $1 WHERE id = $2", time.Now().UTC().Add(-time.Minute), expiringApiKey.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = auth.VerifyApiKey(ctx, database, expiringApiKeyToken)
+	if err != auth.ErrApiKeyExpired {
+		t.Errorf("expired in the database. expected: %v | got: %v", auth.ErrApiKeyExpired, err)
+	}
+
+	apiKeys, err := auth.GetApiKeysForAccount(ctx, database, accountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apiKeys) != 2 || apiKeys[0].ID != expiringApiKey.ID || apiKeys[1].ID != apiKey.ID {
+		t.Errorf("API keys of the account. expected: [%s %s] | got: %+v", expiringApiKey.ID, apiKey.ID, apiKeys)
+	}
+
+	// keys can only be revoked by their account
+	err = auth.RevokeApiKey(ctx, database, uuid.New(), apiKey.ID)
+	if err != auth.ErrApiKeyNotFound {
+		t.Errorf("revoking the key of another account. expected: %v | got: %v", auth.ErrApiKeyNotFound, err)
+	}
+	err = auth.RevokeApiKey(ctx, database, accountID, apiKey.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = auth.VerifyApiKey(ctx, database, apiKeyToken)
+	if err != auth.ErrApiKeyNotFound {
+		t.Errorf("revoked key. expected: %v | got: %v", auth.ErrApiKeyNotFound, err)
+	}
+	err = auth.RevokeApiKey(ctx, database, accountID, apiKey.ID)
+	if err != auth.ErrApiKeyNotFound {
+		t.Errorf("revoking twice. expected: %v | got: %v", auth.ErrApiKeyNotFound, err)
+	}
+}
@@ -0,0 +1,25 @@
+// Package auth implements accounts, passwords, sessions, API keys, two-factor authentication (TOTP) and
+// WebAuthn credentials stored in PostgreSQL, an HTTP middleware to authenticate requests, protections
+// against brute-force attacks, and the links sent by email for passwordless logins, email verifications and
+// password resets. Logins with OpenID Connect providers are implemented by the auth/oidc package, and logins
+// of CLIs with the OAuth 2.0 device authorization grant by the auth/device package.
+//
+// The tables used by the package are created by the migrations returned by Migrations, which should be
+// applied with the migrate package, along with the migrations of the service.
+//
+// Functions take a db.Queryer so they can be called either with a db.DB or within a db.Tx.
+// Functions which run several queries (e.g. ChangePassword) should be called within a transaction.
+package auth
+
+import (
+	"errors"
+)
+
+var (
+	ErrAccountNotFound      = errors.New("auth: account not found")
+	ErrAccountAlreadyExists = errors.New("auth: account already exists")
+	ErrPasswordIsNotValid   = errors.New("auth: password is not valid")
+	ErrPasswordIsTooShort   = errors.New("auth: password is too short")
+	ErrPasswordIsTooLong    = errors.New("auth: password is too long")
+	ErrAccountHasNoPassword = errors.New("auth: account has no password")
+)
@@ -0,0 +1,200 @@
+package auth_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/bloom42/stdx/auth"
+	"github.com/bloom42/stdx/crypto"
+	"github.com/bloom42/stdx/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMigrations(t *testing.T) {
+	firstID := int64(1000)
+	migrations := auth.Migrations(firstID)
+
+	if len(migrations) == 0 || len(migrations) > auth.MigrationsIDRange {
+		t.Fatalf("number of migrations. expected: between 1 and %d | got: %d", auth.MigrationsIDRange, len(migrations))
+	}
+
+	for i, migration := range migrations {
+		if migration.ID != firstID+int64(i) {
+			t.Errorf("migration #%d ID. expected: %d | got: %d", i, firstID+int64(i), migration.ID)
+		}
+		if migration.Up == nil || migration.Down == nil {
+			t.Errorf("migration %d: Up and Down must be set", migration.ID)
+		}
+	}
+}
+
+func TestPasswordValidation(t *testing.T) {
+	ctx := context.Background()
+	accountID := uuid.New()
+
+	// passwords are validated before querying the database
+	err := auth.CreateAccount(ctx, nil, accountID, "short")
+	if err != auth.ErrPasswordIsTooShort {
+		t.Errorf("expected: %v | got: %v", auth.ErrPasswordIsTooShort, err)
+	}
+
+	err = auth.CreateAccount(ctx, nil, accountID, strings.Repeat("a", auth.PasswordMaxLength+1))
+	if err != auth.ErrPasswordIsTooLong {
+		t.Errorf("expected: %v | got: %v", auth.ErrPasswordIsTooLong, err)
+	}
+
+	err = auth.ChangePassword(ctx, nil, accountID, uuid.Nil, "current password", "short")
+	if err != auth.ErrPasswordIsTooShort {
+		t.Errorf("expected: %v | got: %v", auth.ErrPasswordIsTooShort, err)
+	}
+
+	err = auth.VerifyPassword(ctx, nil, accountID, strings.Repeat("a", auth.PasswordMaxLength+1))
+	if err != auth.ErrPasswordIsNotValid {
+		t.Errorf("expected: %v | got: %v", auth.ErrPasswordIsNotValid, err)
+	}
+}
+
+func TestAccountsAndPasswords(t *testing.T) {
+	database := newTestDatabase(t)
+	ctx := context.Background()
+	accountID := uuid.New()
+	password := "correct horse battery staple"
+
+	err := auth.CreateAccount(ctx, database, accountID, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = auth.CreateAccount(ctx, database, accountID, password)
+	if err != auth.ErrAccountAlreadyExists {
+		t.Errorf("creating existing account. expected: %v | got: %v", auth.ErrAccountAlreadyExists, err)
+	}
+
+	err = auth.VerifyPassword(ctx, database, accountID, password)
+	if err != nil {
+		t.Errorf("verifying password: %v", err)
+	}
+	err = auth.VerifyPassword(ctx, database, accountID, "wrong password")
+	if err != auth.ErrPasswordIsNotValid {
+		t.Errorf("verifying wrong password. expected: %v | got: %v", auth.ErrPasswordIsNotValid, err)
+	}
+	err = auth.VerifyPassword(ctx, database, uuid.New(), password)
+	if err != auth.ErrPasswordIsNotValid {
+		t.Errorf("verifying password of missing account. expected: %v | got: %v", auth.ErrPasswordIsNotValid, err)
+	}
+
+	err = auth.ChangePassword(ctx, database, accountID, uuid.Nil, "wrong password", "new password")
+	if err != auth.ErrPasswordIsNotValid {
+		t.Errorf("changing password with wrong current password. expected: %v | got: %v", auth.ErrPasswordIsNotValid, err)
+	}
+
+	currentSession, _, err := auth.CreateSession(ctx, database, accountID, auth.SessionOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherSessionTokens, err := auth.CreateSession(ctx, database, accountID, auth.SessionOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = auth.ChangePassword(ctx, database, accountID, currentSession.ID, password, "new password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = auth.VerifyPassword(ctx, database, accountID, password)
+	if err != auth.ErrPasswordIsNotValid {
+		t.Errorf("verifying previous password. expected: %v | got: %v", auth.ErrPasswordIsNotValid, err)
+	}
+	err = auth.VerifyPassword(ctx, database, accountID, "new password")
+	if err != nil {
+		t.Errorf("verifying new password: %v", err)
+	}
+
+	// the other sessions are revoked when the password is changed
+	sessions, err := auth.GetSessionsForAccount(ctx, database, accountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != currentSession.ID {
+		t.Errorf("sessions after password change. expected: only %s | got: %v", currentSession.ID, sessions)
+	}
+	_, err = auth.VerifySession(ctx, database, otherSessionTokens.Token)
+	if err != auth.ErrSessionNotFound {
+		t.Errorf("revoked session. expected: %v | got: %v", auth.ErrSessionNotFound, err)
+	}
+
+	err = auth.DeleteAccount(ctx, database, accountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = auth.GetAccount(ctx, database, accountID)
+	if err != auth.ErrAccountNotFound {
+		t.Errorf("getting deleted account. expected: %v | got: %v", auth.ErrAccountNotFound, err)
+	}
+	sessions, err = auth.GetSessionsForAccount(ctx, database, accountID)
+	if err != nil || len(sessions) != 0 {
+		t.Errorf("sessions of deleted account. expected: none | got: %v (%v)", sessions, err)
+	}
+	err = auth.DeleteAccount(ctx, database, accountID)
+	if err != auth.ErrAccountNotFound {
+		t.Errorf("deleting missing account. expected: %v | got: %v", auth.ErrAccountNotFound, err)
+	}
+}
+
+func TestLegacyPasswordHashes(t *testing.T) {
+	database := newTestDatabase(t)
+	ctx := context.Background()
+	accountID := uuid.New()
+	password := "imported password"
+
+	err := auth.CreateAccount(ctx, database, accountID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = database.Exec(ctx, "INSERT INTO auth_passwords (account_id, created_at, updated_at, hash) VALUES ($1, NOW(), NOW(), $2)",
+		accountID, string(bcryptHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = auth.VerifyPassword(ctx, database, accountID, password)
+	if err != auth.ErrPasswordIsNotValid {
+		t.Errorf("legacy hash without verifier. expected: %v | got: %v", auth.ErrPasswordIsNotValid, err)
+	}
+
+	// accounts with imported hashes can change their password
+	err = auth.ChangePassword(ctx, database, accountID, uuid.Nil, password, "new password", crypto.BcryptVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = auth.VerifyPassword(ctx, database, accountID, "new password")
+	if err != nil {
+		t.Errorf("verifying new password: %v", err)
+	}
+
+	// legacy hashes are upgraded to argon2id once verified
+	_, err = database.Exec(ctx, "UPDATE auth_passwords SET hash = $1 WHERE account_id = $2", string(bcryptHash), accountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = auth.VerifyPassword(ctx, database, accountID, password, crypto.BcryptVerifier)
+	if err != nil {
+		t.Fatalf("verifying legacy hash: %v", err)
+	}
+	var passwordHash string
+	err = database.Get(ctx, &passwordHash, "SELECT hash FROM auth_passwords WHERE account_id = $1", accountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(passwordHash, "$argon2id$") {
+		t.Errorf("legacy hash has not been upgraded: %s", passwordHash)
+	}
+	err = auth.VerifyPassword(ctx, database, accountID, password)
+	if err != nil {
+		t.Errorf("verifying upgraded hash: %v", err)
+	}
+}
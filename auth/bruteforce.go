@@ -0,0 +1,684 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bloom42/stdx/concurrentmap"
+	"github.com/bloom42/stdx/db"
+	"github.com/bloom42/stdx/httpx"
+	"github.com/bloom42/stdx/httpx/middlewarex"
+	"github.com/bloom42/stdx/log/slogx"
+	"github.com/bloom42/stdx/uuid"
+)
+
+const (
+	DefaultAttemptsWindow  = time.Hour
+	DefaultBaseDelay       = time.Second
+	DefaultMaxDelay        = 5 * time.Minute
+	DefaultLockoutDuration = 15 * time.Minute
+
+	// CaptchaResponseHeader is the header containing the CAPTCHA response checked by AttemptTracker.Middleware
+	CaptchaResponseHeader = "Captcha-Response"
+
+	ipv4SubnetPrefixLength = 24
+	ipv6SubnetPrefixLength = 64
+	// maxBackoffShift prevents the exponential delay from overflowing
+	maxBackoffShift = 30
+)
+
+var (
+	ErrTooManyAttempts   = errors.New("auth: too many attempts")
+	ErrCaptchaIsRequired = errors.New("auth: CAPTCHA is required")
+)
+
+var (
+	DefaultAccountLimits = AttemptLimits{FreeAttempts: 3, CaptchaAfter: 5, LockoutAfter: 10}
+	// IPs and subnets are shared by many users (e.g. NAT), so their limits are higher
+	DefaultIPLimits     = AttemptLimits{FreeAttempts: 5, CaptchaAfter: 10, LockoutAfter: 50}
+	DefaultSubnetLimits = AttemptLimits{FreeAttempts: 20, CaptchaAfter: 50, LockoutAfter: 500}
+)
+
+// AttemptStore stores the failed attempts tracked by an AttemptTracker
+type AttemptStore interface {
+	// GetAttempts returns the attempts of keys whose last failure happened after since.
+	// Keys without attempts are not present in the returned map.
+	GetAttempts(ctx context.Context, keys []string, since time.Time) (map[string]Attempts, error)
+	// RecordFailure increments the failures of keys. The failures whose last failure is older than window
+	// are reset.
+	RecordFailure(ctx context.Context, keys []string, now time.Time, window time.Duration) error
+	// ReserveAttempt increments the failures of keys before an attempt is verified, without updating their
+	// last failure, and returns the attempts of keys including the reserved one. The failures whose last
+	// failure is older than window are reset, in which case the last failure is set to now.
+	// The increment and the returned attempts must be atomic for each key (e.g. a single statement), so
+	// concurrent attempts see each other's reservations.
+	ReserveAttempt(ctx context.Context, keys []string, now time.Time, window time.Duration) (map[string]Attempts, error)
+	// RecordReservedFailure sets the last failure of keys, whose failures have been incremented by ReserveAttempt,
+	// to now.
+	RecordReservedFailure(ctx context.Context, keys []string, now time.Time) error
+	// CancelAttempt decrements the failures of keys incremented by ReserveAttempt, e.g. when the attempt succeeds.
+	CancelAttempt(ctx context.Context, keys []string) error
+	ResetAttempts(ctx context.Context, keys []string) error
+	// DeleteExpiredAttempts deletes the attempts whose last failure happened before before, and returns the
+	// number of deleted keys. It should be called periodically.
+	DeleteExpiredAttempts(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Attempts are the failed attempts of a key
+type Attempts struct {
+	Failures      int64     `db:"failures"`
+	LastFailureAt time.Time `db:"last_failure_at"`
+}
+
+// AttemptLimits are the limits applied to a kind of key. A limit of 0 is disabled.
+type AttemptLimits struct {
+	// FreeAttempts is the number of failures allowed before attempts are delayed with an exponential backoff
+	FreeAttempts int64
+	// CaptchaAfter is the number of failures after which a CAPTCHA is required
+	CaptchaAfter int64
+	// LockoutAfter is the number of failures after which the key is locked for LockoutDuration
+	LockoutAfter int64
+}
+
+// AttemptStatus is the result of AttemptTracker.Check
+type AttemptStatus struct {
+	// RetryAfter is the duration to wait before the next attempt is allowed
+	RetryAfter time.Duration
+	// CaptchaRequired is true if the next attempt requires to solve a CAPTCHA
+	CaptchaRequired bool
+}
+
+type AttemptTrackerConfig struct {
+	// default: NewMemoryAttemptStore()
+	Store AttemptStore
+	// Window is the duration after which failures are forgotten. It should be longer than LockoutDuration.
+	// default: DefaultAttemptsWindow
+	Window time.Duration
+	// BaseDelay is the delay after FreeAttempts failures, doubled for each additional failure.
+	// default: DefaultBaseDelay
+	BaseDelay time.Duration
+	// default: DefaultMaxDelay
+	MaxDelay time.Duration
+	// default: DefaultLockoutDuration
+	LockoutDuration time.Duration
+	// default: DefaultAccountLimits
+	AccountLimits AttemptLimits
+	// default: DefaultIPLimits
+	IPLimits AttemptLimits
+	// default: DefaultSubnetLimits
+	SubnetLimits AttemptLimits
+	// CaptchaVerifier verifies the CAPTCHAs once they are required. If nil, CAPTCHAs are never enforced
+	// and AttemptStatus.CaptchaRequired is only a signal.
+	CaptchaVerifier CaptchaVerifier
+}
+
+// AttemptTracker protects password and second factor verifications against brute-force and credential
+// stuffing attacks, by tracking the failed attempts of each account, IP address and IP subnet (/24 for IPv4
+// and /64 for IPv6), and applying an exponential backoff, temporary lockouts and CAPTCHAs.
+type AttemptTracker struct {
+	config AttemptTrackerConfig
+}
+
+// MemoryAttemptStore is an AttemptStore for single-instance deployments
+type MemoryAttemptStore struct {
+	attempts concurrentmap.ConcurrentMap[string, Attempts]
+}
+
+// PostgresAttemptStore is an AttemptStore shared by all the instances of a service, using the
+// auth_failed_attempts table
+type PostgresAttemptStore struct {
+	db db.Queryer
+}
+
+func NewAttemptTracker(config AttemptTrackerConfig) *AttemptTracker {
+	if config.Store == nil {
+		config.Store = NewMemoryAttemptStore()
+	}
+	if config.Window == 0 {
+		config.Window = DefaultAttemptsWindow
+	}
+	if config.BaseDelay == 0 {
+		config.BaseDelay = DefaultBaseDelay
+	}
+	if config.MaxDelay == 0 {
+		config.MaxDelay = DefaultMaxDelay
+	}
+	if config.LockoutDuration == 0 {
+		config.LockoutDuration = DefaultLockoutDuration
+	}
+	if config.AccountLimits == (AttemptLimits{}) {
+		config.AccountLimits = DefaultAccountLimits
+	}
+	if config.IPLimits == (AttemptLimits{}) {
+		config.IPLimits = DefaultIPLimits
+	}
+	if config.SubnetLimits == (AttemptLimits{}) {
+		config.SubnetLimits = DefaultSubnetLimits
+	}
+
+	return &AttemptTracker{config: config}
+}
+
+// Check returns the status of the next attempt for an account and an IP address, and ErrTooManyAttempts if the
+// attempt is not allowed yet. accountID can be uuid.Nil, e.g. when the account is not known yet, and ip can
+// be the zero netip.Addr.
+func (tracker *AttemptTracker) Check(ctx context.Context, accountID uuid.UUID, ip netip.Addr) (status AttemptStatus, err error) {
+	keys := attemptKeys(accountID, ip)
+	if len(keys) == 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+	attempts, err := tracker.config.Store.GetAttempts(ctx, keyNames(keys), now.Add(-tracker.config.Window))
+	if err != nil {
+		return
+	}
+
+	status = tracker.keysStatus(keys, attempts, now)
+	if status.RetryAfter > 0 {
+		err = ErrTooManyAttempts
+		return
+	}
+
+	return
+}
+
+// RecordFailure records a failed attempt for an account and an IP address
+func (tracker *AttemptTracker) RecordFailure(ctx context.Context, accountID uuid.UUID, ip netip.Addr) error {
+	keys := attemptKeys(accountID, ip)
+	if len(keys) == 0 {
+		return nil
+	}
+
+	return tracker.config.Store.RecordFailure(ctx, keyNames(keys), time.Now().UTC(), tracker.config.Window)
+}
+
+// RecordSuccess resets the failed attempts of an account.
+// The attempts of IP addresses are not reset, otherwise an attacker could reset them by logging in to their own
+// account.
+func (tracker *AttemptTracker) RecordSuccess(ctx context.Context, accountID uuid.UUID) error {
+	if accountID == uuid.Nil {
+		return nil
+	}
+
+	return tracker.config.Store.ResetAttempts(ctx, []string{accountAttemptKey(accountID)})
+}
+
+// Protect runs verify, e.g. a closure calling VerifyLogin, if the attempt is allowed, and records its result.
+// captchaResponse is verified with the CaptchaVerifier if a CAPTCHA is required, in which case
+// ErrCaptchaIsRequired is returned if it is empty.
+//
+//	err = tracker.Protect(ctx, accountID, ip, input.CaptchaResponse, func() error {
+//		return auth.VerifyLogin(ctx, tx, totpKey, accountID, input.Password, input.Code)
+//	})
+func (tracker *AttemptTracker) Protect(ctx context.Context, accountID uuid.UUID, ip netip.Addr, captchaResponse string, verify func() error) (status AttemptStatus, err error) {
+	keys := attemptKeys(accountID, ip)
+
+	status, err = tracker.reserveAttempt(ctx, keys)
+	if err != nil {
+		return
+	}
+
+	err = tracker.verifyCaptcha(ctx, status, captchaResponse, ip)
+	if err == nil {
+		err = verify()
+	}
+
+	failed := err != nil && isFailedAttempt(err)
+	completeErr := tracker.completeAttempt(ctx, keys, failed)
+	if completeErr != nil && (err == nil || failed) {
+		err = completeErr
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	err = tracker.RecordSuccess(ctx, accountID)
+	return
+}
+
+// Middleware applies the limits of the client IP address to the requests, e.g. for login endpoints, and
+// records the requests answered with a 401 status code as failed attempts.
+// Throttled requests are rejected with a 429 error, and the requests missing a required CAPTCHA response in
+// the CaptchaResponseHeader header are rejected with a 403 error.
+// clientIP returns the IP address of the client. If nil, middlewarex.ClientIP is used.
+func (tracker *AttemptTracker) Middleware(clientIP func(req *http.Request) netip.Addr) func(next http.Handler) http.Handler {
+	if clientIP == nil {
+		clientIP = middlewarex.ClientIP
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, req *http.Request) {
+			ctx := req.Context()
+			ip := clientIP(req)
+			keys := attemptKeys(uuid.Nil, ip)
+
+			status, err := tracker.reserveAttempt(ctx, keys)
+			if err == nil {
+				err = tracker.verifyCaptcha(ctx, status, req.Header.Get(CaptchaResponseHeader), ip)
+				if err != nil {
+					cancelErr := tracker.completeAttempt(ctx, keys, false)
+					if cancelErr != nil {
+						slogx.FromCtx(ctx).Error("auth: cancelling attempt", slogx.Err(cancelErr))
+					}
+				}
+			}
+			if err != nil {
+				switch {
+				case errors.Is(err, ErrTooManyAttempts):
+					w.Header().Set(httpx.HeaderRetryAfter, strconv.FormatInt(int64((status.RetryAfter+time.Second-1)/time.Second), 10))
+					writeErrorResponse(w, http.StatusTooManyRequests, "too_many_attempts", "Too many attempts. Please retry later")
+				case errors.Is(err, ErrCaptchaIsRequired):
+					writeErrorResponse(w, http.StatusForbidden, "captcha_required", "Please solve the CAPTCHA")
+				case errors.Is(err, ErrCaptchaIsNotValid):
+					writeErrorResponse(w, http.StatusForbidden, "captcha_is_not_valid", "CAPTCHA is not valid")
+				default:
+					slogx.FromCtx(ctx).Error("auth: checking attempts", slogx.Err(err))
+					writeErrorResponse(w, http.StatusInternalServerError, "internal", "Internal error")
+				}
+				return
+			}
+
+			recorder := middlewarex.NewResponseRecorder(w)
+			next.ServeHTTP(recorder, req)
+
+			err = tracker.completeAttempt(ctx, keys, recorder.StatusCode() == http.StatusUnauthorized)
+			if err != nil {
+				slogx.FromCtx(ctx).Error("auth: recording attempt", slogx.Err(err))
+			}
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// reserveAttempt counts an attempt as failed before it's verified, so concurrent attempts can't all be verified
+// before their failures are recorded, and returns the status of the attempt, computed from the attempts which
+// precede it. The reservation is cancelled and ErrTooManyAttempts is returned if the attempt is not allowed yet.
+// Otherwise, the reservation must be completed with completeAttempt.
+func (tracker *AttemptTracker) reserveAttempt(ctx context.Context, keys []attemptKey) (status AttemptStatus, err error) {
+	if len(keys) == 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+	attempts, err := tracker.config.Store.ReserveAttempt(ctx, keyNames(keys), now, tracker.config.Window)
+	if err != nil {
+		return
+	}
+
+	for key, keyAttempts := range attempts {
+		keyAttempts.Failures -= 1
+		attempts[key] = keyAttempts
+	}
+
+	status = tracker.keysStatus(keys, attempts, now)
+	if status.RetryAfter > 0 {
+		err = tracker.config.Store.CancelAttempt(ctx, keyNames(keys))
+		if err != nil {
+			return
+		}
+		err = ErrTooManyAttempts
+		return
+	}
+
+	return
+}
+
+// completeAttempt records the failure of an attempt reserved with reserveAttempt, or cancels the reservation
+// if the attempt has not failed.
+func (tracker *AttemptTracker) completeAttempt(ctx context.Context, keys []attemptKey, failed bool) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	if failed {
+		return tracker.config.Store.RecordReservedFailure(ctx, keyNames(keys), time.Now().UTC())
+	}
+
+	return tracker.config.Store.CancelAttempt(ctx, keyNames(keys))
+}
+
+// keysStatus returns the most restrictive status of keys
+func (tracker *AttemptTracker) keysStatus(keys []attemptKey, attempts map[string]Attempts, now time.Time) (status AttemptStatus) {
+	for _, key := range keys {
+		keyAttempts, exists := attempts[key.name]
+		if !exists {
+			continue
+		}
+
+		keyStatus := tracker.status(keyAttempts, tracker.limits(key.kind), now)
+		status.CaptchaRequired = status.CaptchaRequired || keyStatus.CaptchaRequired
+		if keyStatus.RetryAfter > status.RetryAfter {
+			status.RetryAfter = keyStatus.RetryAfter
+		}
+	}
+
+	return
+}
+
+func (tracker *AttemptTracker) verifyCaptcha(ctx context.Context, status AttemptStatus, captchaResponse string, ip netip.Addr) (err error) {
+	if !status.CaptchaRequired || tracker.config.CaptchaVerifier == nil {
+		return
+	}
+
+	if captchaResponse == "" {
+		err = ErrCaptchaIsRequired
+		return
+	}
+
+	return tracker.config.CaptchaVerifier.VerifyCaptcha(ctx, captchaResponse, ip)
+}
+
+func (tracker *AttemptTracker) status(attempts Attempts, limits AttemptLimits, now time.Time) (status AttemptStatus) {
+	status.CaptchaRequired = limits.CaptchaAfter > 0 && attempts.Failures >= limits.CaptchaAfter
+
+	var delay time.Duration
+	if limits.LockoutAfter > 0 && attempts.Failures >= limits.LockoutAfter {
+		delay = tracker.config.LockoutDuration
+	} else if limits.FreeAttempts > 0 && attempts.Failures >= limits.FreeAttempts {
+		shift := attempts.Failures - limits.FreeAttempts
+		if shift > maxBackoffShift {
+			shift = maxBackoffShift
+		}
+		delay = tracker.config.BaseDelay << shift
+		if delay > tracker.config.MaxDelay {
+			delay = tracker.config.MaxDelay
+		}
+	}
+
+	if retryAfter := attempts.LastFailureAt.Add(delay).Sub(now); retryAfter > 0 {
+		status.RetryAfter = retryAfter
+	}
+
+	return
+}
+
+func (tracker *AttemptTracker) limits(kind attemptKeyKind) AttemptLimits {
+	switch kind {
+	case attemptKeyAccount:
+		return tracker.config.AccountLimits
+	case attemptKeyIP:
+		return tracker.config.IPLimits
+	default:
+		return tracker.config.SubnetLimits
+	}
+}
+
+// isFailedAttempt returns true if err means that the credentials of an attempt are not valid
+func isFailedAttempt(err error) bool {
+	return errors.Is(err, ErrPasswordIsNotValid) ||
+		errors.Is(err, ErrAccountNotFound) ||
+		errors.Is(err, ErrSecondFactorIsNotValid) ||
+		errors.Is(err, ErrTotpCodeIsNotValid) ||
+		errors.Is(err, ErrTotpCodeAlreadyUsed) ||
+		errors.Is(err, ErrRecoveryCodeIsNotValid)
+}
+
+type attemptKeyKind int
+
+const (
+	attemptKeyAccount attemptKeyKind = iota
+	attemptKeyIP
+	attemptKeySubnet
+)
+
+type attemptKey struct {
+	kind attemptKeyKind
+	name string
+}
+
+func attemptKeys(accountID uuid.UUID, ip netip.Addr) (keys []attemptKey) {
+	keys = make([]attemptKey, 0, 3)
+
+	if accountID != uuid.Nil {
+		keys = append(keys, attemptKey{kind: attemptKeyAccount, name: accountAttemptKey(accountID)})
+	}
+
+	if ip.IsValid() {
+		ip = ip.Unmap()
+		prefixLength := ipv6SubnetPrefixLength
+		if ip.Is4() {
+			prefixLength = ipv4SubnetPrefixLength
+		}
+		subnet, _ := ip.Prefix(prefixLength)
+		keys = append(keys,
+			attemptKey{kind: attemptKeyIP, name: "ip:" + ip.String()},
+			attemptKey{kind: attemptKeySubnet, name: "subnet:" + subnet.String()},
+		)
+	}
+
+	return
+}
+
+func accountAttemptKey(accountID uuid.UUID) string {
+	return "account:" + accountID.String()
+}
+
+func keyNames(keys []attemptKey) []string {
+	names := make([]string, len(keys))
+	for i, key := range keys {
+		names[i] = key.name
+	}
+	return names
+}
+
+func NewMemoryAttemptStore() *MemoryAttemptStore {
+	return &MemoryAttemptStore{
+		attempts: concurrentmap.New[Attempts](),
+	}
+}
+
+func (store *MemoryAttemptStore) GetAttempts(ctx context.Context, keys []string, since time.Time) (attempts map[string]Attempts, err error) {
+	attempts = make(map[string]Attempts, len(keys))
+	for _, key := range keys {
+		keyAttempts, exists := store.attempts.Get(key)
+		if exists && !keyAttempts.LastFailureAt.Before(since) {
+			attempts[key] = keyAttempts
+		}
+	}
+	return
+}
+
+func (store *MemoryAttemptStore) RecordFailure(ctx context.Context, keys []string, now time.Time, window time.Duration) (err error) {
+	for _, key := range keys {
+		store.attempts.Upsert(key, Attempts{Failures: 1, LastFailureAt: now}, func(exists bool, existing, newAttempts Attempts) Attempts {
+			if !exists || existing.LastFailureAt.Before(now.Add(-window)) {
+				return newAttempts
+			}
+			existing.Failures += 1
+			existing.LastFailureAt = now
+			return existing
+		})
+	}
+	return
+}
+
+func (store *MemoryAttemptStore) ReserveAttempt(ctx context.Context, keys []string, now time.Time, window time.Duration) (attempts map[string]Attempts, err error) {
+	attempts = make(map[string]Attempts, len(keys))
+	for _, key := range keys {
+		attempts[key] = store.attempts.Upsert(key, Attempts{Failures: 1, LastFailureAt: now}, func(exists bool, existing, newAttempts Attempts) Attempts {
+			if !exists || existing.LastFailureAt.Before(now.Add(-window)) {
+				return newAttempts
+			}
+			existing.Failures += 1
+			return existing
+		})
+	}
+	return
+}
+
+func (store *MemoryAttemptStore) RecordReservedFailure(ctx context.Context, keys []string, now time.Time) (err error) {
+	for _, key := range keys {
+		store.attempts.Upsert(key, Attempts{Failures: 1, LastFailureAt: now}, func(exists bool, existing, newAttempts Attempts) Attempts {
+			// the attempts may have been deleted by DeleteExpiredAttempts since they have been reserved
+			if !exists {
+				return newAttempts
+			}
+			existing.LastFailureAt = now
+			return existing
+		})
+	}
+	return
+}
+
+func (store *MemoryAttemptStore) CancelAttempt(ctx context.Context, keys []string) (err error) {
+	for _, key := range keys {
+		store.attempts.Upsert(key, Attempts{}, func(exists bool, existing, newAttempts Attempts) Attempts {
+			if !exists {
+				// deleted by DeleteExpiredAttempts, the empty attempts are deleted by the next call
+				return newAttempts
+			}
+			if existing.Failures > 0 {
+				existing.Failures -= 1
+			}
+			return existing
+		})
+	}
+	return
+}
+
+func (store *MemoryAttemptStore) ResetAttempts(ctx context.Context, keys []string) (err error) {
+	for _, key := range keys {
+		store.attempts.Remove(key)
+	}
+	return
+}
+
+func (store *MemoryAttemptStore) DeleteExpiredAttempts(ctx context.Context, before time.Time) (deleted int64, err error) {
+	for _, key := range store.attempts.Keys() {
+		removed := store.attempts.RemoveCb(key, func(key string, attempts Attempts, exists bool) bool {
+			return exists && attempts.LastFailureAt.Before(before)
+		})
+		if removed {
+			deleted += 1
+		}
+	}
+	return
+}
+
+func NewPostgresAttemptStore(db db.Queryer) *PostgresAttemptStore {
+	return &PostgresAttemptStore{db: db}
+}
+
+func (store *PostgresAttemptStore) GetAttempts(ctx context.Context, keys []string, since time.Time) (attempts map[string]Attempts, err error) {
+	attempts = make(map[string]Attempts, len(keys))
+	if len(keys) == 0 {
+		return
+	}
+
+	placeholders := make([]string, len(keys))
+	args := make([]any, 0, len(keys)+1)
+	args = append(args, since)
+	for i, key := range keys {
+		placeholders[i] = "$" + strconv.Itoa(i+2)
+		args = append(args, key)
+	}
+
+	var rows []struct {
+		Key string `db:"key"`
+		Attempts
+	}
+	err = store.db.Select(ctx, &rows, `SELECT key, failures, last_failure_at FROM auth_failed_attempts
+		WHERE last_failure_at >= $1 AND key IN (`+strings.Join(placeholders, ", ")+`)`, args...)
+	if err != nil {
+		err = fmt.Errorf("auth: getting failed attempts: %w", err)
+		return
+	}
+
+	for _, row := range rows {
+		attempts[row.Key] = row.Attempts
+	}
+	return
+}
+
+func (store *PostgresAttemptStore) RecordFailure(ctx context.Context, keys []string, now time.Time, window time.Duration) (err error) {
+	for _, key := range keys {
+		_, err = store.db.Exec(ctx, `INSERT INTO auth_failed_attempts (key, failures, last_failure_at) VALUES ($1, 1, $2)
+			ON CONFLICT (key) DO UPDATE SET
+				failures = CASE WHEN auth_failed_attempts.last_failure_at < $3 THEN 1 ELSE auth_failed_attempts.failures + 1 END,
+				last_failure_at = $2`, key, now, now.Add(-window))
+		if err != nil {
+			err = fmt.Errorf("auth: recording failed attempt: %w", err)
+			return
+		}
+	}
+	return
+}
+
+func (store *PostgresAttemptStore) ReserveAttempt(ctx context.Context, keys []string, now time.Time, window time.Duration) (attempts map[string]Attempts, err error) {
+	attempts = make(map[string]Attempts, len(keys))
+	for _, key := range keys {
+		var keyAttempts Attempts
+
+		// the row is locked by the upsert, so the returned attempts include the concurrent reservations
+		err = store.db.Get(ctx, &keyAttempts, `INSERT INTO auth_failed_attempts (key, failures, last_failure_at) VALUES ($1, 1, $2)
+			ON CONFLICT (key) DO UPDATE SET
+				failures = CASE WHEN auth_failed_attempts.last_failure_at < $3 THEN 1 ELSE auth_failed_attempts.failures + 1 END,
+				last_failure_at = CASE WHEN auth_failed_attempts.last_failure_at < $3 THEN $2 ELSE auth_failed_attempts.last_failure_at END
+			RETURNING failures, last_failure_at`, key, now, now.Add(-window))
+		if err != nil {
+			err = fmt.Errorf("auth: reserving attempt: %w", err)
+			return
+		}
+		attempts[key] = keyAttempts
+	}
+	return
+}
+
+func (store *PostgresAttemptStore) RecordReservedFailure(ctx context.Context, keys []string, now time.Time) (err error) {
+	for _, key := range keys {
+		// the row may have been deleted by DeleteExpiredAttempts since the attempt has been reserved
+		_, err = store.db.Exec(ctx, `INSERT INTO auth_failed_attempts (key, failures, last_failure_at) VALUES ($1, 1, $2)
+			ON CONFLICT (key) DO UPDATE SET last_failure_at = $2`, key, now)
+		if err != nil {
+			err = fmt.Errorf("auth: recording failed attempt: %w", err)
+			return
+		}
+	}
+	return
+}
+
+func (store *PostgresAttemptStore) CancelAttempt(ctx context.Context, keys []string) (err error) {
+	for _, key := range keys {
+		_, err = store.db.Exec(ctx, "UPDATE auth_failed_attempts SET failures = failures - 1 WHERE key = $1 AND failures > 0", key)
+		if err != nil {
+			err = fmt.Errorf("auth: cancelling attempt: %w", err)
+			return
+		}
+	}
+	return
+}
+
+func (store *PostgresAttemptStore) ResetAttempts(ctx context.Context, keys []string) (err error) {
+	for _, key := range keys {
+		_, err = store.db.Exec(ctx, "DELETE FROM auth_failed_attempts WHERE key = $1", key)
+		if err != nil {
+			err = fmt.Errorf("auth: resetting failed attempts: %w", err)
+			return
+		}
+	}
+	return
+}
+
+func (store *PostgresAttemptStore) DeleteExpiredAttempts(ctx context.Context, before time.Time) (deleted int64, err error) {
+	result, err := store.db.Exec(ctx, "DELETE FROM auth_failed_attempts WHERE last_failure_at < $1", before)
+	if err != nil {
+		err = fmt.Errorf("auth: deleting expired failed attempts: %w", err)
+		return
+	}
+
+	deleted, err = result.RowsAffected()
+	if err != nil {
+		err = fmt.Errorf("auth: deleting expired failed attempts: %w", err)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,359 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bloom42/stdx/auth"
+	"github.com/bloom42/stdx/uuid"
+)
+
+type fakeCaptchaVerifier struct {
+	validResponse string
+}
+
+func (verifier fakeCaptchaVerifier) VerifyCaptcha(ctx context.Context, response string, remoteIP netip.Addr) error {
+	if response != verifier.validResponse {
+		return auth.ErrCaptchaIsNotValid
+	}
+	return nil
+}
+
+func TestAttemptTrackerBackoffAndLockout(t *testing.T) {
+	ctx := context.Background()
+	tracker := auth.NewAttemptTracker(auth.AttemptTrackerConfig{
+		BaseDelay:       time.Minute,
+		MaxDelay:        4 * time.Minute,
+		LockoutDuration: time.Hour,
+		Window:          2 * time.Hour,
+		AccountLimits:   auth.AttemptLimits{FreeAttempts: 2, CaptchaAfter: 3, LockoutAfter: 6},
+	})
+	accountID := uuid.New()
+	ip := netip.MustParseAddr("203.0.113.7")
+
+	expectedDelays := []time.Duration{0, 0, time.Minute, 2 * time.Minute, 4 * time.Minute, 4 * time.Minute, time.Hour}
+	for failures, expectedDelay := range expectedDelays {
+		status, err := tracker.Check(ctx, accountID, ip)
+		if expectedDelay == 0 {
+			if err != nil || status.RetryAfter != 0 {
+				t.Errorf("%d failures: expected no delay | got: %v (%v)", failures, status.RetryAfter, err)
+			}
+		} else {
+			if !errors.Is(err, auth.ErrTooManyAttempts) {
+				t.Errorf("%d failures. expected: %v | got: %v", failures, auth.ErrTooManyAttempts, err)
+			}
+			if status.RetryAfter <= expectedDelay-time.Second || status.RetryAfter > expectedDelay {
+				t.Errorf("%d failures: retry after. expected: %v | got: %v", failures, expectedDelay, status.RetryAfter)
+			}
+		}
+		if expectedCaptcha := failures >= 3; status.CaptchaRequired != expectedCaptcha {
+			t.Errorf("%d failures: CAPTCHA required. expected: %v | got: %v", failures, expectedCaptcha, status.CaptchaRequired)
+		}
+
+		err = tracker.RecordFailure(ctx, accountID, ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// other accounts from other networks are not affected
+	status, err := tracker.Check(ctx, uuid.New(), netip.MustParseAddr("2001:db8::1"))
+	if err != nil || status != (auth.AttemptStatus{}) {
+		t.Errorf("other account: expected no limits | got: %+v (%v)", status, err)
+	}
+
+	// a success resets the account, but not the IP address
+	err = tracker.RecordSuccess(ctx, accountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err = tracker.Check(ctx, accountID, netip.Addr{})
+	if err != nil || status != (auth.AttemptStatus{}) {
+		t.Errorf("after success: expected no limits | got: %+v (%v)", status, err)
+	}
+	_, err = tracker.Check(ctx, uuid.Nil, ip)
+	if !errors.Is(err, auth.ErrTooManyAttempts) {
+		t.Errorf("IP after success. expected: %v | got: %v", auth.ErrTooManyAttempts, err)
+	}
+}
+
+func TestAttemptTrackerSubnets(t *testing.T) {
+	ctx := context.Background()
+	tracker := auth.NewAttemptTracker(auth.AttemptTrackerConfig{
+		IPLimits:     auth.AttemptLimits{FreeAttempts: 100},
+		SubnetLimits: auth.AttemptLimits{FreeAttempts: 2},
+	})
+
+	err := tracker.RecordFailure(ctx, uuid.Nil, netip.MustParseAddr("198.51.100.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tracker.RecordFailure(ctx, uuid.Nil, netip.MustParseAddr("::ffff:198.51.100.2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = tracker.Check(ctx, uuid.Nil, netip.MustParseAddr("198.51.100.200"))
+	if !errors.Is(err, auth.ErrTooManyAttempts) {
+		t.Errorf("same subnet. expected: %v | got: %v", auth.ErrTooManyAttempts, err)
+	}
+	_, err = tracker.Check(ctx, uuid.Nil, netip.MustParseAddr("198.51.101.1"))
+	if err != nil {
+		t.Errorf("other subnet: %v", err)
+	}
+}
+
+func TestAttemptTrackerProtect(t *testing.T) {
+	ctx := context.Background()
+	tracker := auth.NewAttemptTracker(auth.AttemptTrackerConfig{
+		AccountLimits:   auth.AttemptLimits{CaptchaAfter: 2},
+		CaptchaVerifier: fakeCaptchaVerifier{validResponse: "solved"},
+	})
+	accountID := uuid.New()
+	ip := netip.MustParseAddr("192.0.2.1")
+	wrongPassword := func() error { return auth.ErrPasswordIsNotValid }
+
+	for i := 0; i < 2; i += 1 {
+		_, err := tracker.Protect(ctx, accountID, ip, "", wrongPassword)
+		if !errors.Is(err, auth.ErrPasswordIsNotValid) {
+			t.Fatalf("attempt %d. expected: %v | got: %v", i, auth.ErrPasswordIsNotValid, err)
+		}
+	}
+
+	verified := false
+	rightPassword := func() error {
+		verified = true
+		return nil
+	}
+
+	status, err := tracker.Protect(ctx, accountID, ip, "", rightPassword)
+	if !errors.Is(err, auth.ErrCaptchaIsRequired) || !status.CaptchaRequired || verified {
+		t.Errorf("missing CAPTCHA. expected: %v | got: %v (verified: %v)", auth.ErrCaptchaIsRequired, err, verified)
+	}
+
+	_, err = tracker.Protect(ctx, accountID, ip, "wrong", rightPassword)
+	if !errors.Is(err, auth.ErrCaptchaIsNotValid) || verified {
+		t.Errorf("wrong CAPTCHA. expected: %v | got: %v (verified: %v)", auth.ErrCaptchaIsNotValid, err, verified)
+	}
+
+	_, err = tracker.Protect(ctx, accountID, ip, "solved", rightPassword)
+	if err != nil || !verified {
+		t.Errorf("solved CAPTCHA: %v (verified: %v)", err, verified)
+	}
+
+	status, err = tracker.Check(ctx, accountID, netip.Addr{})
+	if err != nil || status.CaptchaRequired {
+		t.Errorf("after success: expected no CAPTCHA | got: %+v (%v)", status, err)
+	}
+}
+
+func TestAttemptTrackerMiddleware(t *testing.T) {
+	tracker := auth.NewAttemptTracker(auth.AttemptTrackerConfig{
+		BaseDelay: time.Minute,
+		IPLimits:  auth.AttemptLimits{FreeAttempts: 1},
+	})
+	handler := tracker.Middleware(nil)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/login", nil)
+	req.RemoteAddr = "192.0.2.10:4321"
+
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("first attempt. expected: %d | got: %d", http.StatusUnauthorized, res.Code)
+	}
+
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	checkErrorResponse(t, res, http.StatusTooManyRequests, "too_many_attempts")
+	if retryAfter := res.Header().Get("Retry-After"); retryAfter != "60" {
+		t.Errorf("Retry-After. expected: 60 | got: %s", retryAfter)
+	}
+}
+
+func TestAttemptTrackerConcurrentAttempts(t *testing.T) {
+	testConcurrentAttempts(t, auth.NewMemoryAttemptStore())
+}
+
+func TestPostgresAttemptStore(t *testing.T) {
+	ctx := context.Background()
+	database := newTestDatabase(t)
+	store := auth.NewPostgresAttemptStore(database)
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	window := time.Hour
+	keys := []string{"ip:192.0.2.1", "subnet:192.0.2.0/24"}
+
+	for i := 0; i < 2; i += 1 {
+		err := store.RecordFailure(ctx, keys, now, window)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	attempts, err := store.GetAttempts(ctx, append(keys, "ip:192.0.2.2"), now.Add(-window))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != len(keys) {
+		t.Fatalf("GetAttempts. expected: %d keys | got: %d", len(keys), len(attempts))
+	}
+	for _, key := range keys {
+		if attempts[key].Failures != 2 || !attempts[key].LastFailureAt.Equal(now) {
+			t.Errorf("%s. expected: 2 failures at %v | got: %+v", key, now, attempts[key])
+		}
+	}
+
+	// a reservation increments the failures without updating the last failure
+	later := now.Add(time.Minute)
+	attempts, err = store.ReserveAttempt(ctx, keys, later, window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts[keys[0]].Failures != 3 || !attempts[keys[0]].LastFailureAt.Equal(now) {
+		t.Errorf("ReserveAttempt. expected: 3 failures at %v | got: %+v", now, attempts[keys[0]])
+	}
+
+	err = store.CancelAttempt(ctx, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attempts, err = store.GetAttempts(ctx, keys, now.Add(-window))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts[keys[0]].Failures != 2 {
+		t.Errorf("CancelAttempt. expected: 2 failures | got: %d", attempts[keys[0]].Failures)
+	}
+
+	_, err = store.ReserveAttempt(ctx, keys, later, window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.RecordReservedFailure(ctx, keys, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attempts, err = store.GetAttempts(ctx, keys, now.Add(-window))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts[keys[0]].Failures != 3 || !attempts[keys[0]].LastFailureAt.Equal(later) {
+		t.Errorf("RecordReservedFailure. expected: 3 failures at %v | got: %+v", later, attempts[keys[0]])
+	}
+
+	// failures older than the window are reset
+	muchLater := later.Add(2 * window)
+	attempts, err = store.ReserveAttempt(ctx, keys, muchLater, window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts[keys[0]].Failures != 1 || !attempts[keys[0]].LastFailureAt.Equal(muchLater) {
+		t.Errorf("ReserveAttempt after window. expected: 1 failure at %v | got: %+v", muchLater, attempts[keys[0]])
+	}
+
+	err = store.ResetAttempts(ctx, keys[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := store.DeleteExpiredAttempts(ctx, muchLater.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Errorf("DeleteExpiredAttempts. expected: 1 | got: %d", deleted)
+	}
+
+	testConcurrentAttempts(t, store)
+}
+
+// testConcurrentAttempts verifies concurrent attempts and checks that only the free attempts are verified
+func testConcurrentAttempts(t *testing.T, store auth.AttemptStore) {
+	t.Helper()
+
+	const freeAttempts = 2
+	const concurrentAttempts = 10
+	ctx := context.Background()
+	tracker := auth.NewAttemptTracker(auth.AttemptTrackerConfig{
+		Store:         store,
+		BaseDelay:     time.Minute,
+		AccountLimits: auth.AttemptLimits{FreeAttempts: freeAttempts},
+	})
+	accountID := uuid.New()
+	ip := netip.MustParseAddr("198.51.100.42")
+
+	var verified atomic.Int64
+	wrongPassword := func() error {
+		verified.Add(1)
+		// keeps the attempt in flight while the others are checked
+		time.Sleep(20 * time.Millisecond)
+		return auth.ErrPasswordIsNotValid
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrentAttempts; i += 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := tracker.Protect(ctx, accountID, ip, "", wrongPassword)
+			if !errors.Is(err, auth.ErrPasswordIsNotValid) && !errors.Is(err, auth.ErrTooManyAttempts) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if verified.Load() != freeAttempts {
+		t.Errorf("verified attempts. expected: %d | got: %d", freeAttempts, verified.Load())
+	}
+
+	// the rejected attempts are not counted as failures
+	_, err := tracker.Check(ctx, accountID, netip.Addr{})
+	if !errors.Is(err, auth.ErrTooManyAttempts) {
+		t.Errorf("expected: %v | got: %v", auth.ErrTooManyAttempts, err)
+	}
+	attempts, err := store.GetAttempts(ctx, []string{"account:" + accountID.String()}, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failures := attempts["account:"+accountID.String()].Failures; failures != freeAttempts {
+		t.Errorf("failures. expected: %d | got: %d", freeAttempts, failures)
+	}
+}
+
+func TestAttemptTrackerMiddlewareConcurrentAttempts(t *testing.T) {
+	tracker := auth.NewAttemptTracker(auth.AttemptTrackerConfig{
+		BaseDelay: time.Minute,
+		IPLimits:  auth.AttemptLimits{FreeAttempts: 1},
+	})
+	var served atomic.Int64
+	handler := tracker.Middleware(nil)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		served.Add(1)
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i += 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/login", nil)
+			req.RemoteAddr = "192.0.2.20:4321"
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+	}
+	wg.Wait()
+
+	if served.Load() != 1 {
+		t.Errorf("served requests. expected: 1 | got: %d", served.Load())
+	}
+}
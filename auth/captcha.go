@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"github.com/bloom42/stdx/httpx"
+)
+
+const TurnstileVerifyURL = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+
+var ErrCaptchaIsNotValid = errors.New("auth: CAPTCHA is not valid")
+
+// CaptchaVerifier verifies the responses of the CAPTCHAs solved by users
+type CaptchaVerifier interface {
+	// VerifyCaptcha returns an error wrapping ErrCaptchaIsNotValid if response is not valid.
+	// remoteIP can be the zero netip.Addr.
+	VerifyCaptcha(ctx context.Context, response string, remoteIP netip.Addr) error
+}
+
+type TurnstileConfig struct {
+	SecretKey string
+	// Hostname is the expected hostname of the site where the challenge was solved. Optional.
+	Hostname string
+	// Action is the expected action of the widget. Optional.
+	Action string
+	// default: httpx.DefaultClient()
+	HTTPClient *http.Client
+	// default: TurnstileVerifyURL
+	VerifyURL string
+}
+
+// TurnstileVerifier is a CaptchaVerifier for Cloudflare Turnstile
+// (https://developers.cloudflare.com/turnstile/get-started/server-side-validation)
+type TurnstileVerifier struct {
+	config TurnstileConfig
+}
+
+type turnstileResponse struct {
+	Success    bool     `json:"success"`
+	ErrorCodes []string `json:"error-codes"`
+	Hostname   string   `json:"hostname"`
+	Action     string   `json:"action"`
+}
+
+func NewTurnstileVerifier(config TurnstileConfig) *TurnstileVerifier {
+	if config.HTTPClient == nil {
+		config.HTTPClient = httpx.DefaultClient()
+	}
+	if config.VerifyURL == "" {
+		config.VerifyURL = TurnstileVerifyURL
+	}
+
+	return &TurnstileVerifier{config: config}
+}
+
+func (verifier *TurnstileVerifier) VerifyCaptcha(ctx context.Context, response string, remoteIP netip.Addr) (err error) {
+	form := url.Values{}
+	form.Set("secret", verifier.config.SecretKey)
+	form.Set("response", response)
+	if remoteIP.IsValid() {
+		form.Set("remoteip", remoteIP.String())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, verifier.config.VerifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		err = fmt.Errorf("auth: creating Turnstile request: %w", err)
+		return
+	}
+	req.Header.Set(httpx.HeaderContentType, "application/x-www-form-urlencoded")
+	req.Header.Set(httpx.HeaderAccept, httpx.MediaTypeJson)
+
+	res, err := verifier.config.HTTPClient.Do(req)
+	if err != nil {
+		err = fmt.Errorf("auth: verifying Turnstile response: %w", err)
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("auth: verifying Turnstile response: status code: %d", res.StatusCode)
+		return
+	}
+
+	var result turnstileResponse
+	err = json.NewDecoder(res.Body).Decode(&result)
+	if err != nil {
+		err = fmt.Errorf("auth: decoding Turnstile response: %w", err)
+		return
+	}
+
+	if !result.Success {
+		err = fmt.Errorf("%w: %s", ErrCaptchaIsNotValid, strings.Join(result.ErrorCodes, ", "))
+		return
+	}
+	if verifier.config.Hostname != "" && result.Hostname != verifier.config.Hostname {
+		err = fmt.Errorf("%w: hostname doesn't match", ErrCaptchaIsNotValid)
+		return
+	}
+	if verifier.config.Action != "" && result.Action != verifier.config.Action {
+		err = fmt.Errorf("%w: action doesn't match", ErrCaptchaIsNotValid)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,63 @@
+package auth_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/bloom42/stdx/auth"
+)
+
+func TestTurnstileVerifier(t *testing.T) {
+	const secretKey = "secret"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		err := req.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		response := map[string]any{"success": false, "error-codes": []string{"invalid-input-response"}}
+		if req.PostForm.Get("secret") == secretKey && req.PostForm.Get("response") == "valid" &&
+			req.PostForm.Get("remoteip") == "192.0.2.1" {
+			response = map[string]any{"success": true, "hostname": "example.com", "action": "login"}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	ip := netip.MustParseAddr("192.0.2.1")
+
+	verifier := auth.NewTurnstileVerifier(auth.TurnstileConfig{
+		SecretKey: secretKey,
+		Hostname:  "example.com",
+		Action:    "login",
+		VerifyURL: server.URL,
+	})
+	err := verifier.VerifyCaptcha(ctx, "valid", ip)
+	if err != nil {
+		t.Errorf("valid response: %v", err)
+	}
+
+	err = verifier.VerifyCaptcha(ctx, "invalid", ip)
+	if !errors.Is(err, auth.ErrCaptchaIsNotValid) {
+		t.Errorf("invalid response. expected: %v | got: %v", auth.ErrCaptchaIsNotValid, err)
+	}
+
+	verifier = auth.NewTurnstileVerifier(auth.TurnstileConfig{
+		SecretKey: secretKey,
+		Hostname:  "other.example.com",
+		VerifyURL: server.URL,
+	})
+	err = verifier.VerifyCaptcha(ctx, "valid", ip)
+	if !errors.Is(err, auth.ErrCaptchaIsNotValid) {
+		t.Errorf("other hostname. expected: %v | got: %v", auth.ErrCaptchaIsNotValid, err)
+	}
+}
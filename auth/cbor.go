@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// cborDecode implements the subset of CBOR (RFC 8949) used by WebAuthn: integers, byte and text strings,
+// arrays, maps with integer or text keys, booleans and null. Indefinite lengths, tags and floats are not
+// supported, as they are not allowed in CTAP2 canonical CBOR.
+// It returns the first decoded item and the remaining bytes.
+
+const cborMaxDepth = 16
+
+var errCborIsNotValid = errors.New("auth: CBOR data is not valid")
+
+const (
+	cborMajorUnsigned = 0
+	cborMajorNegative = 1
+	cborMajorBytes    = 2
+	cborMajorText     = 3
+	cborMajorArray    = 4
+	cborMajorMap      = 5
+	cborMajorSimple   = 7
+)
+
+func cborDecode(data []byte) (value any, rest []byte, err error) {
+	return cborDecodeItem(data, 0)
+}
+
+func cborDecodeItem(data []byte, depth int) (value any, rest []byte, err error) {
+	if depth > cborMaxDepth || len(data) == 0 {
+		err = errCborIsNotValid
+		return
+	}
+
+	major := data[0] >> 5
+	additional := data[0] & 0x1f
+	data = data[1:]
+
+	if major == cborMajorSimple {
+		switch additional {
+		case 20:
+			return false, data, nil
+		case 21:
+			return true, data, nil
+		case 22:
+			return nil, data, nil
+		default:
+			err = errCborIsNotValid
+			return
+		}
+	}
+
+	argument, data, err := cborDecodeArgument(additional, data)
+	if err != nil {
+		return
+	}
+
+	switch major {
+	case cborMajorUnsigned:
+		if argument > math.MaxInt64 {
+			err = errCborIsNotValid
+			return
+		}
+		return int64(argument), data, nil
+
+	case cborMajorNegative:
+		if argument > math.MaxInt64 {
+			err = errCborIsNotValid
+			return
+		}
+		return -1 - int64(argument), data, nil
+
+	case cborMajorBytes, cborMajorText:
+		if argument > uint64(len(data)) {
+			err = errCborIsNotValid
+			return
+		}
+		if major == cborMajorText {
+			return string(data[:argument]), data[argument:], nil
+		}
+		bytes := make([]byte, argument)
+		copy(bytes, data[:argument])
+		return bytes, data[argument:], nil
+
+	case cborMajorArray:
+		// each item is at least 1 byte long
+		if argument > uint64(len(data)) {
+			err = errCborIsNotValid
+			return
+		}
+		array := make([]any, 0, argument)
+		for i := uint64(0); i < argument; i += 1 {
+			var item any
+			item, data, err = cborDecodeItem(data, depth+1)
+			if err != nil {
+				return
+			}
+			array = append(array, item)
+		}
+		return array, data, nil
+
+	case cborMajorMap:
+		if argument > uint64(len(data))/2 {
+			err = errCborIsNotValid
+			return
+		}
+		cborMap := make(map[any]any, argument)
+		for i := uint64(0); i < argument; i += 1 {
+			var key, item any
+			key, data, err = cborDecodeItem(data, depth+1)
+			if err != nil {
+				return
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				err = errCborIsNotValid
+				return
+			}
+			if _, duplicate := cborMap[key]; duplicate {
+				err = errCborIsNotValid
+				return
+			}
+
+			item, data, err = cborDecodeItem(data, depth+1)
+			if err != nil {
+				return
+			}
+			cborMap[key] = item
+		}
+		return cborMap, data, nil
+
+	default:
+		// tags
+		err = errCborIsNotValid
+		return
+	}
+}
+
+func cborDecodeArgument(additional byte, data []byte) (argument uint64, rest []byte, err error) {
+	switch {
+	case additional < 24:
+		return uint64(additional), data, nil
+	case additional == 24 && len(data) >= 1:
+		return uint64(data[0]), data[1:], nil
+	case additional == 25 && len(data) >= 2:
+		return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case additional == 26 && len(data) >= 4:
+		return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case additional == 27 && len(data) >= 8:
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	default:
+		// reserved values, indefinite lengths, or truncated data
+		err = errCborIsNotValid
+		return
+	}
+}
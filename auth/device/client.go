@@ -0,0 +1,293 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bloom42/stdx/httpx"
+)
+
+const (
+	// maxResponseSize limits the size of the responses of the server
+	maxResponseSize     = 1 << 20
+	credentialsFileName = "credentials"
+)
+
+var (
+	ErrAccessDenied = errors.New("device: login has been denied")
+	ErrExpiredToken = errors.New("device: login has expired")
+	ErrNotLoggedIn  = errors.New("device: not logged in")
+)
+
+// OAuthError is an error returned by the server which is not handled by the Client
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func (err *OAuthError) Error() string {
+	if err.Description != "" {
+		return fmt.Sprintf("device: server returned an error: %s: %s", err.Code, err.Description)
+	}
+	return "device: server returned an error: " + err.Code
+}
+
+type ClientConfig struct {
+	// DeviceAuthorizationURL is the URL of the DeviceAuthorizationHandler of the server. Required.
+	DeviceAuthorizationURL string
+	// TokenURL is the URL of the TokenHandler of the server. Required.
+	TokenURL string
+	// ClientID identifies the CLI, e.g. "mycli". Required.
+	ClientID string
+	Scopes   []string
+	// default: httpx.DefaultClient()
+	HTTPClient *http.Client
+}
+
+// Client implements the device side of the device authorization grant
+type Client struct {
+	config ClientConfig
+}
+
+// DeviceAuthorization is the response of the device authorization endpoint. UserCode and VerificationURI
+// should be displayed to the user.
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	// ExpiresIn and Interval are in seconds
+	ExpiresIn int64 `json:"expires_in"`
+	Interval  int64 `json:"interval"`
+}
+
+func NewClient(config ClientConfig) (client *Client, err error) {
+	if config.DeviceAuthorizationURL == "" || config.TokenURL == "" {
+		err = errors.New("device: device authorization URL and token URL are required")
+		return
+	}
+	if config.ClientID == "" {
+		err = errors.New("device: client ID is required")
+		return
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = httpx.DefaultClient()
+	}
+
+	client = &Client{config: config}
+	return
+}
+
+// Authorize requests a device code and a user code
+func (client *Client) Authorize(ctx context.Context) (authorization DeviceAuthorization, err error) {
+	form := url.Values{}
+	form.Set("client_id", client.config.ClientID)
+	if len(client.config.Scopes) != 0 {
+		form.Set("scope", strings.Join(client.config.Scopes, " "))
+	}
+
+	err = client.postForm(ctx, client.config.DeviceAuthorizationURL, form, &authorization)
+	if err != nil {
+		return
+	}
+
+	if authorization.DeviceCode == "" || authorization.UserCode == "" || authorization.VerificationURI == "" {
+		err = errors.New("device: device authorization response is not valid")
+		return
+	}
+	if authorization.Interval <= 0 {
+		authorization.Interval = int64(DefaultInterval.Seconds())
+	}
+
+	return
+}
+
+// Poll polls the token endpoint until the user approves or denies the authorization, the authorization
+// expires or ctx is canceled, and returns the API key created for the device.
+func (client *Client) Poll(ctx context.Context, authorization DeviceAuthorization) (apiKey string, err error) {
+	interval := time.Duration(authorization.Interval) * time.Second
+	var deadline <-chan time.Time
+	if authorization.ExpiresIn > 0 {
+		timer := time.NewTimer(time.Duration(authorization.ExpiresIn) * time.Second)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", GrantType)
+	form.Set("device_code", authorization.DeviceCode)
+	form.Set("client_id", client.config.ClientID)
+
+	for {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		case <-deadline:
+			err = ErrExpiredToken
+			return
+		case <-time.After(interval):
+		}
+
+		var response tokenResponse
+		err = client.postForm(ctx, client.config.TokenURL, form, &response)
+		if err == nil {
+			if response.AccessToken == "" {
+				err = errors.New("device: token response is not valid")
+				return
+			}
+			apiKey = response.AccessToken
+			return
+		}
+
+		var oauthErr *OAuthError
+		if !errors.As(err, &oauthErr) {
+			return
+		}
+		switch oauthErr.Code {
+		case ErrorAuthorizationPending:
+		case ErrorSlowDown:
+			interval += slowDownIncrement
+		case ErrorAccessDenied:
+			err = ErrAccessDenied
+			return
+		case ErrorExpiredToken:
+			err = ErrExpiredToken
+			return
+		default:
+			return
+		}
+	}
+}
+
+func (client *Client) postForm(ctx context.Context, endpoint string, form url.Values, response any) (err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		err = fmt.Errorf("device: creating request: %w", err)
+		return
+	}
+	req.Header.Set(httpx.HeaderContentType, "application/x-www-form-urlencoded")
+	req.Header.Set(httpx.HeaderAccept, httpx.MediaTypeJson)
+
+	res, err := client.config.HTTPClient.Do(req)
+	if err != nil {
+		err = fmt.Errorf("device: sending request: %w", err)
+		return
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxResponseSize))
+	if err != nil {
+		err = fmt.Errorf("device: reading response: %w", err)
+		return
+	}
+
+	if res.StatusCode != http.StatusOK {
+		var errorRes errorResponse
+		if json.Unmarshal(body, &errorRes) == nil && errorRes.Error != "" {
+			err = &OAuthError{Code: errorRes.Error, Description: errorRes.ErrorDescription}
+		} else {
+			err = fmt.Errorf("device: server returned status code: %d", res.StatusCode)
+		}
+		return
+	}
+
+	err = json.Unmarshal(body, response)
+	if err != nil {
+		err = fmt.Errorf("device: decoding response: %w", err)
+		return
+	}
+
+	return
+}
+
+// DefaultCredentialsPath returns the path of the file storing the API key of a CLI, in the configuration
+// directory of the user, e.g. ~/.config/<appName>/credentials on Linux.
+func DefaultCredentialsPath(appName string) (path string, err error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		err = fmt.Errorf("device: getting user configuration directory: %w", err)
+		return
+	}
+
+	path = filepath.Join(configDir, appName, credentialsFileName)
+	return
+}
+
+// SaveApiKey saves an API key to a file only readable by the current user
+func SaveApiKey(path, apiKey string) (err error) {
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		err = fmt.Errorf("device: creating credentials directory: %w", err)
+		return
+	}
+
+	// the key is written to a temporary file which is then renamed, so the file is never partially written
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		err = fmt.Errorf("device: creating credentials file: %w", err)
+		return
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.WriteString(apiKey + "\n")
+	if err == nil {
+		err = tmpFile.Chmod(0600)
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		err = fmt.Errorf("device: writing credentials file: %w", err)
+		return
+	}
+
+	err = os.Rename(tmpFile.Name(), path)
+	if err != nil {
+		err = fmt.Errorf("device: saving credentials file: %w", err)
+		return
+	}
+
+	return
+}
+
+// LoadApiKey loads the API key saved with SaveApiKey, or returns ErrNotLoggedIn
+func LoadApiKey(path string) (apiKey string, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = ErrNotLoggedIn
+		} else {
+			err = fmt.Errorf("device: reading credentials file: %w", err)
+		}
+		return
+	}
+
+	apiKey = strings.TrimSpace(string(data))
+	if apiKey == "" {
+		err = ErrNotLoggedIn
+		return
+	}
+
+	return
+}
+
+// DeleteApiKey deletes the API key saved with SaveApiKey
+func DeleteApiKey(path string) (err error) {
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		err = fmt.Errorf("device: deleting credentials file: %w", err)
+		return
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package device
+
+import (
+	"fmt"
+
+	"github.com/bloom42/stdx/cobra"
+)
+
+// LoginCommand returns a "login" command which logs in with the device authorization grant and saves the
+// API key to credentialsPath (see DefaultCredentialsPath).
+func LoginCommand(client *Client, credentialsPath string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "login",
+		Short: "Log in to your account",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			ctx := cmd.Context()
+
+			authorization, err := client.Authorize(ctx)
+			if err != nil {
+				return
+			}
+
+			verificationURL := authorization.VerificationURIComplete
+			if verificationURL == "" {
+				verificationURL = authorization.VerificationURI
+			}
+			fmt.Fprintf(cmd.ErrOrStderr(), "To log in, open the following URL in your browser and check that it displays the code %s:\n\n    %s\n\nWaiting for approval...\n",
+				authorization.UserCode, verificationURL)
+
+			apiKey, err := client.Poll(ctx, authorization)
+			if err != nil {
+				return
+			}
+
+			err = SaveApiKey(credentialsPath, apiKey)
+			if err != nil {
+				return
+			}
+
+			fmt.Fprintln(cmd.ErrOrStderr(), "Logged in.")
+			return
+		},
+	}
+}
+
+// LogoutCommand returns a "logout" command which deletes the API key saved by LoginCommand.
+// The API key is not revoked on the server.
+func LogoutCommand(credentialsPath string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "logout",
+		Short: "Log out of your account",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			err = DeleteApiKey(credentialsPath)
+			if err != nil {
+				return
+			}
+
+			fmt.Fprintln(cmd.ErrOrStderr(), "Logged out.")
+			return
+		},
+	}
+}
@@ -0,0 +1,281 @@
+// Package device implements the OAuth 2.0 device authorization grant (RFC 8628) to log in to CLIs and other
+// devices without a browser.
+//
+//  1. The device requests a device code and a user code from the DeviceAuthorizationHandler.
+//  2. The user opens the verification page (VerificationHandler) in a browser where they are logged in,
+//     enters the user code and approves the device.
+//  3. Meanwhile, the device polls the TokenHandler with the device code. Once the device is approved, an auth
+//     API key with the requested scopes is created and returned to the device.
+//
+// Client implements the device side, and LoginCommand and LogoutCommand provide ready-made commands for CLIs
+// built with cobra. The table is created by the migrations of the auth package.
+package device
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bloom42/stdx/auth"
+	"github.com/bloom42/stdx/base32"
+	"github.com/bloom42/stdx/crypto"
+	"github.com/bloom42/stdx/db"
+	"github.com/bloom42/stdx/guid"
+	"github.com/bloom42/stdx/token"
+	"github.com/bloom42/stdx/uuid"
+)
+
+const (
+	DeviceCodePrefix = "dvc_"
+	// GrantType is the grant_type of the token requests of the device authorization grant
+	GrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+	DefaultExpiresIn = 10 * time.Minute
+	DefaultInterval  = 5 * time.Second
+
+	ClientIDMaxLength = 64
+
+	// userCodeLength is the number of characters of user codes, which have 40 bits of entropy
+	userCodeLength = 8
+	// slowDownIncrement is added to the polling interval of devices polling too fast (RFC 8628 section 3.5)
+	slowDownIncrement     = 5 * time.Second
+	maxUserCodeCollisions = 3
+)
+
+var (
+	ErrAuthorizationNotFound = errors.New("device: authorization not found or expired")
+	ErrUserCodeIsNotValid    = errors.New("device: user code is not valid")
+	ErrClientIDIsNotValid    = errors.New("device: client ID is not valid")
+	ErrScopeIsNotAllowed     = errors.New("device: scope is not allowed")
+)
+
+// Authorization is a pending device authorization request
+type Authorization struct {
+	ID        uuid.UUID   `db:"id"`
+	CreatedAt time.Time   `db:"created_at"`
+	ExpiresAt time.Time   `db:"expires_at"`
+	ClientID  string      `db:"client_id"`
+	Scopes    auth.Scopes `db:"scopes"`
+	// UserCode is normalized: upper case and without separator
+	UserCode string `db:"user_code"`
+	// AccountID is set once the authorization is approved
+	AccountID *uuid.UUID `db:"account_id"`
+	Denied    bool       `db:"denied"`
+}
+
+type authorizationRow struct {
+	Authorization
+	DeviceCodeHash []byte     `db:"device_code_hash"`
+	PollInterval   int64      `db:"poll_interval"`
+	LastPolledAt   *time.Time `db:"last_polled_at"`
+}
+
+const authorizationColumns = `id, created_at, expires_at, client_id, scopes, user_code, account_id, denied,
+	device_code_hash, poll_interval, last_polled_at`
+
+// FormatUserCode formats a user code for display, e.g. "WDJB-MJHT"
+func FormatUserCode(userCode string) string {
+	userCode = NormalizeUserCode(userCode)
+	if len(userCode) != userCodeLength {
+		return userCode
+	}
+	return userCode[:userCodeLength/2] + "-" + userCode[userCodeLength/2:]
+}
+
+// NormalizeUserCode removes the separators and spaces that users may type, and converts the user code to
+// upper case
+func NormalizeUserCode(userCode string) string {
+	userCode = strings.ToUpper(userCode)
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, userCode)
+}
+
+// GetAuthorization returns the pending authorization of a user code, or ErrAuthorizationNotFound if it
+// doesn't exist, has expired or has already been approved or denied.
+func GetAuthorization(ctx context.Context, db db.Queryer, userCode string) (authorization Authorization, err error) {
+	userCode, err = validateUserCode(userCode)
+	if err != nil {
+		return
+	}
+
+	var row authorizationRow
+	err = db.Get(ctx, &row, `SELECT `+authorizationColumns+` FROM auth_device_authorizations
+		WHERE user_code = $1 AND expires_at > $2 AND account_id IS NULL AND denied = false`, userCode, time.Now().UTC())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrAuthorizationNotFound
+		} else {
+			err = fmt.Errorf("device: getting authorization: %w", err)
+		}
+		return
+	}
+
+	authorization = row.Authorization
+	return
+}
+
+// ApproveAuthorization approves the pending authorization of a user code for an account. The next poll of
+// the device creates an API key for the account.
+func ApproveAuthorization(ctx context.Context, db db.Queryer, userCode string, accountID uuid.UUID) (err error) {
+	userCode, err = validateUserCode(userCode)
+	if err != nil {
+		return
+	}
+
+	result, err := db.Exec(ctx, `UPDATE auth_device_authorizations SET account_id = $1
+		WHERE user_code = $2 AND expires_at > $3 AND account_id IS NULL AND denied = false`,
+		accountID, userCode, time.Now().UTC())
+	if err != nil {
+		err = fmt.Errorf("device: approving authorization: %w", err)
+		return
+	}
+
+	return checkAuthorizationUpdated(result)
+}
+
+// DenyAuthorization denies the pending authorization of a user code
+func DenyAuthorization(ctx context.Context, db db.Queryer, userCode string) (err error) {
+	userCode, err = validateUserCode(userCode)
+	if err != nil {
+		return
+	}
+
+	result, err := db.Exec(ctx, `UPDATE auth_device_authorizations SET denied = true
+		WHERE user_code = $1 AND expires_at > $2 AND account_id IS NULL AND denied = false`,
+		userCode, time.Now().UTC())
+	if err != nil {
+		err = fmt.Errorf("device: denying authorization: %w", err)
+		return
+	}
+
+	return checkAuthorizationUpdated(result)
+}
+
+// DeleteExpiredAuthorizations deletes the authorizations which have expired. It should be called periodically.
+func DeleteExpiredAuthorizations(ctx context.Context, db db.Queryer) (deleted int64, err error) {
+	result, err := db.Exec(ctx, "DELETE FROM auth_device_authorizations WHERE expires_at < $1", time.Now().UTC())
+	if err != nil {
+		err = fmt.Errorf("device: deleting expired authorizations: %w", err)
+		return
+	}
+
+	deleted, err = result.RowsAffected()
+	if err != nil {
+		err = fmt.Errorf("device: deleting expired authorizations: %w", err)
+		return
+	}
+
+	return
+}
+
+// createAuthorization creates a new authorization and returns its device code
+func createAuthorization(ctx context.Context, db db.Queryer, clientID string, scopes []string, expiresIn, interval time.Duration) (authorization Authorization, deviceCode string, err error) {
+	authorizationID, err := uuid.NewRandom()
+	if err != nil {
+		err = fmt.Errorf("device: generating authorization ID: %w", err)
+		return
+	}
+
+	now := time.Now().UTC()
+	expiresAt := now.Add(expiresIn).Truncate(time.Second)
+	deviceCodeToken, err := token.NewWithOptions(DeviceCodePrefix, token.Options{
+		ID:        guid.GUID(authorizationID),
+		Checksum:  true,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		err = fmt.Errorf("device: generating device code: %w", err)
+		return
+	}
+
+	authorization = Authorization{
+		ID:        authorizationID,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+		ClientID:  clientID,
+		Scopes:    scopes,
+	}
+
+	for i := 0; i < maxUserCodeCollisions; i += 1 {
+		authorization.UserCode, err = newUserCode()
+		if err != nil {
+			return
+		}
+
+		var result sql.Result
+		result, err = db.Exec(ctx, `INSERT INTO auth_device_authorizations (`+authorizationColumns+`)
+			VALUES ($1, $2, $3, $4, $5, $6, NULL, false, $7, $8, NULL)
+			ON CONFLICT (user_code) DO NOTHING`,
+			authorization.ID, authorization.CreatedAt, authorization.ExpiresAt, authorization.ClientID,
+			authorization.Scopes, authorization.UserCode, deviceCodeToken.Hash(), int64(interval.Seconds()))
+		if err != nil {
+			err = fmt.Errorf("device: creating authorization: %w", err)
+			return
+		}
+
+		var rowsAffected int64
+		rowsAffected, err = result.RowsAffected()
+		if err != nil {
+			err = fmt.Errorf("device: creating authorization: %w", err)
+			return
+		}
+		if rowsAffected == 1 {
+			deviceCode = deviceCodeToken.String()
+			return
+		}
+	}
+
+	err = errors.New("device: creating authorization: too many user code collisions")
+	return
+}
+
+func newUserCode() (userCode string, err error) {
+	randomBytes, err := crypto.RandBytes(userCodeLength * 5 / 8)
+	if err != nil {
+		err = fmt.Errorf("device: generating user code: %w", err)
+		return
+	}
+
+	userCode = strings.ToUpper(base32.EncodeToString(randomBytes))
+	return
+}
+
+// validateUserCode normalizes a user code and checks that it only contains characters of the base32
+// alphabet, so invalid codes are rejected without querying the database
+func validateUserCode(userCode string) (normalized string, err error) {
+	normalized = NormalizeUserCode(userCode)
+	if len(normalized) != userCodeLength {
+		err = ErrUserCodeIsNotValid
+		return
+	}
+
+	for _, r := range normalized {
+		if !strings.ContainsRune(strings.ToUpper(base32.Alphabet), r) {
+			err = ErrUserCodeIsNotValid
+			return
+		}
+	}
+
+	return
+}
+
+func checkAuthorizationUpdated(result sql.Result) (err error) {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		err = fmt.Errorf("device: updating authorization: %w", err)
+		return
+	}
+	if rowsAffected == 0 {
+		err = ErrAuthorizationNotFound
+		return
+	}
+
+	return
+}
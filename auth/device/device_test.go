@@ -0,0 +1,462 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bloom42/stdx/auth"
+	"github.com/bloom42/stdx/crypto"
+	"github.com/bloom42/stdx/db/dbtest"
+	"github.com/bloom42/stdx/httpx/middlewarex"
+	"github.com/bloom42/stdx/token"
+	"github.com/bloom42/stdx/uuid"
+)
+
+func newTestServer(t *testing.T) *Server {
+	key, err := crypto.RandBytes(crypto.KeySize256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := crypto.NewKeyring(map[crypto.KeyID][]byte{1: key}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the database is not used by the requests tested here
+	return &Server{config: ServerConfig{
+		Keyring:              keyring,
+		VerificationURL:      "https://example.com/device",
+		ClientIDs:            []string{"mycli"},
+		Scopes:               []string{"projects:read"},
+		ExpiresIn:            DefaultExpiresIn,
+		Interval:             DefaultInterval,
+		VerificationTemplate: DefaultVerificationTemplate,
+		ClientIP:             middlewarex.ClientIP,
+	}}
+}
+
+func TestUserCode(t *testing.T) {
+	for i := 0; i < 100; i += 1 {
+		userCode, err := newUserCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(userCode) != userCodeLength {
+			t.Fatalf("user code length. expected: %d | got: %s", userCodeLength, userCode)
+		}
+
+		formatted := FormatUserCode(userCode)
+		if len(formatted) != userCodeLength+1 || formatted[4] != '-' {
+			t.Fatalf("formatted user code is not valid: %s", formatted)
+		}
+
+		normalized, err := validateUserCode(" " + strings.ToLower(formatted))
+		if err != nil || normalized != userCode {
+			t.Fatalf("validating user code. expected: %s | got: %s (%v)", userCode, normalized, err)
+		}
+	}
+
+	for _, userCode := range []string{"", "ABCD-EFG", "ABCD-EFGHJ", "ABCD-EFGI", "ABCD-EFGO", "ABCD-EFG!"} {
+		_, err := validateUserCode(userCode)
+		if !errors.Is(err, ErrUserCodeIsNotValid) {
+			t.Errorf("user code %q. expected: %v | got: %v", userCode, ErrUserCodeIsNotValid, err)
+		}
+	}
+}
+
+func TestServerRequestValidation(t *testing.T) {
+	server := newTestServer(t)
+
+	expiredDeviceCode, err := token.NewWithOptions(DeviceCodePrefix, token.Options{
+		Checksum:  true,
+		ExpiresAt: time.Now().Add(-time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		handler        http.Handler
+		form           url.Values
+		expectedStatus int
+		expectedError  string
+	}{
+		{"unknown client", server.DeviceAuthorizationHandler(), url.Values{"client_id": {"other"}},
+			http.StatusUnauthorized, ErrorInvalidClient},
+		{"scope not allowed", server.DeviceAuthorizationHandler(), url.Values{"client_id": {"mycli"}, "scope": {"projects:read admin"}},
+			http.StatusBadRequest, ErrorInvalidScope},
+		{"unsupported grant type", server.TokenHandler(), url.Values{"grant_type": {"password"}},
+			http.StatusBadRequest, ErrorUnsupportedGrantType},
+		{"missing device code", server.TokenHandler(), url.Values{"grant_type": {GrantType}, "client_id": {"mycli"}},
+			http.StatusBadRequest, ErrorInvalidRequest},
+		{"invalid device code", server.TokenHandler(), url.Values{"grant_type": {GrantType}, "client_id": {"mycli"}, "device_code": {"dvc_invalid"}},
+			http.StatusBadRequest, ErrorInvalidGrant},
+		{"expired device code", server.TokenHandler(), url.Values{"grant_type": {GrantType}, "client_id": {"mycli"}, "device_code": {expiredDeviceCode.String()}},
+			http.StatusBadRequest, ErrorExpiredToken},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		res := httptest.NewRecorder()
+		test.handler.ServeHTTP(res, req)
+
+		var body errorResponse
+		err := json.Unmarshal(res.Body.Bytes(), &body)
+		if err != nil {
+			t.Fatalf("%s: decoding response: %v", test.name, err)
+		}
+		if res.Code != test.expectedStatus || body.Error != test.expectedError {
+			t.Errorf("%s. expected: %d %s | got: %d %s", test.name, test.expectedStatus, test.expectedError, res.Code, body.Error)
+		}
+		if res.Header().Get("Cache-Control") != "no-store" {
+			t.Errorf("%s: response must not be cached", test.name)
+		}
+	}
+}
+
+func TestVerificationHandlerWithoutDatabase(t *testing.T) {
+	server := newTestServer(t)
+	handler := server.VerificationHandler()
+	principal := &auth.Principal{AccountID: uuid.New(), Session: &auth.Session{ID: uuid.New()}}
+
+	serve := func(req *http.Request, principal *auth.Principal) *httptest.ResponseRecorder {
+		if principal != nil {
+			req = req.WithContext(auth.PrincipalToCtx(req.Context(), principal))
+		}
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		return res
+	}
+
+	res := serve(httptest.NewRequest(http.MethodGet, "/device", nil), nil)
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous. expected: %d | got: %d", http.StatusUnauthorized, res.Code)
+	}
+
+	// API keys can't approve devices
+	res = serve(httptest.NewRequest(http.MethodGet, "/device", nil), &auth.Principal{AccountID: uuid.New(), ApiKey: &auth.ApiKey{}})
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("API key. expected: %d | got: %d", http.StatusUnauthorized, res.Code)
+	}
+
+	res = serve(httptest.NewRequest(http.MethodGet, "/device", nil), principal)
+	if res.Code != http.StatusOK || !strings.Contains(res.Body.String(), `name="user_code"`) {
+		t.Errorf("enter step. status: %d | body: %s", res.Code, res.Body.String())
+	}
+	if res.Header().Get("X-Frame-Options") != "DENY" {
+		t.Error("verification page can be framed")
+	}
+
+	// confirmations without a valid confirmation token are rejected (CSRF)
+	form := url.Values{"user_code": {"ABCD-EFGH"}, "action": {"approve"}, "confirmation_token": {"invalid"}}
+	req := httptest.NewRequest(http.MethodPost, "/device", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res = serve(req, principal)
+	if res.Code != http.StatusOK || !strings.Contains(res.Body.String(), "This form has expired") {
+		t.Errorf("invalid confirmation token. status: %d | body: %s", res.Code, res.Body.String())
+	}
+}
+
+func TestClientPoll(t *testing.T) {
+	responses := []string{ErrorAuthorizationPending, ErrorAuthorizationPending, ""}
+	polls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/device/code":
+			if req.PostFormValue("client_id") != "mycli" || req.PostFormValue("scope") != "projects:read" {
+				writeError(w, http.StatusBadRequest, ErrorInvalidRequest, "")
+				return
+			}
+			writeJSON(w, http.StatusOK, deviceAuthorizationResponse{
+				DeviceCode:      "dvc_test",
+				UserCode:        "WDJB-MJHT",
+				VerificationURI: "https://example.com/device",
+				ExpiresIn:       60,
+				Interval:        1,
+			})
+		case "/token":
+			if req.PostFormValue("grant_type") != GrantType || req.PostFormValue("device_code") != "dvc_test" {
+				writeError(w, http.StatusBadRequest, ErrorInvalidGrant, "")
+				return
+			}
+			response := responses[polls]
+			polls += 1
+			if response != "" {
+				writeError(w, http.StatusBadRequest, response, "")
+				return
+			}
+			writeJSON(w, http.StatusOK, tokenResponse{AccessToken: "key_test", TokenType: "Bearer"})
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(ClientConfig{
+		DeviceAuthorizationURL: server.URL + "/device/code",
+		TokenURL:               server.URL + "/token",
+		ClientID:               "mycli",
+		Scopes:                 []string{"projects:read"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	authorization, err := client.Authorize(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authorization.UserCode != "WDJB-MJHT" {
+		t.Errorf("user code. expected: WDJB-MJHT | got: %s", authorization.UserCode)
+	}
+
+	// don't wait between polls
+	authorization.Interval = 0
+	apiKey, err := client.Poll(ctx, authorization)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if apiKey != "key_test" || polls != 3 {
+		t.Errorf("API key. expected: key_test after 3 polls | got: %s after %d polls", apiKey, polls)
+	}
+
+	responses = []string{ErrorAccessDenied}
+	polls = 0
+	_, err = client.Poll(ctx, authorization)
+	if !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("denied. expected: %v | got: %v", ErrAccessDenied, err)
+	}
+
+	responses = []string{ErrorInvalidGrant}
+	polls = 0
+	_, err = client.Poll(ctx, authorization)
+	var oauthErr *OAuthError
+	if !errors.As(err, &oauthErr) || oauthErr.Code != ErrorInvalidGrant {
+		t.Errorf("invalid grant. expected: %s | got: %v", ErrorInvalidGrant, err)
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mycli", credentialsFileName)
+
+	_, err := LoadApiKey(path)
+	if !errors.Is(err, ErrNotLoggedIn) {
+		t.Errorf("missing file. expected: %v | got: %v", ErrNotLoggedIn, err)
+	}
+
+	err = SaveApiKey(path, "key_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("file permissions. expected: 0600 | got: %o", info.Mode().Perm())
+	}
+
+	apiKey, err := LoadApiKey(path)
+	if err != nil || apiKey != "key_test" {
+		t.Errorf("loading API key. expected: key_test | got: %s (%v)", apiKey, err)
+	}
+
+	err = DeleteApiKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = DeleteApiKey(path)
+	if err != nil {
+		t.Errorf("deleting a missing file: %v", err)
+	}
+	_, err = LoadApiKey(path)
+	if !errors.Is(err, ErrNotLoggedIn) {
+		t.Errorf("after logout. expected: %v | got: %v", ErrNotLoggedIn, err)
+	}
+}
+
+var confirmationTokenRegexp = regexp.MustCompile(`name="confirmation_token" value="([^"]+)"`)
+
+func TestDeviceFlow(t *testing.T) {
+	database := dbtest.New(t, auth.Migrations(1))
+	ctx := context.Background()
+	accountID := uuid.New()
+
+	err := auth.CreateAccount(ctx, database, accountID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testServer := newTestServer(t)
+	server, err := NewServer(ServerConfig{
+		DB:              database,
+		Keyring:         testServer.config.Keyring,
+		VerificationURL: testServer.config.VerificationURL,
+		ClientIDs:       testServer.config.ClientIDs,
+		Scopes:          testServer.config.Scopes,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	principal := &auth.Principal{AccountID: accountID, Session: &auth.Session{ID: uuid.New(), AccountID: accountID}}
+
+	postForm := func(handler http.Handler, form url.Values, principal *auth.Principal) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if principal != nil {
+			req = req.WithContext(auth.PrincipalToCtx(req.Context(), principal))
+		}
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		return res
+	}
+
+	authorize := func() (authorization deviceAuthorizationResponse) {
+		t.Helper()
+
+		res := postForm(server.DeviceAuthorizationHandler(), url.Values{"client_id": {"mycli"}, "scope": {"projects:read"}}, nil)
+		if res.Code != http.StatusOK {
+			t.Fatalf("device authorization. status: %d | body: %s", res.Code, res.Body.String())
+		}
+		err := json.Unmarshal(res.Body.Bytes(), &authorization)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if authorization.Interval != int64(DefaultInterval.Seconds()) {
+			t.Errorf("interval. expected: %d | got: %d", int64(DefaultInterval.Seconds()), authorization.Interval)
+		}
+		return
+	}
+
+	// poll returns the access token, or the OAuth error code
+	poll := func(deviceCode string) (response tokenResponse, errorCode string) {
+		t.Helper()
+
+		res := postForm(server.TokenHandler(), url.Values{
+			"grant_type":  {GrantType},
+			"device_code": {deviceCode},
+			"client_id":   {"mycli"},
+		}, nil)
+		if res.Code == http.StatusOK {
+			err := json.Unmarshal(res.Body.Bytes(), &response)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return
+		}
+
+		var errResponse errorResponse
+		err := json.Unmarshal(res.Body.Bytes(), &errResponse)
+		if err != nil {
+			t.Fatalf("token. status: %d | body: %s", res.Code, res.Body.String())
+		}
+		errorCode = errResponse.Error
+		return
+	}
+
+	// confirm opens the verification page with userCode and submits the confirmation form with action
+	confirm := func(userCode, action string) {
+		t.Helper()
+
+		req := httptest.NewRequest(http.MethodGet, "/device?"+url.Values{UserCodeQueryParameter: {userCode}}.Encode(), nil)
+		req = req.WithContext(auth.PrincipalToCtx(req.Context(), principal))
+		res := httptest.NewRecorder()
+		server.VerificationHandler().ServeHTTP(res, req)
+		match := confirmationTokenRegexp.FindStringSubmatch(res.Body.String())
+		if res.Code != http.StatusOK || match == nil {
+			t.Fatalf("confirmation step. status: %d | body: %s", res.Code, res.Body.String())
+		}
+
+		res = postForm(server.VerificationHandler(), url.Values{
+			"user_code":          {userCode},
+			"confirmation_token": {html.UnescapeString(match[1])},
+			"action":             {action},
+		}, principal)
+		expectedPage := "Your device is logged in"
+		if action == "deny" {
+			expectedPage = "The login has been denied"
+		}
+		if res.Code != http.StatusOK || !strings.Contains(res.Body.String(), expectedPage) {
+			t.Fatalf("%s. status: %d | body: %s", action, res.Code, res.Body.String())
+		}
+	}
+
+	authorization := authorize()
+
+	_, errorCode := poll(authorization.DeviceCode)
+	if errorCode != ErrorAuthorizationPending {
+		t.Errorf("first poll. expected: %s | got: %s", ErrorAuthorizationPending, errorCode)
+	}
+
+	// the device polls again without waiting for the interval: the interval is increased
+	_, errorCode = poll(authorization.DeviceCode)
+	if errorCode != ErrorSlowDown {
+		t.Errorf("second poll. expected: %s | got: %s", ErrorSlowDown, errorCode)
+	}
+	var pollInterval int64
+	err = database.Get(ctx, &pollInterval, "SELECT poll_interval FROM auth_device_authorizations WHERE user_code = $1",
+		NormalizeUserCode(authorization.UserCode))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := int64((DefaultInterval + slowDownIncrement).Seconds()); pollInterval != expected {
+		t.Errorf("poll interval after slow_down. expected: %d | got: %d", expected, pollInterval)
+	}
+
+	confirm(authorization.UserCode, "approve")
+
+	response, errorCode := poll(authorization.DeviceCode)
+	if errorCode != "" {
+		t.Fatalf("poll after approval: %s", errorCode)
+	}
+	if response.TokenType != "Bearer" || response.Scope != "projects:read" {
+		t.Errorf("token response is not valid: %+v", response)
+	}
+	apiKey, err := auth.VerifyApiKey(ctx, database, response.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if apiKey.AccountID != accountID || !apiKey.HasScope("projects:read") || apiKey.ExpiresAt != nil {
+		t.Errorf("API key is not valid: %+v", apiKey)
+	}
+
+	// the device code can only be exchanged once
+	_, errorCode = poll(authorization.DeviceCode)
+	if errorCode != ErrorInvalidGrant {
+		t.Errorf("second exchange. expected: %s | got: %s", ErrorInvalidGrant, errorCode)
+	}
+
+	// denied authorizations
+	authorization = authorize()
+	confirm(authorization.UserCode, "deny")
+
+	_, errorCode = poll(authorization.DeviceCode)
+	if errorCode != ErrorAccessDenied {
+		t.Errorf("poll after denial. expected: %s | got: %s", ErrorAccessDenied, errorCode)
+	}
+	_, errorCode = poll(authorization.DeviceCode)
+	if errorCode != ErrorInvalidGrant {
+		t.Errorf("poll after access_denied. expected: %s | got: %s", ErrorInvalidGrant, errorCode)
+	}
+
+	// the API key has been created for the first authorization only
+	apiKeys, err := auth.GetApiKeysForAccount(ctx, database, accountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apiKeys) != 1 {
+		t.Errorf("API keys. expected: 1 | got: %d", len(apiKeys))
+	}
+}
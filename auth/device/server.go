@@ -0,0 +1,531 @@
+package device
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bloom42/stdx/auth"
+	"github.com/bloom42/stdx/crypto"
+	"github.com/bloom42/stdx/db"
+	"github.com/bloom42/stdx/httpx"
+	"github.com/bloom42/stdx/httpx/middlewarex"
+	"github.com/bloom42/stdx/log/slogx"
+	"github.com/bloom42/stdx/statelesstoken"
+	"github.com/bloom42/stdx/token"
+	"github.com/bloom42/stdx/uuid"
+)
+
+const (
+	// UserCodeQueryParameter is the query parameter of the verification page containing the user code
+	UserCodeQueryParameter = "user_code"
+
+	confirmationAudience = "auth.device.confirmation"
+)
+
+// OAuth 2.0 error codes (RFC 6749 section 5.2 and RFC 8628 section 3.5)
+const (
+	ErrorInvalidRequest       = "invalid_request"
+	ErrorInvalidClient        = "invalid_client"
+	ErrorInvalidGrant         = "invalid_grant"
+	ErrorInvalidScope         = "invalid_scope"
+	ErrorUnsupportedGrantType = "unsupported_grant_type"
+	ErrorAuthorizationPending = "authorization_pending"
+	ErrorSlowDown             = "slow_down"
+	ErrorAccessDenied         = "access_denied"
+	ErrorExpiredToken         = "expired_token"
+	ErrorServerError          = "server_error"
+)
+
+type ServerConfig struct {
+	// DB is used to store the authorizations and create the API keys. Required.
+	DB db.DB
+	// Keyring is used to sign the confirmation forms of the verification page. Required.
+	Keyring *crypto.Keyring
+	// VerificationURL is the URL of the VerificationHandler, displayed to users. Required.
+	VerificationURL string
+	// ClientIDs are the client IDs allowed to request authorizations, e.g. ["mycli"]. Required.
+	ClientIDs []string
+	// Scopes are the scopes that clients can request. If empty, any scope can be requested.
+	Scopes []string
+	// ExpiresIn is the time the user has to approve a device.
+	// default: DefaultExpiresIn
+	ExpiresIn time.Duration
+	// Interval is the minimum time between two polls of a device.
+	// default: DefaultInterval
+	Interval time.Duration
+	// ApiKeyLifetime is the lifetime of the API keys created for the devices.
+	// default: 0, the API keys don't expire
+	ApiKeyLifetime time.Duration
+	// VerificationTemplate renders the verification page with a VerificationPage.
+	// default: DefaultVerificationTemplate
+	VerificationTemplate *template.Template
+	// AttemptTracker limits the attempts to guess user codes on the verification page. Optional.
+	AttemptTracker *auth.AttemptTracker
+	// ClientIP returns the IP address of the client of a request, for the AttemptTracker.
+	// default: middlewarex.ClientIP
+	ClientIP func(req *http.Request) netip.Addr
+}
+
+// Server implements the HTTP endpoints of the device authorization grant
+type Server struct {
+	config ServerConfig
+}
+
+// VerificationPage is the data of the VerificationTemplate
+type VerificationPage struct {
+	// Step is "enter" to display the form to enter a user code, "confirm" to approve or deny an authorization,
+	// "approved" or "denied"
+	Step     string
+	UserCode string
+	ClientID string
+	Scopes   []string
+	Error    string
+	// ConfirmationToken must be sent in the "confirmation_token" field of the confirmation form
+	ConfirmationToken string
+}
+
+type confirmationData struct {
+	SessionID uuid.UUID `json:"session_id"`
+	UserCode  string    `json:"user_code"`
+}
+
+type deviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in,omitempty"`
+	Scope       string `json:"scope,omitempty"`
+}
+
+type errorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// DefaultVerificationTemplate is a minimal verification page
+var DefaultVerificationTemplate = template.Must(template.New("verification").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>Device login</title>
+</head>
+<body>
+	{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+	{{if eq .Step "enter"}}
+	<form method="GET">
+		<label for="user_code">Enter the code displayed on your device</label>
+		<input id="user_code" name="user_code" value="{{.UserCode}}" autocomplete="off" autofocus required>
+		<button type="submit">Continue</button>
+	</form>
+	{{else if eq .Step "confirm"}}
+	<p><strong>{{.ClientID}}</strong> is requesting access to your account with the code <strong>{{.UserCode}}</strong>.</p>
+	{{if .Scopes}}<ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>{{end}}
+	<p>Only approve if you have just started a login on your device.</p>
+	<form method="POST">
+		<input type="hidden" name="user_code" value="{{.UserCode}}">
+		<input type="hidden" name="confirmation_token" value="{{.ConfirmationToken}}">
+		<button type="submit" name="action" value="approve">Approve</button>
+		<button type="submit" name="action" value="deny">Deny</button>
+	</form>
+	{{else if eq .Step "approved"}}
+	<p>Your device is logged in. You can close this page.</p>
+	{{else}}
+	<p>The login has been denied.</p>
+	{{end}}
+</body>
+</html>
+`))
+
+func NewServer(config ServerConfig) (server *Server, err error) {
+	if config.DB == nil {
+		err = errors.New("device: DB is required")
+		return
+	}
+	if config.Keyring == nil {
+		err = errors.New("device: keyring is required")
+		return
+	}
+	if config.VerificationURL == "" {
+		err = errors.New("device: verification URL is required")
+		return
+	}
+	if len(config.ClientIDs) == 0 {
+		err = errors.New("device: client IDs are required")
+		return
+	}
+	for _, clientID := range config.ClientIDs {
+		if clientID == "" || len(clientID) > ClientIDMaxLength {
+			err = fmt.Errorf("%w: %s", ErrClientIDIsNotValid, clientID)
+			return
+		}
+	}
+	if config.ExpiresIn <= 0 {
+		config.ExpiresIn = DefaultExpiresIn
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultInterval
+	}
+	if config.VerificationTemplate == nil {
+		config.VerificationTemplate = DefaultVerificationTemplate
+	}
+	if config.ClientIP == nil {
+		config.ClientIP = middlewarex.ClientIP
+	}
+
+	server = &Server{config: config}
+	return
+}
+
+// DeviceAuthorizationHandler handles the device authorization requests (RFC 8628 section 3.1), which are
+// POST requests with the client_id and scope form parameters.
+func (server *Server) DeviceAuthorizationHandler() http.Handler {
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, ErrorInvalidRequest, "method must be POST")
+			return
+		}
+
+		clientID := req.PostFormValue("client_id")
+		if !slices.Contains(server.config.ClientIDs, clientID) {
+			writeError(w, http.StatusUnauthorized, ErrorInvalidClient, "client_id is not valid")
+			return
+		}
+
+		scopes := strings.Fields(req.PostFormValue("scope"))
+		err := server.validateScopes(scopes)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrorInvalidScope, err.Error())
+			return
+		}
+
+		authorization, deviceCode, err := createAuthorization(req.Context(), server.config.DB, clientID, scopes,
+			server.config.ExpiresIn, server.config.Interval)
+		if err != nil {
+			slogx.FromCtx(req.Context()).Error("device: creating authorization", slogx.Err(err))
+			writeError(w, http.StatusInternalServerError, ErrorServerError, "")
+			return
+		}
+
+		verificationURIComplete, err := url.Parse(server.config.VerificationURL)
+		if err != nil {
+			slogx.FromCtx(req.Context()).Error("device: parsing verification URL", slogx.Err(err))
+			writeError(w, http.StatusInternalServerError, ErrorServerError, "")
+			return
+		}
+		query := verificationURIComplete.Query()
+		query.Set(UserCodeQueryParameter, FormatUserCode(authorization.UserCode))
+		verificationURIComplete.RawQuery = query.Encode()
+
+		writeJSON(w, http.StatusOK, deviceAuthorizationResponse{
+			DeviceCode:              deviceCode,
+			UserCode:                FormatUserCode(authorization.UserCode),
+			VerificationURI:         server.config.VerificationURL,
+			VerificationURIComplete: verificationURIComplete.String(),
+			ExpiresIn:               int64(server.config.ExpiresIn.Seconds()),
+			Interval:                int64(server.config.Interval.Seconds()),
+		})
+	}
+	return http.HandlerFunc(fn)
+}
+
+// TokenHandler handles the device access token requests (RFC 8628 section 3.4). It can be mounted on the
+// token endpoint of the service, as it only handles the GrantType grant type.
+func (server *Server) TokenHandler() http.Handler {
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, ErrorInvalidRequest, "method must be POST")
+			return
+		}
+		if req.PostFormValue("grant_type") != GrantType {
+			writeError(w, http.StatusBadRequest, ErrorUnsupportedGrantType, "")
+			return
+		}
+
+		deviceCode := req.PostFormValue("device_code")
+		clientID := req.PostFormValue("client_id")
+		if deviceCode == "" || clientID == "" {
+			writeError(w, http.StatusBadRequest, ErrorInvalidRequest, "device_code and client_id are required")
+			return
+		}
+
+		deviceCodeToken, err := token.Parse(DeviceCodePrefix, deviceCode)
+		if err != nil {
+			if errors.Is(err, token.ErrTokenExpired) {
+				writeError(w, http.StatusBadRequest, ErrorExpiredToken, "")
+			} else {
+				writeError(w, http.StatusBadRequest, ErrorInvalidGrant, "device_code is not valid")
+			}
+			return
+		}
+
+		var response tokenResponse
+		var errorCode string
+		err = server.config.DB.Transaction(req.Context(), func(tx db.Tx) (txErr error) {
+			response, errorCode, txErr = server.exchangeDeviceCode(req.Context(), tx, deviceCodeToken, clientID)
+			return txErr
+		})
+		if err != nil {
+			slogx.FromCtx(req.Context()).Error("device: exchanging device code", slogx.Err(err))
+			writeError(w, http.StatusInternalServerError, ErrorServerError, "")
+			return
+		}
+		if errorCode != "" {
+			writeError(w, http.StatusBadRequest, errorCode, "")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, response)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// VerificationHandler serves the verification page, where users enter the user code displayed by their
+// device, and approve or deny it. It MUST be wrapped by auth.Middleware and only accepts users logged in
+// with a session: anonymous users should be redirected to the login page.
+func (server *Server) VerificationHandler() http.Handler {
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+		principal := auth.PrincipalFromCtx(ctx)
+		if principal == nil || principal.Session == nil {
+			http.Error(w, "Authentication is required", http.StatusUnauthorized)
+			return
+		}
+
+		var page VerificationPage
+		var err error
+		switch req.Method {
+		case http.MethodGet:
+			page, err = server.verificationPage(ctx, req, principal, req.URL.Query().Get(UserCodeQueryParameter))
+		case http.MethodPost:
+			page, err = server.confirm(ctx, req, principal)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err != nil {
+			slogx.FromCtx(ctx).Error("device: verification page", slogx.Err(err))
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set(httpx.HeaderContentType, httpx.MediaTypeHtmlUtf8)
+		w.Header().Set(httpx.HeaderCacheControl, "no-store")
+		// the page must not be framed, so users can't be tricked into approving a device (clickjacking)
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+		err = server.config.VerificationTemplate.Execute(w, page)
+		if err != nil {
+			slogx.FromCtx(ctx).Error("device: rendering verification page", slogx.Err(err))
+		}
+	}
+	return http.HandlerFunc(fn)
+}
+
+// verificationPage returns the form to enter a user code, or the confirmation form if userCode is valid
+func (server *Server) verificationPage(ctx context.Context, req *http.Request, principal *auth.Principal, userCode string) (page VerificationPage, err error) {
+	page = VerificationPage{Step: "enter", UserCode: userCode}
+	if userCode == "" {
+		return
+	}
+
+	ip := server.config.ClientIP(req)
+	if server.config.AttemptTracker != nil {
+		_, err = server.config.AttemptTracker.Check(ctx, principal.AccountID, ip)
+		if errors.Is(err, auth.ErrTooManyAttempts) {
+			page.Error = "Too many attempts. Please retry later."
+			err = nil
+			return
+		} else if err != nil {
+			return
+		}
+	}
+
+	authorization, err := GetAuthorization(ctx, server.config.DB, userCode)
+	if err != nil {
+		if !errors.Is(err, ErrAuthorizationNotFound) && !errors.Is(err, ErrUserCodeIsNotValid) {
+			return
+		}
+		page.Error = "This code is not valid or has expired."
+		err = nil
+		if server.config.AttemptTracker != nil {
+			err = server.config.AttemptTracker.RecordFailure(ctx, principal.AccountID, ip)
+		}
+		return
+	}
+
+	confirmationToken, err := statelesstoken.NewV2(server.config.Keyring, statelesstoken.Claims{
+		Audience: confirmationAudience,
+		Expire:   authorization.ExpiresAt,
+	}, confirmationData{SessionID: principal.Session.ID, UserCode: authorization.UserCode})
+	if err != nil {
+		err = fmt.Errorf("device: generating confirmation token: %w", err)
+		return
+	}
+
+	page = VerificationPage{
+		Step:              "confirm",
+		UserCode:          FormatUserCode(authorization.UserCode),
+		ClientID:          authorization.ClientID,
+		Scopes:            authorization.Scopes,
+		ConfirmationToken: confirmationToken.String(),
+	}
+	return
+}
+
+// confirm approves or denies an authorization from the confirmation form. The confirmation token protects
+// against CSRF, as it is bound to the session of the user and to the user code.
+func (server *Server) confirm(ctx context.Context, req *http.Request, principal *auth.Principal) (page VerificationPage, err error) {
+	userCode := NormalizeUserCode(req.PostFormValue("user_code"))
+	page = VerificationPage{Step: "enter", Error: "This form has expired. Please enter the code again."}
+
+	confirmationToken, err := statelesstoken.ParseV2[confirmationData](req.PostFormValue("confirmation_token"))
+	if err != nil {
+		err = nil
+		return
+	}
+	err = confirmationToken.Verify(server.config.Keyring, confirmationAudience, 0)
+	data := confirmationToken.Data()
+	if err != nil || data.SessionID != principal.Session.ID || data.UserCode != userCode {
+		err = nil
+		return
+	}
+
+	if req.PostFormValue("action") == "approve" {
+		err = ApproveAuthorization(ctx, server.config.DB, userCode, principal.AccountID)
+		page = VerificationPage{Step: "approved", UserCode: FormatUserCode(userCode)}
+	} else {
+		err = DenyAuthorization(ctx, server.config.DB, userCode)
+		page = VerificationPage{Step: "denied", UserCode: FormatUserCode(userCode)}
+	}
+	if errors.Is(err, ErrAuthorizationNotFound) {
+		page = VerificationPage{Step: "enter", Error: "This code is not valid or has expired."}
+		err = nil
+	}
+	return
+}
+
+// exchangeDeviceCode returns the API key of an approved authorization, or an OAuth error code
+func (server *Server) exchangeDeviceCode(ctx context.Context, tx db.Queryer, deviceCodeToken token.Token, clientID string) (response tokenResponse, errorCode string, err error) {
+	var row authorizationRow
+	err = tx.Get(ctx, &row, `SELECT `+authorizationColumns+` FROM auth_device_authorizations WHERE id = $1 FOR UPDATE`,
+		uuid.UUID(deviceCodeToken.ID()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			errorCode = ErrorInvalidGrant
+			err = nil
+		} else {
+			err = fmt.Errorf("device: getting authorization: %w", err)
+		}
+		return
+	}
+
+	if deviceCodeToken.Verify(row.DeviceCodeHash) != nil || row.ClientID != clientID {
+		errorCode = ErrorInvalidGrant
+		return
+	}
+
+	now := time.Now().UTC()
+	if !now.Before(row.ExpiresAt) {
+		errorCode = ErrorExpiredToken
+		return
+	}
+
+	if row.Denied {
+		errorCode = ErrorAccessDenied
+		err = deleteAuthorization(ctx, tx, row.ID)
+		return
+	}
+
+	if row.AccountID == nil {
+		pollInterval := time.Duration(row.PollInterval) * time.Second
+		if row.LastPolledAt != nil && now.Sub(*row.LastPolledAt) < pollInterval {
+			errorCode = ErrorSlowDown
+			row.PollInterval += int64(slowDownIncrement.Seconds())
+		} else {
+			errorCode = ErrorAuthorizationPending
+		}
+
+		_, err = tx.Exec(ctx, "UPDATE auth_device_authorizations SET last_polled_at = $1, poll_interval = $2 WHERE id = $3",
+			now, row.PollInterval, row.ID)
+		if err != nil {
+			err = fmt.Errorf("device: updating authorization: %w", err)
+			return
+		}
+		return
+	}
+
+	var apiKeyExpiresAt time.Time
+	if server.config.ApiKeyLifetime > 0 {
+		apiKeyExpiresAt = now.Add(server.config.ApiKeyLifetime)
+		response.ExpiresIn = int64(server.config.ApiKeyLifetime.Seconds())
+	}
+
+	_, apiKeyToken, err := auth.CreateApiKey(ctx, tx, *row.AccountID, row.ClientID+" (device login)", row.Scopes, apiKeyExpiresAt)
+	if err != nil {
+		return
+	}
+
+	// the authorization is deleted so the device code can only be exchanged once
+	err = deleteAuthorization(ctx, tx, row.ID)
+	if err != nil {
+		return
+	}
+
+	response.AccessToken = apiKeyToken
+	response.TokenType = "Bearer"
+	response.Scope = strings.Join(row.Scopes, " ")
+	return
+}
+
+func (server *Server) validateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		if len(server.config.Scopes) != 0 && !slices.Contains(server.config.Scopes, scope) {
+			return fmt.Errorf("%w: %s", ErrScopeIsNotAllowed, scope)
+		}
+		if len(scope) > auth.ScopeMaxLength {
+			return auth.ErrScopeIsNotValid
+		}
+	}
+	return nil
+}
+
+func deleteAuthorization(ctx context.Context, db db.Queryer, authorizationID uuid.UUID) (err error) {
+	_, err = db.Exec(ctx, "DELETE FROM auth_device_authorizations WHERE id = $1", authorizationID)
+	if err != nil {
+		err = fmt.Errorf("device: deleting authorization: %w", err)
+		return
+	}
+
+	return
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, data any) {
+	w.Header().Set(httpx.HeaderContentType, httpx.MediaTypeJson)
+	// token responses must not be cached (RFC 6749 section 5.1)
+	w.Header().Set(httpx.HeaderCacheControl, "no-store")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(data)
+}
+
+func writeError(w http.ResponseWriter, statusCode int, code, description string) {
+	writeJSON(w, statusCode, errorResponse{Error: code, ErrorDescription: description})
+}
@@ -0,0 +1,502 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bloom42/stdx/crypto"
+	"github.com/bloom42/stdx/db"
+	"github.com/bloom42/stdx/email"
+	"github.com/bloom42/stdx/statelesstoken"
+	"github.com/bloom42/stdx/uuid"
+)
+
+const (
+	DefaultMagicLinkTimeout         = 15 * time.Minute
+	DefaultEmailVerificationTimeout = 24 * time.Hour
+	DefaultPasswordResetTimeout     = time.Hour
+
+	// LinkBrowserCookieName is the name of the cookie binding links to the browser which requested them
+	LinkBrowserCookieName = "auth_link_browser"
+	// LinkTokenQueryParameter is the query parameter of the links containing their token
+	LinkTokenQueryParameter = "token"
+
+	linkBrowserSecretSize       = 32
+	linkPasswordFingerprintSize = 16
+)
+
+// LinkKind is the kind of a link. It is used as the audience of the tokens of the links.
+type LinkKind string
+
+const (
+	LinkKindMagicLink         LinkKind = "auth.magic_link"
+	LinkKindEmailVerification LinkKind = "auth.email_verification"
+	LinkKindPasswordReset     LinkKind = "auth.password_reset"
+)
+
+var (
+	ErrLinkIsNotValid             = errors.New("auth: link is not valid")
+	ErrLinkExpired                = errors.New("auth: link has expired")
+	ErrLinkAlreadyUsed            = errors.New("auth: link has already been used")
+	ErrLinkOpenedInAnotherBrowser = errors.New("auth: link has been opened in another browser than the one which requested it")
+)
+
+type LinksConfig struct {
+	// Keyring is used to sign the tokens of the links. Required.
+	Keyring *crypto.Keyring
+	// Mailer sends the links. Required.
+	Mailer email.Mailer
+	// From is the sender of the emails. Required.
+	From mail.Address
+	// MagicLinkURL, EmailVerificationURL and PasswordResetURL are the URLs of the pages verifying the links,
+	// e.g. "https://example.com/login/verify". The token is added to the LinkTokenQueryParameter query parameter.
+	// They are required to send the corresponding links.
+	MagicLinkURL         string
+	EmailVerificationURL string
+	PasswordResetURL     string
+	// default: DefaultMagicLinkTimeout
+	MagicLinkTimeout time.Duration
+	// default: DefaultEmailVerificationTimeout
+	EmailVerificationTimeout time.Duration
+	// default: DefaultPasswordResetTimeout
+	PasswordResetTimeout time.Duration
+	// RenderEmail returns the subject and the plaintext body of the email sending a link.
+	// default: DefaultLinkEmail
+	RenderEmail func(kind LinkKind, linkURL string, expiresIn time.Duration) (subject, text string)
+	// InsecureCookie allows the browser cookie to be sent over plain HTTP, e.g. for local development.
+	// default: false
+	InsecureCookie bool
+}
+
+// Links implements the flows sending single-use links by email: passwordless logins (magic links), email
+// verifications and password resets.
+//
+// Links carry statelesstoken v2 tokens, so nothing is stored until they are used. The IDs of the used tokens
+// are then stored until they expire, so links can't be used twice. Magic links and password reset links are
+// bound to the browser which requested them with a cookie, so intercepted links are useless, and are
+// invalidated when the password of the account changes.
+type Links struct {
+	config LinksConfig
+}
+
+type linkData struct {
+	AccountID uuid.UUID `json:"account_id"`
+	Email     string    `json:"email,omitempty"`
+	// Browser is the SHA-256 hash of the secret of the browser cookie
+	Browser []byte `json:"browser,omitempty"`
+	// Password is a fingerprint of the password hash of the account when the link was issued
+	Password []byte `json:"password,omitempty"`
+}
+
+func NewLinks(config LinksConfig) (links *Links, err error) {
+	if config.Keyring == nil {
+		err = errors.New("auth: links keyring is required")
+		return
+	}
+	if config.Mailer == nil {
+		err = errors.New("auth: links mailer is required")
+		return
+	}
+	if config.From.Address == "" {
+		err = errors.New("auth: links sender address is required")
+		return
+	}
+	if config.MagicLinkTimeout <= 0 {
+		config.MagicLinkTimeout = DefaultMagicLinkTimeout
+	}
+	if config.EmailVerificationTimeout <= 0 {
+		config.EmailVerificationTimeout = DefaultEmailVerificationTimeout
+	}
+	if config.PasswordResetTimeout <= 0 {
+		config.PasswordResetTimeout = DefaultPasswordResetTimeout
+	}
+	if config.RenderEmail == nil {
+		config.RenderEmail = DefaultLinkEmail
+	}
+
+	links = &Links{config: config}
+	return
+}
+
+// DefaultLinkEmail returns the subject and the plaintext body of the default emails sending links
+func DefaultLinkEmail(kind LinkKind, linkURL string, expiresIn time.Duration) (subject, text string) {
+	var action string
+	switch kind {
+	case LinkKindMagicLink:
+		subject = "Your sign-in link"
+		action = "sign in"
+	case LinkKindEmailVerification:
+		subject = "Verify your email address"
+		action = "verify your email address"
+	default:
+		subject = "Reset your password"
+		action = "reset your password"
+	}
+
+	text = fmt.Sprintf("Please click the following link to %s:\n\n%s\n\nThis link expires in %s and can only be used once. "+
+		"If you didn't request it, you can safely ignore this email.\n", action, linkURL, expiresIn.Round(time.Minute))
+	return
+}
+
+// SendMagicLink sends a link to log in to an account without password, and sets the browser cookie.
+// The service is responsible for finding the account of the email address, and should respond the same way
+// whether it exists or not.
+func (links *Links) SendMagicLink(ctx context.Context, db db.Queryer, w http.ResponseWriter, req *http.Request, accountID uuid.UUID, to mail.Address) (err error) {
+	passwordFingerprint, err := getPasswordFingerprint(ctx, db, accountID)
+	if err != nil {
+		return
+	}
+
+	return links.sendLink(ctx, w, req, LinkKindMagicLink, to, linkData{AccountID: accountID, Password: passwordFingerprint})
+}
+
+// VerifyMagicLink verifies and consumes the token of a magic link, and returns the ID of the account to
+// create a session for.
+// VerifyMagicLink should be called within a transaction.
+func (links *Links) VerifyMagicLink(ctx context.Context, db db.Queryer, req *http.Request, token string) (accountID uuid.UUID, err error) {
+	data, err := links.useLink(ctx, db, req, LinkKindMagicLink, token)
+	if err != nil {
+		return
+	}
+
+	accountID = data.AccountID
+	return
+}
+
+// SendEmailVerification sends a link to verify that the owner of an account controls an email address.
+// Email verification links are not bound to the browser, so they can be opened on another device.
+func (links *Links) SendEmailVerification(ctx context.Context, db db.Queryer, accountID uuid.UUID, to mail.Address) (err error) {
+	_, err = GetAccount(ctx, db, accountID)
+	if err != nil {
+		return
+	}
+
+	return links.sendLink(ctx, nil, nil, LinkKindEmailVerification, to, linkData{AccountID: accountID, Email: to.Address})
+}
+
+// VerifyEmail verifies and consumes the token of an email verification link, and returns the account and
+// the verified email address.
+// VerifyEmail should be called within a transaction.
+func (links *Links) VerifyEmail(ctx context.Context, db db.Queryer, token string) (accountID uuid.UUID, emailAddress string, err error) {
+	data, err := links.useLink(ctx, db, nil, LinkKindEmailVerification, token)
+	if err != nil {
+		return
+	}
+
+	accountID = data.AccountID
+	emailAddress = data.Email
+	return
+}
+
+// SendPasswordReset sends a link to reset the password of an account, and sets the browser cookie.
+// The service is responsible for finding the account of the email address, and should respond the same way
+// whether it exists or not.
+func (links *Links) SendPasswordReset(ctx context.Context, db db.Queryer, w http.ResponseWriter, req *http.Request, accountID uuid.UUID, to mail.Address) (err error) {
+	passwordFingerprint, err := getPasswordFingerprint(ctx, db, accountID)
+	if err != nil {
+		return
+	}
+
+	return links.sendLink(ctx, w, req, LinkKindPasswordReset, to, linkData{AccountID: accountID, Password: passwordFingerprint})
+}
+
+// VerifyPasswordReset verifies the token of a password reset link without consuming it, e.g. before
+// displaying the form to choose a new password.
+func (links *Links) VerifyPasswordReset(ctx context.Context, db db.Queryer, req *http.Request, token string) (accountID uuid.UUID, err error) {
+	data, _, err := links.verifyLink(ctx, db, req, LinkKindPasswordReset, token)
+	if err != nil {
+		return
+	}
+
+	accountID = data.AccountID
+	return
+}
+
+// ResetPassword verifies and consumes the token of a password reset link, and sets the new password of the
+// account. All the sessions of the account are revoked, and the other outstanding links are invalidated.
+// ResetPassword should be called within a transaction.
+func (links *Links) ResetPassword(ctx context.Context, db db.Queryer, req *http.Request, token, newPassword string) (accountID uuid.UUID, err error) {
+	err = validatePassword(newPassword)
+	if err != nil {
+		return
+	}
+
+	data, err := links.useLink(ctx, db, req, LinkKindPasswordReset, token)
+	if err != nil {
+		return
+	}
+
+	err = SetPassword(ctx, db, data.AccountID, uuid.Nil, newPassword)
+	if err != nil {
+		return
+	}
+
+	accountID = data.AccountID
+	return
+}
+
+// DeleteExpiredUsedLinks deletes the IDs of the used links which have expired. It should be called periodically.
+func DeleteExpiredUsedLinks(ctx context.Context, db db.Queryer) (deleted int64, err error) {
+	result, err := db.Exec(ctx, "DELETE FROM auth_used_links WHERE expires_at < $1", time.Now().UTC())
+	if err != nil {
+		err = fmt.Errorf("auth: deleting expired used links: %w", err)
+		return
+	}
+
+	deleted, err = result.RowsAffected()
+	if err != nil {
+		err = fmt.Errorf("auth: deleting expired used links: %w", err)
+		return
+	}
+
+	return
+}
+
+func (links *Links) sendLink(ctx context.Context, w http.ResponseWriter, req *http.Request, kind LinkKind, to mail.Address, data linkData) (err error) {
+	baseURL, timeout := links.kindConfig(kind)
+	if baseURL == "" {
+		err = fmt.Errorf("auth: URL of %s links is not configured", kind)
+		return
+	}
+
+	if w != nil {
+		var browserSecret []byte
+		browserSecret, err = links.browserSecret(w, req, timeout)
+		if err != nil {
+			return
+		}
+		browserHash := sha256.Sum256(browserSecret)
+		data.Browser = browserHash[:]
+	}
+
+	token, err := statelesstoken.NewV2(links.config.Keyring, statelesstoken.Claims{
+		Audience: string(kind),
+		Expire:   time.Now().Add(timeout),
+	}, data)
+	if err != nil {
+		err = fmt.Errorf("auth: generating link token: %w", err)
+		return
+	}
+
+	linkURL, err := url.Parse(baseURL)
+	if err != nil {
+		err = fmt.Errorf("auth: parsing link URL: %w", err)
+		return
+	}
+	query := linkURL.Query()
+	query.Set(LinkTokenQueryParameter, token.String())
+	linkURL.RawQuery = query.Encode()
+
+	subject, text := links.config.RenderEmail(kind, linkURL.String(), timeout)
+	err = links.config.Mailer.SendTransactionnal(ctx, email.Email{
+		From:    links.config.From,
+		To:      []mail.Address{to},
+		Subject: subject,
+		Text:    []byte(text),
+	})
+	if err != nil {
+		err = fmt.Errorf("auth: sending link: %w", err)
+		return
+	}
+
+	return
+}
+
+// browserSecret returns the secret of the browser cookie, and sets the cookie if it doesn't exist.
+// The cookie is set again when it exists so that it expires after all the links bound to it, i.e. after
+// max(its remaining lifetime, timeout).
+func (links *Links) browserSecret(w http.ResponseWriter, req *http.Request, timeout time.Duration) (secret []byte, err error) {
+	expiresAt := time.Now().Add(timeout)
+
+	if cookie, cookieErr := req.Cookie(LinkBrowserCookieName); cookieErr == nil {
+		cookieSecret, cookieExpiresAt, parseErr := parseBrowserCookie(cookie.Value)
+		if parseErr == nil {
+			secret = cookieSecret
+			if cookieExpiresAt.After(expiresAt) {
+				expiresAt = cookieExpiresAt
+			}
+		}
+	}
+
+	if secret == nil {
+		secret, err = crypto.RandBytes(linkBrowserSecretSize)
+		if err != nil {
+			err = fmt.Errorf("auth: generating browser secret: %w", err)
+			return
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     LinkBrowserCookieName,
+		Value:    base64.RawURLEncoding.EncodeToString(secret) + "." + strconv.FormatInt(expiresAt.Unix(), 10),
+		Path:     "/",
+		MaxAge:   int(math.Ceil(time.Until(expiresAt).Seconds())),
+		HttpOnly: true,
+		Secure:   !links.config.InsecureCookie,
+		// Lax so the cookie is sent when the link is opened from an email client
+		SameSite: http.SameSiteLaxMode,
+	})
+	return
+}
+
+// parseBrowserCookie parses the value of the browser cookie: base64(secret).expiresAt, where expiresAt is the
+// UNIX timestamp of the expiration of the cookie, which is not sent back by browsers.
+// expiresAt is not authenticated but it's only used to extend the lifetime of the cookie.
+func parseBrowserCookie(value string) (secret []byte, expiresAt time.Time, err error) {
+	encodedSecret, encodedExpiresAt, _ := strings.Cut(value, ".")
+
+	secret, err = base64.RawURLEncoding.DecodeString(encodedSecret)
+	if err != nil || len(secret) != linkBrowserSecretSize {
+		secret = nil
+		err = errors.New("auth: browser cookie is not valid")
+		return
+	}
+
+	// the cookies set before the expiration was added only contain the secret
+	if encodedExpiresAt != "" {
+		expiresAtUnix, parseErr := strconv.ParseInt(encodedExpiresAt, 10, 64)
+		if parseErr == nil {
+			expiresAt = time.Unix(expiresAtUnix, 0)
+		}
+	}
+	return
+}
+
+// verifyLink verifies the token of a link, the browser which opened it, and that the password of the account
+// hasn't changed since the link was issued.
+func (links *Links) verifyLink(ctx context.Context, db db.Queryer, req *http.Request, kind LinkKind, tokenStr string) (data linkData, token statelesstoken.Token[linkData], err error) {
+	token, err = links.parseLink(req, kind, tokenStr)
+	if err != nil {
+		return
+	}
+	data = token.Data()
+
+	if kind != LinkKindEmailVerification {
+		var passwordFingerprint []byte
+		passwordFingerprint, err = getPasswordFingerprint(ctx, db, data.AccountID)
+		if err != nil {
+			if errors.Is(err, ErrAccountNotFound) {
+				err = ErrLinkIsNotValid
+			}
+			return
+		}
+		if !crypto.ConstantTimeCompare(passwordFingerprint, data.Password) {
+			err = fmt.Errorf("%w: password has changed", ErrLinkIsNotValid)
+			return
+		}
+	}
+
+	return
+}
+
+// parseLink verifies the signature, the kind, the expiration and the browser of the token of a link
+func (links *Links) parseLink(req *http.Request, kind LinkKind, tokenStr string) (token statelesstoken.Token[linkData], err error) {
+	token, err = statelesstoken.ParseV2[linkData](tokenStr)
+	if err != nil {
+		err = ErrLinkIsNotValid
+		return
+	}
+
+	err = token.Verify(links.config.Keyring, string(kind), 0)
+	if err != nil {
+		if errors.Is(err, statelesstoken.ErrTokenExpired) {
+			err = ErrLinkExpired
+		} else {
+			err = fmt.Errorf("%w: %w", ErrLinkIsNotValid, err)
+		}
+		return
+	}
+
+	data := token.Data()
+	if len(data.Browser) != 0 {
+		if req == nil {
+			err = ErrLinkOpenedInAnotherBrowser
+			return
+		}
+		cookie, cookieErr := req.Cookie(LinkBrowserCookieName)
+		if cookieErr != nil {
+			err = ErrLinkOpenedInAnotherBrowser
+			return
+		}
+		browserSecret, _, parseErr := parseBrowserCookie(cookie.Value)
+		browserHash := sha256.Sum256(browserSecret)
+		if parseErr != nil || !crypto.ConstantTimeCompare(browserHash[:], data.Browser) {
+			err = ErrLinkOpenedInAnotherBrowser
+			return
+		}
+	}
+
+	return
+}
+
+// useLink verifies the token of a link and marks it as used
+func (links *Links) useLink(ctx context.Context, db db.Queryer, req *http.Request, kind LinkKind, tokenStr string) (data linkData, err error) {
+	data, token, err := links.verifyLink(ctx, db, req, kind, tokenStr)
+	if err != nil {
+		return
+	}
+
+	result, err := db.Exec(ctx, `INSERT INTO auth_used_links (id, expires_at) VALUES ($1, $2)
+		ON CONFLICT (id) DO NOTHING`, token.ID(), token.Expire())
+	if err != nil {
+		err = fmt.Errorf("auth: using link: %w", err)
+		return
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		err = fmt.Errorf("auth: using link: %w", err)
+		return
+	}
+	if rowsAffected == 0 {
+		err = ErrLinkAlreadyUsed
+		return
+	}
+
+	return
+}
+
+func (links *Links) kindConfig(kind LinkKind) (baseURL string, timeout time.Duration) {
+	switch kind {
+	case LinkKindMagicLink:
+		return links.config.MagicLinkURL, links.config.MagicLinkTimeout
+	case LinkKindEmailVerification:
+		return links.config.EmailVerificationURL, links.config.EmailVerificationTimeout
+	default:
+		return links.config.PasswordResetURL, links.config.PasswordResetTimeout
+	}
+}
+
+// getPasswordFingerprint returns a truncated hash of the password hash of an account, which changes when the
+// password changes. It is empty if the account has no password.
+func getPasswordFingerprint(ctx context.Context, db db.Queryer, accountID uuid.UUID) (fingerprint []byte, err error) {
+	_, err = GetAccount(ctx, db, accountID)
+	if err != nil {
+		return
+	}
+
+	var passwordHash string
+	err = db.Get(ctx, &passwordHash, "SELECT hash FROM auth_passwords WHERE account_id = $1", accountID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = nil
+		} else {
+			err = fmt.Errorf("auth: getting password hash: %w", err)
+		}
+		return
+	}
+
+	hash := sha256.Sum256([]byte(passwordHash))
+	fingerprint = hash[:linkPasswordFingerprintSize]
+	return
+}
@@ -0,0 +1,372 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/bloom42/stdx/crypto"
+	"github.com/bloom42/stdx/db/dbtest"
+	"github.com/bloom42/stdx/email"
+	"github.com/bloom42/stdx/statelesstoken"
+	"github.com/bloom42/stdx/uuid"
+)
+
+type fakeMailer struct {
+	emails []email.Email
+}
+
+func (mailer *fakeMailer) SendTransactionnal(ctx context.Context, email email.Email) error {
+	mailer.emails = append(mailer.emails, email)
+	return nil
+}
+
+func (mailer *fakeMailer) SendBroadcast(ctx context.Context, email email.Email) error {
+	return errors.New("broadcast emails are not supported")
+}
+
+var linkURLRegexp = regexp.MustCompile(`https://\S+`)
+
+func newTestLinks(t *testing.T) (links *Links, mailer *fakeMailer) {
+	key, err := crypto.RandBytes(crypto.KeySize256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := crypto.NewKeyring(map[crypto.KeyID][]byte{1: key}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mailer = &fakeMailer{}
+	links, err = NewLinks(LinksConfig{
+		Keyring:              keyring,
+		Mailer:               mailer,
+		From:                 mail.Address{Name: "Example", Address: "noreply@example.com"},
+		MagicLinkURL:         "https://example.com/login/verify?lang=en",
+		EmailVerificationURL: "https://example.com/email/verify",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// lastLinkToken returns the token of the link sent in the last email
+func lastLinkToken(t *testing.T, mailer *fakeMailer) string {
+	t.Helper()
+
+	if len(mailer.emails) == 0 {
+		t.Fatal("no email has been sent")
+	}
+	linkURL, err := url.Parse(linkURLRegexp.FindString(string(mailer.emails[len(mailer.emails)-1].Text)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return linkURL.Query().Get(LinkTokenQueryParameter)
+}
+
+func TestLinksBrowserBinding(t *testing.T) {
+	ctx := context.Background()
+	links, mailer := newTestLinks(t)
+	to := mail.Address{Address: "user@example.com"}
+	data := linkData{AccountID: uuid.New()}
+
+	res := httptest.NewRecorder()
+	err := links.sendLink(ctx, res, httptest.NewRequest(http.MethodPost, "/login", nil), LinkKindMagicLink, to, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sentEmail := mailer.emails[0]
+	if len(sentEmail.To) != 1 || sentEmail.To[0].Address != to.Address || sentEmail.Subject == "" {
+		t.Errorf("email is not valid: %+v", sentEmail)
+	}
+	cookies := res.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != LinkBrowserCookieName || !cookies[0].HttpOnly || !cookies[0].Secure {
+		t.Fatalf("browser cookie is not valid: %+v", cookies)
+	}
+	browserCookie := cookies[0]
+	token := lastLinkToken(t, mailer)
+
+	req := httptest.NewRequest(http.MethodGet, "/login/verify", nil)
+	req.AddCookie(browserCookie)
+	parsedToken, err := links.parseLink(req, LinkKindMagicLink, token)
+	if err != nil {
+		t.Fatalf("same browser: %v", err)
+	}
+	if parsedToken.Data().AccountID != data.AccountID {
+		t.Errorf("account ID. expected: %s | got: %s", data.AccountID, parsedToken.Data().AccountID)
+	}
+
+	_, err = links.parseLink(httptest.NewRequest(http.MethodGet, "/login/verify", nil), LinkKindMagicLink, token)
+	if !errors.Is(err, ErrLinkOpenedInAnotherBrowser) {
+		t.Errorf("without cookie. expected: %v | got: %v", ErrLinkOpenedInAnotherBrowser, err)
+	}
+
+	otherBrowserReq := httptest.NewRequest(http.MethodGet, "/login/verify", nil)
+	otherBrowserReq.AddCookie(&http.Cookie{Name: LinkBrowserCookieName, Value: "b3RoZXIgYnJvd3Nlcg"})
+	_, err = links.parseLink(otherBrowserReq, LinkKindMagicLink, token)
+	if !errors.Is(err, ErrLinkOpenedInAnotherBrowser) {
+		t.Errorf("other browser. expected: %v | got: %v", ErrLinkOpenedInAnotherBrowser, err)
+	}
+
+	// the secret of the browser is reused for the next links, and the cookie is extended to outlive them
+	res = httptest.NewRecorder()
+	err = links.sendLink(ctx, res, req, LinkKindEmailVerification, to, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies = res.Result().Cookies()
+	if len(cookies) != 1 || !sameBrowserSecret(t, cookies[0], browserCookie) {
+		t.Fatalf("browser cookie has been replaced: %+v", cookies)
+	}
+	if maxAge := time.Duration(cookies[0].MaxAge) * time.Second; maxAge < DefaultEmailVerificationTimeout-time.Second {
+		t.Errorf("max age. expected: %s | got: %s", DefaultEmailVerificationTimeout, maxAge)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/email/verify", nil)
+	req.AddCookie(cookies[0])
+	_, err = links.parseLink(req, LinkKindEmailVerification, lastLinkToken(t, mailer))
+	if err != nil {
+		t.Errorf("second link: %v", err)
+	}
+	_, err = links.parseLink(req, LinkKindMagicLink, token)
+	if err != nil {
+		t.Errorf("first link after the second one: %v", err)
+	}
+
+	// a link with a shorter timeout doesn't shorten the lifetime of the cookie
+	res = httptest.NewRecorder()
+	err = links.sendLink(ctx, res, req, LinkKindMagicLink, to, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies = res.Result().Cookies()
+	if len(cookies) != 1 || !sameBrowserSecret(t, cookies[0], browserCookie) {
+		t.Fatalf("browser cookie has been replaced: %+v", cookies)
+	}
+	if maxAge := time.Duration(cookies[0].MaxAge) * time.Second; maxAge < DefaultEmailVerificationTimeout-time.Minute {
+		t.Errorf("max age. expected: %s | got: %s", DefaultEmailVerificationTimeout, maxAge)
+	}
+
+	// cookies without expiration are still valid
+	browserSecret, _, err := parseBrowserCookie(browserCookie.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/login/verify", nil)
+	req.AddCookie(&http.Cookie{Name: LinkBrowserCookieName, Value: base64.RawURLEncoding.EncodeToString(browserSecret)})
+	_, err = links.parseLink(req, LinkKindMagicLink, token)
+	if err != nil {
+		t.Errorf("cookie without expiration: %v", err)
+	}
+}
+
+func sameBrowserSecret(t *testing.T, cookie, otherCookie *http.Cookie) bool {
+	secret, _, err := parseBrowserCookie(cookie.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSecret, _, err := parseBrowserCookie(otherCookie.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Equal(secret, otherSecret)
+}
+
+func TestLinksErrors(t *testing.T) {
+	ctx := context.Background()
+	links, mailer := newTestLinks(t)
+	to := mail.Address{Address: "user@example.com"}
+
+	err := links.sendLink(ctx, nil, nil, LinkKindEmailVerification, to, linkData{AccountID: uuid.New(), Email: to.Address})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := lastLinkToken(t, mailer)
+
+	// email verification links are not bound to the browser
+	parsedToken, err := links.parseLink(nil, LinkKindEmailVerification, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsedToken.Data().Email != to.Address {
+		t.Errorf("email. expected: %s | got: %s", to.Address, parsedToken.Data().Email)
+	}
+
+	// links can't be used for another kind of link
+	_, err = links.parseLink(nil, LinkKindPasswordReset, token)
+	if !errors.Is(err, ErrLinkIsNotValid) {
+		t.Errorf("other kind. expected: %v | got: %v", ErrLinkIsNotValid, err)
+	}
+
+	_, err = links.parseLink(nil, LinkKindEmailVerification, token[:len(token)-2])
+	if !errors.Is(err, ErrLinkIsNotValid) {
+		t.Errorf("altered token. expected: %v | got: %v", ErrLinkIsNotValid, err)
+	}
+
+	expiredToken, err := statelesstoken.NewV2(links.config.Keyring, statelesstoken.Claims{
+		Audience:  string(LinkKindEmailVerification),
+		NotBefore: time.Now().Add(-2 * time.Hour),
+		Expire:    time.Now().Add(-time.Hour),
+	}, linkData{AccountID: uuid.New()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = links.parseLink(nil, LinkKindEmailVerification, expiredToken.String())
+	if !errors.Is(err, ErrLinkExpired) {
+		t.Errorf("expired link. expected: %v | got: %v", ErrLinkExpired, err)
+	}
+
+	// the URL of password reset links is not configured
+	err = links.sendLink(ctx, nil, nil, LinkKindPasswordReset, to, linkData{AccountID: uuid.New()})
+	if err == nil {
+		t.Error("sending a link without URL should fail")
+	}
+
+	_, err = NewLinks(LinksConfig{Keyring: links.config.Keyring, Mailer: mailer})
+	if err == nil {
+		t.Error("creating links without sender should fail")
+	}
+}
+
+func TestLinksWithDatabase(t *testing.T) {
+	database := dbtest.New(t, Migrations(1))
+	ctx := context.Background()
+	links, mailer := newTestLinks(t)
+	links.config.PasswordResetURL = "https://example.com/password/reset"
+	to := mail.Address{Address: "user@example.com"}
+	accountID := uuid.New()
+	password := "correct horse battery staple"
+
+	err := CreateAccount(ctx, database, accountID, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sendLink sends a link and returns a request from the browser which requested it
+	sendLink := func(kind LinkKind) (req *http.Request, token string) {
+		t.Helper()
+
+		res := httptest.NewRecorder()
+		var err error
+		if kind == LinkKindMagicLink {
+			err = links.SendMagicLink(ctx, database, res, httptest.NewRequest(http.MethodPost, "/login", nil), accountID, to)
+		} else {
+			err = links.SendPasswordReset(ctx, database, res, httptest.NewRequest(http.MethodPost, "/password/forgot", nil), accountID, to)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req = httptest.NewRequest(http.MethodGet, "/verify", nil)
+		for _, cookie := range res.Result().Cookies() {
+			req.AddCookie(cookie)
+		}
+		token = lastLinkToken(t, mailer)
+		return
+	}
+
+	// magic links can only be used once
+	req, token := sendLink(LinkKindMagicLink)
+	verifiedAccountID, err := links.VerifyMagicLink(ctx, database, req, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verifiedAccountID != accountID {
+		t.Errorf("account ID. expected: %s | got: %s", accountID, verifiedAccountID)
+	}
+	_, err = links.VerifyMagicLink(ctx, database, req, token)
+	if !errors.Is(err, ErrLinkAlreadyUsed) {
+		t.Errorf("reused magic link. expected: %v | got: %v", ErrLinkAlreadyUsed, err)
+	}
+
+	// so are email verification links
+	err = links.SendEmailVerification(ctx, database, accountID, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token = lastLinkToken(t, mailer)
+	_, verifiedEmail, err := links.VerifyEmail(ctx, database, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verifiedEmail != to.Address {
+		t.Errorf("email. expected: %s | got: %s", to.Address, verifiedEmail)
+	}
+	_, _, err = links.VerifyEmail(ctx, database, token)
+	if !errors.Is(err, ErrLinkAlreadyUsed) {
+		t.Errorf("reused email verification link. expected: %v | got: %v", ErrLinkAlreadyUsed, err)
+	}
+
+	// password reset links are not consumed by VerifyPasswordReset, nor by an invalid new password
+	_, sessionTokens, err := CreateSession(ctx, database, accountID, SessionOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, token = sendLink(LinkKindPasswordReset)
+	for i := 0; i < 2; i += 1 {
+		_, err = links.VerifyPasswordReset(ctx, database, req, token)
+		if err != nil {
+			t.Fatalf("verifying password reset link: %v", err)
+		}
+	}
+	_, err = links.ResetPassword(ctx, database, req, token, "short")
+	if !errors.Is(err, ErrPasswordIsTooShort) {
+		t.Errorf("short password. expected: %v | got: %v", ErrPasswordIsTooShort, err)
+	}
+
+	newPassword := "another correct horse battery staple"
+	verifiedAccountID, err = links.ResetPassword(ctx, database, req, token, newPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verifiedAccountID != accountID {
+		t.Errorf("account ID. expected: %s | got: %s", accountID, verifiedAccountID)
+	}
+	err = VerifyPassword(ctx, database, accountID, newPassword)
+	if err != nil {
+		t.Errorf("new password: %v", err)
+	}
+	_, err = VerifySession(ctx, database, sessionTokens.Token)
+	if !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("session after password reset. expected: %v | got: %v", ErrSessionNotFound, err)
+	}
+	_, err = links.ResetPassword(ctx, database, req, token, "yet another correct horse battery staple")
+	if !errors.Is(err, ErrLinkIsNotValid) {
+		t.Errorf("reused password reset link. expected: %v | got: %v", ErrLinkIsNotValid, err)
+	}
+
+	// the outstanding links are invalidated when the password changes
+	magicLinkReq, magicLinkToken := sendLink(LinkKindMagicLink)
+	passwordResetReq, passwordResetToken := sendLink(LinkKindPasswordReset)
+	err = ChangePassword(ctx, database, accountID, uuid.Nil, newPassword, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = links.VerifyMagicLink(ctx, database, magicLinkReq, magicLinkToken)
+	if !errors.Is(err, ErrLinkIsNotValid) {
+		t.Errorf("magic link after ChangePassword. expected: %v | got: %v", ErrLinkIsNotValid, err)
+	}
+	_, err = links.VerifyPasswordReset(ctx, database, passwordResetReq, passwordResetToken)
+	if !errors.Is(err, ErrLinkIsNotValid) {
+		t.Errorf("password reset link after ChangePassword. expected: %v | got: %v", ErrLinkIsNotValid, err)
+	}
+
+	magicLinkReq, magicLinkToken = sendLink(LinkKindMagicLink)
+	err = SetPassword(ctx, database, accountID, uuid.Nil, newPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = links.VerifyMagicLink(ctx, database, magicLinkReq, magicLinkToken)
+	if !errors.Is(err, ErrLinkIsNotValid) {
+		t.Errorf("magic link after SetPassword. expected: %v | got: %v", ErrLinkIsNotValid, err)
+	}
+}
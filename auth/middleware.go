@@ -0,0 +1,262 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/bloom42/stdx/db"
+	"github.com/bloom42/stdx/httpx"
+	"github.com/bloom42/stdx/log/slogx"
+	"github.com/bloom42/stdx/uuid"
+)
+
+const DefaultSessionCookieName = "session"
+
+type principalContextKey struct{}
+
+var principalCtxKey = principalContextKey{}
+
+// Principal is the authenticated account of a request, with the session or the API key used
+type Principal struct {
+	AccountID uuid.UUID
+	// Session is set if the request is authenticated with a session token
+	Session *Session
+	// ApiKey is set if the request is authenticated with an API key
+	ApiKey *ApiKey
+}
+
+// HasScope returns true if the principal is allowed to perform actions requiring scope.
+// Sessions have all the scopes, while API keys only have the scopes they were created with. Session cookies
+// can't be used by cross-origin requests with unsafe methods (see Middleware), so they can't be used to perform
+// scoped actions on behalf of the user from other websites.
+func (principal *Principal) HasScope(scope string) bool {
+	if principal.ApiKey != nil {
+		return principal.ApiKey.HasScope(scope)
+	}
+	return principal.Session != nil
+}
+
+// MiddlewareConfig is the configuration of Middleware
+type MiddlewareConfig struct {
+	// DB is used to verify sessions and API keys. Required.
+	DB db.Queryer
+	// SessionCookieName is the name of the cookie containing the session token.
+	// default: DefaultSessionCookieName
+	SessionCookieName string
+	// TrustedOrigins are the origins, e.g. https://app.example.com, other than the origin of the server, which are
+	// allowed to send requests with unsafe methods authenticated with the session cookie.
+	// default: none
+	TrustedOrigins []string
+}
+
+type errorResponse struct {
+	Error errorResponseBody `json:"error"`
+}
+
+type errorResponseBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PrincipalToCtx returns a copy of ctx with principal associated.
+func PrincipalToCtx(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalCtxKey, principal)
+}
+
+// PrincipalFromCtx returns the principal associated with ctx, or nil if the request is not authenticated.
+func PrincipalFromCtx(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalCtxKey).(*Principal)
+	return principal
+}
+
+// Middleware authenticates requests with either an "Authorization: Bearer <token>" header, containing an API
+// key or a session token, or a session cookie. The Principal can then be retrieved with PrincipalFromCtx, and
+// is added to the attributes of the slogx logger of the request.
+//
+// Requests without credentials, or with an expired session cookie, are passed to next unauthenticated: use
+// RequireAuth to reject them. Requests with an invalid Authorization header are rejected with a 401 error.
+//
+// As browsers send cookies with cross-site requests, cross-origin requests with an unsafe method (e.g. POST)
+// authenticated with the session cookie are rejected with a 403 error to protect against CSRF. The origin of a
+// request is checked with the Sec-Fetch-Site and Origin headers: requests without these headers don't come from
+// a browser, and are allowed. Safe methods (GET, HEAD, OPTIONS) must not have side effects.
+// The session cookie should be HttpOnly, Secure, and SameSite=Lax (or Strict) as a defense in depth, and for
+// the old browsers which don't send the Origin header.
+func Middleware(config MiddlewareConfig) func(next http.Handler) http.Handler {
+	if config.SessionCookieName == "" {
+		config.SessionCookieName = DefaultSessionCookieName
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, req *http.Request) {
+			ctx := req.Context()
+			var principal *Principal
+			var err error
+
+			if authorizationHeader := req.Header.Get(httpx.HeaderAuthorization); authorizationHeader != "" {
+				token, isBearer := strings.CutPrefix(authorizationHeader, "Bearer ")
+				if !isBearer {
+					writeErrorResponse(w, http.StatusUnauthorized, "unauthorized", "Authorization header must use the Bearer scheme")
+					return
+				}
+
+				principal, err = authenticateToken(ctx, config.DB, strings.TrimSpace(token))
+				if err != nil {
+					handleAuthenticationError(w, req, err)
+					return
+				}
+			} else if cookie, cookieErr := req.Cookie(config.SessionCookieName); cookieErr == nil && cookie.Value != "" {
+				if isCrossOriginUnsafeRequest(req, config.TrustedOrigins) {
+					writeErrorResponse(w, http.StatusForbidden, "forbidden", "Cross-origin requests can't be authenticated with a session cookie")
+					return
+				}
+
+				var session Session
+				session, err = VerifySession(ctx, config.DB, cookie.Value)
+				if err == nil {
+					principal = &Principal{AccountID: session.AccountID, Session: &session}
+				} else if !errors.Is(err, ErrSessionNotFound) && !errors.Is(err, ErrSessionExpired) {
+					handleAuthenticationError(w, req, err)
+					return
+				}
+			}
+
+			if principal != nil {
+				ctx = PrincipalToCtx(ctx, principal)
+				ctx = slogx.ToCtx(ctx, slogx.FromCtx(ctx).With(principal.logAttributes()))
+				req = req.WithContext(ctx)
+			}
+
+			next.ServeHTTP(w, req)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// RequireAuth rejects the requests which are not authenticated with a 401 error
+func RequireAuth(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		if PrincipalFromCtx(req.Context()) == nil {
+			writeErrorResponse(w, http.StatusUnauthorized, "unauthorized", "Authentication is required")
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// RequireScope rejects the requests which are not authenticated with a 401 error, and the requests whose
+// principal doesn't have scope with a 403 error.
+func RequireScope(scope string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, req *http.Request) {
+			principal := PrincipalFromCtx(req.Context())
+			if principal == nil {
+				writeErrorResponse(w, http.StatusUnauthorized, "unauthorized", "Authentication is required")
+				return
+			}
+
+			if !principal.HasScope(scope) {
+				writeErrorResponse(w, http.StatusForbidden, "forbidden", "Missing scope: "+scope)
+				return
+			}
+
+			next.ServeHTTP(w, req)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+func authenticateToken(ctx context.Context, db db.Queryer, token string) (principal *Principal, err error) {
+	switch {
+	case strings.HasPrefix(token, ApiKeyTokenPrefix):
+		var apiKey ApiKey
+		apiKey, err = VerifyApiKey(ctx, db, token)
+		if err != nil {
+			return
+		}
+		principal = &Principal{AccountID: apiKey.AccountID, ApiKey: &apiKey}
+
+	case strings.HasPrefix(token, SessionTokenPrefix):
+		var session Session
+		session, err = VerifySession(ctx, db, token)
+		if err != nil {
+			return
+		}
+		principal = &Principal{AccountID: session.AccountID, Session: &session}
+
+	default:
+		err = ErrApiKeyNotFound
+	}
+
+	return
+}
+
+// isCrossOriginUnsafeRequest returns true if req has an unsafe method and has been sent by a browser from an
+// origin other than the origin of the server and trustedOrigins.
+func isCrossOriginUnsafeRequest(req *http.Request, trustedOrigins []string) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+
+	origin := req.Header.Get("Origin")
+	if slices.Contains(trustedOrigins, origin) {
+		return false
+	}
+
+	switch req.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		// "none" is for the requests initiated by the user, e.g. by opening a bookmark
+		return false
+	case "":
+		// older browsers don't send Sec-Fetch-Site, so we fall back to comparing the Origin header with the host
+		if origin == "" {
+			return false
+		}
+		originURL, err := url.Parse(origin)
+		return err != nil || originURL.Host != req.Host
+	default:
+		return true
+	}
+}
+
+func handleAuthenticationError(w http.ResponseWriter, req *http.Request, err error) {
+	switch {
+	case errors.Is(err, ErrApiKeyExpired), errors.Is(err, ErrSessionExpired):
+		writeErrorResponse(w, http.StatusUnauthorized, "unauthorized", "Credentials have expired")
+	case errors.Is(err, ErrApiKeyNotFound), errors.Is(err, ErrSessionNotFound):
+		writeErrorResponse(w, http.StatusUnauthorized, "unauthorized", "Credentials are not valid")
+	default:
+		slogx.FromCtx(req.Context()).Error("auth: authenticating request", slogx.Err(err))
+		writeErrorResponse(w, http.StatusInternalServerError, "internal", "Internal error")
+	}
+}
+
+func (principal *Principal) logAttributes() slog.Attr {
+	attributes := []any{slog.String("account_id", principal.AccountID.String())}
+	if principal.Session != nil {
+		attributes = append(attributes, slog.String("session_id", principal.Session.ID.String()))
+	}
+	if principal.ApiKey != nil {
+		attributes = append(attributes, slog.String("api_key_id", principal.ApiKey.ID.String()))
+	}
+	return slog.Group("auth", attributes...)
+}
+
+func writeErrorResponse(w http.ResponseWriter, statusCode int, code, message string) {
+	if statusCode == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+	}
+	w.Header().Set(httpx.HeaderContentType, httpx.MediaTypeJson)
+	w.Header().Set(httpx.HeaderCacheControl, httpx.CacheControlNoCache)
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(errorResponse{Error: errorResponseBody{Code: code, Message: message}})
+}
@@ -0,0 +1,208 @@
+package auth_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bloom42/stdx/auth"
+	"github.com/bloom42/stdx/token"
+	"github.com/bloom42/stdx/uuid"
+)
+
+type middlewareErrorResponse struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func serveWithPrincipal(handler http.Handler, principal *auth.Principal, req *http.Request) *httptest.ResponseRecorder {
+	if principal != nil {
+		req = req.WithContext(auth.PrincipalToCtx(req.Context(), principal))
+	}
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	return res
+}
+
+func checkErrorResponse(t *testing.T, res *httptest.ResponseRecorder, expectedStatus int, expectedCode string) {
+	t.Helper()
+
+	if res.Code != expectedStatus {
+		t.Errorf("status. expected: %d | got: %d", expectedStatus, res.Code)
+	}
+	if contentType := res.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("content type. expected: application/json | got: %s", contentType)
+	}
+
+	var body middlewareErrorResponse
+	err := json.Unmarshal(res.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if body.Error.Code != expectedCode || body.Error.Message == "" {
+		t.Errorf("error. expected code: %s | got: %+v", expectedCode, body.Error)
+	}
+}
+
+func TestMiddlewareWithoutDatabase(t *testing.T) {
+	var nextCalled bool
+	var nextPrincipal *auth.Principal
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		nextCalled = true
+		nextPrincipal = auth.PrincipalFromCtx(req.Context())
+	})
+	handler := auth.Middleware(auth.MiddlewareConfig{})(next)
+
+	// requests without credentials are anonymous
+	nextCalled = false
+	res := serveWithPrincipal(handler, nil, httptest.NewRequest(http.MethodGet, "/", nil))
+	if res.Code != http.StatusOK || !nextCalled || nextPrincipal != nil {
+		t.Errorf("anonymous request. status: %d | next called: %v | principal: %v", res.Code, nextCalled, nextPrincipal)
+	}
+
+	// malformed session cookies are ignored
+	nextCalled = false
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: auth.DefaultSessionCookieName, Value: "not_a_session"})
+	res = serveWithPrincipal(handler, nil, req)
+	if res.Code != http.StatusOK || !nextCalled || nextPrincipal != nil {
+		t.Errorf("malformed cookie. status: %d | next called: %v | principal: %v", res.Code, nextCalled, nextPrincipal)
+	}
+
+	expiredApiKey, err := token.NewWithOptions(auth.ApiKeyTokenPrefix, token.Options{
+		Checksum:  true,
+		ExpiresAt: time.Now().Add(-time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// invalid Authorization headers are rejected
+	for _, authorization := range []string{
+		"Basic dXNlcjpwYXNzd29yZA==",
+		"Bearer ",
+		"Bearer not_a_token",
+		"Bearer key_invalid",
+		"Bearer ses_invalid",
+		"Bearer " + expiredApiKey.String(),
+	} {
+		nextCalled = false
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", authorization)
+		res := serveWithPrincipal(handler, nil, req)
+		checkErrorResponse(t, res, http.StatusUnauthorized, "unauthorized")
+		if nextCalled {
+			t.Errorf("next was called for Authorization: %s", authorization)
+		}
+		if res.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("WWW-Authenticate header is missing for Authorization: %s", authorization)
+		}
+	}
+}
+
+func TestRequireAuth(t *testing.T) {
+	handler := auth.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+	res := serveWithPrincipal(handler, nil, httptest.NewRequest(http.MethodGet, "/", nil))
+	checkErrorResponse(t, res, http.StatusUnauthorized, "unauthorized")
+
+	principal := &auth.Principal{AccountID: uuid.New(), Session: &auth.Session{}}
+	res = serveWithPrincipal(handler, principal, httptest.NewRequest(http.MethodGet, "/", nil))
+	if res.Code != http.StatusOK {
+		t.Errorf("authenticated request. expected: %d | got: %d", http.StatusOK, res.Code)
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	handler := auth.RequireScope("billing:write")(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	accountID := uuid.New()
+
+	tests := []struct {
+		name           string
+		principal      *auth.Principal
+		expectedStatus int
+		expectedCode   string
+	}{
+		{"anonymous", nil, http.StatusUnauthorized, "unauthorized"},
+		{"session", &auth.Principal{AccountID: accountID, Session: &auth.Session{}}, http.StatusOK, ""},
+		{"api key with scope", &auth.Principal{AccountID: accountID, ApiKey: &auth.ApiKey{
+			Scopes: auth.Scopes{"billing:read", "billing:write"},
+		}}, http.StatusOK, ""},
+		{"api key without scope", &auth.Principal{AccountID: accountID, ApiKey: &auth.ApiKey{
+			Scopes: auth.Scopes{"billing:read"},
+		}}, http.StatusForbidden, "forbidden"},
+	}
+
+	for _, test := range tests {
+		res := serveWithPrincipal(handler, test.principal, httptest.NewRequest(http.MethodGet, "/", nil))
+		if test.expectedCode == "" {
+			if res.Code != test.expectedStatus {
+				t.Errorf("%s. expected: %d | got: %d", test.name, test.expectedStatus, res.Code)
+			}
+		} else {
+			checkErrorResponse(t, res, test.expectedStatus, test.expectedCode)
+		}
+	}
+}
+
+func TestMiddlewareCrossOriginCookie(t *testing.T) {
+	var nextCalled bool
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		nextCalled = true
+	})
+	handler := auth.Middleware(auth.MiddlewareConfig{
+		TrustedOrigins: []string{"https://app.example.com"},
+	})(next)
+
+	// httptest requests have the host example.com. The session cookie is malformed, so the requests which are
+	// not rejected are passed to next unauthenticated.
+	tests := []struct {
+		name          string
+		method        string
+		headers       map[string]string
+		expectedError bool
+	}{
+		{"without browser headers", http.MethodPost, nil, false},
+		{"same origin", http.MethodPost, map[string]string{"Sec-Fetch-Site": "same-origin", "Origin": "http://example.com"}, false},
+		{"user initiated", http.MethodPost, map[string]string{"Sec-Fetch-Site": "none"}, false},
+		{"same site", http.MethodPost, map[string]string{"Sec-Fetch-Site": "same-site", "Origin": "https://evil.example.com"}, true},
+		{"cross site", http.MethodDelete, map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": "https://evil.com"}, true},
+		{"cross site GET", http.MethodGet, map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": "https://evil.com"}, false},
+		{"trusted origin", http.MethodPost, map[string]string{"Sec-Fetch-Site": "same-site", "Origin": "https://app.example.com"}, false},
+		{"same origin without Sec-Fetch-Site", http.MethodPost, map[string]string{"Origin": "http://example.com"}, false},
+		{"cross origin without Sec-Fetch-Site", http.MethodPut, map[string]string{"Origin": "https://evil.com"}, true},
+		{"null origin without Sec-Fetch-Site", http.MethodPost, map[string]string{"Origin": "null"}, true},
+	}
+
+	for _, test := range tests {
+		nextCalled = false
+		req := httptest.NewRequest(test.method, "/", nil)
+		req.AddCookie(&http.Cookie{Name: auth.DefaultSessionCookieName, Value: "not_a_session"})
+		for key, value := range test.headers {
+			req.Header.Set(key, value)
+		}
+		res := serveWithPrincipal(handler, nil, req)
+
+		if test.expectedError {
+			checkErrorResponse(t, res, http.StatusForbidden, "forbidden")
+			if nextCalled {
+				t.Errorf("%s: next was called", test.name)
+			}
+		} else if res.Code != http.StatusOK || !nextCalled {
+			t.Errorf("%s. status: %d | next called: %v", test.name, res.Code, nextCalled)
+		}
+	}
+
+	// requests without the session cookie are not checked
+	nextCalled = false
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("Sec-Fetch-Site", "cross-site")
+	res := serveWithPrincipal(handler, nil, req)
+	if res.Code != http.StatusOK || !nextCalled {
+		t.Errorf("cross-site request without cookie. status: %d | next called: %v", res.Code, nextCalled)
+	}
+}
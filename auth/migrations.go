@@ -0,0 +1,253 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/bloom42/stdx/db"
+	"github.com/bloom42/stdx/migrate"
+)
+
+// MigrationsIDRange is the number of migration IDs reserved for the auth package: the IDs of the
+// migrations returned by Migrations are always between firstID and firstID + MigrationsIDRange - 1.
+// New migrations are only ever appended, so the range must not be used by other migrations.
+const MigrationsIDRange = 100
+
+// Migrations returns the migrations creating the tables used by the package, with IDs starting at firstID
+func Migrations(firstID int64) []migrate.Migration {
+	migrations := make([]migrate.Migration, len(migrationsSQL))
+	for i, migration := range migrationsSQL {
+		up := migration.up
+		down := migration.down
+		migrations[i] = migrate.Migration{
+			ID: firstID + int64(i),
+			Up: func(ctx context.Context, tx db.Queryer) (err error) {
+				for _, query := range up {
+					_, err = tx.Exec(ctx, query)
+					if err != nil {
+						return
+					}
+				}
+				return
+			},
+			Down: func(ctx context.Context, tx db.Queryer) (err error) {
+				for _, query := range down {
+					_, err = tx.Exec(ctx, query)
+					if err != nil {
+						return
+					}
+				}
+				return
+			},
+		}
+	}
+
+	return migrations
+}
+
+// migrationsSQL MUST only be appended to, as migrations may already have been applied
+var migrationsSQL = []struct {
+	up   []string
+	down []string
+}{
+	{
+		up: []string{
+			`CREATE TABLE auth_accounts (
+				id UUID PRIMARY KEY,
+				created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+				updated_at TIMESTAMP WITH TIME ZONE NOT NULL
+			)`,
+		},
+		down: []string{"DROP TABLE auth_accounts"},
+	},
+	{
+		up: []string{
+			`CREATE TABLE auth_passwords (
+				account_id UUID PRIMARY KEY REFERENCES auth_accounts (id) ON DELETE CASCADE,
+				created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+				updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+				hash TEXT NOT NULL
+			)`,
+		},
+		down: []string{"DROP TABLE auth_passwords"},
+	},
+	{
+		up: []string{
+			`CREATE TABLE auth_sessions (
+				id UUID PRIMARY KEY,
+				created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+				updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+				account_id UUID NOT NULL REFERENCES auth_accounts (id) ON DELETE CASCADE,
+				token_hash BYTEA NOT NULL
+			)`,
+			`CREATE INDEX index_auth_sessions_on_account_id ON auth_sessions (account_id)`,
+		},
+		down: []string{"DROP TABLE auth_sessions"},
+	},
+	{
+		up: []string{
+			`CREATE TABLE auth_api_keys (
+				id UUID PRIMARY KEY,
+				created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+				updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+				account_id UUID NOT NULL REFERENCES auth_accounts (id) ON DELETE CASCADE,
+				name TEXT NOT NULL,
+				token_hash BYTEA NOT NULL
+			)`,
+			`CREATE INDEX index_auth_api_keys_on_account_id ON auth_api_keys (account_id)`,
+		},
+		down: []string{"DROP TABLE auth_api_keys"},
+	},
+	{
+		up: []string{
+			`ALTER TABLE auth_sessions
+				ADD COLUMN refresh_token_hash BYTEA NOT NULL DEFAULT ''::bytea,
+				ADD COLUMN expires_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+				ADD COLUMN idle_expires_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+				ADD COLUMN idle_timeout BIGINT NOT NULL DEFAULT 0,
+				ADD COLUMN last_active_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+				ADD COLUMN ip_address TEXT NOT NULL DEFAULT '',
+				ADD COLUMN user_agent TEXT NOT NULL DEFAULT '',
+				ADD COLUMN country TEXT NOT NULL DEFAULT '',
+				ADD COLUMN city TEXT NOT NULL DEFAULT ''`,
+			`CREATE INDEX index_auth_sessions_on_expires_at ON auth_sessions (expires_at)`,
+			`CREATE TABLE auth_session_used_refresh_tokens (
+				hash BYTEA PRIMARY KEY,
+				session_id UUID NOT NULL REFERENCES auth_sessions (id) ON DELETE CASCADE,
+				used_at TIMESTAMP WITH TIME ZONE NOT NULL
+			)`,
+			`CREATE INDEX index_auth_session_used_refresh_tokens_on_session_id ON auth_session_used_refresh_tokens (session_id)`,
+		},
+		down: []string{
+			"DROP TABLE auth_session_used_refresh_tokens",
+			`ALTER TABLE auth_sessions
+				DROP COLUMN refresh_token_hash,
+				DROP COLUMN expires_at,
+				DROP COLUMN idle_expires_at,
+				DROP COLUMN idle_timeout,
+				DROP COLUMN last_active_at,
+				DROP COLUMN ip_address,
+				DROP COLUMN user_agent,
+				DROP COLUMN country,
+				DROP COLUMN city`,
+		},
+	},
+	{
+		up: []string{
+			`ALTER TABLE auth_api_keys
+				ADD COLUMN scopes JSONB NOT NULL DEFAULT '[]'::jsonb,
+				ADD COLUMN expires_at TIMESTAMP WITH TIME ZONE,
+				ADD COLUMN last_used_at TIMESTAMP WITH TIME ZONE`,
+		},
+		down: []string{
+			`ALTER TABLE auth_api_keys
+				DROP COLUMN scopes,
+				DROP COLUMN expires_at,
+				DROP COLUMN last_used_at`,
+		},
+	},
+	{
+		up: []string{
+			`CREATE TABLE auth_totp (
+				account_id UUID PRIMARY KEY REFERENCES auth_accounts (id) ON DELETE CASCADE,
+				created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+				updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+				encrypted_secret BYTEA NOT NULL,
+				enabled_at TIMESTAMP WITH TIME ZONE,
+				last_used_step BIGINT NOT NULL DEFAULT 0
+			)`,
+			`CREATE TABLE auth_recovery_codes (
+				account_id UUID NOT NULL REFERENCES auth_accounts (id) ON DELETE CASCADE,
+				hash BYTEA NOT NULL,
+				created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+				PRIMARY KEY (account_id, hash)
+			)`,
+		},
+		down: []string{
+			"DROP TABLE auth_recovery_codes",
+			"DROP TABLE auth_totp",
+		},
+	},
+	{
+		up: []string{
+			`CREATE TABLE auth_webauthn_credentials (
+				id BYTEA PRIMARY KEY,
+				created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+				updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+				account_id UUID NOT NULL REFERENCES auth_accounts (id) ON DELETE CASCADE,
+				name TEXT NOT NULL,
+				public_key BYTEA NOT NULL,
+				sign_count BIGINT NOT NULL,
+				aaguid BYTEA NOT NULL,
+				attestation_format TEXT NOT NULL,
+				last_used_at TIMESTAMP WITH TIME ZONE
+			)`,
+			`CREATE INDEX index_auth_webauthn_credentials_on_account_id ON auth_webauthn_credentials (account_id)`,
+		},
+		down: []string{"DROP TABLE auth_webauthn_credentials"},
+	},
+	{
+		up: []string{
+			`CREATE TABLE auth_external_identities (
+				provider TEXT NOT NULL,
+				subject TEXT NOT NULL,
+				created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+				updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+				account_id UUID NOT NULL REFERENCES auth_accounts (id) ON DELETE CASCADE,
+				email TEXT NOT NULL,
+				PRIMARY KEY (provider, subject)
+			)`,
+			`CREATE INDEX index_auth_external_identities_on_account_id ON auth_external_identities (account_id)`,
+		},
+		down: []string{"DROP TABLE auth_external_identities"},
+	},
+	{
+		up: []string{
+			`CREATE TABLE auth_failed_attempts (
+				key TEXT PRIMARY KEY,
+				failures BIGINT NOT NULL,
+				last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL
+			)`,
+			`CREATE INDEX index_auth_failed_attempts_on_last_failure_at ON auth_failed_attempts (last_failure_at)`,
+		},
+		down: []string{"DROP TABLE auth_failed_attempts"},
+	},
+	{
+		up: []string{
+			`CREATE TABLE auth_used_links (
+				id UUID PRIMARY KEY,
+				expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+			)`,
+			`CREATE INDEX index_auth_used_links_on_expires_at ON auth_used_links (expires_at)`,
+		},
+		down: []string{"DROP TABLE auth_used_links"},
+	},
+	{
+		up: []string{
+			`CREATE TABLE auth_device_authorizations (
+				id UUID PRIMARY KEY,
+				created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+				expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+				client_id TEXT NOT NULL,
+				scopes JSONB NOT NULL,
+				user_code TEXT NOT NULL UNIQUE,
+				account_id UUID REFERENCES auth_accounts (id) ON DELETE CASCADE,
+				denied BOOLEAN NOT NULL,
+				device_code_hash BYTEA NOT NULL,
+				poll_interval BIGINT NOT NULL,
+				last_polled_at TIMESTAMP WITH TIME ZONE
+			)`,
+			`CREATE INDEX index_auth_device_authorizations_on_expires_at ON auth_device_authorizations (expires_at)`,
+		},
+		down: []string{"DROP TABLE auth_device_authorizations"},
+	},
+	{
+		up: []string{
+			`CREATE TABLE auth_used_webauthn_challenges (
+				id UUID PRIMARY KEY,
+				expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+			)`,
+			`CREATE INDEX index_auth_used_webauthn_challenges_on_expires_at ON auth_used_webauthn_challenges (expires_at)`,
+		},
+		down: []string{"DROP TABLE auth_used_webauthn_challenges"},
+	},
+}
@@ -0,0 +1,114 @@
+package oidc
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bloom42/stdx/auth"
+	"github.com/bloom42/stdx/db"
+	"github.com/bloom42/stdx/uuid"
+)
+
+var (
+	ErrIdentityNotLinked     = errors.New("oidc: identity is not linked to an account")
+	ErrIdentityAlreadyLinked = errors.New("oidc: identity is already linked to an account")
+)
+
+// LinkedIdentity is an external identity linked to an auth account
+type LinkedIdentity struct {
+	Provider  string    `db:"provider"`
+	Subject   string    `db:"subject"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+	AccountID uuid.UUID `db:"account_id"`
+	// Email is the email of the identity when it was last used
+	Email string `db:"email"`
+}
+
+// FindAccount returns the ID of the account linked to identity, or ErrIdentityNotLinked.
+// The email of the identity is updated if it has changed.
+func FindAccount(ctx context.Context, db db.Queryer, identity Identity) (accountID uuid.UUID, err error) {
+	err = db.Get(ctx, &accountID, `UPDATE auth_external_identities SET email = $1,
+			updated_at = CASE WHEN email = $1 THEN updated_at ELSE $2 END
+		WHERE provider = $3 AND subject = $4
+		RETURNING account_id`, identity.Email, time.Now().UTC(), identity.Provider, identity.Subject)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrIdentityNotLinked
+		} else {
+			err = fmt.Errorf("oidc: finding account: %w", err)
+		}
+		return
+	}
+
+	return
+}
+
+// LinkIdentity links an external identity to an account, e.g. after a sign up or when a logged-in user
+// connects an account of the provider.
+// Accounts should NOT be linked automatically based on the email of the identity, as it may not be verified
+// by the provider.
+func LinkIdentity(ctx context.Context, db db.Queryer, accountID uuid.UUID, identity Identity) (err error) {
+	_, err = auth.GetAccount(ctx, db, accountID)
+	if err != nil {
+		return
+	}
+
+	now := time.Now().UTC()
+	result, err := db.Exec(ctx, `INSERT INTO auth_external_identities (provider, subject, created_at, updated_at, account_id, email)
+		VALUES ($1, $2, $3, $3, $4, $5)
+		ON CONFLICT (provider, subject) DO NOTHING`,
+		identity.Provider, identity.Subject, now, accountID, identity.Email)
+	if err != nil {
+		err = fmt.Errorf("oidc: linking identity: %w", err)
+		return
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		err = fmt.Errorf("oidc: linking identity: %w", err)
+		return
+	}
+	if rowsAffected == 0 {
+		err = ErrIdentityAlreadyLinked
+		return
+	}
+
+	return
+}
+
+// UnlinkIdentity removes the link between an external identity and an account
+func UnlinkIdentity(ctx context.Context, db db.Queryer, accountID uuid.UUID, provider, subject string) (err error) {
+	result, err := db.Exec(ctx, "DELETE FROM auth_external_identities WHERE provider = $1 AND subject = $2 AND account_id = $3",
+		provider, subject, accountID)
+	if err != nil {
+		err = fmt.Errorf("oidc: unlinking identity: %w", err)
+		return
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		err = fmt.Errorf("oidc: unlinking identity: %w", err)
+		return
+	}
+	if rowsAffected == 0 {
+		err = ErrIdentityNotLinked
+		return
+	}
+
+	return
+}
+
+// GetIdentitiesForAccount returns the external identities linked to an account
+func GetIdentitiesForAccount(ctx context.Context, db db.Queryer, accountID uuid.UUID) (identities []LinkedIdentity, err error) {
+	identities = make([]LinkedIdentity, 0)
+	err = db.Select(ctx, &identities, `SELECT provider, subject, created_at, updated_at, account_id, email
+		FROM auth_external_identities WHERE account_id = $1 ORDER BY created_at`, accountID)
+	if err != nil {
+		err = fmt.Errorf("oidc: getting identities: %w", err)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,121 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/bloom42/stdx/auth"
+	"github.com/bloom42/stdx/db/dbtest"
+	"github.com/bloom42/stdx/uuid"
+)
+
+func TestIdentities(t *testing.T) {
+	database := dbtest.New(t, auth.Migrations(1))
+	ctx := context.Background()
+	accountID := uuid.New()
+	otherAccountID := uuid.New()
+
+	for _, id := range []uuid.UUID{accountID, otherAccountID} {
+		err := auth.CreateAccount(ctx, database, id, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	identity := Identity{Provider: "google", Subject: "1234", Email: "user@example.com"}
+
+	_, err := FindAccount(ctx, database, identity)
+	if !errors.Is(err, ErrIdentityNotLinked) {
+		t.Errorf("identity not linked yet. expected: %v | got: %v", ErrIdentityNotLinked, err)
+	}
+
+	err = LinkIdentity(ctx, database, uuid.New(), identity)
+	if !errors.Is(err, auth.ErrAccountNotFound) {
+		t.Errorf("linking to a missing account. expected: %v | got: %v", auth.ErrAccountNotFound, err)
+	}
+
+	err = LinkIdentity(ctx, database, accountID, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an identity can only be linked to one account
+	for _, id := range []uuid.UUID{accountID, otherAccountID} {
+		err = LinkIdentity(ctx, database, id, identity)
+		if !errors.Is(err, ErrIdentityAlreadyLinked) {
+			t.Errorf("linking twice. expected: %v | got: %v", ErrIdentityAlreadyLinked, err)
+		}
+	}
+
+	// the same subject from another provider is another identity
+	otherProviderIdentity := Identity{Provider: "github", Subject: identity.Subject, Email: identity.Email}
+	err = LinkIdentity(ctx, database, otherAccountID, otherProviderIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	foundAccountID, err := FindAccount(ctx, database, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if foundAccountID != accountID {
+		t.Errorf("account ID. expected: %s | got: %s", accountID, foundAccountID)
+	}
+	foundAccountID, err = FindAccount(ctx, database, otherProviderIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if foundAccountID != otherAccountID {
+		t.Errorf("account ID of the other provider. expected: %s | got: %s", otherAccountID, foundAccountID)
+	}
+
+	// the email is updated when the identity is used
+	identity.Email = "new@example.com"
+	_, err = FindAccount(ctx, database, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identities, err := GetIdentitiesForAccount(ctx, database, accountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identities) != 1 || identities[0].Provider != "google" || identities[0].Subject != identity.Subject ||
+		identities[0].Email != identity.Email || identities[0].AccountID != accountID {
+		t.Fatalf("identities of the account. expected: [%+v] | got: %+v", identity, identities)
+	}
+	if !identities[0].UpdatedAt.After(identities[0].CreatedAt) {
+		t.Errorf("updated at has not been updated: %+v", identities[0])
+	}
+
+	// identities can only be unlinked by their account
+	err = UnlinkIdentity(ctx, database, otherAccountID, identity.Provider, identity.Subject)
+	if !errors.Is(err, ErrIdentityNotLinked) {
+		t.Errorf("unlinking from another account. expected: %v | got: %v", ErrIdentityNotLinked, err)
+	}
+	err = UnlinkIdentity(ctx, database, accountID, identity.Provider, identity.Subject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = FindAccount(ctx, database, identity)
+	if !errors.Is(err, ErrIdentityNotLinked) {
+		t.Errorf("unlinked identity. expected: %v | got: %v", ErrIdentityNotLinked, err)
+	}
+	err = UnlinkIdentity(ctx, database, accountID, identity.Provider, identity.Subject)
+	if !errors.Is(err, ErrIdentityNotLinked) {
+		t.Errorf("unlinking twice. expected: %v | got: %v", ErrIdentityNotLinked, err)
+	}
+
+	// once unlinked, the identity can be linked to another account
+	err = LinkIdentity(ctx, database, otherAccountID, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	foundAccountID, err = FindAccount(ctx, database, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if foundAccountID != otherAccountID {
+		t.Errorf("account ID after relinking. expected: %s | got: %s", otherAccountID, foundAccountID)
+	}
+}
@@ -0,0 +1,379 @@
+package oidc
+
+import (
+	"context"
+	stdcrypto "crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	algorithmRS256 = "RS256"
+	algorithmES256 = "ES256"
+	algorithmEdDSA = "EdDSA"
+
+	minRSAKeySize = 2048
+)
+
+// publicKey is a signing key of the provider, from its JWKS
+type publicKey struct {
+	id        string
+	algorithm string
+	key       any
+}
+
+type jsonWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+}
+
+type jwsHeader struct {
+	Algorithm string          `json:"alg"`
+	KeyID     string          `json:"kid"`
+	Critical  json.RawMessage `json:"crit"`
+}
+
+type idTokenClaims struct {
+	Issuer          string       `json:"iss"`
+	Subject         string       `json:"sub"`
+	Audience        audience     `json:"aud"`
+	AuthorizedParty string       `json:"azp"`
+	Expire          numericDate  `json:"exp"`
+	IssuedAt        numericDate  `json:"iat"`
+	Nonce           string       `json:"nonce"`
+	Email           string       `json:"email"`
+	EmailVerified   flexibleBool `json:"email_verified"`
+	Name            string       `json:"name"`
+	Picture         string       `json:"picture"`
+}
+
+// audience is either a string or an array of strings
+type audience []string
+
+func (aud *audience) UnmarshalJSON(data []byte) (err error) {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		*aud = audience{single}
+		return
+	}
+
+	var multiple []string
+	err = json.Unmarshal(data, &multiple)
+	if err != nil {
+		return
+	}
+	*aud = multiple
+	return
+}
+
+func (aud audience) contains(value string) bool {
+	for _, item := range aud {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// numericDate is a number of seconds since the Unix epoch, which may have a fractional part
+type numericDate int64
+
+func (date *numericDate) UnmarshalJSON(data []byte) (err error) {
+	var value float64
+	err = json.Unmarshal(data, &value)
+	if err != nil {
+		return
+	}
+	*date = numericDate(value)
+	return
+}
+
+func (date numericDate) time() time.Time {
+	return time.Unix(int64(date), 0)
+}
+
+// flexibleBool is a boolean which some providers encode as a string
+type flexibleBool bool
+
+func (value *flexibleBool) UnmarshalJSON(data []byte) (err error) {
+	var boolValue bool
+	if json.Unmarshal(data, &boolValue) == nil {
+		*value = flexibleBool(boolValue)
+		return
+	}
+
+	var stringValue string
+	err = json.Unmarshal(data, &stringValue)
+	if err != nil {
+		return
+	}
+	*value = stringValue == "true"
+	return
+}
+
+// verifyIDToken verifies the signature and the claims of an ID token, except the nonce
+// (https://openid.net/specs/openid-connect-core-1_0.html#IDTokenValidation)
+func (provider *Provider) verifyIDToken(ctx context.Context, idToken string, now time.Time) (claims idTokenClaims, err error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		err = ErrIDTokenIsNotValid
+		return
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		err = ErrIDTokenIsNotValid
+		return
+	}
+	var header jwsHeader
+	err = json.Unmarshal(headerJSON, &header)
+	if err != nil || header.Critical != nil {
+		err = ErrIDTokenIsNotValid
+		return
+	}
+
+	switch header.Algorithm {
+	case algorithmRS256, algorithmES256, algorithmEdDSA:
+	default:
+		err = fmt.Errorf("%w: %s", ErrAlgorithmIsNotSupported, header.Algorithm)
+		return
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		err = ErrIDTokenIsNotValid
+		return
+	}
+
+	keys, err := provider.getKeys(ctx, header.KeyID)
+	if err != nil {
+		return
+	}
+
+	signingInput := []byte(parts[0] + "." + parts[1])
+	validSignature := false
+	for _, key := range keys {
+		if key.algorithm == header.Algorithm && (header.KeyID == "" || key.id == header.KeyID) &&
+			verifySignature(key, signingInput, signature) {
+			validSignature = true
+			break
+		}
+	}
+	if !validSignature {
+		err = fmt.Errorf("%w: signature is not valid", ErrIDTokenIsNotValid)
+		return
+	}
+
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		err = ErrIDTokenIsNotValid
+		return
+	}
+	err = json.Unmarshal(claimsJSON, &claims)
+	if err != nil {
+		err = ErrIDTokenIsNotValid
+		return
+	}
+
+	if claims.Issuer != provider.metadata.Issuer {
+		err = fmt.Errorf("%w: issuer doesn't match", ErrIDTokenIsNotValid)
+		return
+	}
+	if claims.Subject == "" {
+		err = fmt.Errorf("%w: subject is missing", ErrIDTokenIsNotValid)
+		return
+	}
+	if !claims.Audience.contains(provider.config.ClientID) {
+		err = fmt.Errorf("%w: audience doesn't match", ErrIDTokenIsNotValid)
+		return
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != provider.config.ClientID {
+		err = fmt.Errorf("%w: authorized party doesn't match", ErrIDTokenIsNotValid)
+		return
+	}
+	if claims.Expire == 0 || !now.Add(-DefaultClockSkew).Before(claims.Expire.time()) {
+		err = ErrIDTokenExpired
+		return
+	}
+	if claims.IssuedAt.time().After(now.Add(DefaultClockSkew)) {
+		err = fmt.Errorf("%w: token is issued in the future", ErrIDTokenIsNotValid)
+		return
+	}
+
+	return
+}
+
+// getKeys returns the cached keys of the provider. The keys are fetched if the cache has expired, or if keyID
+// is not known, as the provider may have rotated its keys.
+func (provider *Provider) getKeys(ctx context.Context, keyID string) (keys []publicKey, err error) {
+	provider.jwksMutex.Lock()
+	defer provider.jwksMutex.Unlock()
+
+	now := time.Now()
+	cacheExpired := provider.jwks == nil || now.Sub(provider.jwksFetchedAt) > provider.config.JwksCacheDuration
+	unknownKey := keyID != "" && !hasKey(provider.jwks, keyID) &&
+		now.Sub(provider.jwksFetchedAt) > minJwksRefreshInterval
+
+	if cacheExpired || unknownKey {
+		var jwks struct {
+			Keys []jsonWebKey `json:"keys"`
+		}
+		err = provider.getJSON(ctx, provider.metadata.JwksURI, &jwks)
+		if err != nil {
+			err = fmt.Errorf("oidc: fetching JWKS: %w", err)
+			return
+		}
+
+		fetchedKeys := make([]publicKey, 0, len(jwks.Keys))
+		for _, jwk := range jwks.Keys {
+			key, keyErr := parseJsonWebKey(jwk)
+			if keyErr != nil {
+				// unsupported keys are ignored
+				continue
+			}
+			fetchedKeys = append(fetchedKeys, key)
+		}
+
+		provider.jwks = fetchedKeys
+		provider.jwksFetchedAt = now
+	}
+
+	if keyID != "" && !hasKey(provider.jwks, keyID) {
+		err = fmt.Errorf("%w: %s", ErrKeyNotFound, keyID)
+		return
+	}
+
+	keys = provider.jwks
+	return
+}
+
+func hasKey(keys []publicKey, keyID string) bool {
+	for _, key := range keys {
+		if key.id == keyID {
+			return true
+		}
+	}
+	return false
+}
+
+func parseJsonWebKey(jwk jsonWebKey) (key publicKey, err error) {
+	if jwk.Use != "" && jwk.Use != "sig" {
+		err = fmt.Errorf("oidc: key use is not supported: %s", jwk.Use)
+		return
+	}
+
+	key.id = jwk.KeyID
+
+	switch {
+	case jwk.KeyType == "RSA":
+		var n, e []byte
+		n, err = base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return
+		}
+		e, err = base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return
+		}
+		if len(n)*8 < minRSAKeySize || len(e) == 0 || len(e) > 4 {
+			err = fmt.Errorf("oidc: RSA key is not valid")
+			return
+		}
+		key.algorithm = algorithmRS256
+		key.key = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+
+	case jwk.KeyType == "EC" && jwk.Curve == "P-256":
+		var x, y []byte
+		x, err = base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return
+		}
+		y, err = base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return
+		}
+		if len(x) != 32 || len(y) != 32 {
+			err = fmt.Errorf("oidc: EC key is not valid")
+			return
+		}
+		// crypto/ecdh checks that the point is on the curve
+		_, err = ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...))
+		if err != nil {
+			return
+		}
+		key.algorithm = algorithmES256
+		key.key = &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+
+	case jwk.KeyType == "OKP" && jwk.Curve == "Ed25519":
+		var x []byte
+		x, err = base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return
+		}
+		if len(x) != ed25519.PublicKeySize {
+			err = fmt.Errorf("oidc: Ed25519 key is not valid")
+			return
+		}
+		key.algorithm = algorithmEdDSA
+		key.key = ed25519.PublicKey(x)
+
+	default:
+		err = fmt.Errorf("oidc: key type is not supported: %s", jwk.KeyType)
+		return
+	}
+
+	if jwk.Algorithm != "" && jwk.Algorithm != key.algorithm {
+		err = fmt.Errorf("oidc: key algorithm is not supported: %s", jwk.Algorithm)
+		return
+	}
+
+	return
+}
+
+func verifySignature(key publicKey, signingInput, signature []byte) bool {
+	switch publicKey := key.key.(type) {
+	case *rsa.PublicKey:
+		hash := sha256.Sum256(signingInput)
+		return rsa.VerifyPKCS1v15(publicKey, stdcrypto.SHA256, hash[:], signature) == nil
+
+	case *ecdsa.PublicKey:
+		// JWS ES256 signatures are the concatenation of R and S (RFC 7518 section 3.4)
+		if len(signature) != 64 {
+			return false
+		}
+		hash := sha256.Sum256(signingInput)
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(publicKey, hash[:], r, s)
+
+	case ed25519.PublicKey:
+		return ed25519.Verify(publicKey, signingInput, signature)
+
+	default:
+		return false
+	}
+}
@@ -0,0 +1,403 @@
+// Package oidc implements an OpenID Connect client ("Sign in with Google / Microsoft / ...") using the
+// authorization code flow with PKCE.
+//
+// A login is stateless:
+//
+//  1. Begin returns the URL of the authorization endpoint of the provider, and a flow token which MUST be
+//     stored in a short-lived, HttpOnly cookie. The flow token is a statelesstoken carrying the state,
+//     the nonce and the PKCE code verifier, which never appears in URLs.
+//  2. The provider redirects the user to the redirect URL. Exchange verifies the state against the flow
+//     token, exchanges the authorization code and verifies the ID token returned by the provider.
+//
+// ID tokens signed with RS256, ES256 and EdDSA are supported. The keys of the provider (JWKS) are cached
+// and refreshed when a token is signed with an unknown key.
+//
+// The external identities returned by Exchange can be linked to auth accounts with LinkIdentity and
+// FindAccount. The table is created by the migrations of the auth package.
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bloom42/stdx/crypto"
+	"github.com/bloom42/stdx/httpx"
+	"github.com/bloom42/stdx/statelesstoken"
+)
+
+const (
+	DefaultFlowTimeout       = 10 * time.Minute
+	DefaultJwksCacheDuration = time.Hour
+	// DefaultClockSkew is tolerated when checking the times of ID tokens
+	DefaultClockSkew = time.Minute
+
+	flowTokenAudience = "auth.oidc.flow"
+	// maxResponseSize limits the size of the responses of the provider
+	maxResponseSize = 1 << 20
+	// minJwksRefreshInterval limits the refreshes of the JWKS triggered by unknown key IDs
+	minJwksRefreshInterval = time.Minute
+)
+
+var (
+	ErrFlowIsNotValid          = errors.New("oidc: login flow is not valid or has expired")
+	ErrStateIsNotValid         = errors.New("oidc: state is not valid")
+	ErrIDTokenIsNotValid       = errors.New("oidc: ID token is not valid")
+	ErrIDTokenExpired          = errors.New("oidc: ID token has expired")
+	ErrNonceIsNotValid         = errors.New("oidc: nonce is not valid")
+	ErrAlgorithmIsNotSupported = errors.New("oidc: signing algorithm is not supported")
+	ErrKeyNotFound             = errors.New("oidc: signing key not found")
+)
+
+// ProviderError is returned by Exchange when the provider redirects with an error, e.g. when the user denies
+// the authorization request.
+type ProviderError struct {
+	Code        string
+	Description string
+}
+
+func (err *ProviderError) Error() string {
+	if err.Description != "" {
+		return fmt.Sprintf("oidc: provider returned an error: %s: %s", err.Code, err.Description)
+	}
+	return "oidc: provider returned an error: " + err.Code
+}
+
+type Config struct {
+	// Name identifies the provider when linking identities, e.g. "google". Required.
+	Name string
+	// Issuer is the issuer URL of the provider, e.g. "https://accounts.google.com". Required.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the URL where the provider redirects users after the login. Required.
+	RedirectURL string
+	// Scopes requested in addition to "openid".
+	// default: ["email", "profile"]
+	Scopes []string
+	// Keyring is used to sign flow tokens. Required.
+	Keyring *crypto.Keyring
+	// default: httpx.DefaultClient()
+	HTTPClient *http.Client
+	// FlowTimeout is the time the user has to log in with the provider.
+	// default: DefaultFlowTimeout
+	FlowTimeout time.Duration
+	// JwksCacheDuration is the duration the keys of the provider are cached.
+	// default: DefaultJwksCacheDuration
+	JwksCacheDuration time.Duration
+}
+
+type Provider struct {
+	config   Config
+	metadata providerMetadata
+
+	jwksMutex     sync.Mutex
+	jwks          []publicKey
+	jwksFetchedAt time.Time
+}
+
+// providerMetadata is the subset of https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+// used by the package
+type providerMetadata struct {
+	Issuer                        string   `json:"issuer"`
+	AuthorizationEndpoint         string   `json:"authorization_endpoint"`
+	TokenEndpoint                 string   `json:"token_endpoint"`
+	JwksURI                       string   `json:"jwks_uri"`
+	UserinfoEndpoint              string   `json:"userinfo_endpoint"`
+	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported"`
+}
+
+// Identity is an external identity, verified from an ID token
+type Identity struct {
+	// Provider is the name of the provider, from Config.Name
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Picture       string
+	// RedirectTo is the value passed to Begin
+	RedirectTo string
+}
+
+type flowData struct {
+	State        string `json:"s"`
+	Nonce        string `json:"n"`
+	CodeVerifier string `json:"v"`
+	RedirectTo   string `json:"r,omitempty"`
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+}
+
+// NewProvider fetches the configuration of the provider with OpenID Connect discovery
+func NewProvider(ctx context.Context, config Config) (provider *Provider, err error) {
+	if config.Name == "" || config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		err = errors.New("oidc: Name, Issuer, ClientID and RedirectURL are required")
+		return
+	}
+	if config.Keyring == nil {
+		err = errors.New("oidc: Keyring is required")
+		return
+	}
+	if config.Scopes == nil {
+		config.Scopes = []string{"email", "profile"}
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = httpx.DefaultClient()
+	}
+	if config.FlowTimeout <= 0 {
+		config.FlowTimeout = DefaultFlowTimeout
+	}
+	if config.JwksCacheDuration <= 0 {
+		config.JwksCacheDuration = DefaultJwksCacheDuration
+	}
+
+	provider = &Provider{config: config}
+
+	discoveryURL := strings.TrimSuffix(config.Issuer, "/") + "/.well-known/openid-configuration"
+	err = provider.getJSON(ctx, discoveryURL, &provider.metadata)
+	if err != nil {
+		err = fmt.Errorf("oidc: fetching provider configuration: %w", err)
+		return
+	}
+
+	// https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderConfigurationValidation
+	if provider.metadata.Issuer != config.Issuer {
+		err = fmt.Errorf("oidc: issuer of the provider configuration (%s) doesn't match the configured issuer (%s)",
+			provider.metadata.Issuer, config.Issuer)
+		return
+	}
+	if provider.metadata.AuthorizationEndpoint == "" || provider.metadata.TokenEndpoint == "" || provider.metadata.JwksURI == "" {
+		err = errors.New("oidc: provider configuration is missing endpoints")
+		return
+	}
+
+	return
+}
+
+// Name returns the name of the provider
+func (provider *Provider) Name() string {
+	return provider.config.Name
+}
+
+// Begin starts a login and returns the URL where the user should be redirected, and a flow token which MUST be
+// stored in a short-lived, HttpOnly cookie and passed to Exchange.
+// redirectTo is returned by Exchange in Identity.RedirectTo, e.g. the page the user wanted to access.
+func (provider *Provider) Begin(redirectTo string) (authorizationURL, flowToken string, err error) {
+	state, err := randomString()
+	if err != nil {
+		return
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return
+	}
+	codeVerifier, err := randomString()
+	if err != nil {
+		return
+	}
+
+	token, err := statelesstoken.NewV2(provider.config.Keyring, statelesstoken.Claims{
+		Audience: flowTokenAudience,
+		Expire:   time.Now().Add(provider.config.FlowTimeout),
+	}, flowData{
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		RedirectTo:   redirectTo,
+	})
+	if err != nil {
+		err = fmt.Errorf("oidc: creating flow token: %w", err)
+		return
+	}
+
+	authorizationEndpoint, err := url.Parse(provider.metadata.AuthorizationEndpoint)
+	if err != nil {
+		err = fmt.Errorf("oidc: parsing authorization endpoint: %w", err)
+		return
+	}
+
+	query := authorizationEndpoint.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", provider.config.ClientID)
+	query.Set("redirect_uri", provider.config.RedirectURL)
+	query.Set("scope", strings.Join(append([]string{"openid"}, provider.config.Scopes...), " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge(codeVerifier))
+	query.Set("code_challenge_method", "S256")
+	authorizationEndpoint.RawQuery = query.Encode()
+
+	authorizationURL = authorizationEndpoint.String()
+	flowToken = token.String()
+	return
+}
+
+// Exchange completes a login: callbackQuery is the query of the request to the redirect URL and flowToken the
+// token returned by Begin. It returns the identity of the user, verified from the ID token.
+func (provider *Provider) Exchange(ctx context.Context, flowToken string, callbackQuery url.Values) (identity Identity, err error) {
+	token, err := statelesstoken.ParseV2[flowData](flowToken)
+	if err != nil {
+		err = ErrFlowIsNotValid
+		return
+	}
+	err = token.Verify(provider.config.Keyring, flowTokenAudience, 0)
+	if err != nil {
+		err = ErrFlowIsNotValid
+		return
+	}
+	flow := token.Data()
+
+	if !crypto.ConstantTimeCompare([]byte(callbackQuery.Get("state")), []byte(flow.State)) {
+		err = ErrStateIsNotValid
+		return
+	}
+
+	if errorCode := callbackQuery.Get("error"); errorCode != "" {
+		err = &ProviderError{Code: errorCode, Description: callbackQuery.Get("error_description")}
+		return
+	}
+
+	code := callbackQuery.Get("code")
+	if code == "" {
+		err = errors.New("oidc: authorization code is missing")
+		return
+	}
+
+	tokens, err := provider.exchangeCode(ctx, code, flow.CodeVerifier)
+	if err != nil {
+		return
+	}
+
+	claims, err := provider.verifyIDToken(ctx, tokens.IDToken, time.Now())
+	if err != nil {
+		return
+	}
+	if !crypto.ConstantTimeCompare([]byte(claims.Nonce), []byte(flow.Nonce)) {
+		err = ErrNonceIsNotValid
+		return
+	}
+
+	identity = Identity{
+		Provider:      provider.config.Name,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Name:          claims.Name,
+		Picture:       claims.Picture,
+		RedirectTo:    flow.RedirectTo,
+	}
+	return
+}
+
+func (provider *Provider) exchangeCode(ctx context.Context, code, codeVerifier string) (tokens tokenResponse, err error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", provider.config.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+	if provider.config.ClientSecret == "" {
+		form.Set("client_id", provider.config.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		err = fmt.Errorf("oidc: creating token request: %w", err)
+		return
+	}
+	req.Header.Set(httpx.HeaderContentType, "application/x-www-form-urlencoded")
+	req.Header.Set(httpx.HeaderAccept, "application/json")
+	if provider.config.ClientSecret != "" {
+		// client_secret_basic is the default authentication method of the token endpoint
+		req.SetBasicAuth(url.QueryEscape(provider.config.ClientID), url.QueryEscape(provider.config.ClientSecret))
+	}
+
+	res, err := provider.config.HTTPClient.Do(req)
+	if err != nil {
+		err = fmt.Errorf("oidc: exchanging authorization code: %w", err)
+		return
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxResponseSize))
+	if err != nil {
+		err = fmt.Errorf("oidc: reading token response: %w", err)
+		return
+	}
+
+	if res.StatusCode != http.StatusOK {
+		var providerError struct {
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+		}
+		if json.Unmarshal(body, &providerError) == nil && providerError.Error != "" {
+			err = &ProviderError{Code: providerError.Error, Description: providerError.ErrorDescription}
+		} else {
+			err = fmt.Errorf("oidc: exchanging authorization code: status code %d", res.StatusCode)
+		}
+		return
+	}
+
+	err = json.Unmarshal(body, &tokens)
+	if err != nil {
+		err = fmt.Errorf("oidc: decoding token response: %w", err)
+		return
+	}
+	if tokens.IDToken == "" {
+		err = fmt.Errorf("%w: ID token is missing from the token response", ErrIDTokenIsNotValid)
+		return
+	}
+
+	return
+}
+
+func (provider *Provider) getJSON(ctx context.Context, url string, data any) (err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set(httpx.HeaderAccept, "application/json")
+
+	res, err := provider.config.HTTPClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("status code %d", res.StatusCode)
+		return
+	}
+
+	return json.NewDecoder(io.LimitReader(res.Body, maxResponseSize)).Decode(data)
+}
+
+func randomString() (str string, err error) {
+	randomBytes, err := crypto.RandBytes(32)
+	if err != nil {
+		err = fmt.Errorf("oidc: generating random string: %w", err)
+		return
+	}
+
+	str = base64.RawURLEncoding.EncodeToString(randomBytes)
+	return
+}
+
+// codeChallenge computes the S256 PKCE challenge of codeVerifier (RFC 7636)
+func codeChallenge(codeVerifier string) string {
+	hash := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
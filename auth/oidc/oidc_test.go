@@ -0,0 +1,473 @@
+package oidc
+
+import (
+	"context"
+	stdcrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bloom42/stdx/crypto"
+)
+
+const (
+	testClientID     = "test-client"
+	testClientSecret = "test-secret"
+	testRedirectURL  = "https://app.example.com/oidc/callback"
+	testSubject      = "248289761001"
+)
+
+type fakeAuthorization struct {
+	nonce         string
+	codeChallenge string
+	redirectURI   string
+}
+
+// fakeProvider is an in-process OpenID Connect provider
+type fakeProvider struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mutex          sync.Mutex
+	algorithm      string
+	keyID          string
+	signingKey     any
+	authorizations map[string]fakeAuthorization
+	jwksRequests   int
+	// modifyClaims is called before signing ID tokens
+	modifyClaims func(claims map[string]any)
+}
+
+func newFakeProvider(t *testing.T, algorithm string) *fakeProvider {
+	provider := &fakeProvider{
+		t:              t,
+		authorizations: map[string]fakeAuthorization{},
+	}
+	provider.rotateKey(algorithm, "key-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", provider.handleDiscovery)
+	mux.HandleFunc("/jwks", provider.handleJwks)
+	mux.HandleFunc("/authorize", provider.handleAuthorize)
+	mux.HandleFunc("/token", provider.handleToken)
+	provider.server = httptest.NewServer(mux)
+	t.Cleanup(provider.server.Close)
+
+	return provider
+}
+
+func (provider *fakeProvider) rotateKey(algorithm, keyID string) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	var err error
+	switch algorithm {
+	case algorithmES256:
+		provider.signingKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case algorithmRS256:
+		provider.signingKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	case algorithmEdDSA:
+		_, provider.signingKey, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		provider.t.Fatal(err)
+	}
+	provider.algorithm = algorithm
+	provider.keyID = keyID
+}
+
+func (provider *fakeProvider) issuer() string {
+	return provider.server.URL
+}
+
+func (provider *fakeProvider) handleDiscovery(w http.ResponseWriter, req *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{
+		"issuer":                           provider.issuer(),
+		"authorization_endpoint":           provider.issuer() + "/authorize",
+		"token_endpoint":                   provider.issuer() + "/token",
+		"jwks_uri":                         provider.issuer() + "/jwks",
+		"code_challenge_methods_supported": []string{"S256"},
+	})
+}
+
+func (provider *fakeProvider) handleJwks(w http.ResponseWriter, req *http.Request) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	provider.jwksRequests += 1
+
+	encode := base64.RawURLEncoding.EncodeToString
+	jwk := map[string]any{"kid": provider.keyID, "use": "sig", "alg": provider.algorithm}
+	switch key := provider.signingKey.(type) {
+	case *ecdsa.PrivateKey:
+		jwk["kty"] = "EC"
+		jwk["crv"] = "P-256"
+		jwk["x"] = encode(key.X.FillBytes(make([]byte, 32)))
+		jwk["y"] = encode(key.Y.FillBytes(make([]byte, 32)))
+	case *rsa.PrivateKey:
+		jwk["kty"] = "RSA"
+		jwk["n"] = encode(key.N.Bytes())
+		jwk["e"] = encode(big.NewInt(int64(key.E)).Bytes())
+	case ed25519.PrivateKey:
+		jwk["kty"] = "OKP"
+		jwk["crv"] = "Ed25519"
+		jwk["x"] = encode(key.Public().(ed25519.PublicKey))
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"keys": []any{
+			// keys which are not used for signatures are ignored
+			map[string]any{"kty": "RSA", "use": "enc", "kid": "encryption", "n": "AQAB", "e": "AQAB"},
+			jwk,
+		},
+	})
+}
+
+// handleAuthorize simulates a user who logs in and consents
+func (provider *fakeProvider) handleAuthorize(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	if query.Get("client_id") != testClientID || query.Get("response_type") != "code" ||
+		query.Get("code_challenge_method") != "S256" || query.Get("scope") != "openid email profile" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	code := base64.RawURLEncoding.EncodeToString(randomBytes(provider.t, 16))
+	provider.mutex.Lock()
+	provider.authorizations[code] = fakeAuthorization{
+		nonce:         query.Get("nonce"),
+		codeChallenge: query.Get("code_challenge"),
+		redirectURI:   query.Get("redirect_uri"),
+	}
+	provider.mutex.Unlock()
+
+	redirectURL, _ := url.Parse(query.Get("redirect_uri"))
+	redirectURL.RawQuery = url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+	http.Redirect(w, req, redirectURL.String(), http.StatusFound)
+}
+
+func (provider *fakeProvider) handleToken(w http.ResponseWriter, req *http.Request) {
+	clientID, clientSecret, ok := req.BasicAuth()
+	if !ok || clientID != testClientID || clientSecret != testClientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	provider.mutex.Lock()
+	authorization, exists := provider.authorizations[req.PostFormValue("code")]
+	delete(provider.authorizations, req.PostFormValue("code"))
+	provider.mutex.Unlock()
+
+	codeVerifierHash := sha256.Sum256([]byte(req.PostFormValue("code_verifier")))
+	if !exists || req.PostFormValue("grant_type") != "authorization_code" ||
+		req.PostFormValue("redirect_uri") != authorization.redirectURI ||
+		base64.RawURLEncoding.EncodeToString(codeVerifierHash[:]) != authorization.codeChallenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := map[string]any{
+		"iss":            provider.issuer(),
+		"sub":            testSubject,
+		"aud":            testClientID,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          authorization.nonce,
+		"email":          "jane@example.com",
+		"email_verified": true,
+		"name":           "Jane Doe",
+	}
+	if provider.modifyClaims != nil {
+		provider.modifyClaims(claims)
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access-token",
+		"token_type":   "Bearer",
+		"id_token":     provider.signIDToken(claims),
+	})
+}
+
+func (provider *fakeProvider) signIDToken(claims map[string]any) string {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	headerJSON, _ := json.Marshal(map[string]string{"alg": provider.algorithm, "kid": provider.keyID, "typ": "JWT"})
+	claimsJSON, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	hash := sha256.Sum256([]byte(signingInput))
+
+	var signature []byte
+	var err error
+	switch key := provider.signingKey.(type) {
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, key, hash[:])
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, stdcrypto.SHA256, hash[:])
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(key, []byte(signingInput))
+	}
+	if err != nil {
+		provider.t.Fatal(err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func randomBytes(t *testing.T, size uint64) []byte {
+	data, err := crypto.RandBytes(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func newTestProvider(t *testing.T, fake *fakeProvider) *Provider {
+	keyring, err := crypto.NewKeyring(map[crypto.KeyID][]byte{1: randomBytes(t, crypto.KeySize256)}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider, err := NewProvider(context.Background(), Config{
+		Name:         "fake",
+		Issuer:       fake.issuer(),
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+		Keyring:      keyring,
+		HTTPClient:   fake.server.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return provider
+}
+
+// login simulates the redirection of the user to the provider and returns the query of the callback
+func login(t *testing.T, fake *fakeProvider, authorizationURL string) url.Values {
+	client := fake.server.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	res, err := client.Get(authorizationURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("authorization request: status code %d", res.StatusCode)
+	}
+
+	callbackURL, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return callbackURL.Query()
+}
+
+func TestLogin(t *testing.T) {
+	for _, algorithm := range []string{algorithmES256, algorithmRS256, algorithmEdDSA} {
+		t.Run(algorithm, func(t *testing.T) {
+			fake := newFakeProvider(t, algorithm)
+			provider := newTestProvider(t, fake)
+
+			authorizationURL, flowToken, err := provider.Begin("/settings")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			identity, err := provider.Exchange(context.Background(), flowToken, login(t, fake, authorizationURL))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := Identity{
+				Provider:      "fake",
+				Subject:       testSubject,
+				Email:         "jane@example.com",
+				EmailVerified: true,
+				Name:          "Jane Doe",
+				RedirectTo:    "/settings",
+			}
+			if identity != expected {
+				t.Errorf("identity. expected: %#v | got: %#v", expected, identity)
+			}
+		})
+	}
+}
+
+func TestLoginErrors(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeProvider(t, algorithmES256)
+	provider := newTestProvider(t, fake)
+
+	// state of another flow
+	authorizationURL, _, err := provider.Begin("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherFlowToken, err := provider.Begin("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = provider.Exchange(ctx, otherFlowToken, login(t, fake, authorizationURL))
+	if !errors.Is(err, ErrStateIsNotValid) {
+		t.Errorf("state of another flow. expected: %v | got: %v", ErrStateIsNotValid, err)
+	}
+
+	authorizationURL, flowToken, err := provider.Begin("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = provider.Exchange(ctx, flowToken+"a", login(t, fake, authorizationURL))
+	if !errors.Is(err, ErrFlowIsNotValid) {
+		t.Errorf("tampered flow token. expected: %v | got: %v", ErrFlowIsNotValid, err)
+	}
+
+	// the user denied the request
+	authorizationURL, flowToken, err = provider.Begin("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	callbackQuery := login(t, fake, authorizationURL)
+	callbackQuery.Del("code")
+	callbackQuery.Set("error", "access_denied")
+	_, err = provider.Exchange(ctx, flowToken, callbackQuery)
+	var providerError *ProviderError
+	if !errors.As(err, &providerError) || providerError.Code != "access_denied" {
+		t.Errorf("provider error. expected: access_denied | got: %v", err)
+	}
+
+	// authorization codes can only be used once
+	authorizationURL, flowToken, err = provider.Begin("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	callbackQuery = login(t, fake, authorizationURL)
+	_, err = provider.Exchange(ctx, flowToken, callbackQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = provider.Exchange(ctx, flowToken, callbackQuery)
+	if !errors.As(err, &providerError) || providerError.Code != "invalid_grant" {
+		t.Errorf("reused code. expected: invalid_grant | got: %v", err)
+	}
+}
+
+func TestIDTokenVerification(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeProvider(t, algorithmES256)
+	provider := newTestProvider(t, fake)
+
+	tests := []struct {
+		name         string
+		modifyClaims func(claims map[string]any)
+		expected     error
+	}{
+		{"nonce", func(claims map[string]any) { claims["nonce"] = "other nonce" }, ErrNonceIsNotValid},
+		{"audience", func(claims map[string]any) { claims["aud"] = "other-client" }, ErrIDTokenIsNotValid},
+		{"authorized party", func(claims map[string]any) {
+			claims["aud"] = []string{testClientID, "other-client"}
+			claims["azp"] = "other-client"
+		}, ErrIDTokenIsNotValid},
+		{"issuer", func(claims map[string]any) { claims["iss"] = "https://evil.example.com" }, ErrIDTokenIsNotValid},
+		{"expired", func(claims map[string]any) { claims["exp"] = time.Now().Add(-time.Hour).Unix() }, ErrIDTokenExpired},
+		{"issued in the future", func(claims map[string]any) { claims["iat"] = time.Now().Add(time.Hour).Unix() }, ErrIDTokenIsNotValid},
+		{"multiple audiences", func(claims map[string]any) {
+			claims["aud"] = []string{testClientID, "other-client"}
+			claims["azp"] = testClientID
+		}, nil},
+		{"email verified as string", func(claims map[string]any) { claims["email_verified"] = "true" }, nil},
+	}
+
+	for _, test := range tests {
+		fake.modifyClaims = test.modifyClaims
+		authorizationURL, flowToken, err := provider.Begin("")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = provider.Exchange(ctx, flowToken, login(t, fake, authorizationURL))
+		if !errors.Is(err, test.expected) {
+			t.Errorf("%s. expected: %v | got: %v", test.name, test.expected, err)
+		}
+	}
+	fake.modifyClaims = nil
+
+	// tokens without a valid signature are rejected
+	validToken := fake.signIDToken(map[string]any{"iss": fake.issuer(), "sub": testSubject, "aud": testClientID,
+		"exp": time.Now().Add(time.Hour).Unix()})
+	_, err := provider.verifyIDToken(ctx, validToken, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(validToken, ".")
+	noneHeader := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	_, err = provider.verifyIDToken(ctx, noneHeader+"."+parts[1]+".", time.Now())
+	if !errors.Is(err, ErrAlgorithmIsNotSupported) {
+		t.Errorf("alg none. expected: %v | got: %v", ErrAlgorithmIsNotSupported, err)
+	}
+
+	tamperedToken := []byte(validToken)
+	tamperedToken[len(tamperedToken)-2] ^= 1
+	_, err = provider.verifyIDToken(ctx, string(tamperedToken), time.Now())
+	if !errors.Is(err, ErrIDTokenIsNotValid) {
+		t.Errorf("tampered signature. expected: %v | got: %v", ErrIDTokenIsNotValid, err)
+	}
+}
+
+func TestJwksCache(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeProvider(t, algorithmES256)
+	provider := newTestProvider(t, fake)
+
+	claims := map[string]any{"iss": fake.issuer(), "sub": testSubject, "aud": testClientID,
+		"exp": time.Now().Add(time.Hour).Unix()}
+
+	for i := 0; i < 3; i += 1 {
+		_, err := provider.verifyIDToken(ctx, fake.signIDToken(claims), time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fake.jwksRequests != 1 {
+		t.Errorf("JWKS requests. expected: 1 | got: %d", fake.jwksRequests)
+	}
+
+	// unknown keys trigger a refresh, at most once per minJwksRefreshInterval
+	fake.rotateKey(algorithmEdDSA, "key-2")
+	_, err := provider.verifyIDToken(ctx, fake.signIDToken(claims), time.Now())
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("rotated key before refresh interval. expected: %v | got: %v", ErrKeyNotFound, err)
+	}
+
+	provider.jwksFetchedAt = time.Now().Add(-2 * minJwksRefreshInterval)
+	_, err = provider.verifyIDToken(ctx, fake.signIDToken(claims), time.Now())
+	if err != nil {
+		t.Errorf("rotated key: %v", err)
+	}
+	if fake.jwksRequests != 2 {
+		t.Errorf("JWKS requests. expected: 2 | got: %d", fake.jwksRequests)
+	}
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bloom42/stdx/crypto"
+	"github.com/bloom42/stdx/db"
+	"github.com/bloom42/stdx/uuid"
+)
+
+const (
+	PasswordMinLength = 8
+	// PasswordMaxLength limits the size of the input of the password hashing function
+	PasswordMaxLength = 512
+)
+
+var (
+	dummyPasswordHash     string
+	dummyPasswordHashOnce sync.Once
+)
+
+// VerifyPassword verifies the password of an account. It returns ErrPasswordIsNotValid if the password is
+// not valid or if the account doesn't exist, so accounts can't be enumerated.
+//
+// legacyVerifiers allow to verify the password hashes imported from other systems (e.g. bcrypt).
+// Legacy hashes, and hashes created with outdated parameters, are transparently upgraded.
+func VerifyPassword(ctx context.Context, db db.Queryer, accountID uuid.UUID, password string, legacyVerifiers ...crypto.PasswordHashVerifier) (err error) {
+	if len(password) > PasswordMaxLength {
+		err = ErrPasswordIsNotValid
+		return
+	}
+
+	var passwordHash string
+	err = db.Get(ctx, &passwordHash, "SELECT hash FROM auth_passwords WHERE account_id = $1", accountID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			err = fmt.Errorf("auth: getting password hash: %w", err)
+			return
+		}
+
+		// hash the password anyway so the response time doesn't reveal if the account exists
+		crypto.VerifyPasswordHash([]byte(password), getDummyPasswordHash())
+		err = ErrPasswordIsNotValid
+		return
+	}
+
+	valid, newHash, err := crypto.VerifyPasswordHashAndRehash([]byte(password), passwordHash, legacyVerifiers...)
+	if err != nil {
+		err = fmt.Errorf("auth: verifying password: %w", err)
+		return
+	}
+	if !valid {
+		err = ErrPasswordIsNotValid
+		return
+	}
+
+	if newHash != "" {
+		_, err = db.Exec(ctx, "UPDATE auth_passwords SET hash = $1, updated_at = $2 WHERE account_id = $3 AND hash = $4",
+			newHash, time.Now().UTC(), accountID, passwordHash)
+		if err != nil {
+			err = fmt.Errorf("auth: upgrading password hash: %w", err)
+			return
+		}
+	}
+
+	return
+}
+
+// ChangePassword verifies the current password of an account and replaces it with newPassword.
+// legacyVerifiers are passed to VerifyPassword, so accounts with imported password hashes can change their
+// password. All the sessions of the account are revoked, except currentSessionID, which can be uuid.Nil.
+// ChangePassword should be called within a transaction.
+func ChangePassword(ctx context.Context, db db.Queryer, accountID, currentSessionID uuid.UUID, currentPassword, newPassword string, legacyVerifiers ...crypto.PasswordHashVerifier) (err error) {
+	err = validatePassword(newPassword)
+	if err != nil {
+		return
+	}
+
+	err = VerifyPassword(ctx, db, accountID, currentPassword, legacyVerifiers...)
+	if err != nil {
+		return
+	}
+
+	return SetPassword(ctx, db, accountID, currentSessionID, newPassword)
+}
+
+// SetPassword sets the password of an account without verifying the current one, e.g. after a password
+// reset or for accounts which don't have a password yet.
+// All the sessions of the account are revoked, except currentSessionID, which can be uuid.Nil.
+// SetPassword should be called within a transaction.
+func SetPassword(ctx context.Context, db db.Queryer, accountID, currentSessionID uuid.UUID, newPassword string) (err error) {
+	passwordHash, err := hashPassword(newPassword)
+	if err != nil {
+		return
+	}
+
+	_, err = GetAccount(ctx, db, accountID)
+	if err != nil {
+		return
+	}
+
+	err = savePasswordHash(ctx, db, accountID, passwordHash, time.Now().UTC())
+	if err != nil {
+		return
+	}
+
+	return DeleteAllSessionsForAccount(ctx, db, accountID, currentSessionID)
+}
+
+func savePasswordHash(ctx context.Context, db db.Queryer, accountID uuid.UUID, passwordHash string, now time.Time) (err error) {
+	_, err = db.Exec(ctx, `INSERT INTO auth_passwords (account_id, created_at, updated_at, hash) VALUES ($1, $2, $2, $3)
+		ON CONFLICT (account_id) DO UPDATE SET hash = $3, updated_at = $2`, accountID, now, passwordHash)
+	if err != nil {
+		err = fmt.Errorf("auth: saving password hash: %w", err)
+		return
+	}
+
+	return
+}
+
+func validatePassword(password string) error {
+	length := utf8.RuneCountInString(password)
+	if length < PasswordMinLength {
+		return ErrPasswordIsTooShort
+	}
+	if len(password) > PasswordMaxLength {
+		return ErrPasswordIsTooLong
+	}
+	return nil
+}
+
+func hashPassword(password string) (hash string, err error) {
+	err = validatePassword(password)
+	if err != nil {
+		return
+	}
+
+	hash, err = crypto.HashPassword([]byte(password), crypto.DefaultHashPasswordParams)
+	if err != nil {
+		err = fmt.Errorf("auth: hashing password: %w", err)
+		return
+	}
+
+	return
+}
+
+func getDummyPasswordHash() string {
+	dummyPasswordHashOnce.Do(func() {
+		dummyPasswordHash, _ = crypto.HashPassword([]byte("dummy password"), crypto.DefaultHashPasswordParams)
+	})
+	return dummyPasswordHash
+}
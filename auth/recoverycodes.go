@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bloom42/stdx/base32"
+	"github.com/bloom42/stdx/crypto"
+	"github.com/bloom42/stdx/db"
+	"github.com/bloom42/stdx/uuid"
+)
+
+// Recovery codes are random 80-bit strings formatted as xxxx-xxxx-xxxx-xxxx. They have enough entropy to be
+// stored as SHA-256 hashes, and are deleted once used.
+
+const (
+	RecoveryCodesCount = 10
+
+	recoveryCodeSize = 10
+)
+
+var ErrRecoveryCodeIsNotValid = errors.New("auth: recovery code is not valid")
+
+// GenerateRecoveryCodes generates new recovery codes for an account, replacing the existing ones.
+// The codes should be displayed once to the user, as only their hashes are stored.
+// GenerateRecoveryCodes should be called within a transaction.
+func GenerateRecoveryCodes(ctx context.Context, db db.Queryer, accountID uuid.UUID) (recoveryCodes []string, err error) {
+	recoveryCodes = make([]string, RecoveryCodesCount)
+	for i := range recoveryCodes {
+		recoveryCodes[i], err = newRecoveryCode()
+		if err != nil {
+			return
+		}
+	}
+
+	_, err = db.Exec(ctx, "DELETE FROM auth_recovery_codes WHERE account_id = $1", accountID)
+	if err != nil {
+		err = fmt.Errorf("auth: deleting recovery codes: %w", err)
+		return
+	}
+
+	now := time.Now().UTC()
+	for _, recoveryCode := range recoveryCodes {
+		_, err = db.Exec(ctx, "INSERT INTO auth_recovery_codes (account_id, hash, created_at) VALUES ($1, $2, $3)",
+			accountID, hashRecoveryCode(recoveryCode), now)
+		if err != nil {
+			err = fmt.Errorf("auth: saving recovery code: %w", err)
+			return
+		}
+	}
+
+	return
+}
+
+// UseRecoveryCode verifies a recovery code of an account and deletes it, so it can't be used again.
+func UseRecoveryCode(ctx context.Context, db db.Queryer, accountID uuid.UUID, recoveryCode string) (err error) {
+	normalized := normalizeRecoveryCode(recoveryCode)
+	if len(normalized) != base32EncodedLen(recoveryCodeSize) {
+		err = ErrRecoveryCodeIsNotValid
+		return
+	}
+
+	result, err := db.Exec(ctx, "DELETE FROM auth_recovery_codes WHERE account_id = $1 AND hash = $2",
+		accountID, hashRecoveryCode(normalized))
+	if err != nil {
+		err = fmt.Errorf("auth: deleting recovery code: %w", err)
+		return
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		err = fmt.Errorf("auth: deleting recovery code: %w", err)
+		return
+	}
+	if rowsAffected == 0 {
+		err = ErrRecoveryCodeIsNotValid
+		return
+	}
+
+	return
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes of an account
+func CountRecoveryCodes(ctx context.Context, db db.Queryer, accountID uuid.UUID) (count int64, err error) {
+	err = db.Get(ctx, &count, "SELECT COUNT(*) FROM auth_recovery_codes WHERE account_id = $1", accountID)
+	if err != nil {
+		err = fmt.Errorf("auth: counting recovery codes: %w", err)
+		return
+	}
+
+	return
+}
+
+func newRecoveryCode() (recoveryCode string, err error) {
+	randomBytes, err := crypto.RandBytes(recoveryCodeSize)
+	if err != nil {
+		err = fmt.Errorf("auth: generating recovery code: %w", err)
+		return
+	}
+
+	encoded := base32.EncodeToString(randomBytes)
+	parts := make([]string, 0, len(encoded)/4)
+	for i := 0; i < len(encoded); i += 4 {
+		parts = append(parts, encoded[i:i+4])
+	}
+
+	recoveryCode = strings.Join(parts, "-")
+	return
+}
+
+// normalizeRecoveryCode removes the separators and spaces that users may type
+func normalizeRecoveryCode(recoveryCode string) string {
+	recoveryCode = strings.ToLower(recoveryCode)
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, recoveryCode)
+}
+
+func hashRecoveryCode(recoveryCode string) []byte {
+	hash := sha256.Sum256([]byte(normalizeRecoveryCode(recoveryCode)))
+	return hash[:]
+}
+
+func base32EncodedLen(n int) int {
+	return (n*8 + 4) / 5
+}
@@ -2,32 +2,456 @@ package auth
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
 	"time"
 
+	"github.com/bloom42/stdx/crypto"
 	"github.com/bloom42/stdx/db"
+	"github.com/bloom42/stdx/guid"
+	"github.com/bloom42/stdx/mmdb"
+	"github.com/bloom42/stdx/token"
+	"github.com/bloom42/stdx/useragent"
 	"github.com/bloom42/stdx/uuid"
 )
 
+// A session is created when a user logs in, and is identified by 2 tokens:
+// - the session token, which authenticates the requests of the user (e.g. stored in a cookie)
+// - the refresh token, which can be exchanged once for a new pair of tokens with RefreshSession
+//
+// Both tokens have the ID of the session as token ID, and only their hashes are stored.
+// The hashes of the refresh tokens which have already been used are kept until the session is deleted:
+// if one of them is presented again, the refresh token has probably been stolen, so the session and all
+// the tokens derived from it (the "family") are revoked.
+
+const (
+	SessionTokenPrefix = "ses_"
+	RefreshTokenPrefix = "ref_"
+
+	DefaultSessionIdleTimeout     = 7 * 24 * time.Hour
+	DefaultSessionAbsoluteTimeout = 90 * 24 * time.Hour
+
+	// sessionActivityUpdateInterval throttles the updates of last_active_at and idle_expires_at
+	sessionActivityUpdateInterval = time.Minute
+)
+
+var (
+	ErrSessionNotFound    = errors.New("auth: session not found")
+	ErrSessionExpired     = errors.New("auth: session has expired")
+	ErrRefreshTokenReused = errors.New("auth: refresh token has already been used. The session has been revoked")
+)
+
 type Session struct {
-	ID        uuid.UUID
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID        uuid.UUID `db:"id"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+
+	AccountID uuid.UUID `db:"account_id"`
+	// ExpiresAt is the absolute expiry of the session. It is not extended by activity or refreshes.
+	ExpiresAt time.Time `db:"expires_at"`
+	// IdleExpiresAt is the time at which the session expires if it's not used
+	IdleExpiresAt time.Time `db:"idle_expires_at"`
+	LastActiveAt  time.Time `db:"last_active_at"`
+	IPAddress     string    `db:"ip_address"`
+	UserAgent     string    `db:"user_agent"`
+	// Country is the ISO code of the country of IPAddress, if known
+	Country string `db:"country"`
+	City    string `db:"city"`
+}
+
+// SessionTokens are the tokens of a session, which should be sent to the client
+type SessionTokens struct {
+	Token        string
+	RefreshToken string
+}
+
+// SessionOptions are the options of CreateSession
+type SessionOptions struct {
+	IPAddress string
+	UserAgent string
+	// GeoIPDatabase is used to find the approximate location of IPAddress, e.g. a GeoLite2 City database.
+	// default: nil (no location)
+	GeoIPDatabase *mmdb.Reader
+	// IdleTimeout is the duration of inactivity after which the session expires.
+	// default: DefaultSessionIdleTimeout
+	IdleTimeout time.Duration
+	// AbsoluteTimeout is the maximum lifetime of the session.
+	// default: DefaultSessionAbsoluteTimeout
+	AbsoluteTimeout time.Duration
+}
+
+type sessionRow struct {
+	Session
+	TokenHash        []byte `db:"token_hash"`
+	RefreshTokenHash []byte `db:"refresh_token_hash"`
+	IdleTimeout      int64  `db:"idle_timeout"`
+}
+
+const sessionColumns = `id, created_at, updated_at, account_id, token_hash, refresh_token_hash, expires_at,
+	idle_expires_at, idle_timeout, last_active_at, ip_address, user_agent, country, city`
+
+// Device returns a displayable description of the device of the session, e.g. "Firefox on Linux"
+func (session *Session) Device() string {
+	parsedUserAgent := useragent.Parse(session.UserAgent)
+
+	switch {
+	case parsedUserAgent.Name != "" && parsedUserAgent.OS != "":
+		return parsedUserAgent.Name + " on " + parsedUserAgent.OS
+	case parsedUserAgent.Name != "":
+		return parsedUserAgent.Name
+	case parsedUserAgent.OS != "":
+		return parsedUserAgent.OS
+	default:
+		return "Unknown device"
+	}
+}
 
-	AccountID uuid.UUID
+// Location returns the approximate location of the session, e.g. "Paris, FR", or an empty string if unknown
+func (session *Session) Location() string {
+	switch {
+	case session.City != "" && session.Country != "":
+		return session.City + ", " + session.Country
+	default:
+		return session.Country
+	}
 }
 
-func CreateSession(ctx context.Context, db db.Queryer, accountID uuid.UUID) (token string, err error) {
-	panic("TODO")
+// CreateSession creates a new session for an account, e.g. after the user has logged in.
+func CreateSession(ctx context.Context, db db.Queryer, accountID uuid.UUID, options SessionOptions) (session Session, tokens SessionTokens, err error) {
+	if options.IdleTimeout <= 0 {
+		options.IdleTimeout = DefaultSessionIdleTimeout
+	}
+	if options.AbsoluteTimeout <= 0 {
+		options.AbsoluteTimeout = DefaultSessionAbsoluteTimeout
+	}
+
+	sessionID, err := uuid.NewRandom()
+	if err != nil {
+		err = fmt.Errorf("auth: generating session ID: %w", err)
+		return
+	}
+
+	sessionToken, refreshToken, err := newSessionTokens(sessionID)
+	if err != nil {
+		return
+	}
+
+	now := time.Now().UTC()
+	session = Session{
+		ID:            sessionID,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		AccountID:     accountID,
+		ExpiresAt:     now.Add(options.AbsoluteTimeout),
+		IdleExpiresAt: now.Add(options.IdleTimeout),
+		LastActiveAt:  now,
+		IPAddress:     options.IPAddress,
+		UserAgent:     options.UserAgent,
+	}
+	if session.IdleExpiresAt.After(session.ExpiresAt) {
+		session.IdleExpiresAt = session.ExpiresAt
+	}
+	if options.GeoIPDatabase != nil {
+		session.Country, session.City = lookupLocation(options.GeoIPDatabase, options.IPAddress)
+	}
+
+	_, err = db.Exec(ctx, `INSERT INTO auth_sessions (`+sessionColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+		session.ID, session.CreatedAt, session.UpdatedAt, session.AccountID, sessionToken.Hash(), refreshToken.Hash(),
+		session.ExpiresAt, session.IdleExpiresAt, int64(options.IdleTimeout/time.Second), session.LastActiveAt,
+		session.IPAddress, session.UserAgent, session.Country, session.City,
+	)
+	if err != nil {
+		err = fmt.Errorf("auth: creating session: %w", err)
+		return
+	}
+
+	tokens = SessionTokens{
+		Token:        sessionToken.String(),
+		RefreshToken: refreshToken.String(),
+	}
+	return
+}
+
+// VerifySession verifies a session token and returns the corresponding session.
+// The idle expiry of the session is extended.
+func VerifySession(ctx context.Context, db db.Queryer, sessionToken string) (session Session, err error) {
+	parsedToken, err := token.Parse(SessionTokenPrefix, sessionToken)
+	if err != nil {
+		err = ErrSessionNotFound
+		return
+	}
+
+	row, err := findSession(ctx, db, uuid.UUID(parsedToken.ID()), false)
+	if err != nil {
+		return
+	}
+
+	if parsedToken.Verify(row.TokenHash) != nil {
+		err = ErrSessionNotFound
+		return
+	}
+
+	now := time.Now().UTC()
+	if !now.Before(row.ExpiresAt) || !now.Before(row.IdleExpiresAt) {
+		err = ErrSessionExpired
+		return
+	}
+
+	session = row.Session
+	if now.Sub(session.LastActiveAt) >= sessionActivityUpdateInterval {
+		session.LastActiveAt = now
+		session.IdleExpiresAt = now.Add(time.Duration(row.IdleTimeout) * time.Second)
+		if session.IdleExpiresAt.After(session.ExpiresAt) {
+			session.IdleExpiresAt = session.ExpiresAt
+		}
+
+		_, err = db.Exec(ctx, "UPDATE auth_sessions SET last_active_at = $1, idle_expires_at = $2 WHERE id = $3",
+			session.LastActiveAt, session.IdleExpiresAt, session.ID)
+		if err != nil {
+			err = fmt.Errorf("auth: updating session activity: %w", err)
+			return
+		}
+	}
+
+	return
+}
+
+// RefreshSession exchanges a refresh token for a new pair of tokens. The previous session token and
+// refresh token are no longer valid.
+// If the refresh token has already been used, the session is revoked and ErrRefreshTokenReused is returned.
+// RefreshSession runs in its own transaction, so the revocation is committed even though an error is
+// returned.
+func RefreshSession(ctx context.Context, database db.DB, refreshToken string) (tokens SessionTokens, err error) {
+	parsedToken, err := token.Parse(RefreshTokenPrefix, refreshToken)
+	if err != nil {
+		err = ErrSessionNotFound
+		return
+	}
+	sessionID := uuid.UUID(parsedToken.ID())
+
+	var reused bool
+	err = database.Transaction(ctx, func(tx db.Tx) (txErr error) {
+		tokens, reused, txErr = refreshSession(ctx, tx, sessionID, parsedToken)
+		return txErr
+	})
+	if err != nil {
+		return
+	}
+
+	if reused {
+		err = ErrRefreshTokenReused
+		return
+	}
+
+	return
 }
 
+// refreshSession rotates the tokens of a session. If the refresh token has already been used, the session
+// is deleted and reused is true, without returning an error, so the deletion is committed.
+func refreshSession(ctx context.Context, tx db.Queryer, sessionID uuid.UUID, parsedToken token.Token) (tokens SessionTokens, reused bool, err error) {
+	row, err := findSession(ctx, tx, sessionID, true)
+	if err != nil {
+		return
+	}
+
+	if parsedToken.Verify(row.RefreshTokenHash) != nil {
+		err = tx.Get(ctx, &reused, `SELECT EXISTS(SELECT 1 FROM auth_session_used_refresh_tokens
+			WHERE hash = $1 AND session_id = $2)`, parsedToken.Hash(), sessionID)
+		if err != nil {
+			err = fmt.Errorf("auth: checking refresh token reuse: %w", err)
+			return
+		}
+		if !reused {
+			err = ErrSessionNotFound
+			return
+		}
+
+		err = deleteSession(ctx, tx, sessionID)
+		return
+	}
+
+	now := time.Now().UTC()
+	if !now.Before(row.ExpiresAt) || !now.Before(row.IdleExpiresAt) {
+		err = ErrSessionExpired
+		return
+	}
+
+	newSessionToken, newRefreshToken, err := newSessionTokens(sessionID)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(ctx, "INSERT INTO auth_session_used_refresh_tokens (hash, session_id, used_at) VALUES ($1, $2, $3)",
+		row.RefreshTokenHash, sessionID, now)
+	if err != nil {
+		err = fmt.Errorf("auth: saving used refresh token: %w", err)
+		return
+	}
+
+	idleExpiresAt := now.Add(time.Duration(row.IdleTimeout) * time.Second)
+	if idleExpiresAt.After(row.ExpiresAt) {
+		idleExpiresAt = row.ExpiresAt
+	}
+	_, err = tx.Exec(ctx, `UPDATE auth_sessions
+		SET token_hash = $1, refresh_token_hash = $2, updated_at = $3, last_active_at = $3, idle_expires_at = $4
+		WHERE id = $5`,
+		newSessionToken.Hash(), newRefreshToken.Hash(), now, idleExpiresAt, sessionID)
+	if err != nil {
+		err = fmt.Errorf("auth: refreshing session: %w", err)
+		return
+	}
+
+	tokens = SessionTokens{
+		Token:        newSessionToken.String(),
+		RefreshToken: newRefreshToken.String(),
+	}
+	return
+}
+
+// GetSessionsForAccount returns the active sessions of an account, most recently active first.
+// Use Session.Device and Session.Location to display them.
 func GetSessionsForAccount(ctx context.Context, db db.Queryer, accountID uuid.UUID) (sessions []Session, err error) {
-	panic("TODO")
+	rows := make([]sessionRow, 0)
+	err = db.Select(ctx, &rows, `SELECT `+sessionColumns+` FROM auth_sessions
+		WHERE account_id = $1 AND expires_at > $2 AND idle_expires_at > $2
+		ORDER BY last_active_at DESC`, accountID, time.Now().UTC())
+	if err != nil {
+		err = fmt.Errorf("auth: getting sessions: %w", err)
+		return
+	}
+
+	sessions = make([]Session, len(rows))
+	for i, row := range rows {
+		sessions[i] = row.Session
+	}
+	return
+}
+
+// DeleteSession deletes the session of the given session token, e.g. when the user logs out
+func DeleteSession(ctx context.Context, db db.Queryer, sessionToken string) (err error) {
+	session, err := VerifySession(ctx, db, sessionToken)
+	if err != nil {
+		return
+	}
+
+	return deleteSession(ctx, db, session.ID)
+}
+
+// RevokeSession deletes a session of an account, e.g. from a list of the active sessions of the user
+func RevokeSession(ctx context.Context, db db.Queryer, accountID, sessionID uuid.UUID) (err error) {
+	result, err := db.Exec(ctx, "DELETE FROM auth_sessions WHERE id = $1 AND account_id = $2", sessionID, accountID)
+	if err != nil {
+		err = fmt.Errorf("auth: deleting session: %w", err)
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		err = fmt.Errorf("auth: deleting session: %w", err)
+		return
+	}
+	if rowsAffected == 0 {
+		err = ErrSessionNotFound
+		return
+	}
+
+	return
+}
+
+// DeleteAllSessionsForAccount deletes all the sessions of an account ("log out everywhere"), except
+// exceptSessionID, which can be uuid.Nil.
+func DeleteAllSessionsForAccount(ctx context.Context, db db.Queryer, accountID, exceptSessionID uuid.UUID) (err error) {
+	_, err = db.Exec(ctx, "DELETE FROM auth_sessions WHERE account_id = $1 AND id != $2", accountID, exceptSessionID)
+	if err != nil {
+		err = fmt.Errorf("auth: deleting sessions: %w", err)
+		return
+	}
+
+	return
+}
+
+// DeleteExpiredSessions deletes the expired sessions. It should be called periodically.
+func DeleteExpiredSessions(ctx context.Context, db db.Queryer) (deleted int64, err error) {
+	result, err := db.Exec(ctx, "DELETE FROM auth_sessions WHERE expires_at <= $1 OR idle_expires_at <= $1",
+		time.Now().UTC())
+	if err != nil {
+		err = fmt.Errorf("auth: deleting expired sessions: %w", err)
+		return
+	}
+
+	deleted, err = result.RowsAffected()
+	return
+}
+
+func findSession(ctx context.Context, db db.Queryer, sessionID uuid.UUID, forUpdate bool) (row sessionRow, err error) {
+	query := `SELECT ` + sessionColumns + ` FROM auth_sessions WHERE id = $1`
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+
+	err = db.Get(ctx, &row, query, sessionID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrSessionNotFound
+		} else {
+			err = fmt.Errorf("auth: getting session: %w", err)
+		}
+		return
+	}
+
+	return
 }
 
-func RefreshSession(ctx context.Context, db db.Queryer, oldToken string) (newToken string, err error) {
-	panic("TODO")
+func deleteSession(ctx context.Context, db db.Queryer, sessionID uuid.UUID) (err error) {
+	_, err = db.Exec(ctx, "DELETE FROM auth_sessions WHERE id = $1", sessionID)
+	if err != nil {
+		err = fmt.Errorf("auth: deleting session: %w", err)
+		return
+	}
+
+	return
+}
+
+func newSessionTokens(sessionID uuid.UUID) (sessionToken, refreshToken token.Token, err error) {
+	sessionToken, err = token.NewWithOptions(SessionTokenPrefix, token.Options{ID: guid.GUID(sessionID), Checksum: true})
+	if err != nil {
+		return
+	}
+
+	refreshToken, err = token.NewWithOptions(RefreshTokenPrefix, token.Options{ID: guid.GUID(sessionID), Checksum: true})
+	if err != nil {
+		crypto.Zeroize(sessionToken.Secret())
+		return
+	}
+
+	return
 }
 
-func DeleteSession(ctx context.Context, db db.Queryer, token string) (err error) {
-	panic("TODO")
+type geoIPRecord struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+// lookupLocation returns the country ISO code and the english name of the city of ipAddress.
+// The location is only informative, so errors are ignored.
+func lookupLocation(geoIPDatabase *mmdb.Reader, ipAddress string) (country, city string) {
+	ip := net.ParseIP(ipAddress)
+	if ip == nil {
+		return
+	}
+
+	var record geoIPRecord
+	err := geoIPDatabase.Lookup(ip, &record)
+	if err != nil {
+		return
+	}
+
+	return record.Country.ISOCode, record.City.Names["en"]
 }
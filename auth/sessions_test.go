@@ -0,0 +1,127 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bloom42/stdx/auth"
+	"github.com/bloom42/stdx/token"
+	"github.com/bloom42/stdx/uuid"
+)
+
+func TestSessionDevice(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		expected  string
+	}{
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0", "Firefox on Linux"},
+		{"", "Unknown device"},
+	}
+
+	for _, test := range tests {
+		session := auth.Session{UserAgent: test.userAgent}
+		device := session.Device()
+		if device != test.expected {
+			t.Errorf("device for (%s). expected: %s | got: %s", test.userAgent, test.expected, device)
+		}
+	}
+}
+
+func TestSessionLocation(t *testing.T) {
+	tests := []struct {
+		session  auth.Session
+		expected string
+	}{
+		{auth.Session{City: "Paris", Country: "FR"}, "Paris, FR"},
+		{auth.Session{Country: "FR"}, "FR"},
+		{auth.Session{}, ""},
+	}
+
+	for _, test := range tests {
+		location := test.session.Location()
+		if location != test.expected {
+			t.Errorf("location. expected: %s | got: %s", test.expected, location)
+		}
+	}
+}
+
+func TestSessionTokensAreNotInterchangeable(t *testing.T) {
+	ctx := context.Background()
+
+	refreshToken, err := token.New(auth.RefreshTokenPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionToken, err := token.New(auth.SessionTokenPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tokens are parsed before querying the database
+	_, err = auth.VerifySession(ctx, nil, refreshToken.String())
+	if err != auth.ErrSessionNotFound {
+		t.Errorf("verifying refresh token as session token. expected: %v | got: %v", auth.ErrSessionNotFound, err)
+	}
+
+	_, err = auth.RefreshSession(ctx, nil, sessionToken.String())
+	if err != auth.ErrSessionNotFound {
+		t.Errorf("refreshing with session token. expected: %v | got: %v", auth.ErrSessionNotFound, err)
+	}
+
+	_, err = auth.VerifySession(ctx, nil, "not a token")
+	if err != auth.ErrSessionNotFound {
+		t.Errorf("verifying invalid token. expected: %v | got: %v", auth.ErrSessionNotFound, err)
+	}
+}
+
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	database := newTestDatabase(t)
+	ctx := context.Background()
+	accountID := uuid.New()
+
+	err := auth.CreateAccount(ctx, database, accountID, "correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, tokens, err := auth.CreateSession(ctx, database, accountID, auth.SessionOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newTokens, err := auth.RefreshSession(ctx, database, tokens.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = auth.VerifySession(ctx, database, tokens.Token)
+	if err != auth.ErrSessionNotFound {
+		t.Errorf("previous session token. expected: %v | got: %v", auth.ErrSessionNotFound, err)
+	}
+	_, err = auth.VerifySession(ctx, database, newTokens.Token)
+	if err != nil {
+		t.Errorf("new session token: %v", err)
+	}
+
+	// the first refresh token is presented again: the whole family is revoked
+	_, err = auth.RefreshSession(ctx, database, tokens.RefreshToken)
+	if err != auth.ErrRefreshTokenReused {
+		t.Errorf("reused refresh token. expected: %v | got: %v", auth.ErrRefreshTokenReused, err)
+	}
+
+	var sessions int64
+	err = database.Get(ctx, &sessions, "SELECT COUNT(*) FROM auth_sessions WHERE id = $1", session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sessions != 0 {
+		t.Error("session still exists after refresh token reuse")
+	}
+
+	_, err = auth.VerifySession(ctx, database, newTokens.Token)
+	if err != auth.ErrSessionNotFound {
+		t.Errorf("session token after revocation. expected: %v | got: %v", auth.ErrSessionNotFound, err)
+	}
+	_, err = auth.RefreshSession(ctx, database, newTokens.RefreshToken)
+	if err != auth.ErrSessionNotFound {
+		t.Errorf("refresh token after revocation. expected: %v | got: %v", auth.ErrSessionNotFound, err)
+	}
+}
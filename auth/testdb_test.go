@@ -0,0 +1,17 @@
+package auth_test
+
+import (
+	"testing"
+
+	"github.com/bloom42/stdx/auth"
+	"github.com/bloom42/stdx/db"
+	"github.com/bloom42/stdx/db/dbtest"
+)
+
+// newTestDatabase returns a connection to a new schema of the test database, where the migrations of the
+// package have been applied. See dbtest.New.
+func newTestDatabase(t *testing.T) db.DB {
+	t.Helper()
+
+	return dbtest.New(t, auth.Migrations(1))
+}
@@ -0,0 +1,341 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	"time"
+
+	"github.com/bloom42/stdx/crypto"
+	"github.com/bloom42/stdx/db"
+	"github.com/bloom42/stdx/otp"
+	"github.com/bloom42/stdx/otp/totp"
+	"github.com/bloom42/stdx/uuid"
+)
+
+// TOTP secrets are encrypted with crypto.Encrypt using an encryption key provided by the service, and the
+// ID of the account as additional data, so an encrypted secret can't be moved to another account.
+//
+// The time step of the last valid code is saved so a code can't be used twice.
+
+const (
+	TotpQrCodeSize = 256
+
+	totpPeriod = 30
+	totpSkew   = 1
+)
+
+var (
+	ErrTotpNotEnabled         = errors.New("auth: two-factor authentication is not enabled")
+	ErrTotpAlreadyEnabled     = errors.New("auth: two-factor authentication is already enabled")
+	ErrTotpEnrollmentNotFound = errors.New("auth: two-factor authentication enrollment not found")
+	ErrTotpCodeIsNotValid     = errors.New("auth: two-factor authentication code is not valid")
+	ErrTotpCodeAlreadyUsed    = errors.New("auth: two-factor authentication code has already been used")
+	ErrTotpEncryptionKeySize  = fmt.Errorf("auth: TOTP encryption key must be %d bytes", crypto.KeySize256)
+	ErrSecondFactorIsRequired = errors.New("auth: a two-factor authentication code is required")
+	ErrSecondFactorIsNotValid = errors.New("auth: two-factor authentication code or recovery code is not valid")
+)
+
+type totpRow struct {
+	AccountID       uuid.UUID  `db:"account_id"`
+	CreatedAt       time.Time  `db:"created_at"`
+	UpdatedAt       time.Time  `db:"updated_at"`
+	EncryptedSecret []byte     `db:"encrypted_secret"`
+	EnabledAt       *time.Time `db:"enabled_at"`
+	LastUsedStep    int64      `db:"last_used_step"`
+}
+
+// StartTotpEnrollment generates a new TOTP secret for an account and returns the key, which should be displayed
+// to the user along with its QR code. 2FA is only enabled once the enrollment is confirmed with
+// ConfirmTotpEnrollment.
+// encryptionKey is a 32-byte key used to encrypt the secret.
+func StartTotpEnrollment(ctx context.Context, db db.Queryer, encryptionKey []byte, accountID uuid.UUID, issuer, accountName string) (key *otp.Key, qrCode image.Image, err error) {
+	if len(encryptionKey) != crypto.KeySize256 {
+		err = ErrTotpEncryptionKeySize
+		return
+	}
+
+	key, err = totp.Generate(totp.GenerateOpts{
+		Issuer:      issuer,
+		AccountName: accountName,
+		Period:      totpPeriod,
+		Digits:      otp.DigitsSix,
+		Algorithm:   otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		err = fmt.Errorf("auth: generating TOTP key: %w", err)
+		return
+	}
+
+	qrCode, err = key.QrCode(TotpQrCodeSize, TotpQrCodeSize)
+	if err != nil {
+		err = fmt.Errorf("auth: generating TOTP QR code: %w", err)
+		return
+	}
+
+	encryptedSecret, err := encryptTotpSecret(encryptionKey, accountID, key.Secret())
+	if err != nil {
+		return
+	}
+
+	now := time.Now().UTC()
+	// a pending enrollment is replaced, but not an enabled one
+	result, err := db.Exec(ctx, `INSERT INTO auth_totp (account_id, created_at, updated_at, encrypted_secret, enabled_at, last_used_step)
+		VALUES ($1, $2, $2, $3, NULL, 0)
+		ON CONFLICT (account_id) DO UPDATE SET created_at = $2, updated_at = $2, encrypted_secret = $3, last_used_step = 0
+		WHERE auth_totp.enabled_at IS NULL`, accountID, now, encryptedSecret)
+	if err != nil {
+		err = fmt.Errorf("auth: saving TOTP secret: %w", err)
+		return
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		err = fmt.Errorf("auth: saving TOTP secret: %w", err)
+		return
+	}
+	if rowsAffected == 0 {
+		err = ErrTotpAlreadyEnabled
+		return
+	}
+
+	return
+}
+
+// ConfirmTotpEnrollment enables 2FA for an account if code is valid for the secret generated by
+// StartTotpEnrollment. It returns recovery codes which should be displayed once to the user.
+// ConfirmTotpEnrollment should be called within a transaction.
+func ConfirmTotpEnrollment(ctx context.Context, db db.Queryer, encryptionKey []byte, accountID uuid.UUID, code string) (recoveryCodes []string, err error) {
+	row, err := findTotp(ctx, db, accountID, true)
+	if err != nil {
+		if err == ErrTotpNotEnabled {
+			err = ErrTotpEnrollmentNotFound
+		}
+		return
+	}
+	if row.EnabledAt != nil {
+		err = ErrTotpAlreadyEnabled
+		return
+	}
+
+	step, err := validateTotpCode(encryptionKey, row, code, time.Now())
+	if err != nil {
+		return
+	}
+
+	now := time.Now().UTC()
+	_, err = db.Exec(ctx, "UPDATE auth_totp SET enabled_at = $1, updated_at = $1, last_used_step = $2 WHERE account_id = $3",
+		now, step, accountID)
+	if err != nil {
+		err = fmt.Errorf("auth: enabling TOTP: %w", err)
+		return
+	}
+
+	return GenerateRecoveryCodes(ctx, db, accountID)
+}
+
+// VerifyTotp verifies a TOTP code of an account. A code can only be used once.
+func VerifyTotp(ctx context.Context, db db.Queryer, encryptionKey []byte, accountID uuid.UUID, code string) (err error) {
+	row, err := findTotp(ctx, db, accountID, false)
+	if err != nil {
+		return
+	}
+	if row.EnabledAt == nil {
+		err = ErrTotpNotEnabled
+		return
+	}
+
+	step, err := validateTotpCode(encryptionKey, row, code, time.Now())
+	if err != nil {
+		return
+	}
+
+	// the condition on last_used_step prevents concurrent requests from using the same code
+	result, err := db.Exec(ctx, "UPDATE auth_totp SET last_used_step = $1 WHERE account_id = $2 AND last_used_step < $1",
+		step, accountID)
+	if err != nil {
+		err = fmt.Errorf("auth: saving TOTP step: %w", err)
+		return
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		err = fmt.Errorf("auth: saving TOTP step: %w", err)
+		return
+	}
+	if rowsAffected == 0 {
+		err = ErrTotpCodeAlreadyUsed
+		return
+	}
+
+	return
+}
+
+// IsTotpEnabled returns true if 2FA is enabled for an account
+func IsTotpEnabled(ctx context.Context, db db.Queryer, accountID uuid.UUID) (enabled bool, err error) {
+	err = db.Get(ctx, &enabled, "SELECT EXISTS(SELECT 1 FROM auth_totp WHERE account_id = $1 AND enabled_at IS NOT NULL)",
+		accountID)
+	if err != nil {
+		err = fmt.Errorf("auth: checking TOTP: %w", err)
+		return
+	}
+
+	return
+}
+
+// DisableTotp disables 2FA for an account and deletes its recovery codes.
+// DisableTotp should be called within a transaction.
+func DisableTotp(ctx context.Context, db db.Queryer, accountID uuid.UUID) (err error) {
+	_, err = db.Exec(ctx, "DELETE FROM auth_totp WHERE account_id = $1", accountID)
+	if err != nil {
+		err = fmt.Errorf("auth: deleting TOTP: %w", err)
+		return
+	}
+
+	_, err = db.Exec(ctx, "DELETE FROM auth_recovery_codes WHERE account_id = $1", accountID)
+	if err != nil {
+		err = fmt.Errorf("auth: deleting recovery codes: %w", err)
+		return
+	}
+
+	return
+}
+
+// VerifyLogin verifies the password of an account, and its second factor if 2FA is enabled.
+// secondFactorCode can be either a TOTP code or a recovery code. If 2FA is enabled and secondFactorCode is
+// empty, ErrSecondFactorIsRequired is returned, so the service can ask for a code.
+// legacyVerifiers are passed to VerifyPassword.
+// VerifyLogin should be called within a transaction.
+func VerifyLogin(ctx context.Context, db db.Queryer, totpEncryptionKey []byte, accountID uuid.UUID, password, secondFactorCode string, legacyVerifiers ...crypto.PasswordHashVerifier) (err error) {
+	err = VerifyPassword(ctx, db, accountID, password, legacyVerifiers...)
+	if err != nil {
+		return
+	}
+
+	totpEnabled, err := IsTotpEnabled(ctx, db, accountID)
+	if err != nil || !totpEnabled {
+		return
+	}
+
+	if secondFactorCode == "" {
+		err = ErrSecondFactorIsRequired
+		return
+	}
+
+	if isTotpCodeFormat(secondFactorCode) {
+		err = VerifyTotp(ctx, db, totpEncryptionKey, accountID, secondFactorCode)
+		if err == ErrTotpCodeIsNotValid {
+			err = ErrSecondFactorIsNotValid
+		}
+		return
+	}
+
+	err = UseRecoveryCode(ctx, db, accountID, secondFactorCode)
+	if err == ErrRecoveryCodeIsNotValid {
+		err = ErrSecondFactorIsNotValid
+	}
+	return
+}
+
+func findTotp(ctx context.Context, db db.Queryer, accountID uuid.UUID, forUpdate bool) (row totpRow, err error) {
+	query := `SELECT account_id, created_at, updated_at, encrypted_secret, enabled_at, last_used_step
+		FROM auth_totp WHERE account_id = $1`
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+
+	err = db.Get(ctx, &row, query, accountID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrTotpNotEnabled
+		} else {
+			err = fmt.Errorf("auth: getting TOTP: %w", err)
+		}
+		return
+	}
+
+	return
+}
+
+// validateTotpCode returns the time step of code if it's valid for the secret of row and has not already been
+// used
+func validateTotpCode(encryptionKey []byte, row totpRow, code string, now time.Time) (step int64, err error) {
+	if !isTotpCodeFormat(code) {
+		err = ErrTotpCodeIsNotValid
+		return
+	}
+
+	secret, err := decryptTotpSecret(encryptionKey, row.AccountID, row.EncryptedSecret)
+	if err != nil {
+		return
+	}
+
+	currentStep := now.Unix() / totpPeriod
+	for _, candidateStep := range []int64{currentStep, currentStep - totpSkew, currentStep + totpSkew} {
+		var valid bool
+		valid, err = totp.ValidateCustom(code, secret, time.Unix(candidateStep*totpPeriod, 0), totp.ValidateOpts{
+			Period:    totpPeriod,
+			Skew:      0,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil {
+			err = fmt.Errorf("auth: validating TOTP code: %w", err)
+			return
+		}
+		if valid {
+			if candidateStep <= row.LastUsedStep {
+				err = ErrTotpCodeAlreadyUsed
+				return
+			}
+			step = candidateStep
+			return
+		}
+	}
+
+	err = ErrTotpCodeIsNotValid
+	return
+}
+
+func isTotpCodeFormat(code string) bool {
+	if len(code) != otp.DigitsSix.Length() {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func encryptTotpSecret(encryptionKey []byte, accountID uuid.UUID, secret string) (encryptedSecret []byte, err error) {
+	if len(encryptionKey) != crypto.KeySize256 {
+		err = ErrTotpEncryptionKeySize
+		return
+	}
+
+	encryptedSecret, err = crypto.Encrypt(encryptionKey, []byte(secret), accountID[:])
+	if err != nil {
+		err = fmt.Errorf("auth: encrypting TOTP secret: %w", err)
+		return
+	}
+
+	return
+}
+
+func decryptTotpSecret(encryptionKey []byte, accountID uuid.UUID, encryptedSecret []byte) (secret string, err error) {
+	if len(encryptionKey) != crypto.KeySize256 {
+		err = ErrTotpEncryptionKeySize
+		return
+	}
+
+	secretBytes, err := crypto.Decrypt(encryptionKey, encryptedSecret, accountID[:])
+	if err != nil {
+		err = fmt.Errorf("auth: decrypting TOTP secret: %w", err)
+		return
+	}
+
+	secret = string(secretBytes)
+	return
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bloom42/stdx/otp"
+	"github.com/bloom42/stdx/otp/totp"
+	"github.com/bloom42/stdx/uuid"
+)
+
+func TestValidateTotpCode(t *testing.T) {
+	encryptionKey := make([]byte, 32)
+	accountID := uuid.New()
+	secret := "JBSWY3DPEHPK3PXP"
+	encryptedSecret, err := encryptTotpSecret(encryptionKey, accountID, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := totpRow{AccountID: accountID, EncryptedSecret: encryptedSecret}
+
+	now := time.Unix(1_700_000_010, 0)
+	currentStep := now.Unix() / totpPeriod
+	codeAt := func(offset time.Duration) string {
+		code, err := totp.GenerateCodeCustom(secret, now.Add(offset), totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	// codes of the previous and next time steps are accepted, to allow for clock skew
+	skewTests := []struct {
+		offset       time.Duration
+		expectedStep int64
+		expectedErr  error
+	}{
+		{0, currentStep, nil},
+		{-totpPeriod * time.Second, currentStep - 1, nil},
+		{totpPeriod * time.Second, currentStep + 1, nil},
+		{-2 * totpPeriod * time.Second, 0, ErrTotpCodeIsNotValid},
+		{2 * totpPeriod * time.Second, 0, ErrTotpCodeIsNotValid},
+	}
+	for _, test := range skewTests {
+		step, err := validateTotpCode(encryptionKey, row, codeAt(test.offset), now)
+		if err != test.expectedErr || step != test.expectedStep {
+			t.Errorf("offset %s. expected: step %d (%v) | got: step %d (%v)", test.offset, test.expectedStep,
+				test.expectedErr, step, err)
+		}
+	}
+
+	// codes of the last used step, and of the steps before it, can't be replayed
+	row.LastUsedStep = currentStep
+	for _, offset := range []time.Duration{0, -totpPeriod * time.Second} {
+		_, err = validateTotpCode(encryptionKey, row, codeAt(offset), now)
+		if err != ErrTotpCodeAlreadyUsed {
+			t.Errorf("replayed code (offset %s). expected: %v | got: %v", offset, ErrTotpCodeAlreadyUsed, err)
+		}
+	}
+	step, err := validateTotpCode(encryptionKey, row, codeAt(totpPeriod*time.Second), now)
+	if err != nil || step != currentStep+1 {
+		t.Errorf("code of the next step after use. expected: step %d | got: step %d (%v)", currentStep+1, step, err)
+	}
+
+	for _, code := range []string{"", "12345", "1234567", "abcdef"} {
+		_, err = validateTotpCode(encryptionKey, row, code, now)
+		if err != ErrTotpCodeIsNotValid {
+			t.Errorf("code (%s). expected: %v | got: %v", code, ErrTotpCodeIsNotValid, err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bloom42/stdx/auth"
+	"github.com/bloom42/stdx/crypto"
+	"github.com/bloom42/stdx/otp/totp"
+	"github.com/bloom42/stdx/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestStartTotpEnrollmentEncryptionKeySize(t *testing.T) {
+	ctx := context.Background()
+
+	// the encryption key is validated before querying the database
+	for _, keySize := range []int{0, 16, 64} {
+		_, _, err := auth.StartTotpEnrollment(ctx, nil, make([]byte, keySize), uuid.New(), "stdx", "test@example.com")
+		if err != auth.ErrTotpEncryptionKeySize {
+			t.Errorf("key size %d. expected: %v | got: %v", keySize, auth.ErrTotpEncryptionKeySize, err)
+		}
+	}
+}
+
+func TestUseRecoveryCodeFormat(t *testing.T) {
+	ctx := context.Background()
+
+	// malformed recovery codes are rejected before querying the database
+	for _, recoveryCode := range []string{"", "1234", "abcd-efgh-jkmn-pqrt-uvwx"} {
+		err := auth.UseRecoveryCode(ctx, nil, uuid.New(), recoveryCode)
+		if err != auth.ErrRecoveryCodeIsNotValid {
+			t.Errorf("recovery code (%s). expected: %v | got: %v", recoveryCode, auth.ErrRecoveryCodeIsNotValid, err)
+		}
+	}
+}
+
+func TestTotpAndRecoveryCodes(t *testing.T) {
+	database := newTestDatabase(t)
+	ctx := context.Background()
+	accountID := uuid.New()
+	password := "imported password"
+	encryptionKey := make([]byte, crypto.KeySize256)
+
+	err := auth.CreateAccount(ctx, database, accountID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the password hash was imported from another system
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = database.Exec(ctx, "INSERT INTO auth_passwords (account_id, created_at, updated_at, hash) VALUES ($1, NOW(), NOW(), $2)",
+		accountID, string(bcryptHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, _, err := auth.StartTotpEnrollment(ctx, database, encryptionKey, accountID, "stdx", "test@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	codeAt := func(offset time.Duration) string {
+		code, err := totp.GenerateCode(key.Secret(), time.Now().Add(offset))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	recoveryCodes, err := auth.ConfirmTotpEnrollment(ctx, database, encryptionKey, accountID, codeAt(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = auth.VerifyLogin(ctx, database, encryptionKey, accountID, password, "", crypto.BcryptVerifier)
+	if err != auth.ErrSecondFactorIsRequired {
+		t.Errorf("login without code. expected: %v | got: %v", auth.ErrSecondFactorIsRequired, err)
+	}
+
+	// users with imported password hashes can log in with 2FA. The code of the next time step is accepted
+	// because of the allowed clock skew.
+	err = auth.VerifyLogin(ctx, database, encryptionKey, accountID, password, codeAt(30*time.Second), crypto.BcryptVerifier)
+	if err != nil {
+		t.Fatalf("login with TOTP code: %v", err)
+	}
+
+	// a code can't be replayed, nor can the codes of the previous time steps
+	err = auth.VerifyTotp(ctx, database, encryptionKey, accountID, codeAt(30*time.Second))
+	if err != auth.ErrTotpCodeAlreadyUsed {
+		t.Errorf("replayed code. expected: %v | got: %v", auth.ErrTotpCodeAlreadyUsed, err)
+	}
+	err = auth.VerifyTotp(ctx, database, encryptionKey, accountID, codeAt(0))
+	if err != auth.ErrTotpCodeAlreadyUsed {
+		t.Errorf("code of a previous step. expected: %v | got: %v", auth.ErrTotpCodeAlreadyUsed, err)
+	}
+	// codes outside of the skew window are rejected
+	err = auth.VerifyTotp(ctx, database, encryptionKey, accountID, codeAt(90*time.Second))
+	if err != auth.ErrTotpCodeIsNotValid {
+		t.Errorf("code outside of the skew window. expected: %v | got: %v", auth.ErrTotpCodeIsNotValid, err)
+	}
+
+	// recovery codes can only be used once
+	err = auth.VerifyLogin(ctx, database, encryptionKey, accountID, password, recoveryCodes[0], crypto.BcryptVerifier)
+	if err != nil {
+		t.Fatalf("login with recovery code: %v", err)
+	}
+	err = auth.VerifyLogin(ctx, database, encryptionKey, accountID, password, recoveryCodes[0], crypto.BcryptVerifier)
+	if err != auth.ErrSecondFactorIsNotValid {
+		t.Errorf("reused recovery code. expected: %v | got: %v", auth.ErrSecondFactorIsNotValid, err)
+	}
+	count, err := auth.CountRecoveryCodes(ctx, database, accountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != int64(len(recoveryCodes)-1) {
+		t.Errorf("remaining recovery codes. expected: %d | got: %d", len(recoveryCodes)-1, count)
+	}
+}
@@ -0,0 +1,782 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bloom42/stdx/crypto"
+	"github.com/bloom42/stdx/db"
+	"github.com/bloom42/stdx/statelesstoken"
+	"github.com/bloom42/stdx/uuid"
+)
+
+// WebAuthn implements a WebAuthn (https://www.w3.org/TR/webauthn-2) relying party, to register and
+// authenticate with passkeys and security keys.
+//
+// Challenges are statelesstoken v2 tokens carrying the ID of the account, so no state needs to be stored
+// between the beginning and the end of a ceremony. Once used, their IDs are stored in the
+// auth_used_webauthn_challenges table until they expire, so responses can't be replayed (see
+// DeleteExpiredUsedWebAuthnChallenges). Options and responses use the JSON serialization of WebAuthn Level 3
+// (PublicKeyCredential.parseCreationOptionsFromJSON and PublicKeyCredential.toJSON), where binary fields are
+// encoded as base64url.
+//
+// The "none" and "packed" attestation formats are supported. Attestation certificates are checked but not
+// validated against a list of trusted roots, so attestation only proves the integrity of the registration.
+// Ed25519 (EdDSA) and P-256 (ES256) credentials are supported.
+
+const (
+	DefaultWebAuthnTimeout = 5 * time.Minute
+
+	WebAuthnCredentialNameMaxLength = 128
+
+	webAuthnRegistrationAudience   = "auth.webauthn.registration"
+	webAuthnAuthenticationAudience = "auth.webauthn.authentication"
+
+	webAuthnFlagUserPresent            byte = 1 << 0
+	webAuthnFlagUserVerified           byte = 1 << 2
+	webAuthnFlagAttestedCredentialData byte = 1 << 6
+	webAuthnFlagExtensionData          byte = 1 << 7
+
+	webAuthnCredentialIDMaxSize = 1023
+)
+
+var (
+	ErrWebAuthnResponseIsNotValid          = errors.New("auth: WebAuthn response is not valid")
+	ErrWebAuthnChallengeIsNotValid         = errors.New("auth: WebAuthn challenge is not valid")
+	ErrWebAuthnChallengeAlreadyUsed        = errors.New("auth: WebAuthn challenge has already been used")
+	ErrWebAuthnOriginIsNotValid            = errors.New("auth: WebAuthn origin is not valid")
+	ErrWebAuthnUserNotPresent              = errors.New("auth: WebAuthn user presence is required")
+	ErrWebAuthnUserNotVerified             = errors.New("auth: WebAuthn user verification is required")
+	ErrWebAuthnSignatureIsNotValid         = errors.New("auth: WebAuthn signature is not valid")
+	ErrWebAuthnAlgorithmIsNotSupported     = errors.New("auth: WebAuthn algorithm is not supported")
+	ErrWebAuthnAttestationIsNotValid       = errors.New("auth: WebAuthn attestation is not valid")
+	ErrWebAuthnAttestationIsNotSupported   = errors.New("auth: WebAuthn attestation format is not supported")
+	ErrWebAuthnSignCountIsNotValid         = errors.New("auth: WebAuthn signature counter is not valid. The authenticator may have been cloned")
+	ErrWebAuthnCredentialNotFound          = errors.New("auth: WebAuthn credential not found")
+	ErrWebAuthnCredentialAlreadyExists     = errors.New("auth: WebAuthn credential already exists")
+	ErrWebAuthnCredentialNameIsNotValid    = errors.New("auth: WebAuthn credential name is not valid")
+	errWebAuthnAuthenticatorDataIsNotValid = errors.New("auth: WebAuthn authenticator data is not valid")
+)
+
+type WebAuthnConfig struct {
+	// RelyingPartyID is the domain of the service, e.g. "example.com". Required.
+	RelyingPartyID string
+	// RelyingPartyName is the name of the service displayed by authenticators. Required.
+	RelyingPartyName string
+	// Origins are the origins allowed to use the credentials, e.g. "https://example.com". Required.
+	Origins []string
+	// Keyring is used to sign the challenges. Required.
+	Keyring *crypto.Keyring
+	// Timeout is the time the user has to complete a ceremony.
+	// default: DefaultWebAuthnTimeout
+	Timeout time.Duration
+	// RequireUserVerification requires the authenticators to verify the user, e.g. with a PIN or biometrics.
+	// default: false
+	RequireUserVerification bool
+}
+
+type WebAuthn struct {
+	config WebAuthnConfig
+}
+
+type WebAuthnCredential struct {
+	ID        []byte    `db:"id"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+
+	AccountID uuid.UUID `db:"account_id"`
+	Name      string    `db:"name"`
+	// PublicKey is the COSE-encoded public key of the credential
+	PublicKey         []byte     `db:"public_key"`
+	SignCount         int64      `db:"sign_count"`
+	AAGUID            []byte     `db:"aaguid"`
+	AttestationFormat string     `db:"attestation_format"`
+	LastUsedAt        *time.Time `db:"last_used_at"`
+}
+
+const webAuthnCredentialColumns = `id, created_at, updated_at, account_id, name, public_key, sign_count, aaguid,
+	attestation_format, last_used_at`
+
+// WebAuthnBytes is a byte slice encoded in JSON as a base64url string
+type WebAuthnBytes []byte
+
+func (data WebAuthnBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(data))
+}
+
+func (data *WebAuthnBytes) UnmarshalJSON(input []byte) (err error) {
+	var encoded string
+	err = json.Unmarshal(input, &encoded)
+	if err != nil {
+		return
+	}
+
+	*data, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	return
+}
+
+type WebAuthnRelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type WebAuthnUser struct {
+	ID          WebAuthnBytes `json:"id"`
+	Name        string        `json:"name"`
+	DisplayName string        `json:"displayName"`
+}
+
+type WebAuthnCredentialParameters struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+type WebAuthnCredentialDescriptor struct {
+	Type string        `json:"type"`
+	ID   WebAuthnBytes `json:"id"`
+}
+
+type WebAuthnAuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// WebAuthnCreationOptions are the options of navigator.credentials.create
+type WebAuthnCreationOptions struct {
+	RelyingParty           WebAuthnRelyingParty           `json:"rp"`
+	User                   WebAuthnUser                   `json:"user"`
+	Challenge              WebAuthnBytes                  `json:"challenge"`
+	PubKeyCredParams       []WebAuthnCredentialParameters `json:"pubKeyCredParams"`
+	Timeout                int64                          `json:"timeout"`
+	ExcludeCredentials     []WebAuthnCredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection WebAuthnAuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                         `json:"attestation"`
+}
+
+// WebAuthnRequestOptions are the options of navigator.credentials.get
+type WebAuthnRequestOptions struct {
+	Challenge        WebAuthnBytes                  `json:"challenge"`
+	Timeout          int64                          `json:"timeout"`
+	RelyingPartyID   string                         `json:"rpId"`
+	AllowCredentials []WebAuthnCredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                         `json:"userVerification"`
+}
+
+// WebAuthnRegistrationResponse is the PublicKeyCredential returned by navigator.credentials.create
+type WebAuthnRegistrationResponse struct {
+	ID       string                      `json:"id"`
+	RawID    WebAuthnBytes               `json:"rawId"`
+	Type     string                      `json:"type"`
+	Response WebAuthnAttestationResponse `json:"response"`
+}
+
+type WebAuthnAttestationResponse struct {
+	ClientDataJSON    WebAuthnBytes `json:"clientDataJSON"`
+	AttestationObject WebAuthnBytes `json:"attestationObject"`
+}
+
+// WebAuthnAuthenticationResponse is the PublicKeyCredential returned by navigator.credentials.get
+type WebAuthnAuthenticationResponse struct {
+	ID       string                    `json:"id"`
+	RawID    WebAuthnBytes             `json:"rawId"`
+	Type     string                    `json:"type"`
+	Response WebAuthnAssertionResponse `json:"response"`
+}
+
+type WebAuthnAssertionResponse struct {
+	ClientDataJSON    WebAuthnBytes `json:"clientDataJSON"`
+	AuthenticatorData WebAuthnBytes `json:"authenticatorData"`
+	Signature         WebAuthnBytes `json:"signature"`
+	UserHandle        WebAuthnBytes `json:"userHandle"`
+}
+
+type webAuthnChallenge struct {
+	AccountID uuid.UUID `json:"account_id"`
+}
+
+type webAuthnClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+type webAuthnAuthenticatorData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+	// the following fields are only set if the attested credential data flag is set
+	aaguid              []byte
+	credentialID        []byte
+	credentialPublicKey []byte
+}
+
+// webAuthnRegistration is a verified registration
+type webAuthnRegistration struct {
+	challenge         statelesstoken.Token[webAuthnChallenge]
+	credentialID      []byte
+	publicKey         []byte
+	signCount         uint32
+	aaguid            []byte
+	attestationFormat string
+}
+
+func NewWebAuthn(config WebAuthnConfig) (webauthn *WebAuthn, err error) {
+	if config.RelyingPartyID == "" || config.RelyingPartyName == "" {
+		err = errors.New("auth: WebAuthn relying party ID and name are required")
+		return
+	}
+	if len(config.Origins) == 0 {
+		err = errors.New("auth: WebAuthn origins are required")
+		return
+	}
+	if config.Keyring == nil {
+		err = errors.New("auth: WebAuthn keyring is required")
+		return
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultWebAuthnTimeout
+	}
+
+	webauthn = &WebAuthn{config: config}
+	return
+}
+
+// BeginRegistration returns the options to pass to navigator.credentials.create to register a new
+// credential for an account. userName and userDisplayName are displayed by the authenticator.
+func (webauthn *WebAuthn) BeginRegistration(ctx context.Context, db db.Queryer, accountID uuid.UUID, userName, userDisplayName string) (options WebAuthnCreationOptions, err error) {
+	challenge, err := webauthn.newChallenge(accountID, webAuthnRegistrationAudience)
+	if err != nil {
+		return
+	}
+
+	credentials, err := GetWebAuthnCredentialsForAccount(ctx, db, accountID)
+	if err != nil {
+		return
+	}
+
+	options = WebAuthnCreationOptions{
+		RelyingParty: WebAuthnRelyingParty{
+			ID:   webauthn.config.RelyingPartyID,
+			Name: webauthn.config.RelyingPartyName,
+		},
+		User: WebAuthnUser{
+			ID:          accountID[:],
+			Name:        userName,
+			DisplayName: userDisplayName,
+		},
+		Challenge: challenge,
+		PubKeyCredParams: []WebAuthnCredentialParameters{
+			{Type: "public-key", Alg: coseAlgorithmEdDSA},
+			{Type: "public-key", Alg: coseAlgorithmES256},
+		},
+		Timeout:            webauthn.config.Timeout.Milliseconds(),
+		ExcludeCredentials: webAuthnCredentialDescriptors(credentials),
+		AuthenticatorSelection: WebAuthnAuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: webauthn.userVerification(),
+		},
+		Attestation: "none",
+	}
+	return
+}
+
+// FinishRegistration verifies the response of navigator.credentials.create and saves the new credential.
+// name is chosen by the user to recognize the credential, e.g. "YubiKey".
+// FinishRegistration should be called within a transaction.
+func (webauthn *WebAuthn) FinishRegistration(ctx context.Context, db db.Queryer, accountID uuid.UUID, name string, response WebAuthnRegistrationResponse) (credential WebAuthnCredential, err error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len([]rune(name)) > WebAuthnCredentialNameMaxLength {
+		err = ErrWebAuthnCredentialNameIsNotValid
+		return
+	}
+
+	registration, err := webauthn.verifyRegistration(accountID, response)
+	if err != nil {
+		return
+	}
+
+	err = useWebAuthnChallenge(ctx, db, registration.challenge)
+	if err != nil {
+		return
+	}
+
+	now := time.Now().UTC()
+	credential = WebAuthnCredential{
+		ID:                registration.credentialID,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+		AccountID:         accountID,
+		Name:              name,
+		PublicKey:         registration.publicKey,
+		SignCount:         int64(registration.signCount),
+		AAGUID:            registration.aaguid,
+		AttestationFormat: registration.attestationFormat,
+	}
+
+	result, err := db.Exec(ctx, `INSERT INTO auth_webauthn_credentials (`+webAuthnCredentialColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (id) DO NOTHING`,
+		credential.ID, credential.CreatedAt, credential.UpdatedAt, credential.AccountID, credential.Name,
+		credential.PublicKey, credential.SignCount, credential.AAGUID, credential.AttestationFormat, credential.LastUsedAt,
+	)
+	if err != nil {
+		err = fmt.Errorf("auth: saving WebAuthn credential: %w", err)
+		return
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		err = fmt.Errorf("auth: saving WebAuthn credential: %w", err)
+		return
+	}
+	if rowsAffected == 0 {
+		err = ErrWebAuthnCredentialAlreadyExists
+		return
+	}
+
+	return
+}
+
+// BeginAuthentication returns the options to pass to navigator.credentials.get.
+// If accountID is uuid.Nil, any discoverable credential (passkey) can be used, so the user doesn't need to
+// enter a username.
+func (webauthn *WebAuthn) BeginAuthentication(ctx context.Context, db db.Queryer, accountID uuid.UUID) (options WebAuthnRequestOptions, err error) {
+	challenge, err := webauthn.newChallenge(accountID, webAuthnAuthenticationAudience)
+	if err != nil {
+		return
+	}
+
+	allowCredentials := []WebAuthnCredentialDescriptor{}
+	if accountID != uuid.Nil {
+		var credentials []WebAuthnCredential
+		credentials, err = GetWebAuthnCredentialsForAccount(ctx, db, accountID)
+		if err != nil {
+			return
+		}
+		if len(credentials) == 0 {
+			err = ErrWebAuthnCredentialNotFound
+			return
+		}
+		allowCredentials = webAuthnCredentialDescriptors(credentials)
+	}
+
+	options = WebAuthnRequestOptions{
+		Challenge:        challenge,
+		Timeout:          webauthn.config.Timeout.Milliseconds(),
+		RelyingPartyID:   webauthn.config.RelyingPartyID,
+		AllowCredentials: allowCredentials,
+		UserVerification: webauthn.userVerification(),
+	}
+	return
+}
+
+// FinishAuthentication verifies the response of navigator.credentials.get and returns the credential used,
+// whose AccountID is the authenticated account.
+// FinishAuthentication should be called within a transaction.
+func (webauthn *WebAuthn) FinishAuthentication(ctx context.Context, db db.Queryer, response WebAuthnAuthenticationResponse) (credential WebAuthnCredential, err error) {
+	if len(response.RawID) == 0 || len(response.RawID) > webAuthnCredentialIDMaxSize {
+		err = ErrWebAuthnResponseIsNotValid
+		return
+	}
+
+	err = db.Get(ctx, &credential, "SELECT "+webAuthnCredentialColumns+" FROM auth_webauthn_credentials WHERE id = $1 FOR UPDATE",
+		[]byte(response.RawID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrWebAuthnCredentialNotFound
+		} else {
+			err = fmt.Errorf("auth: getting WebAuthn credential: %w", err)
+		}
+		return
+	}
+
+	signCount, challenge, err := webauthn.verifyAuthentication(credential, response)
+	if err != nil {
+		return
+	}
+
+	// authenticators which don't implement the signature counter can't detect replayed responses, so
+	// challenges are single use
+	err = useWebAuthnChallenge(ctx, db, challenge)
+	if err != nil {
+		return
+	}
+
+	now := time.Now().UTC()
+	credential.SignCount = int64(signCount)
+	credential.LastUsedAt = &now
+	_, err = db.Exec(ctx, "UPDATE auth_webauthn_credentials SET sign_count = $1, last_used_at = $2 WHERE id = $3",
+		credential.SignCount, now, credential.ID)
+	if err != nil {
+		err = fmt.Errorf("auth: updating WebAuthn credential: %w", err)
+		return
+	}
+
+	return
+}
+
+// GetWebAuthnCredentialsForAccount returns the WebAuthn credentials of an account, most recent first
+func GetWebAuthnCredentialsForAccount(ctx context.Context, db db.Queryer, accountID uuid.UUID) (credentials []WebAuthnCredential, err error) {
+	credentials = make([]WebAuthnCredential, 0)
+	err = db.Select(ctx, &credentials, "SELECT "+webAuthnCredentialColumns+` FROM auth_webauthn_credentials
+		WHERE account_id = $1 ORDER BY created_at DESC`, accountID)
+	if err != nil {
+		err = fmt.Errorf("auth: getting WebAuthn credentials: %w", err)
+		return
+	}
+
+	return
+}
+
+// DeleteWebAuthnCredential deletes a WebAuthn credential of an account
+func DeleteWebAuthnCredential(ctx context.Context, db db.Queryer, accountID uuid.UUID, credentialID []byte) (err error) {
+	result, err := db.Exec(ctx, "DELETE FROM auth_webauthn_credentials WHERE id = $1 AND account_id = $2",
+		credentialID, accountID)
+	if err != nil {
+		err = fmt.Errorf("auth: deleting WebAuthn credential: %w", err)
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		err = fmt.Errorf("auth: deleting WebAuthn credential: %w", err)
+		return
+	}
+	if rowsAffected == 0 {
+		err = ErrWebAuthnCredentialNotFound
+		return
+	}
+
+	return
+}
+
+// DeleteExpiredUsedWebAuthnChallenges deletes the IDs of the used WebAuthn challenges which have expired.
+// It should be called periodically.
+func DeleteExpiredUsedWebAuthnChallenges(ctx context.Context, db db.Queryer) (deleted int64, err error) {
+	result, err := db.Exec(ctx, "DELETE FROM auth_used_webauthn_challenges WHERE expires_at < $1", time.Now().UTC())
+	if err != nil {
+		err = fmt.Errorf("auth: deleting expired used WebAuthn challenges: %w", err)
+		return
+	}
+
+	deleted, err = result.RowsAffected()
+	if err != nil {
+		err = fmt.Errorf("auth: deleting expired used WebAuthn challenges: %w", err)
+		return
+	}
+
+	return
+}
+
+// useWebAuthnChallenge marks a verified challenge as used, and returns ErrWebAuthnChallengeAlreadyUsed if it
+// has already been used
+func useWebAuthnChallenge(ctx context.Context, db db.Queryer, challenge statelesstoken.Token[webAuthnChallenge]) (err error) {
+	result, err := db.Exec(ctx, `INSERT INTO auth_used_webauthn_challenges (id, expires_at) VALUES ($1, $2)
+		ON CONFLICT (id) DO NOTHING`, challenge.ID(), challenge.Expire())
+	if err != nil {
+		err = fmt.Errorf("auth: using WebAuthn challenge: %w", err)
+		return
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		err = fmt.Errorf("auth: using WebAuthn challenge: %w", err)
+		return
+	}
+	if rowsAffected == 0 {
+		err = ErrWebAuthnChallengeAlreadyUsed
+		return
+	}
+
+	return
+}
+
+func (webauthn *WebAuthn) newChallenge(accountID uuid.UUID, audience string) (challenge []byte, err error) {
+	token, err := statelesstoken.NewV2(webauthn.config.Keyring, statelesstoken.Claims{
+		Audience: audience,
+		Expire:   time.Now().Add(webauthn.config.Timeout),
+	}, webAuthnChallenge{AccountID: accountID})
+	if err != nil {
+		err = fmt.Errorf("auth: generating WebAuthn challenge: %w", err)
+		return
+	}
+
+	challenge = []byte(token.String())
+	return
+}
+
+func (webauthn *WebAuthn) userVerification() string {
+	if webauthn.config.RequireUserVerification {
+		return "required"
+	}
+	return "preferred"
+}
+
+// verifyClientData verifies the client data of a ceremony and returns its challenge
+func (webauthn *WebAuthn) verifyClientData(clientDataJSON []byte, ceremonyType, audience string) (challenge statelesstoken.Token[webAuthnChallenge], err error) {
+	var clientData webAuthnClientData
+	err = json.Unmarshal(clientDataJSON, &clientData)
+	if err != nil || clientData.Type != ceremonyType {
+		err = ErrWebAuthnResponseIsNotValid
+		return
+	}
+
+	validOrigin := false
+	for _, origin := range webauthn.config.Origins {
+		if clientData.Origin == origin {
+			validOrigin = true
+			break
+		}
+	}
+	if !validOrigin || clientData.CrossOrigin {
+		err = ErrWebAuthnOriginIsNotValid
+		return
+	}
+
+	rawChallenge, err := base64.RawURLEncoding.DecodeString(clientData.Challenge)
+	if err != nil {
+		err = ErrWebAuthnChallengeIsNotValid
+		return
+	}
+
+	challenge, err = statelesstoken.ParseV2[webAuthnChallenge](string(rawChallenge))
+	if err != nil {
+		err = ErrWebAuthnChallengeIsNotValid
+		return
+	}
+	err = challenge.Verify(webauthn.config.Keyring, audience, 0)
+	if err != nil {
+		err = fmt.Errorf("%w: %w", ErrWebAuthnChallengeIsNotValid, err)
+		return
+	}
+
+	return
+}
+
+func (webauthn *WebAuthn) verifyAuthenticatorFlags(authenticatorData webAuthnAuthenticatorData) (err error) {
+	expectedRpIDHash := sha256.Sum256([]byte(webauthn.config.RelyingPartyID))
+	if !bytes.Equal(authenticatorData.rpIDHash, expectedRpIDHash[:]) {
+		err = fmt.Errorf("%w: relying party ID hash doesn't match", ErrWebAuthnResponseIsNotValid)
+		return
+	}
+
+	if authenticatorData.flags&webAuthnFlagUserPresent == 0 {
+		err = ErrWebAuthnUserNotPresent
+		return
+	}
+
+	if webauthn.config.RequireUserVerification && authenticatorData.flags&webAuthnFlagUserVerified == 0 {
+		err = ErrWebAuthnUserNotVerified
+		return
+	}
+
+	return
+}
+
+func (webauthn *WebAuthn) verifyRegistration(accountID uuid.UUID, response WebAuthnRegistrationResponse) (registration webAuthnRegistration, err error) {
+	if response.Type != "public-key" {
+		err = ErrWebAuthnResponseIsNotValid
+		return
+	}
+
+	challenge, err := webauthn.verifyClientData(response.Response.ClientDataJSON, "webauthn.create",
+		webAuthnRegistrationAudience)
+	if err != nil {
+		return
+	}
+	if challenge.Data().AccountID != accountID {
+		err = ErrWebAuthnChallengeIsNotValid
+		return
+	}
+
+	attestationObject, rest, err := cborDecode(response.Response.AttestationObject)
+	if err != nil || len(rest) != 0 {
+		err = ErrWebAuthnResponseIsNotValid
+		return
+	}
+	attestationMap, ok := attestationObject.(map[any]any)
+	if !ok {
+		err = ErrWebAuthnResponseIsNotValid
+		return
+	}
+	attestationFormat, formatOk := attestationMap["fmt"].(string)
+	attestationStatement, statementOk := attestationMap["attStmt"].(map[any]any)
+	rawAuthenticatorData, authenticatorDataOk := attestationMap["authData"].([]byte)
+	if !formatOk || !statementOk || !authenticatorDataOk {
+		err = ErrWebAuthnResponseIsNotValid
+		return
+	}
+
+	authenticatorData, err := parseWebAuthnAuthenticatorData(rawAuthenticatorData)
+	if err != nil {
+		return
+	}
+	err = webauthn.verifyAuthenticatorFlags(authenticatorData)
+	if err != nil {
+		return
+	}
+	if authenticatorData.flags&webAuthnFlagAttestedCredentialData == 0 {
+		err = fmt.Errorf("%w: attested credential data is missing", ErrWebAuthnResponseIsNotValid)
+		return
+	}
+	if !bytes.Equal(authenticatorData.credentialID, response.RawID) {
+		err = fmt.Errorf("%w: credential ID doesn't match", ErrWebAuthnResponseIsNotValid)
+		return
+	}
+
+	credentialPublicKey, err := parseCoseKey(authenticatorData.credentialPublicKey)
+	if err != nil {
+		return
+	}
+
+	clientDataHash := sha256.Sum256(response.Response.ClientDataJSON)
+	signedData := append(append([]byte{}, rawAuthenticatorData...), clientDataHash[:]...)
+
+	switch attestationFormat {
+	case "none":
+		if len(attestationStatement) != 0 {
+			err = ErrWebAuthnAttestationIsNotValid
+			return
+		}
+	case "packed":
+		err = verifyPackedAttestation(attestationStatement, signedData, credentialPublicKey, authenticatorData.aaguid)
+		if err != nil {
+			return
+		}
+	default:
+		err = ErrWebAuthnAttestationIsNotSupported
+		return
+	}
+
+	registration = webAuthnRegistration{
+		challenge:         challenge,
+		credentialID:      authenticatorData.credentialID,
+		publicKey:         authenticatorData.credentialPublicKey,
+		signCount:         authenticatorData.signCount,
+		aaguid:            authenticatorData.aaguid,
+		attestationFormat: attestationFormat,
+	}
+	return
+}
+
+// verifyAuthentication verifies an assertion made with credential and returns the new signature counter and
+// the challenge of the assertion
+func (webauthn *WebAuthn) verifyAuthentication(credential WebAuthnCredential, response WebAuthnAuthenticationResponse) (signCount uint32, challenge statelesstoken.Token[webAuthnChallenge], err error) {
+	if response.Type != "public-key" || !bytes.Equal(response.RawID, credential.ID) {
+		err = ErrWebAuthnResponseIsNotValid
+		return
+	}
+
+	challenge, err = webauthn.verifyClientData(response.Response.ClientDataJSON, "webauthn.get",
+		webAuthnAuthenticationAudience)
+	if err != nil {
+		return
+	}
+	// if the challenge was issued for a specific account, the credential must belong to it
+	if challengeAccountID := challenge.Data().AccountID; challengeAccountID != uuid.Nil && challengeAccountID != credential.AccountID {
+		err = ErrWebAuthnCredentialNotFound
+		return
+	}
+	if len(response.Response.UserHandle) != 0 && !bytes.Equal(response.Response.UserHandle, credential.AccountID[:]) {
+		err = ErrWebAuthnCredentialNotFound
+		return
+	}
+
+	authenticatorData, err := parseWebAuthnAuthenticatorData(response.Response.AuthenticatorData)
+	if err != nil {
+		return
+	}
+	err = webauthn.verifyAuthenticatorFlags(authenticatorData)
+	if err != nil {
+		return
+	}
+
+	publicKey, err := parseCoseKey(credential.PublicKey)
+	if err != nil {
+		return
+	}
+
+	clientDataHash := sha256.Sum256(response.Response.ClientDataJSON)
+	signedData := append(append([]byte{}, response.Response.AuthenticatorData...), clientDataHash[:]...)
+	if !publicKey.verify(signedData, response.Response.Signature) {
+		err = ErrWebAuthnSignatureIsNotValid
+		return
+	}
+
+	// authenticators which don't implement the counter always return 0
+	signCount = authenticatorData.signCount
+	if (signCount != 0 || credential.SignCount != 0) && int64(signCount) <= credential.SignCount {
+		err = ErrWebAuthnSignCountIsNotValid
+		return
+	}
+
+	return
+}
+
+func parseWebAuthnAuthenticatorData(data []byte) (authenticatorData webAuthnAuthenticatorData, err error) {
+	if len(data) < 37 {
+		err = errWebAuthnAuthenticatorDataIsNotValid
+		return
+	}
+
+	authenticatorData.rpIDHash = data[:32]
+	authenticatorData.flags = data[32]
+	authenticatorData.signCount = binary.BigEndian.Uint32(data[33:37])
+	rest := data[37:]
+
+	if authenticatorData.flags&webAuthnFlagAttestedCredentialData != 0 {
+		if len(rest) < 18 {
+			err = errWebAuthnAuthenticatorDataIsNotValid
+			return
+		}
+		authenticatorData.aaguid = rest[:16]
+		credentialIDLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if credentialIDLength == 0 || credentialIDLength > webAuthnCredentialIDMaxSize || len(rest) < credentialIDLength {
+			err = errWebAuthnAuthenticatorDataIsNotValid
+			return
+		}
+		authenticatorData.credentialID = rest[:credentialIDLength]
+		rest = rest[credentialIDLength:]
+
+		var afterPublicKey []byte
+		_, afterPublicKey, err = cborDecode(rest)
+		if err != nil {
+			err = errWebAuthnAuthenticatorDataIsNotValid
+			return
+		}
+		authenticatorData.credentialPublicKey = rest[:len(rest)-len(afterPublicKey)]
+		rest = afterPublicKey
+	}
+
+	if authenticatorData.flags&webAuthnFlagExtensionData != 0 {
+		_, rest, err = cborDecode(rest)
+		if err != nil {
+			err = errWebAuthnAuthenticatorDataIsNotValid
+			return
+		}
+	}
+
+	if len(rest) != 0 {
+		err = errWebAuthnAuthenticatorDataIsNotValid
+		return
+	}
+
+	return
+}
+
+func webAuthnCredentialDescriptors(credentials []WebAuthnCredential) (descriptors []WebAuthnCredentialDescriptor) {
+	descriptors = make([]WebAuthnCredentialDescriptor, len(credentials))
+	for i, credential := range credentials {
+		descriptors[i] = WebAuthnCredentialDescriptor{Type: "public-key", ID: credential.ID}
+	}
+	return
+}
@@ -0,0 +1,228 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+)
+
+// COSE (RFC 9052 and RFC 9053) identifiers of the keys and algorithms supported for WebAuthn credentials
+const (
+	coseAlgorithmEdDSA int64 = -8
+	coseAlgorithmES256 int64 = -7
+
+	coseKeyTypeOKP int64 = 1
+	coseKeyTypeEC2 int64 = 2
+
+	coseCurveP256    int64 = 1
+	coseCurveEd25519 int64 = 6
+
+	coseKeyLabelKeyType   int64 = 1
+	coseKeyLabelAlgorithm int64 = 3
+	coseKeyLabelCurve     int64 = -1
+	coseKeyLabelX         int64 = -2
+	coseKeyLabelY         int64 = -3
+)
+
+// oidFidoGenCeAaguid is the OID of the extension of attestation certificates containing the AAGUID of the
+// authenticator
+var oidFidoGenCeAaguid = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+type coseKey struct {
+	algorithm int64
+	ed25519   ed25519.PublicKey
+	ecdsa     *ecdsa.PublicKey
+}
+
+func parseCoseKey(data []byte) (key coseKey, err error) {
+	decoded, rest, err := cborDecode(data)
+	if err != nil || len(rest) != 0 {
+		err = fmt.Errorf("%w: public key is not valid", ErrWebAuthnResponseIsNotValid)
+		return
+	}
+	keyMap, ok := decoded.(map[any]any)
+	if !ok {
+		err = fmt.Errorf("%w: public key is not valid", ErrWebAuthnResponseIsNotValid)
+		return
+	}
+
+	keyType, _ := keyMap[coseKeyLabelKeyType].(int64)
+	key.algorithm, _ = keyMap[coseKeyLabelAlgorithm].(int64)
+	curve, _ := keyMap[coseKeyLabelCurve].(int64)
+	x, _ := keyMap[coseKeyLabelX].([]byte)
+
+	switch {
+	case key.algorithm == coseAlgorithmEdDSA && keyType == coseKeyTypeOKP && curve == coseCurveEd25519:
+		if len(x) != ed25519.PublicKeySize {
+			err = fmt.Errorf("%w: Ed25519 public key is not valid", ErrWebAuthnResponseIsNotValid)
+			return
+		}
+		key.ed25519 = ed25519.PublicKey(x)
+
+	case key.algorithm == coseAlgorithmES256 && keyType == coseKeyTypeEC2 && curve == coseCurveP256:
+		y, _ := keyMap[coseKeyLabelY].([]byte)
+		key.ecdsa, err = newP256PublicKey(x, y)
+		if err != nil {
+			return
+		}
+
+	default:
+		err = ErrWebAuthnAlgorithmIsNotSupported
+		return
+	}
+
+	return
+}
+
+func newP256PublicKey(x, y []byte) (publicKey *ecdsa.PublicKey, err error) {
+	if len(x) != 32 || len(y) != 32 {
+		err = fmt.Errorf("%w: P-256 public key is not valid", ErrWebAuthnResponseIsNotValid)
+		return
+	}
+
+	// crypto/ecdh checks that the point is on the curve
+	uncompressedPoint := append(append([]byte{4}, x...), y...)
+	_, err = ecdh.P256().NewPublicKey(uncompressedPoint)
+	if err != nil {
+		err = fmt.Errorf("%w: P-256 public key is not valid", ErrWebAuthnResponseIsNotValid)
+		return
+	}
+
+	publicKey = &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}
+	return
+}
+
+func (key coseKey) verify(data, signature []byte) bool {
+	switch key.algorithm {
+	case coseAlgorithmEdDSA:
+		return ed25519.Verify(key.ed25519, data, signature)
+	case coseAlgorithmES256:
+		hash := sha256.Sum256(data)
+		return ecdsa.VerifyASN1(key.ecdsa, hash[:], signature)
+	default:
+		return false
+	}
+}
+
+// verifyPackedAttestation verifies a "packed" attestation statement (https://www.w3.org/TR/webauthn-2/#sctn-packed-attestation),
+// either self attestation, signed by the credential key, or signed by an attestation certificate.
+func verifyPackedAttestation(statement map[any]any, signedData []byte, credentialPublicKey coseKey, aaguid []byte) (err error) {
+	algorithm, algorithmOk := statement["alg"].(int64)
+	signature, signatureOk := statement["sig"].([]byte)
+	if !algorithmOk || !signatureOk {
+		err = ErrWebAuthnAttestationIsNotValid
+		return
+	}
+
+	x5c, hasCertificates := statement["x5c"].([]any)
+	if !hasCertificates {
+		if _, ok := statement["x5c"]; ok {
+			err = ErrWebAuthnAttestationIsNotValid
+			return
+		}
+
+		// self attestation
+		if algorithm != credentialPublicKey.algorithm {
+			err = ErrWebAuthnAttestationIsNotValid
+			return
+		}
+		if !credentialPublicKey.verify(signedData, signature) {
+			err = fmt.Errorf("%w: signature is not valid", ErrWebAuthnAttestationIsNotValid)
+			return
+		}
+		return
+	}
+
+	if len(x5c) == 0 {
+		err = ErrWebAuthnAttestationIsNotValid
+		return
+	}
+	certificateDER, ok := x5c[0].([]byte)
+	if !ok {
+		err = ErrWebAuthnAttestationIsNotValid
+		return
+	}
+	certificate, err := x509.ParseCertificate(certificateDER)
+	if err != nil {
+		err = fmt.Errorf("%w: parsing certificate: %w", ErrWebAuthnAttestationIsNotValid, err)
+		return
+	}
+
+	err = verifyPackedAttestationCertificate(certificate, aaguid)
+	if err != nil {
+		return
+	}
+
+	var attestationKey coseKey
+	attestationKey.algorithm = algorithm
+	switch publicKey := certificate.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		if algorithm != coseAlgorithmES256 || publicKey.Curve != elliptic.P256() {
+			err = ErrWebAuthnAlgorithmIsNotSupported
+			return
+		}
+		attestationKey.ecdsa = publicKey
+	case ed25519.PublicKey:
+		if algorithm != coseAlgorithmEdDSA {
+			err = ErrWebAuthnAlgorithmIsNotSupported
+			return
+		}
+		attestationKey.ed25519 = publicKey
+	default:
+		err = ErrWebAuthnAlgorithmIsNotSupported
+		return
+	}
+
+	if !attestationKey.verify(signedData, signature) {
+		err = fmt.Errorf("%w: signature is not valid", ErrWebAuthnAttestationIsNotValid)
+		return
+	}
+
+	return
+}
+
+// verifyPackedAttestationCertificate checks the requirements of https://www.w3.org/TR/webauthn-2/#sctn-packed-attestation-cert-requirements
+func verifyPackedAttestationCertificate(certificate *x509.Certificate, aaguid []byte) (err error) {
+	hasAttestationOU := false
+	for _, organizationalUnit := range certificate.Subject.OrganizationalUnit {
+		if organizationalUnit == "Authenticator Attestation" {
+			hasAttestationOU = true
+			break
+		}
+	}
+
+	if certificate.Version != 3 || !hasAttestationOU || !certificate.BasicConstraintsValid || certificate.IsCA {
+		err = fmt.Errorf("%w: certificate doesn't meet the requirements", ErrWebAuthnAttestationIsNotValid)
+		return
+	}
+
+	for _, extension := range certificate.Extensions {
+		if !extension.Id.Equal(oidFidoGenCeAaguid) {
+			continue
+		}
+
+		if extension.Critical {
+			err = fmt.Errorf("%w: AAGUID extension must not be critical", ErrWebAuthnAttestationIsNotValid)
+			return
+		}
+		var certificateAaguid []byte
+		_, err = asn1.Unmarshal(extension.Value, &certificateAaguid)
+		if err != nil || !bytes.Equal(certificateAaguid, aaguid) {
+			err = fmt.Errorf("%w: AAGUID doesn't match", ErrWebAuthnAttestationIsNotValid)
+			return
+		}
+	}
+
+	return
+}
@@ -0,0 +1,654 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/bloom42/stdx/crypto"
+	"github.com/bloom42/stdx/db/dbtest"
+	"github.com/bloom42/stdx/uuid"
+)
+
+const (
+	testRelyingPartyID = "example.com"
+	testOrigin         = "https://example.com"
+)
+
+// cborTestMap is a CBOR map whose keys are encoded in order
+type cborTestMap []cborTestPair
+
+type cborTestPair struct {
+	key   any
+	value any
+}
+
+func cborTestEncode(value any) []byte {
+	header := func(major byte, argument uint64) []byte {
+		switch {
+		case argument < 24:
+			return []byte{major<<5 | byte(argument)}
+		case argument <= 0xff:
+			return []byte{major<<5 | 24, byte(argument)}
+		case argument <= 0xffff:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(argument))
+		default:
+			return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(argument))
+		}
+	}
+
+	switch value := value.(type) {
+	case int64:
+		if value < 0 {
+			return header(cborMajorNegative, uint64(-1-value))
+		}
+		return header(cborMajorUnsigned, uint64(value))
+	case int:
+		return cborTestEncode(int64(value))
+	case []byte:
+		return append(header(cborMajorBytes, uint64(len(value))), value...)
+	case string:
+		return append(header(cborMajorText, uint64(len(value))), value...)
+	case []any:
+		encoded := header(cborMajorArray, uint64(len(value)))
+		for _, item := range value {
+			encoded = append(encoded, cborTestEncode(item)...)
+		}
+		return encoded
+	case cborTestMap:
+		encoded := header(cborMajorMap, uint64(len(value)))
+		for _, pair := range value {
+			encoded = append(encoded, cborTestEncode(pair.key)...)
+			encoded = append(encoded, cborTestEncode(pair.value)...)
+		}
+		return encoded
+	case bool:
+		if value {
+			return []byte{0xf5}
+		}
+		return []byte{0xf4}
+	default:
+		panic("cborTestEncode: unsupported type")
+	}
+}
+
+// softwareAuthenticator is a minimal WebAuthn authenticator
+type softwareAuthenticator struct {
+	rpID         string
+	origin       string
+	aaguid       []byte
+	credentialID []byte
+	algorithm    int64
+	ed25519Key   ed25519.PrivateKey
+	ecdsaKey     *ecdsa.PrivateKey
+	signCount    uint32
+	// counterless authenticators don't implement the signature counter, which is always 0
+	counterless bool
+	// attestation is "none", "packed" (self attestation) or "packed-x5c"
+	attestation            string
+	attestationKey         *ecdsa.PrivateKey
+	attestationCertificate []byte
+}
+
+func newSoftwareAuthenticator(t *testing.T, algorithm int64, attestation string) *softwareAuthenticator {
+	authenticator := &softwareAuthenticator{
+		rpID:         testRelyingPartyID,
+		origin:       testOrigin,
+		aaguid:       make([]byte, 16),
+		credentialID: make([]byte, 32),
+		algorithm:    algorithm,
+		attestation:  attestation,
+	}
+	rand.Read(authenticator.aaguid)
+	rand.Read(authenticator.credentialID)
+
+	var err error
+	switch algorithm {
+	case coseAlgorithmEdDSA:
+		_, authenticator.ed25519Key, err = ed25519.GenerateKey(rand.Reader)
+	case coseAlgorithmES256:
+		authenticator.ecdsaKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if attestation == "packed-x5c" {
+		authenticator.attestationKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		aaguidExtension, _ := asn1.Marshal(authenticator.aaguid)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject: pkix.Name{
+				Country:            []string{"FR"},
+				Organization:       []string{"stdx"},
+				OrganizationalUnit: []string{"Authenticator Attestation"},
+				CommonName:         "stdx software authenticator",
+			},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			BasicConstraintsValid: true,
+			IsCA:                  false,
+			ExtraExtensions:       []pkix.Extension{{Id: oidFidoGenCeAaguid, Value: aaguidExtension}},
+		}
+		authenticator.attestationCertificate, err = x509.CreateCertificate(rand.Reader, template, template,
+			&authenticator.attestationKey.PublicKey, authenticator.attestationKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return authenticator
+}
+
+func (authenticator *softwareAuthenticator) sign(key any, data []byte) []byte {
+	switch key := key.(type) {
+	case ed25519.PrivateKey:
+		return ed25519.Sign(key, data)
+	case *ecdsa.PrivateKey:
+		hash := sha256.Sum256(data)
+		signature, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+		if err != nil {
+			panic(err)
+		}
+		return signature
+	default:
+		panic("sign: unsupported key")
+	}
+}
+
+func (authenticator *softwareAuthenticator) credentialKey() any {
+	if authenticator.ed25519Key != nil {
+		return authenticator.ed25519Key
+	}
+	return authenticator.ecdsaKey
+}
+
+func (authenticator *softwareAuthenticator) cosePublicKey() []byte {
+	if authenticator.ed25519Key != nil {
+		return cborTestEncode(cborTestMap{
+			{coseKeyLabelKeyType, coseKeyTypeOKP},
+			{coseKeyLabelAlgorithm, coseAlgorithmEdDSA},
+			{coseKeyLabelCurve, coseCurveEd25519},
+			{coseKeyLabelX, []byte(authenticator.ed25519Key.Public().(ed25519.PublicKey))},
+		})
+	}
+
+	x := authenticator.ecdsaKey.X.FillBytes(make([]byte, 32))
+	y := authenticator.ecdsaKey.Y.FillBytes(make([]byte, 32))
+	return cborTestEncode(cborTestMap{
+		{coseKeyLabelKeyType, coseKeyTypeEC2},
+		{coseKeyLabelAlgorithm, coseAlgorithmES256},
+		{coseKeyLabelCurve, coseCurveP256},
+		{coseKeyLabelX, x},
+		{coseKeyLabelY, y},
+	})
+}
+
+func (authenticator *softwareAuthenticator) authenticatorData(flags byte, attestedCredentialData bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(authenticator.rpID))
+	data := append([]byte{}, rpIDHash[:]...)
+	if attestedCredentialData {
+		flags |= webAuthnFlagAttestedCredentialData
+	}
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, authenticator.signCount)
+
+	if attestedCredentialData {
+		data = append(data, authenticator.aaguid...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(authenticator.credentialID)))
+		data = append(data, authenticator.credentialID...)
+		data = append(data, authenticator.cosePublicKey()...)
+	}
+
+	return data
+}
+
+func (authenticator *softwareAuthenticator) clientDataJSON(ceremonyType string, challenge []byte) []byte {
+	clientDataJSON, _ := json.Marshal(webAuthnClientData{
+		Type:      ceremonyType,
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		Origin:    authenticator.origin,
+	})
+	return clientDataJSON
+}
+
+func (authenticator *softwareAuthenticator) create(challenge []byte) (response WebAuthnRegistrationResponse) {
+	clientDataJSON := authenticator.clientDataJSON("webauthn.create", challenge)
+	authenticatorData := authenticator.authenticatorData(webAuthnFlagUserPresent|webAuthnFlagUserVerified, true)
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signedData := append(append([]byte{}, authenticatorData...), clientDataHash[:]...)
+
+	var attestationFormat string
+	var attestationStatement cborTestMap
+	switch authenticator.attestation {
+	case "none":
+		attestationFormat = "none"
+		attestationStatement = cborTestMap{}
+	case "packed":
+		attestationFormat = "packed"
+		attestationStatement = cborTestMap{
+			{"alg", authenticator.algorithm},
+			{"sig", authenticator.sign(authenticator.credentialKey(), signedData)},
+		}
+	case "packed-x5c":
+		attestationFormat = "packed"
+		attestationStatement = cborTestMap{
+			{"alg", coseAlgorithmES256},
+			{"sig", authenticator.sign(authenticator.attestationKey, signedData)},
+			{"x5c", []any{authenticator.attestationCertificate}},
+		}
+	}
+
+	attestationObject := cborTestEncode(cborTestMap{
+		{"fmt", attestationFormat},
+		{"attStmt", attestationStatement},
+		{"authData", authenticatorData},
+	})
+
+	response = WebAuthnRegistrationResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(authenticator.credentialID),
+		RawID: authenticator.credentialID,
+		Type:  "public-key",
+		Response: WebAuthnAttestationResponse{
+			ClientDataJSON:    clientDataJSON,
+			AttestationObject: attestationObject,
+		},
+	}
+	return
+}
+
+func (authenticator *softwareAuthenticator) get(challenge []byte, userHandle []byte) (response WebAuthnAuthenticationResponse) {
+	if !authenticator.counterless {
+		authenticator.signCount += 1
+	}
+
+	clientDataJSON := authenticator.clientDataJSON("webauthn.get", challenge)
+	authenticatorData := authenticator.authenticatorData(webAuthnFlagUserPresent|webAuthnFlagUserVerified, false)
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signedData := append(append([]byte{}, authenticatorData...), clientDataHash[:]...)
+
+	response = WebAuthnAuthenticationResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(authenticator.credentialID),
+		RawID: authenticator.credentialID,
+		Type:  "public-key",
+		Response: WebAuthnAssertionResponse{
+			ClientDataJSON:    clientDataJSON,
+			AuthenticatorData: authenticatorData,
+			Signature:         authenticator.sign(authenticator.credentialKey(), signedData),
+			UserHandle:        userHandle,
+		},
+	}
+	return
+}
+
+func newTestWebAuthn(t *testing.T) *WebAuthn {
+	key, err := crypto.NewAEADKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := crypto.NewKeyring(map[crypto.KeyID][]byte{1: key}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	webauthn, err := NewWebAuthn(WebAuthnConfig{
+		RelyingPartyID:          testRelyingPartyID,
+		RelyingPartyName:        "Example",
+		Origins:                 []string{testOrigin},
+		Keyring:                 keyring,
+		RequireUserVerification: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return webauthn
+}
+
+// registerTestCredential runs a registration ceremony and returns the credential as it would be stored
+func registerTestCredential(t *testing.T, webauthn *WebAuthn, authenticator *softwareAuthenticator, accountID uuid.UUID) WebAuthnCredential {
+	challenge, err := webauthn.newChallenge(accountID, webAuthnRegistrationAudience)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registration, err := webauthn.verifyRegistration(accountID, authenticator.create(challenge))
+	if err != nil {
+		t.Fatalf("verifying registration: %v", err)
+	}
+
+	return WebAuthnCredential{
+		ID:                registration.credentialID,
+		AccountID:         accountID,
+		PublicKey:         registration.publicKey,
+		SignCount:         int64(registration.signCount),
+		AAGUID:            registration.aaguid,
+		AttestationFormat: registration.attestationFormat,
+	}
+}
+
+func TestWebAuthnCeremonies(t *testing.T) {
+	webauthn := newTestWebAuthn(t)
+
+	tests := []struct {
+		name        string
+		algorithm   int64
+		attestation string
+	}{
+		{"Ed25519 none", coseAlgorithmEdDSA, "none"},
+		{"ES256 none", coseAlgorithmES256, "none"},
+		{"Ed25519 packed self attestation", coseAlgorithmEdDSA, "packed"},
+		{"ES256 packed self attestation", coseAlgorithmES256, "packed"},
+		{"ES256 packed with certificate", coseAlgorithmES256, "packed-x5c"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			accountID := uuid.New()
+			authenticator := newSoftwareAuthenticator(t, test.algorithm, test.attestation)
+			credential := registerTestCredential(t, webauthn, authenticator, accountID)
+
+			if credential.AttestationFormat != "packed" && credential.AttestationFormat != "none" {
+				t.Errorf("attestation format: %s", credential.AttestationFormat)
+			}
+
+			for i := 0; i < 3; i += 1 {
+				// discoverable credentials use challenges without account
+				challengeAccountID := accountID
+				if i == 1 {
+					challengeAccountID = uuid.Nil
+				}
+				challenge, err := webauthn.newChallenge(challengeAccountID, webAuthnAuthenticationAudience)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				signCount, _, err := webauthn.verifyAuthentication(credential, authenticator.get(challenge, accountID[:]))
+				if err != nil {
+					t.Fatalf("authentication #%d: %v", i, err)
+				}
+				if signCount != authenticator.signCount {
+					t.Errorf("sign count. expected: %d | got: %d", authenticator.signCount, signCount)
+				}
+				credential.SignCount = int64(signCount)
+			}
+		})
+	}
+}
+
+func TestWebAuthnRegistrationErrors(t *testing.T) {
+	webauthn := newTestWebAuthn(t)
+	accountID := uuid.New()
+
+	challenge, err := webauthn.newChallenge(accountID, webAuthnRegistrationAudience)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticationChallenge, err := webauthn.newChallenge(accountID, webAuthnAuthenticationAudience)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator := newSoftwareAuthenticator(t, coseAlgorithmES256, "packed")
+	_, err = webauthn.verifyRegistration(uuid.New(), authenticator.create(challenge))
+	if !errors.Is(err, ErrWebAuthnChallengeIsNotValid) {
+		t.Errorf("challenge of another account. expected: %v | got: %v", ErrWebAuthnChallengeIsNotValid, err)
+	}
+
+	_, err = webauthn.verifyRegistration(accountID, authenticator.create(authenticationChallenge))
+	if !errors.Is(err, ErrWebAuthnChallengeIsNotValid) {
+		t.Errorf("authentication challenge. expected: %v | got: %v", ErrWebAuthnChallengeIsNotValid, err)
+	}
+
+	_, err = webauthn.verifyRegistration(accountID, authenticator.create([]byte("random challenge")))
+	if !errors.Is(err, ErrWebAuthnChallengeIsNotValid) {
+		t.Errorf("forged challenge. expected: %v | got: %v", ErrWebAuthnChallengeIsNotValid, err)
+	}
+
+	authenticator.origin = "https://evil.com"
+	_, err = webauthn.verifyRegistration(accountID, authenticator.create(challenge))
+	if !errors.Is(err, ErrWebAuthnOriginIsNotValid) {
+		t.Errorf("origin. expected: %v | got: %v", ErrWebAuthnOriginIsNotValid, err)
+	}
+	authenticator.origin = testOrigin
+
+	authenticator.rpID = "evil.com"
+	_, err = webauthn.verifyRegistration(accountID, authenticator.create(challenge))
+	if !errors.Is(err, ErrWebAuthnResponseIsNotValid) {
+		t.Errorf("relying party ID. expected: %v | got: %v", ErrWebAuthnResponseIsNotValid, err)
+	}
+	authenticator.rpID = testRelyingPartyID
+
+	response := authenticator.create(challenge)
+	response.Response.ClientDataJSON = authenticator.clientDataJSON("webauthn.create", append(challenge, ' '))
+	_, err = webauthn.verifyRegistration(accountID, response)
+	if !errors.Is(err, ErrWebAuthnChallengeIsNotValid) {
+		t.Errorf("tampered challenge. expected: %v | got: %v", ErrWebAuthnChallengeIsNotValid, err)
+	}
+
+	// the attestation signature covers the client data
+	response = authenticator.create(challenge)
+	otherResponse := authenticator.create(challenge)
+	response.Response.ClientDataJSON = append(otherResponse.Response.ClientDataJSON, ' ')
+	_, err = webauthn.verifyRegistration(accountID, response)
+	if !errors.Is(err, ErrWebAuthnAttestationIsNotValid) {
+		t.Errorf("attestation signature. expected: %v | got: %v", ErrWebAuthnAttestationIsNotValid, err)
+	}
+
+	response = authenticator.create(challenge)
+	response.RawID = []byte("another credential")
+	_, err = webauthn.verifyRegistration(accountID, response)
+	if !errors.Is(err, ErrWebAuthnResponseIsNotValid) {
+		t.Errorf("credential ID. expected: %v | got: %v", ErrWebAuthnResponseIsNotValid, err)
+	}
+}
+
+func TestWebAuthnAuthenticationErrors(t *testing.T) {
+	webauthn := newTestWebAuthn(t)
+	accountID := uuid.New()
+	authenticator := newSoftwareAuthenticator(t, coseAlgorithmEdDSA, "none")
+	credential := registerTestCredential(t, webauthn, authenticator, accountID)
+
+	challenge, err := webauthn.newChallenge(accountID, webAuthnAuthenticationAudience)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := authenticator.get(challenge, nil)
+	response.Response.Signature[0] ^= 1
+	_, _, err = webauthn.verifyAuthentication(credential, response)
+	if !errors.Is(err, ErrWebAuthnSignatureIsNotValid) {
+		t.Errorf("signature. expected: %v | got: %v", ErrWebAuthnSignatureIsNotValid, err)
+	}
+
+	otherAccountChallenge, err := webauthn.newChallenge(uuid.New(), webAuthnAuthenticationAudience)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = webauthn.verifyAuthentication(credential, authenticator.get(otherAccountChallenge, nil))
+	if !errors.Is(err, ErrWebAuthnCredentialNotFound) {
+		t.Errorf("challenge of another account. expected: %v | got: %v", ErrWebAuthnCredentialNotFound, err)
+	}
+
+	otherUserHandle := uuid.New()
+	_, _, err = webauthn.verifyAuthentication(credential, authenticator.get(challenge, otherUserHandle[:]))
+	if !errors.Is(err, ErrWebAuthnCredentialNotFound) {
+		t.Errorf("user handle. expected: %v | got: %v", ErrWebAuthnCredentialNotFound, err)
+	}
+
+	// a cloned authenticator would present a counter which is not greater than the stored one
+	credential.SignCount = int64(authenticator.signCount) + 10
+	_, _, err = webauthn.verifyAuthentication(credential, authenticator.get(challenge, nil))
+	if !errors.Is(err, ErrWebAuthnSignCountIsNotValid) {
+		t.Errorf("sign count. expected: %v | got: %v", ErrWebAuthnSignCountIsNotValid, err)
+	}
+	credential.SignCount = 0
+
+	response = authenticator.get(challenge, nil)
+	response.Response.AuthenticatorData[32] &^= webAuthnFlagUserVerified
+	_, _, err = webauthn.verifyAuthentication(credential, response)
+	if !errors.Is(err, ErrWebAuthnUserNotVerified) {
+		t.Errorf("user verification. expected: %v | got: %v", ErrWebAuthnUserNotVerified, err)
+	}
+}
+
+func TestCborDecode(t *testing.T) {
+	encoded := cborTestEncode(cborTestMap{
+		{int64(-2), []byte{1, 2, 3}},
+		{"key", []any{int64(0), int64(-25), int64(1000), int64(1 << 20), "text", true, false}},
+	})
+
+	decoded, rest, err := cborDecode(append(encoded, 0xff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 1 {
+		t.Errorf("rest. expected: 1 byte | got: %d bytes", len(rest))
+	}
+
+	decodedMap := decoded.(map[any]any)
+	array := decodedMap["key"].([]any)
+	expected := []any{int64(0), int64(-25), int64(1000), int64(1 << 20), "text", true, false}
+	for i := range expected {
+		if array[i] != expected[i] {
+			t.Errorf("array[%d]. expected: %v | got: %v", i, expected[i], array[i])
+		}
+	}
+	if string(decodedMap[int64(-2)].([]byte)) != string([]byte{1, 2, 3}) {
+		t.Errorf("bytes. got: %v", decodedMap[int64(-2)])
+	}
+
+	invalidInputs := [][]byte{
+		{},
+		// truncated byte string
+		{0x43, 1, 2},
+		// indefinite length array
+		{0x9f, 0x01, 0xff},
+		// tag
+		{0xc0, 0x01},
+		// float
+		{0xf9, 0x3c, 0x00},
+		// byte string as map key
+		{0xa1, 0x41, 0x01, 0x01},
+		// duplicate map key
+		{0xa2, 0x01, 0x01, 0x01, 0x02},
+		// huge array length
+		{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	}
+	for _, input := range invalidInputs {
+		_, _, err = cborDecode(input)
+		if err == nil {
+			t.Errorf("decoding %x should fail", input)
+		}
+	}
+
+	// nesting is limited
+	deeplyNested := make([]byte, cborMaxDepth+2)
+	for i := range deeplyNested {
+		deeplyNested[i] = 0x81
+	}
+	_, _, err = cborDecode(append(deeplyNested, 0x01))
+	if err == nil {
+		t.Error("decoding deeply nested arrays should fail")
+	}
+}
+
+func TestWebAuthnBytesJSON(t *testing.T) {
+	data := WebAuthnBytes{0xfb, 0xff, 0x00, 0x01}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(encoded) != `"-_8AAQ"` {
+		t.Errorf("expected: \"-_8AAQ\" | got: %s", encoded)
+	}
+
+	// some clients pad base64url
+	var decoded WebAuthnBytes
+	for _, input := range []string{`"-_8AAQ"`, `"-_8AAQ=="`} {
+		err = json.Unmarshal([]byte(input), &decoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(decoded) != string(data) {
+			t.Errorf("decoded. expected: %x | got: %x", data, decoded)
+		}
+	}
+}
+
+func TestWebAuthnChallengesAreSingleUse(t *testing.T) {
+	database := dbtest.New(t, Migrations(1))
+	ctx := context.Background()
+	webauthn := newTestWebAuthn(t)
+	accountID := uuid.New()
+	// without signature counter, only the challenges prevent responses from being replayed
+	authenticator := newSoftwareAuthenticator(t, coseAlgorithmEdDSA, "none")
+	authenticator.counterless = true
+
+	err := CreateAccount(ctx, database, accountID, "correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creationOptions, err := webauthn.BeginRegistration(ctx, database, accountID, "user", "User")
+	if err != nil {
+		t.Fatal(err)
+	}
+	registrationResponse := authenticator.create(creationOptions.Challenge)
+	_, err = webauthn.FinishRegistration(ctx, database, accountID, "Security key", registrationResponse)
+	if err != nil {
+		t.Fatalf("finishing registration: %v", err)
+	}
+	_, err = webauthn.FinishRegistration(ctx, database, accountID, "Security key", registrationResponse)
+	if !errors.Is(err, ErrWebAuthnChallengeAlreadyUsed) {
+		t.Errorf("replayed registration. expected: %v | got: %v", ErrWebAuthnChallengeAlreadyUsed, err)
+	}
+
+	for i := 0; i < 2; i += 1 {
+		requestOptions, err := webauthn.BeginAuthentication(ctx, database, accountID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		authenticationResponse := authenticator.get(requestOptions.Challenge, accountID[:])
+
+		credential, err := webauthn.FinishAuthentication(ctx, database, authenticationResponse)
+		if err != nil {
+			t.Fatalf("authentication #%d: %v", i, err)
+		}
+		if credential.AccountID != accountID || credential.LastUsedAt == nil {
+			t.Errorf("authentication #%d: credential is not valid: %+v", i, credential)
+		}
+
+		_, err = webauthn.FinishAuthentication(ctx, database, authenticationResponse)
+		if !errors.Is(err, ErrWebAuthnChallengeAlreadyUsed) {
+			t.Errorf("replayed authentication #%d. expected: %v | got: %v", i, ErrWebAuthnChallengeAlreadyUsed, err)
+		}
+	}
+
+	// the used challenges are kept until they expire
+	deleted, err := DeleteExpiredUsedWebAuthnChallenges(ctx, database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 0 {
+		t.Errorf("deleted challenges. expected: 0 | got: %d", deleted)
+	}
+}
@@ -0,0 +1,188 @@
+// Package age implements the age v1 file encryption format (https://age-encryption.org/v1), with
+// X25519 recipients, scrypt passphrase recipients and the armored format, on top of the
+// crypto.Curve25519PublicKey and crypto.Curve25519PrivateKey types.
+//
+// Files encrypted with this package can be decrypted with the reference age and rage tools, and vice
+// versa, so recovering the data never depends on our code.
+package age
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/bloom42/stdx/crypto"
+	"github.com/bloom42/stdx/crypto/kdf"
+)
+
+const (
+	fileKeySize      = 16
+	payloadNonceSize = 16
+)
+
+var (
+	ErrHeaderIsNotValid    = errors.New("age: header is not valid")
+	ErrHeaderMacIsNotValid = errors.New("age: header MAC is not valid")
+	// ErrIncorrectIdentity is returned by Identity.Unwrap when none of the stanzas can be unwrapped by the identity
+	ErrIncorrectIdentity = errors.New("age: incorrect identity")
+	ErrNoIdentityMatched = errors.New("age: no identity matched any of the recipients")
+	ErrNoRecipients      = errors.New("age: at least one recipient is required")
+	errStanzaIsNotValid  = errors.New("age: stanza is not valid")
+)
+
+// Recipient wraps the file key of an age file. Each recipient produces one or more stanzas.
+type Recipient interface {
+	Wrap(fileKey []byte) ([]*Stanza, error)
+}
+
+// Identity unwraps the file key of an age file from the stanzas of its header.
+// Unwrap must return ErrIncorrectIdentity if none of the stanzas match the identity.
+type Identity interface {
+	Unwrap(stanzas []*Stanza) (fileKey []byte, err error)
+}
+
+// Encrypt returns a WriteCloser which encrypts the data written to it in the age v1 format for the
+// given recipients, and writes it to dst. Close must be called to write the last chunk of the file.
+// Close does not close dst.
+func Encrypt(dst io.Writer, recipients ...Recipient) (writer io.WriteCloser, err error) {
+	if len(recipients) == 0 {
+		err = ErrNoRecipients
+		return
+	}
+
+	fileKey, err := crypto.RandBytes(fileKeySize)
+	if err != nil {
+		return
+	}
+	defer crypto.Zeroize(fileKey)
+
+	fileHeader := &header{}
+	for _, recipient := range recipients {
+		var stanzas []*Stanza
+		stanzas, err = recipient.Wrap(fileKey)
+		if err != nil {
+			err = fmt.Errorf("age: wrapping file key: %w", err)
+			return
+		}
+		fileHeader.stanzas = append(fileHeader.stanzas, stanzas...)
+	}
+
+	// a passphrase-encrypted file must not be decryptable by anything else
+	for _, stanza := range fileHeader.stanzas {
+		if stanza.Type == scryptStanzaType && len(fileHeader.stanzas) != 1 {
+			err = errors.New("age: a scrypt recipient must be the only recipient")
+			return
+		}
+	}
+
+	fileHeader.mac, err = headerMac(fileKey, fileHeader.marshalWithoutMac())
+	if err != nil {
+		return
+	}
+
+	_, err = dst.Write(fileHeader.marshal())
+	if err != nil {
+		return
+	}
+
+	nonce, err := crypto.RandBytes(payloadNonceSize)
+	if err != nil {
+		return
+	}
+	_, err = dst.Write(nonce)
+	if err != nil {
+		return
+	}
+
+	payloadKey, err := deriveStreamKey(fileKey, nonce)
+	if err != nil {
+		return
+	}
+	defer crypto.Zeroize(payloadKey)
+
+	return newPayloadWriter(dst, payloadKey)
+}
+
+// Decrypt parses the header of the age file read from src, unwraps the file key with the first
+// matching identity and returns a Reader of the decrypted payload.
+// The payload is authenticated chunk by chunk, so the data read before an error must be discarded.
+func Decrypt(src io.Reader, identities ...Identity) (reader io.Reader, err error) {
+	if len(identities) == 0 {
+		err = errors.New("age: at least one identity is required")
+		return
+	}
+
+	bufferedSrc := bufio.NewReader(src)
+	fileHeader, headerWithoutMac, err := parseHeader(bufferedSrc)
+	if err != nil {
+		return
+	}
+
+	for _, stanza := range fileHeader.stanzas {
+		if stanza.Type == scryptStanzaType && len(fileHeader.stanzas) != 1 {
+			err = fmt.Errorf("%w: a scrypt stanza must be the only stanza", ErrHeaderIsNotValid)
+			return
+		}
+	}
+
+	var fileKey []byte
+	for _, identity := range identities {
+		fileKey, err = identity.Unwrap(fileHeader.stanzas)
+		if errors.Is(err, ErrIncorrectIdentity) {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		break
+	}
+	if fileKey == nil {
+		err = ErrNoIdentityMatched
+		return
+	}
+	defer crypto.Zeroize(fileKey)
+
+	mac, err := headerMac(fileKey, headerWithoutMac)
+	if err != nil {
+		return
+	}
+	if !hmac.Equal(mac, fileHeader.mac) {
+		err = ErrHeaderMacIsNotValid
+		return
+	}
+
+	nonce := make([]byte, payloadNonceSize)
+	_, err = io.ReadFull(bufferedSrc, nonce)
+	if err != nil {
+		err = fmt.Errorf("age: reading payload nonce: %w", errPayloadIsNotValid)
+		return
+	}
+
+	payloadKey, err := deriveStreamKey(fileKey, nonce)
+	if err != nil {
+		return
+	}
+	defer crypto.Zeroize(payloadKey)
+
+	return newPayloadReader(bufferedSrc, payloadKey)
+}
+
+func headerMac(fileKey, headerWithoutMac []byte) (mac []byte, err error) {
+	hmacKey, err := kdf.HkdfSha256(fileKey, []byte("header"), nil, sha256.Size)
+	if err != nil {
+		return
+	}
+	defer crypto.Zeroize(hmacKey)
+
+	hasher := hmac.New(sha256.New, hmacKey)
+	hasher.Write(headerWithoutMac)
+	mac = hasher.Sum(nil)
+	return
+}
+
+func deriveStreamKey(fileKey, nonce []byte) (key []byte, err error) {
+	return kdf.HkdfSha256(fileKey, []byte("payload"), nonce, crypto.KeySize256)
+}
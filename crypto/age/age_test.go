@@ -0,0 +1,341 @@
+package age
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/bloom42/stdx/crypto"
+)
+
+func TestBech32(t *testing.T) {
+	// valid strings from BIP 173
+	validStrings := []string{
+		"A12UEL5L",
+		"a12uel5l",
+		"an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs",
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+		"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w",
+	}
+	for _, validString := range validStrings {
+		_, _, err := bech32Decode(validString)
+		if err != nil {
+			t.Errorf("decoding %s: %v", validString, err)
+		}
+	}
+
+	invalidStrings := []string{
+		"pzry9x0s0muk",
+		"1pzry9x0s0muk",
+		"x1b4n0q5v",
+		"li1dgmt3",
+		"A1G7SGD8",
+		"10a06t8",
+		"1qzzfhee",
+		"a12UEL5L",
+	}
+	for _, invalidString := range invalidStrings {
+		_, _, err := bech32Decode(invalidString)
+		if err == nil {
+			t.Errorf("decoding %s: expected an error", invalidString)
+		}
+	}
+
+	data := []byte("hello world")
+	encoded, err := bech32Encode("test", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hrp, decoded, err := bech32Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hrp != "test" || !bytes.Equal(decoded, data) {
+		t.Errorf("bech32 round trip failed: %s %x", hrp, decoded)
+	}
+}
+
+func TestParseX25519Recipient(t *testing.T) {
+	// from the age documentation
+	encoded := "age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"
+	recipient, err := ParseX25519Recipient(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recipient.String() != encoded {
+		t.Errorf("recipient.String() = %s, expected %s", recipient.String(), encoded)
+	}
+
+	_, err = ParseX25519Recipient("age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8q")
+	if !errors.Is(err, ErrRecipientIsNotValid) {
+		t.Errorf("expected ErrRecipientIsNotValid for a bad checksum, got %v", err)
+	}
+}
+
+func TestX25519IdentityEncoding(t *testing.T) {
+	identity, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded := identity.String()
+	if !strings.HasPrefix(encoded, "AGE-SECRET-KEY-1") {
+		t.Errorf("identity has not the expected prefix: %s", encoded)
+	}
+	if !strings.HasPrefix(identity.Recipient().String(), "age1") {
+		t.Errorf("recipient has not the expected prefix: %s", identity.Recipient().String())
+	}
+
+	parsedIdentity, err := ParseX25519Identity(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsedIdentity.Recipient().String() != identity.Recipient().String() {
+		t.Error("parsed identity doesn't match")
+	}
+
+	identities, err := ParseIdentities(strings.NewReader("# created: today\n\n" + encoded + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identities) != 1 {
+		t.Errorf("expected 1 identity, got %d", len(identities))
+	}
+
+	_, err = ParseIdentities(strings.NewReader("# no identity\n"))
+	if err == nil {
+		t.Error("expected an error for an identity file without identities")
+	}
+}
+
+func encryptTestData(t *testing.T, plaintext []byte, recipients ...Recipient) []byte {
+	var ciphertext bytes.Buffer
+	writer, err := Encrypt(&ciphertext, recipients...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = writer.Write(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ciphertext.Bytes()
+}
+
+func decryptTestData(ciphertext []byte, identities ...Identity) (plaintext []byte, err error) {
+	reader, err := Decrypt(bytes.NewReader(ciphertext), identities...)
+	if err != nil {
+		return
+	}
+	return io.ReadAll(reader)
+}
+
+func TestX25519EncryptDecrypt(t *testing.T) {
+	identity1, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity2, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherIdentity, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := []int{0, 1, 100, chunkSize - 1, chunkSize, chunkSize + 1, 2 * chunkSize, 3*chunkSize + 42}
+	for _, size := range sizes {
+		plaintext, err := crypto.RandBytes(uint64(size))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ciphertext := encryptTestData(t, plaintext, identity1.Recipient(), identity2.Recipient())
+		if !bytes.HasPrefix(ciphertext, []byte("age-encryption.org/v1\n-> X25519 ")) {
+			t.Errorf("size %d: ciphertext has not the expected header", size)
+		}
+
+		for _, identity := range []Identity{identity1, identity2} {
+			decrypted, err := decryptTestData(ciphertext, otherIdentity, identity)
+			if err != nil {
+				t.Fatalf("size %d: %v", size, err)
+			}
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Errorf("size %d: decrypted data doesn't match plaintext", size)
+			}
+		}
+
+		_, err = decryptTestData(ciphertext, otherIdentity)
+		if !errors.Is(err, ErrNoIdentityMatched) {
+			t.Errorf("size %d: expected ErrNoIdentityMatched, got %v", size, err)
+		}
+	}
+}
+
+func TestScryptEncryptDecrypt(t *testing.T) {
+	passphrase := []byte("correct horse battery staple")
+	plaintext := []byte("hello world")
+
+	recipient, err := NewScryptRecipient(passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient.SetWorkFactor(10)
+
+	ciphertext := encryptTestData(t, plaintext, recipient)
+	if !bytes.Contains(ciphertext, []byte("\n-> scrypt ")) || !bytes.Contains(ciphertext, []byte(" 10\n")) {
+		t.Error("ciphertext doesn't contain the expected scrypt stanza")
+	}
+
+	identity, err := NewScryptIdentity(passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := decryptTestData(ciphertext, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Error("decrypted data doesn't match plaintext")
+	}
+
+	wrongIdentity, err := NewScryptIdentity([]byte("wrong passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = decryptTestData(ciphertext, wrongIdentity)
+	if !errors.Is(err, ErrNoIdentityMatched) {
+		t.Errorf("expected ErrNoIdentityMatched, got %v", err)
+	}
+
+	x25519Identity, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Encrypt(io.Discard, recipient, x25519Identity.Recipient())
+	if err == nil {
+		t.Error("expected an error when mixing scrypt with other recipients")
+	}
+
+	// the work factor is checked before deriving the key
+	_, err = identity.Unwrap([]*Stanza{{
+		Type: scryptStanzaType,
+		Args: []string{b64.EncodeToString(make([]byte, scryptSaltSize)), "23"},
+		Body: make([]byte, fileKeySize+16),
+	}})
+	if err == nil || errors.Is(err, ErrIncorrectIdentity) {
+		t.Errorf("expected an error for a work factor greater than MaxScryptWorkFactor, got %v", err)
+	}
+}
+
+func TestArmor(t *testing.T) {
+	identity, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range []int{0, 1, 47, 48, 49, 1000} {
+		plaintext, err := crypto.RandBytes(uint64(size))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var armored bytes.Buffer
+		armorWriter := NewArmorWriter(&armored)
+		writer, err := Encrypt(armorWriter, identity.Recipient())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = writer.Write(plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = writer.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = armorWriter.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lines := strings.Split(strings.TrimSuffix(armored.String(), "\n"), "\n")
+		if lines[0] != armorHeader || lines[len(lines)-1] != armorFooter {
+			t.Fatalf("size %d: armored data has not the expected header and footer", size)
+		}
+		for _, line := range lines[1 : len(lines)-1] {
+			if len(line) > columnsPerLine {
+				t.Errorf("size %d: armored line is too long: %d", size, len(line))
+			}
+		}
+
+		reader, err := Decrypt(NewArmorReader(strings.NewReader("\n  "+armored.String()+"\n\n")), identity)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		decrypted, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("size %d: decrypted data doesn't match plaintext", size)
+		}
+	}
+
+	_, err = io.ReadAll(NewArmorReader(strings.NewReader(armorHeader + "\nAAAA=\n" + armorFooter)))
+	if !errors.Is(err, ErrArmorIsNotValid) {
+		t.Errorf("expected ErrArmorIsNotValid for non-canonical base64, got %v", err)
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	identity, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext, err := crypto.RandBytes(2*chunkSize + 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := encryptTestData(t, plaintext, identity.Recipient())
+	headerSize := bytes.Index(ciphertext, []byte("\n---"))
+	headerSize += bytes.IndexByte(ciphertext[headerSize+1:], '\n') + 2
+
+	// truncated at a chunk boundary: the last chunk is missing
+	truncatedSize := headerSize + payloadNonceSize + 2*encryptedChunkSize
+	_, err = decryptTestData(ciphertext[:truncatedSize], identity)
+	if !errors.Is(err, errPayloadIsNotValid) {
+		t.Errorf("expected errPayloadIsNotValid for a truncated file, got %v", err)
+	}
+
+	_, err = decryptTestData(ciphertext[:len(ciphertext)-1], identity)
+	if !errors.Is(err, errPayloadIsNotValid) {
+		t.Errorf("expected errPayloadIsNotValid for a truncated last chunk, got %v", err)
+	}
+
+	_, err = decryptTestData(append(bytes.Clone(ciphertext), 0), identity)
+	if !errors.Is(err, errPayloadIsNotValid) {
+		t.Errorf("expected errPayloadIsNotValid for trailing data, got %v", err)
+	}
+
+	tamperedPayload := bytes.Clone(ciphertext)
+	tamperedPayload[len(tamperedPayload)-20] ^= 1
+	_, err = decryptTestData(tamperedPayload, identity)
+	if !errors.Is(err, errPayloadIsNotValid) {
+		t.Errorf("expected errPayloadIsNotValid for a tampered payload, got %v", err)
+	}
+
+	// adding a stanza to the header invalidates the MAC
+	tamperedHeader := bytes.Replace(ciphertext, []byte("\n---"), []byte("\n-> unknown arg\n\n---"), 1)
+	_, err = decryptTestData(tamperedHeader, identity)
+	if !errors.Is(err, ErrHeaderMacIsNotValid) {
+		t.Errorf("expected ErrHeaderMacIsNotValid, got %v", err)
+	}
+}
@@ -0,0 +1,191 @@
+package age
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+)
+
+// The armored format is the strict PEM-like encoding of age files: standard padded base64 wrapped at 64
+// columns, between "-----BEGIN AGE ENCRYPTED FILE-----" and "-----END AGE ENCRYPTED FILE-----" lines.
+
+const (
+	armorHeader = "-----BEGIN AGE ENCRYPTED FILE-----"
+	armorFooter = "-----END AGE ENCRYPTED FILE-----"
+	// maxArmoredSize limits the memory used by ArmorReader, which decodes the whole file before returning data
+	maxArmoredSize = 64 * 1024 * 1024
+)
+
+var ErrArmorIsNotValid = errors.New("age: armored data is not valid")
+
+type armorWriter struct {
+	dst     io.Writer
+	encoder io.WriteCloser
+	lines   *lineWriter
+	closed  bool
+}
+
+// NewArmorWriter returns a WriteCloser which writes the data written to it to dst in the armored format.
+// Close must be called to write the footer. Close does not close dst.
+func NewArmorWriter(dst io.Writer) io.WriteCloser {
+	lines := &lineWriter{dst: dst}
+	return &armorWriter{
+		dst:     dst,
+		encoder: base64.NewEncoder(base64.StdEncoding, lines),
+		lines:   lines,
+	}
+}
+
+func (writer *armorWriter) Write(data []byte) (n int, err error) {
+	if !writer.lines.headerWritten {
+		_, err = io.WriteString(writer.dst, armorHeader+"\n")
+		if err != nil {
+			return
+		}
+		writer.lines.headerWritten = true
+	}
+
+	return writer.encoder.Write(data)
+}
+
+func (writer *armorWriter) Close() (err error) {
+	if writer.closed {
+		return nil
+	}
+	writer.closed = true
+
+	if !writer.lines.headerWritten {
+		_, err = io.WriteString(writer.dst, armorHeader+"\n")
+		if err != nil {
+			return
+		}
+	}
+
+	err = writer.encoder.Close()
+	if err != nil {
+		return
+	}
+
+	footer := armorFooter + "\n"
+	if writer.lines.column != 0 {
+		footer = "\n" + footer
+	}
+	_, err = io.WriteString(writer.dst, footer)
+	return
+}
+
+// lineWriter inserts a newline every 64 columns
+type lineWriter struct {
+	dst           io.Writer
+	column        int
+	headerWritten bool
+}
+
+func (writer *lineWriter) Write(data []byte) (n int, err error) {
+	for len(data) > 0 {
+		remaining := columnsPerLine - writer.column
+		if remaining > len(data) {
+			remaining = len(data)
+		}
+
+		_, err = writer.dst.Write(data[:remaining])
+		if err != nil {
+			return
+		}
+		n += remaining
+		writer.column += remaining
+		data = data[remaining:]
+
+		if writer.column == columnsPerLine {
+			_, err = writer.dst.Write([]byte("\n"))
+			if err != nil {
+				return
+			}
+			writer.column = 0
+		}
+	}
+
+	return
+}
+
+// NewArmorReader returns a Reader which decodes the armored data read from src.
+// Leading and trailing whitespace around the armored data is ignored.
+func NewArmorReader(src io.Reader) io.Reader {
+	return &armorReader{src: src}
+}
+
+type armorReader struct {
+	src     io.Reader
+	decoded *bytes.Reader
+	err     error
+}
+
+func (reader *armorReader) Read(data []byte) (n int, err error) {
+	if reader.err != nil {
+		return 0, reader.err
+	}
+
+	if reader.decoded == nil {
+		var decoded []byte
+		decoded, err = decodeArmor(reader.src)
+		if err != nil {
+			reader.err = err
+			return
+		}
+		reader.decoded = bytes.NewReader(decoded)
+	}
+
+	return reader.decoded.Read(data)
+}
+
+func decodeArmor(src io.Reader) (decoded []byte, err error) {
+	data, err := io.ReadAll(io.LimitReader(src, maxArmoredSize+1))
+	if err != nil {
+		return
+	}
+	if len(data) > maxArmoredSize {
+		err = errors.New("age: armored data is too large")
+		return
+	}
+
+	data = bytes.TrimSpace(data)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+
+	if !scanner.Scan() || scanner.Text() != armorHeader {
+		err = ErrArmorIsNotValid
+		return
+	}
+
+	var encoded bytes.Buffer
+	footerFound := false
+	lastLineIsShort := false
+	for scanner.Scan() {
+		line := bytes.TrimSuffix(scanner.Bytes(), []byte("\r"))
+		if string(line) == armorFooter {
+			footerFound = true
+			break
+		}
+
+		// only the last line can be shorter than 64 columns
+		if lastLineIsShort || len(line) > columnsPerLine || len(line) == 0 {
+			err = ErrArmorIsNotValid
+			return
+		}
+		lastLineIsShort = len(line) < columnsPerLine
+		encoded.Write(line)
+	}
+	if !footerFound || scanner.Scan() {
+		err = ErrArmorIsNotValid
+		return
+	}
+
+	decoded, err = base64.StdEncoding.Strict().DecodeString(encoded.String())
+	if err != nil {
+		err = ErrArmorIsNotValid
+		return
+	}
+	return
+}
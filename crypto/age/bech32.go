@@ -0,0 +1,141 @@
+package age
+
+import (
+	"errors"
+	"strings"
+)
+
+// Bech32 (BIP 173) encoding, used to encode age recipients and identities. Unlike BIP 173, the length
+// of strings is not limited to 90 characters.
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var errBech32IsNotValid = errors.New("age: bech32 string is not valid")
+
+func bech32Polymod(values []byte) uint32 {
+	generator := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	checksum := uint32(1)
+	for _, value := range values {
+		top := checksum >> 25
+		checksum = (checksum&0x1ffffff)<<5 ^ uint32(value)
+		for i := 0; i < 5; i += 1 {
+			if (top>>i)&1 == 1 {
+				checksum ^= generator[i]
+			}
+		}
+	}
+	return checksum
+}
+
+func bech32HrpExpand(hrp string) []byte {
+	expanded := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i += 1 {
+		expanded = append(expanded, hrp[i]>>5)
+	}
+	expanded = append(expanded, 0)
+	for i := 0; i < len(hrp); i += 1 {
+		expanded = append(expanded, hrp[i]&31)
+	}
+	return expanded
+}
+
+// convertBits regroups data from fromBits-bit groups to toBits-bit groups
+func convertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	var accumulator uint32
+	var bits uint
+	maxValue := uint32(1)<<toBits - 1
+	converted := make([]byte, 0, len(data)*int(fromBits)/int(toBits)+1)
+
+	for _, value := range data {
+		if value>>fromBits != 0 {
+			return nil, errBech32IsNotValid
+		}
+		accumulator = accumulator<<fromBits | uint32(value)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			converted = append(converted, byte(accumulator>>bits&maxValue))
+		}
+	}
+
+	if pad {
+		if bits > 0 {
+			converted = append(converted, byte(accumulator<<(toBits-bits)&maxValue))
+		}
+	} else if bits >= fromBits || accumulator<<(toBits-bits)&maxValue != 0 {
+		return nil, errBech32IsNotValid
+	}
+
+	return converted, nil
+}
+
+// bech32Encode encodes data with the given human readable part. The result is lowercase unless hrp is uppercase.
+func bech32Encode(hrp string, data []byte) (string, error) {
+	values, err := convertBits(data, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+
+	upper := strings.ToUpper(hrp) == hrp && strings.ToLower(hrp) != hrp
+	hrp = strings.ToLower(hrp)
+
+	checksumInput := append(bech32HrpExpand(hrp), values...)
+	checksumInput = append(checksumInput, 0, 0, 0, 0, 0, 0)
+	polymod := bech32Polymod(checksumInput) ^ 1
+
+	var builder strings.Builder
+	builder.WriteString(hrp)
+	builder.WriteByte('1')
+	for _, value := range values {
+		builder.WriteByte(bech32Charset[value])
+	}
+	for i := 0; i < 6; i += 1 {
+		builder.WriteByte(bech32Charset[(polymod>>(5*(5-i)))&31])
+	}
+
+	if upper {
+		return strings.ToUpper(builder.String()), nil
+	}
+	return builder.String(), nil
+}
+
+// bech32Decode decodes a bech32 string and returns its lowercase human readable part and its data
+func bech32Decode(input string) (hrp string, data []byte, err error) {
+	if strings.ToLower(input) != input && strings.ToUpper(input) != input {
+		err = errBech32IsNotValid
+		return
+	}
+	input = strings.ToLower(input)
+
+	separator := strings.LastIndexByte(input, '1')
+	if separator < 1 || separator+7 > len(input) {
+		err = errBech32IsNotValid
+		return
+	}
+
+	hrp = input[:separator]
+	for i := 0; i < len(hrp); i += 1 {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			err = errBech32IsNotValid
+			return
+		}
+	}
+
+	values := make([]byte, 0, len(input)-separator-1)
+	for i := separator + 1; i < len(input); i += 1 {
+		value := strings.IndexByte(bech32Charset, input[i])
+		if value == -1 {
+			err = errBech32IsNotValid
+			return
+		}
+		values = append(values, byte(value))
+	}
+
+	if bech32Polymod(append(bech32HrpExpand(hrp), values...)) != 1 {
+		err = errBech32IsNotValid
+		return
+	}
+
+	data, err = convertBits(values[:len(values)-6], 5, 8, false)
+	return
+}
@@ -0,0 +1,169 @@
+package age
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	versionLine    = "age-encryption.org/v1"
+	stanzaPrefix   = "-> "
+	footerPrefix   = "---"
+	columnsPerLine = 64
+	// maxHeaderSize limits the memory used to parse malicious headers
+	maxHeaderSize = 1024 * 1024
+)
+
+var b64 = base64.RawStdEncoding.Strict()
+
+// Stanza is a recipient stanza of the header of an age file, which contains the file key wrapped for a recipient
+type Stanza struct {
+	Type string
+	Args []string
+	Body []byte
+}
+
+type header struct {
+	stanzas []*Stanza
+	mac     []byte
+}
+
+// marshalWithoutMac returns the header up to and including the "---" footer, which is the input of the MAC
+func (header *header) marshalWithoutMac() []byte {
+	var buffer bytes.Buffer
+
+	buffer.WriteString(versionLine + "\n")
+	for _, stanza := range header.stanzas {
+		buffer.WriteString(stanzaPrefix + stanza.Type)
+		for _, arg := range stanza.Args {
+			buffer.WriteString(" " + arg)
+		}
+		buffer.WriteString("\n")
+
+		encodedBody := b64.EncodeToString(stanza.Body)
+		for len(encodedBody) >= columnsPerLine {
+			buffer.WriteString(encodedBody[:columnsPerLine] + "\n")
+			encodedBody = encodedBody[columnsPerLine:]
+		}
+		// the last line of the body is always shorter than 64 columns, even if empty
+		buffer.WriteString(encodedBody + "\n")
+	}
+	buffer.WriteString(footerPrefix)
+
+	return buffer.Bytes()
+}
+
+func (header *header) marshal() []byte {
+	data := header.marshalWithoutMac()
+	data = append(data, ' ')
+	data = append(data, b64.EncodeToString(header.mac)...)
+	return append(data, '\n')
+}
+
+// parseHeader parses the header of an age file. It returns the header, and the raw header up to and
+// including the "---" footer, to verify the MAC.
+func parseHeader(reader *bufio.Reader) (parsedHeader *header, headerWithoutMac []byte, err error) {
+	parsedHeader = &header{}
+	var raw bytes.Buffer
+
+	readLine := func() (line string, err error) {
+		line, err = reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				err = ErrHeaderIsNotValid
+			}
+			return
+		}
+		raw.WriteString(line)
+		if raw.Len() > maxHeaderSize {
+			err = ErrHeaderIsNotValid
+			return
+		}
+		return strings.TrimSuffix(line, "\n"), nil
+	}
+
+	line, err := readLine()
+	if err != nil {
+		return
+	}
+	if line != versionLine {
+		err = fmt.Errorf("%w: unsupported version", ErrHeaderIsNotValid)
+		return
+	}
+
+	for {
+		line, err = readLine()
+		if err != nil {
+			return
+		}
+
+		if strings.HasPrefix(line, footerPrefix) {
+			macLine := strings.TrimPrefix(line, footerPrefix)
+			if !strings.HasPrefix(macLine, " ") {
+				err = ErrHeaderIsNotValid
+				return
+			}
+
+			parsedHeader.mac, err = b64.DecodeString(macLine[1:])
+			if err != nil || len(parsedHeader.mac) != 32 {
+				err = ErrHeaderIsNotValid
+				return
+			}
+
+			headerWithoutMac = raw.Bytes()[:raw.Len()-len(macLine)-1]
+			return
+		}
+
+		if !strings.HasPrefix(line, stanzaPrefix) {
+			err = ErrHeaderIsNotValid
+			return
+		}
+
+		args := strings.Split(strings.TrimPrefix(line, stanzaPrefix), " ")
+		for _, arg := range args {
+			if !isValidArg(arg) {
+				err = ErrHeaderIsNotValid
+				return
+			}
+		}
+		stanza := &Stanza{Type: args[0], Args: args[1:]}
+
+		for {
+			line, err = readLine()
+			if err != nil {
+				return
+			}
+
+			var decoded []byte
+			decoded, err = b64.DecodeString(line)
+			if err != nil || len(line) > columnsPerLine {
+				err = ErrHeaderIsNotValid
+				return
+			}
+			stanza.Body = append(stanza.Body, decoded...)
+
+			if len(line) < columnsPerLine {
+				break
+			}
+		}
+
+		parsedHeader.stanzas = append(parsedHeader.stanzas, stanza)
+	}
+}
+
+// isValidArg returns true if arg is a non-empty string of printable ASCII characters
+func isValidArg(arg string) bool {
+	if arg == "" {
+		return false
+	}
+	for _, char := range arg {
+		if char < 33 || char > 126 {
+			return false
+		}
+	}
+	return true
+}
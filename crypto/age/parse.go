@@ -0,0 +1,79 @@
+package age
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ParseIdentities parses an identity file, which contains one "AGE-SECRET-KEY-1..." identity per line.
+// Empty lines and lines starting with "#" are ignored.
+func ParseIdentities(src io.Reader) (identities []Identity, err error) {
+	lines, err := parseKeyFile(src)
+	if err != nil {
+		return
+	}
+
+	for _, line := range lines {
+		var identity *X25519Identity
+		identity, err = ParseX25519Identity(line.text)
+		if err != nil {
+			err = fmt.Errorf("age: line %d: %w", line.number, err)
+			return
+		}
+		identities = append(identities, identity)
+	}
+
+	if len(identities) == 0 {
+		err = fmt.Errorf("%w: no identities found", ErrIdentityIsNotValid)
+		return
+	}
+	return
+}
+
+// ParseRecipients parses a recipients file, which contains one "age1..." recipient per line.
+// Empty lines and lines starting with "#" are ignored.
+func ParseRecipients(src io.Reader) (recipients []Recipient, err error) {
+	lines, err := parseKeyFile(src)
+	if err != nil {
+		return
+	}
+
+	for _, line := range lines {
+		var recipient *X25519Recipient
+		recipient, err = ParseX25519Recipient(line.text)
+		if err != nil {
+			err = fmt.Errorf("age: line %d: %w", line.number, err)
+			return
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	if len(recipients) == 0 {
+		err = fmt.Errorf("%w: no recipients found", ErrRecipientIsNotValid)
+		return
+	}
+	return
+}
+
+type keyFileLine struct {
+	number int
+	text   string
+}
+
+func parseKeyFile(src io.Reader) (lines []keyFileLine, err error) {
+	scanner := bufio.NewScanner(src)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber += 1
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		lines = append(lines, keyFileLine{number: lineNumber, text: text})
+	}
+
+	err = scanner.Err()
+	return
+}
@@ -0,0 +1,164 @@
+package age
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/bloom42/stdx/crypto"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	scryptStanzaType = "scrypt"
+	scryptLabel      = "age-encryption.org/v1/scrypt"
+	scryptSaltSize   = 16
+
+	// DefaultScryptWorkFactor is the default log2 of the scrypt N parameter used by ScryptRecipient
+	DefaultScryptWorkFactor = 18
+	// MaxScryptWorkFactor is the maximum work factor accepted by ScryptIdentity, to limit the resources
+	// used to decrypt malicious files
+	MaxScryptWorkFactor = 22
+)
+
+var ErrPassphraseIsEmpty = errors.New("age: passphrase is empty")
+
+// ScryptRecipient encrypts the file key with a passphrase. It must be the only recipient of a file.
+type ScryptRecipient struct {
+	passphrase []byte
+	workFactor int
+}
+
+// we do this to have a compile-time error if ScryptRecipient no longer satisfies the Recipient interface
+var _ Recipient = (*ScryptRecipient)(nil)
+
+// NewScryptRecipient returns a recipient for passphrase, with DefaultScryptWorkFactor
+func NewScryptRecipient(passphrase []byte) (recipient *ScryptRecipient, err error) {
+	if len(passphrase) == 0 {
+		err = ErrPassphraseIsEmpty
+		return
+	}
+
+	recipient = &ScryptRecipient{
+		passphrase: passphrase,
+		workFactor: DefaultScryptWorkFactor,
+	}
+	return
+}
+
+// SetWorkFactor sets the log2 of the scrypt N parameter. It must be between 1 and 30.
+// Files encrypted with a work factor greater than MaxScryptWorkFactor can't be decrypted by ScryptIdentity.
+func (recipient *ScryptRecipient) SetWorkFactor(logN int) {
+	if logN < 1 || logN > 30 {
+		panic("age: scrypt work factor must be between 1 and 30")
+	}
+	recipient.workFactor = logN
+}
+
+// Wrap implements Recipient
+func (recipient *ScryptRecipient) Wrap(fileKey []byte) (stanzas []*Stanza, err error) {
+	salt, err := crypto.RandBytes(scryptSaltSize)
+	if err != nil {
+		return
+	}
+
+	wrappingKey, err := scryptWrappingKey(recipient.passphrase, salt, recipient.workFactor)
+	if err != nil {
+		return
+	}
+	defer crypto.Zeroize(wrappingKey)
+
+	body, err := aeadSeal(wrappingKey, fileKey)
+	if err != nil {
+		return
+	}
+
+	stanzas = []*Stanza{{
+		Type: scryptStanzaType,
+		Args: []string{b64.EncodeToString(salt), strconv.Itoa(recipient.workFactor)},
+		Body: body,
+	}}
+	return
+}
+
+// ScryptIdentity decrypts files encrypted with a ScryptRecipient
+type ScryptIdentity struct {
+	passphrase    []byte
+	maxWorkFactor int
+}
+
+// we do this to have a compile-time error if ScryptIdentity no longer satisfies the Identity interface
+var _ Identity = (*ScryptIdentity)(nil)
+
+// NewScryptIdentity returns an identity for passphrase
+func NewScryptIdentity(passphrase []byte) (identity *ScryptIdentity, err error) {
+	if len(passphrase) == 0 {
+		err = ErrPassphraseIsEmpty
+		return
+	}
+
+	identity = &ScryptIdentity{
+		passphrase:    passphrase,
+		maxWorkFactor: MaxScryptWorkFactor,
+	}
+	return
+}
+
+// Unwrap implements Identity
+func (identity *ScryptIdentity) Unwrap(stanzas []*Stanza) (fileKey []byte, err error) {
+	for _, stanza := range stanzas {
+		if stanza.Type == scryptStanzaType && len(stanzas) != 1 {
+			err = errors.New("age: a scrypt stanza must be the only stanza")
+			return
+		}
+	}
+	if len(stanzas) != 1 || stanzas[0].Type != scryptStanzaType {
+		err = ErrIncorrectIdentity
+		return
+	}
+	stanza := stanzas[0]
+
+	if len(stanza.Args) != 2 || len(stanza.Body) != fileKeySize+chacha20poly1305.Overhead {
+		err = errStanzaIsNotValid
+		return
+	}
+
+	salt, err := b64.DecodeString(stanza.Args[0])
+	if err != nil || len(salt) != scryptSaltSize {
+		err = errStanzaIsNotValid
+		return
+	}
+
+	// the work factor must be a decimal number without leading zeros
+	workFactorArg := stanza.Args[1]
+	workFactor, err := strconv.Atoi(workFactorArg)
+	if err != nil || workFactorArg != strconv.Itoa(workFactor) || workFactor <= 0 {
+		err = errStanzaIsNotValid
+		return
+	}
+	if workFactor > identity.maxWorkFactor {
+		err = errors.New("age: scrypt work factor is too large")
+		return
+	}
+
+	wrappingKey, err := scryptWrappingKey(identity.passphrase, salt, workFactor)
+	if err != nil {
+		return
+	}
+	defer crypto.Zeroize(wrappingKey)
+
+	fileKey, err = aeadOpen(wrappingKey, stanza.Body)
+	if err != nil {
+		err = ErrIncorrectIdentity
+		return
+	}
+	return
+}
+
+func scryptWrappingKey(passphrase, salt []byte, workFactor int) ([]byte, error) {
+	scryptSalt := make([]byte, 0, len(scryptLabel)+len(salt))
+	scryptSalt = append(scryptSalt, scryptLabel...)
+	scryptSalt = append(scryptSalt, salt...)
+
+	return scrypt.Key(passphrase, scryptSalt, 1<<workFactor, 8, 1, chacha20poly1305.KeySize)
+}
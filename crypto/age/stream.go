@@ -0,0 +1,218 @@
+package age
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// The payload is encrypted with the STREAM construction: chunks of 64 KiB of plaintext encrypted with
+// ChaCha20-Poly1305, with nonce = counter (11 bytes, big endian) || last_chunk_flag (1 byte)
+
+const (
+	chunkSize          = 64 * 1024
+	encryptedChunkSize = chunkSize + chacha20poly1305.Overhead
+	lastChunkFlag      = 0x01
+)
+
+var errPayloadIsNotValid = errors.New("age: payload is not valid")
+
+type payloadWriter struct {
+	dst    io.Writer
+	aead   cipher.AEAD
+	nonce  [chacha20poly1305.NonceSize]byte
+	buffer []byte
+	closed bool
+	err    error
+}
+
+func newPayloadWriter(dst io.Writer, key []byte) (writer *payloadWriter, err error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return
+	}
+
+	writer = &payloadWriter{
+		dst:    dst,
+		aead:   aead,
+		buffer: make([]byte, 0, encryptedChunkSize),
+	}
+	return
+}
+
+func (writer *payloadWriter) Write(data []byte) (n int, err error) {
+	if writer.err != nil {
+		return 0, writer.err
+	}
+	if writer.closed {
+		return 0, errors.New("age: write on closed Writer")
+	}
+
+	for len(data) > 0 {
+		// a full chunk is only sealed once we know that it's not the last one
+		if len(writer.buffer) == chunkSize {
+			err = writer.flushChunk(false)
+			if err != nil {
+				writer.err = err
+				return
+			}
+		}
+
+		copied := copy(writer.buffer[len(writer.buffer):chunkSize], data)
+		writer.buffer = writer.buffer[:len(writer.buffer)+copied]
+		data = data[copied:]
+		n += copied
+	}
+
+	return
+}
+
+// Close writes the last chunk. It does not close the underlying writer.
+func (writer *payloadWriter) Close() (err error) {
+	if writer.err != nil {
+		return writer.err
+	}
+	if writer.closed {
+		return nil
+	}
+
+	err = writer.flushChunk(true)
+	if err != nil {
+		writer.err = err
+		return
+	}
+
+	writer.closed = true
+	return
+}
+
+func (writer *payloadWriter) flushChunk(last bool) (err error) {
+	if last {
+		writer.nonce[len(writer.nonce)-1] = lastChunkFlag
+	}
+
+	ciphertext := writer.aead.Seal(writer.buffer[:0], writer.nonce[:], writer.buffer, nil)
+	_, err = writer.dst.Write(ciphertext)
+	if err != nil {
+		return
+	}
+
+	err = incrementNonce(&writer.nonce)
+	writer.buffer = writer.buffer[:0]
+	return
+}
+
+type payloadReader struct {
+	src       *bufio.Reader
+	aead      cipher.AEAD
+	nonce     [chacha20poly1305.NonceSize]byte
+	buffer    []byte
+	chunk     []byte
+	plaintext []byte
+	lastRead  bool
+	err       error
+}
+
+func newPayloadReader(src *bufio.Reader, key []byte) (reader *payloadReader, err error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return
+	}
+
+	reader = &payloadReader{
+		src:    src,
+		aead:   aead,
+		buffer: make([]byte, encryptedChunkSize),
+		chunk:  make([]byte, chunkSize),
+	}
+	return
+}
+
+func (reader *payloadReader) Read(data []byte) (n int, err error) {
+	if reader.err != nil {
+		return 0, reader.err
+	}
+
+	for len(reader.plaintext) == 0 {
+		if reader.lastRead {
+			// no data can follow the last chunk
+			if _, peekErr := reader.src.Peek(1); peekErr != io.EOF {
+				reader.err = errPayloadIsNotValid
+				return 0, reader.err
+			}
+			return 0, io.EOF
+		}
+
+		err = reader.readChunk()
+		if err != nil {
+			reader.err = err
+			return
+		}
+	}
+
+	n = copy(data, reader.plaintext)
+	reader.plaintext = reader.plaintext[n:]
+	return
+}
+
+func (reader *payloadReader) readChunk() (err error) {
+	n, err := io.ReadFull(reader.src, reader.buffer)
+	last := false
+	switch err {
+	case nil:
+	case io.ErrUnexpectedEOF:
+		last = true
+	case io.EOF:
+		// the last chunk is missing
+		return errPayloadIsNotValid
+	default:
+		return err
+	}
+
+	if last {
+		reader.nonce[len(reader.nonce)-1] = lastChunkFlag
+	}
+
+	// the chunk is not decrypted in place, so it can be opened again if the first attempt fails
+	reader.plaintext, err = reader.aead.Open(reader.chunk[:0], reader.nonce[:], reader.buffer[:n], nil)
+	if err != nil && !last {
+		// the last chunk can be a full chunk
+		last = true
+		reader.nonce[len(reader.nonce)-1] = lastChunkFlag
+		reader.plaintext, err = reader.aead.Open(reader.chunk[:0], reader.nonce[:], reader.buffer[:n], nil)
+	}
+	if err != nil {
+		return errPayloadIsNotValid
+	}
+
+	// only the payload of an empty file can have an empty chunk
+	if len(reader.plaintext) == 0 && !reader.isFirstChunk() {
+		return errPayloadIsNotValid
+	}
+
+	reader.lastRead = last
+	return incrementNonce(&reader.nonce)
+}
+
+func (reader *payloadReader) isFirstChunk() bool {
+	for _, b := range reader.nonce[:len(reader.nonce)-1] {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// incrementNonce increments the 11-byte counter of nonce
+func incrementNonce(nonce *[chacha20poly1305.NonceSize]byte) error {
+	for i := len(nonce) - 2; i >= 0; i -= 1 {
+		nonce[i] += 1
+		if nonce[i] != 0 {
+			return nil
+		}
+	}
+	return errors.New("age: stream is too large")
+}
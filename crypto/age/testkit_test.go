@@ -0,0 +1,145 @@
+package age
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testdata/testkit contains the test vectors of the age specification (https://c2sp.org/CCTV/age), which are
+// used by the reference age and rage implementations. Each file is a textual header followed by an empty
+// line and an age file.
+type testkitVector struct {
+	expect     string
+	payload    []byte
+	identities []Identity
+	armored    bool
+	file       []byte
+}
+
+func parseTestkitVector(t *testing.T, data []byte) (vector testkitVector) {
+	reader := bufio.NewReader(bytes.NewReader(data))
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading vector header: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			break
+		}
+
+		key, value, found := strings.Cut(line, ": ")
+		if !found {
+			t.Fatalf("vector header line is not valid: %q", line)
+		}
+		switch key {
+		case "expect":
+			vector.expect = value
+		case "payload":
+			vector.payload, err = hex.DecodeString(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+		case "identity":
+			var identity *X25519Identity
+			identity, err = ParseX25519Identity(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			vector.identities = append(vector.identities, identity)
+		case "passphrase":
+			var identity *ScryptIdentity
+			identity, err = NewScryptIdentity([]byte(value))
+			if err != nil {
+				t.Fatal(err)
+			}
+			vector.identities = append(vector.identities, identity)
+		case "armored":
+			vector.armored = value == "yes"
+		case "file key", "comment":
+		default:
+			t.Fatalf("unknown vector header key: %s", key)
+		}
+	}
+
+	vector.file, _ = io.ReadAll(reader)
+	return
+}
+
+func TestTestkit(t *testing.T) {
+	paths, err := filepath.Glob("testdata/testkit/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no test vectors found")
+	}
+
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			vector := parseTestkitVector(t, data)
+
+			var src io.Reader = bytes.NewReader(vector.file)
+			if vector.armored {
+				src = NewArmorReader(src)
+			}
+
+			var plaintext []byte
+			reader, err := Decrypt(src, vector.identities...)
+			if err == nil {
+				// the data released before an error must match the payload hash of payload failures
+				plaintext, err = io.ReadAll(reader)
+			}
+
+			switch vector.expect {
+			case "success":
+				if err != nil {
+					t.Fatalf("expected success | got: %v", err)
+				}
+			case "no match":
+				if !errors.Is(err, ErrNoIdentityMatched) {
+					t.Fatalf("expected: %v | got: %v", ErrNoIdentityMatched, err)
+				}
+			case "HMAC failure":
+				if !errors.Is(err, ErrHeaderMacIsNotValid) {
+					t.Fatalf("expected: %v | got: %v", ErrHeaderMacIsNotValid, err)
+				}
+			case "header failure":
+				if err == nil || errors.Is(err, ErrNoIdentityMatched) || errors.Is(err, ErrHeaderMacIsNotValid) {
+					t.Fatalf("expected a header failure | got: %v", err)
+				}
+			case "armor failure":
+				if !errors.Is(err, ErrArmorIsNotValid) {
+					t.Fatalf("expected: %v | got: %v", ErrArmorIsNotValid, err)
+				}
+			case "payload failure":
+				if !errors.Is(err, errPayloadIsNotValid) {
+					t.Fatalf("expected: %v | got: %v", errPayloadIsNotValid, err)
+				}
+			default:
+				t.Fatalf("unknown expected result: %s", vector.expect)
+			}
+
+			// the armor is entirely decoded before the header is parsed, so no data is released before an
+			// armor failure
+			if vector.payload != nil && vector.expect != "armor failure" {
+				hash := sha256.Sum256(plaintext)
+				if !bytes.Equal(hash[:], vector.payload) {
+					t.Errorf("payload hash. expected: %x | got: %x", vector.payload, hash)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package age
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/bloom42/stdx/crypto"
+	"github.com/bloom42/stdx/crypto/kdf"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+)
+
+const (
+	x25519StanzaType = "X25519"
+	x25519Label      = "age-encryption.org/v1/X25519"
+	recipientHrp     = "age"
+	identityHrp      = "AGE-SECRET-KEY-"
+)
+
+var (
+	ErrRecipientIsNotValid = errors.New("age: recipient is not valid")
+	ErrIdentityIsNotValid  = errors.New("age: identity is not valid")
+)
+
+// X25519Recipient is the standard age public key. Files encrypted to it can be decrypted by the
+// corresponding X25519Identity.
+type X25519Recipient struct {
+	publicKey crypto.Curve25519PublicKey
+}
+
+// we do this to have a compile-time error if X25519Recipient no longer satisfies the Recipient interface
+var _ Recipient = (*X25519Recipient)(nil)
+
+// NewX25519Recipient returns a recipient for the given public key
+func NewX25519Recipient(publicKey crypto.Curve25519PublicKey) (recipient *X25519Recipient, err error) {
+	if len(publicKey) != curve25519.PointSize {
+		err = ErrRecipientIsNotValid
+		return
+	}
+
+	recipient = &X25519Recipient{publicKey: publicKey}
+	return
+}
+
+// ParseX25519Recipient parses a recipient encoded with Bech32: "age1..."
+func ParseX25519Recipient(input string) (recipient *X25519Recipient, err error) {
+	hrp, data, err := bech32Decode(input)
+	if err != nil || hrp != recipientHrp {
+		err = ErrRecipientIsNotValid
+		return
+	}
+
+	return NewX25519Recipient(crypto.Curve25519PublicKey(data))
+}
+
+// PublicKey returns the public key of the recipient
+func (recipient *X25519Recipient) PublicKey() crypto.Curve25519PublicKey {
+	return recipient.publicKey
+}
+
+// String returns the recipient encoded with Bech32: "age1..."
+func (recipient *X25519Recipient) String() string {
+	encoded, _ := bech32Encode(recipientHrp, recipient.publicKey)
+	return encoded
+}
+
+// Wrap implements Recipient
+func (recipient *X25519Recipient) Wrap(fileKey []byte) (stanzas []*Stanza, err error) {
+	ephemeralPublicKey, ephemeralPrivateKey, err := crypto.GenerateCurve25519KeyPair()
+	if err != nil {
+		return
+	}
+	defer crypto.Zeroize(ephemeralPrivateKey)
+
+	sharedSecret, err := ephemeralPrivateKey.KeyExchange(recipient.publicKey)
+	if err != nil {
+		return
+	}
+	defer crypto.Zeroize(sharedSecret)
+
+	wrappingKey, err := x25519WrappingKey(sharedSecret, ephemeralPublicKey, recipient.publicKey)
+	if err != nil {
+		return
+	}
+	defer crypto.Zeroize(wrappingKey)
+
+	body, err := aeadSeal(wrappingKey, fileKey)
+	if err != nil {
+		return
+	}
+
+	stanzas = []*Stanza{{
+		Type: x25519StanzaType,
+		Args: []string{b64.EncodeToString(ephemeralPublicKey)},
+		Body: body,
+	}}
+	return
+}
+
+// X25519Identity is the standard age private key, which decrypts files encrypted to its X25519Recipient
+type X25519Identity struct {
+	privateKey crypto.Curve25519PrivateKey
+	publicKey  crypto.Curve25519PublicKey
+}
+
+// we do this to have a compile-time error if X25519Identity no longer satisfies the Identity interface
+var _ Identity = (*X25519Identity)(nil)
+
+// GenerateX25519Identity generates a new random identity
+func GenerateX25519Identity() (identity *X25519Identity, err error) {
+	_, privateKey, err := crypto.GenerateCurve25519KeyPair()
+	if err != nil {
+		return
+	}
+
+	return NewX25519Identity(privateKey)
+}
+
+// NewX25519Identity returns an identity for the given private key
+func NewX25519Identity(privateKey crypto.Curve25519PrivateKey) (identity *X25519Identity, err error) {
+	if len(privateKey) != curve25519.ScalarSize {
+		err = ErrIdentityIsNotValid
+		return
+	}
+
+	publicKey, err := privateKey.Public()
+	if err != nil {
+		err = ErrIdentityIsNotValid
+		return
+	}
+
+	identity = &X25519Identity{
+		privateKey: privateKey,
+		publicKey:  publicKey,
+	}
+	return
+}
+
+// ParseX25519Identity parses an identity encoded with Bech32: "AGE-SECRET-KEY-1..."
+func ParseX25519Identity(input string) (identity *X25519Identity, err error) {
+	hrp, data, err := bech32Decode(input)
+	if err != nil || hrp != strings.ToLower(identityHrp) {
+		err = ErrIdentityIsNotValid
+		return
+	}
+
+	return NewX25519Identity(crypto.Curve25519PrivateKey(data))
+}
+
+// Recipient returns the recipient corresponding to identity
+func (identity *X25519Identity) Recipient() *X25519Recipient {
+	return &X25519Recipient{publicKey: identity.publicKey}
+}
+
+// String returns the identity encoded with Bech32: "AGE-SECRET-KEY-1..."
+func (identity *X25519Identity) String() string {
+	encoded, _ := bech32Encode(identityHrp, identity.privateKey)
+	return encoded
+}
+
+// Unwrap implements Identity
+func (identity *X25519Identity) Unwrap(stanzas []*Stanza) (fileKey []byte, err error) {
+	for _, stanza := range stanzas {
+		if stanza.Type != x25519StanzaType {
+			continue
+		}
+
+		if len(stanza.Args) != 1 {
+			err = errStanzaIsNotValid
+			return
+		}
+		ephemeralPublicKey, decodeErr := b64.DecodeString(stanza.Args[0])
+		if decodeErr != nil || len(ephemeralPublicKey) != curve25519.PointSize {
+			err = errStanzaIsNotValid
+			return
+		}
+		if len(stanza.Body) != fileKeySize+chacha20poly1305.Overhead {
+			err = errStanzaIsNotValid
+			return
+		}
+
+		fileKey, err = identity.unwrapStanza(ephemeralPublicKey, stanza.Body)
+		if err == nil {
+			return
+		}
+		if !errors.Is(err, ErrIncorrectIdentity) {
+			return
+		}
+	}
+
+	err = ErrIncorrectIdentity
+	return
+}
+
+func (identity *X25519Identity) unwrapStanza(ephemeralPublicKey crypto.Curve25519PublicKey, body []byte) (fileKey []byte, err error) {
+	// X25519 returns an error for low-order points
+	sharedSecret, err := identity.privateKey.KeyExchange(ephemeralPublicKey)
+	if err != nil {
+		err = errStanzaIsNotValid
+		return
+	}
+	defer crypto.Zeroize(sharedSecret)
+
+	wrappingKey, err := x25519WrappingKey(sharedSecret, ephemeralPublicKey, identity.publicKey)
+	if err != nil {
+		return
+	}
+	defer crypto.Zeroize(wrappingKey)
+
+	fileKey, err = aeadOpen(wrappingKey, body)
+	if err != nil {
+		err = ErrIncorrectIdentity
+		return
+	}
+	return
+}
+
+func x25519WrappingKey(sharedSecret []byte, ephemeralPublicKey, recipientPublicKey crypto.Curve25519PublicKey) ([]byte, error) {
+	salt := make([]byte, 0, 2*curve25519.PointSize)
+	salt = append(salt, ephemeralPublicKey...)
+	salt = append(salt, recipientPublicKey...)
+
+	return kdf.HkdfSha256(sharedSecret, []byte(x25519Label), salt, chacha20poly1305.KeySize)
+}
+
+// aeadSeal encrypts the file key with ChaCha20-Poly1305 and a zero nonce, which is safe because
+// wrapping keys are never reused
+func aeadSeal(key, fileKey []byte) (ciphertext []byte, err error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return
+	}
+
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	ciphertext = aead.Seal(nil, nonce, fileKey, nil)
+	return
+}
+
+func aeadOpen(key, ciphertext []byte) (fileKey []byte, err error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return
+	}
+
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	fileKey, err = aead.Open(nil, nonce, ciphertext, nil)
+	return
+}
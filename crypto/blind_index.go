@@ -0,0 +1,48 @@
+package crypto
+
+import (
+	"errors"
+)
+
+const (
+	// BlindIndexMaxBits is the maximum size, in bits, of a blind index
+	BlindIndexMaxBits = 256
+
+	blindIndexKeyInfo = "com.bloom42.stdx.crypto.blind_index.v1"
+)
+
+// BlindIndex returns a keyed hash of value truncated to bits bits, which can be stored next to an
+// encrypted column to run equality queries (WHERE email_index = $1) without decrypting the column.
+//
+// The fewer bits, the more values share the same index, which limits what an attacker with access to the
+// database can learn (e.g. which rows have the same value), but requires to decrypt and filter more
+// rows after the lookup. A good starting point is a number of bits such that each index is shared by a few
+// rows: log2(number of rows) - 2. Use BlindIndexMaxBits for exact lookups.
+//
+// The index key is derived from key, so key can be the same key used to encrypt the column.
+// Values should be normalized (e.g. lowercased emails) before being indexed.
+// The last byte of the index is zero-padded if bits is not a multiple of 8.
+func BlindIndex(key, value []byte, bits uint) (index []byte, err error) {
+	if bits < 1 || bits > BlindIndexMaxBits {
+		err = errors.New("crypto: blind index bits must be between 1 and 256")
+		return
+	}
+
+	indexKey, err := DeriveKeyFromKey(key, []byte(blindIndexKeyInfo), KeySize256)
+	if err != nil {
+		return
+	}
+	defer Zeroize(indexKey)
+
+	mac, err := Mac(indexKey, value, KeySize256)
+	if err != nil {
+		return
+	}
+
+	index = mac[:(bits+7)/8]
+	if remainingBits := bits % 8; remainingBits != 0 {
+		index[len(index)-1] &= byte(0xff << (8 - remainingBits))
+	}
+
+	return
+}
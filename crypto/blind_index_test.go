@@ -0,0 +1,45 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBlindIndex(t *testing.T) {
+	key, _ := NewAEADKey()
+	value := []byte("hello@example.com")
+
+	index1, err := BlindIndex(key, value, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index2, _ := BlindIndex(key, value, 256)
+	if !bytes.Equal(index1, index2) {
+		t.Error("blind indexes of the same value should be equal")
+	}
+
+	otherIndex, _ := BlindIndex(key, []byte("other@example.com"), 256)
+	if bytes.Equal(index1, otherIndex) {
+		t.Error("blind indexes of different values should be different")
+	}
+
+	otherKey, _ := NewAEADKey()
+	otherIndex, _ = BlindIndex(otherKey, value, 256)
+	if bytes.Equal(index1, otherIndex) {
+		t.Error("blind indexes with different keys should be different")
+	}
+
+	truncated, err := BlindIndex(key, value, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(truncated) != 2 || truncated[0] != index1[0] || truncated[1] != index1[1]&0xf0 {
+		t.Errorf("truncated index: %x (full index: %x)", truncated, index1)
+	}
+
+	for _, bits := range []uint{0, 257} {
+		if _, err = BlindIndex(key, value, bits); err == nil {
+			t.Errorf("bits = %d should be rejected", bits)
+		}
+	}
+}
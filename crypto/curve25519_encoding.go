@@ -0,0 +1,143 @@
+package crypto
+
+import (
+	"crypto/ecdh"
+	"encoding/base64"
+	"encoding/json"
+)
+
+// MarshalPEM encodes publicKey in a PEM "PUBLIC KEY" block (SubjectPublicKeyInfo)
+func (publicKey Curve25519PublicKey) MarshalPEM() (pemData []byte, err error) {
+	ecdhPublicKey, err := ecdh.X25519().NewPublicKey(publicKey)
+	if err != nil {
+		return
+	}
+
+	return marshalPublicKeyPEM(ecdhPublicKey)
+}
+
+// MarshalJWK encodes publicKey as a JSON Web Key: {"kty":"OKP","crv":"X25519","x":"..."}
+func (publicKey Curve25519PublicKey) MarshalJWK() ([]byte, error) {
+	return json.Marshal(jwk{
+		Kty: jwkKtyOkp,
+		Crv: jwkCrvX25519,
+		X:   base64.RawURLEncoding.EncodeToString(publicKey),
+	})
+}
+
+// ParseCurve25519PublicKeyPEM decodes a public key encoded by Curve25519PublicKey.MarshalPEM
+func ParseCurve25519PublicKeyPEM(pemData []byte) (publicKey Curve25519PublicKey, err error) {
+	key, err := parsePublicKeyPEM(pemData)
+	if err != nil {
+		return
+	}
+
+	ecdhPublicKey, isEcdh := key.(*ecdh.PublicKey)
+	if !isEcdh || ecdhPublicKey.Curve() != ecdh.X25519() {
+		err = ErrKeyTypeIsNotValid
+		return
+	}
+
+	publicKey = Curve25519PublicKey(ecdhPublicKey.Bytes())
+	return
+}
+
+// ParseCurve25519PublicKeyJWK decodes a public key encoded by Curve25519PublicKey.MarshalJWK
+func ParseCurve25519PublicKeyJWK(jwkData []byte) (publicKey Curve25519PublicKey, err error) {
+	key, err := parseJWK(jwkData, jwkCrvX25519)
+	if err != nil {
+		return
+	}
+
+	if len(key.x) != Curve25519PublicKeySize {
+		err = ErrJWKIsNotValid
+		return
+	}
+
+	publicKey = Curve25519PublicKey(key.x)
+	return
+}
+
+// MarshalPEM encodes privateKey in a PEM "PRIVATE KEY" block (PKCS#8)
+func (privateKey Curve25519PrivateKey) MarshalPEM() (pemData []byte, err error) {
+	return privateKey.marshalPEM(nil)
+}
+
+// MarshalEncryptedPEM encodes privateKey in a PEM "ENCRYPTED PRIVATE KEY" block (PKCS#8).
+// See Ed25519PrivateKey.MarshalEncryptedPEM.
+func (privateKey Curve25519PrivateKey) MarshalEncryptedPEM(password []byte) (pemData []byte, err error) {
+	if len(password) == 0 {
+		err = ErrPasswordIsEmpty
+		return
+	}
+
+	return privateKey.marshalPEM(password)
+}
+
+func (privateKey Curve25519PrivateKey) marshalPEM(password []byte) (pemData []byte, err error) {
+	ecdhPrivateKey, err := ecdh.X25519().NewPrivateKey(privateKey)
+	if err != nil {
+		return
+	}
+
+	return marshalPrivateKeyPEM(ecdhPrivateKey, password)
+}
+
+// MarshalJWK encodes privateKey as a JSON Web Key: {"kty":"OKP","crv":"X25519","x":"...","d":"..."}
+func (privateKey Curve25519PrivateKey) MarshalJWK() (jwkData []byte, err error) {
+	publicKey, err := privateKey.Public()
+	if err != nil {
+		return
+	}
+
+	return json.Marshal(jwk{
+		Kty: jwkKtyOkp,
+		Crv: jwkCrvX25519,
+		X:   base64.RawURLEncoding.EncodeToString(publicKey),
+		D:   base64.RawURLEncoding.EncodeToString(privateKey),
+	})
+}
+
+// ParseCurve25519PrivateKeyPEM decodes a private key encoded by Curve25519PrivateKey.MarshalPEM or
+// Curve25519PrivateKey.MarshalEncryptedPEM. password is only used if the key is encrypted.
+func ParseCurve25519PrivateKeyPEM(pemData, password []byte) (privateKey Curve25519PrivateKey, err error) {
+	key, err := parsePrivateKeyPEM(pemData, password)
+	if err != nil {
+		return
+	}
+
+	ecdhPrivateKey, isEcdh := key.(*ecdh.PrivateKey)
+	if !isEcdh || ecdhPrivateKey.Curve() != ecdh.X25519() {
+		err = ErrKeyTypeIsNotValid
+		return
+	}
+
+	privateKey = Curve25519PrivateKey(ecdhPrivateKey.Bytes())
+	return
+}
+
+// ParseCurve25519PrivateKeyJWK decodes a private key encoded by Curve25519PrivateKey.MarshalJWK
+func ParseCurve25519PrivateKeyJWK(jwkData []byte) (privateKey Curve25519PrivateKey, err error) {
+	key, err := parseJWK(jwkData, jwkCrvX25519)
+	if err != nil {
+		return
+	}
+
+	if len(key.d) != Curve25519PrivateKeySize {
+		err = ErrJWKIsNotValid
+		return
+	}
+	privateKey = Curve25519PrivateKey(key.d)
+
+	publicKey, err := privateKey.Public()
+	if err != nil {
+		return
+	}
+	if !ConstantTimeCompare(publicKey, key.x) {
+		privateKey = nil
+		err = ErrJWKIsNotValid
+		return
+	}
+
+	return
+}
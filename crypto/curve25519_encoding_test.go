@@ -0,0 +1,66 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCurve25519KeyEncoding(t *testing.T) {
+	publicKey, privateKey, err := GenerateCurve25519KeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	password := []byte("pa$$word")
+
+	pemPublicKey, err := publicKey.MarshalPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodedPublicKey, err := ParseCurve25519PublicKeyPEM(pemPublicKey)
+	if err != nil || !bytes.Equal(decodedPublicKey, publicKey) {
+		t.Errorf("PEM public key: %v", err)
+	}
+
+	jwkPublicKey, err := publicKey.MarshalJWK()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodedPublicKey, err = ParseCurve25519PublicKeyJWK(jwkPublicKey)
+	if err != nil || !bytes.Equal(decodedPublicKey, publicKey) {
+		t.Errorf("JWK public key: %v", err)
+	}
+
+	pemPrivateKey, err := privateKey.MarshalPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodedPrivateKey, err := ParseCurve25519PrivateKeyPEM(pemPrivateKey, nil)
+	if err != nil || !bytes.Equal(decodedPrivateKey, privateKey) {
+		t.Errorf("PEM private key: %v", err)
+	}
+
+	encryptedPemPrivateKey, err := privateKey.MarshalEncryptedPEM(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodedPrivateKey, err = ParseCurve25519PrivateKeyPEM(encryptedPemPrivateKey, password)
+	if err != nil || !bytes.Equal(decodedPrivateKey, privateKey) {
+		t.Errorf("encrypted PEM private key: %v", err)
+	}
+
+	jwkPrivateKey, err := privateKey.MarshalJWK()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodedPrivateKey, err = ParseCurve25519PrivateKeyJWK(jwkPrivateKey)
+	if err != nil || !bytes.Equal(decodedPrivateKey, privateKey) {
+		t.Errorf("JWK private key: %v", err)
+	}
+
+	// an Ed25519 key is not a Curve25519 key
+	ed25519PublicKey, _, _ := GenerateEd25519KeyPair()
+	ed25519Pem, _ := ed25519PublicKey.MarshalPEM()
+	if _, err = ParseCurve25519PublicKeyPEM(ed25519Pem); err != ErrKeyTypeIsNotValid {
+		t.Errorf("expected: %v | got: %v", ErrKeyTypeIsNotValid, err)
+	}
+}
@@ -10,10 +10,30 @@
 //
 // Only th efunction `HashPassword` should be used for password hashing.
 //
+// `VerifyPasswordHashAndRehash` should be used to verify passwords, as it returns a new hash when the
+// stored one was created with outdated parameters, or by a legacy algorithm (bcrypt, scrypt, PBKDF2).
+//
 // # AEAD
 //
 // AEAD (Authenticated Encryption with Associated Data) is used for secret key (symmetric) cryptography.
 //
+// `NewEncryptWriter` and `NewDecryptReader` should be used to encrypt large files or streams that
+// don't fit in memory.
+//
+// `Keyring` should be used when keys need to be rotated: ciphertexts embed the ID of the key used to
+// encrypt them, and can be progressively re-encrypted with the new primary key with `Keyring.Rewrap`.
+//
+// `EncryptDeterministic` (AES-SIV) and `BlindIndex` allow to run equality queries on encrypted database
+// columns. See the dbx package for an example.
+//
+// # Public key encryption
+//
+// The `crypto/hpke` package (RFC 9180) should be preferred over `Curve25519PublicKey.EncryptEphemeral`
+// when messages need to be decrypted by other implementations.
+//
+// The `crypto/age` package implements the age file format, which should be used for files and backups
+// which may need to be decrypted with the standard age tools.
+//
 // # Hash
 //
 // hash functions (`Hash{256,384,512}`, `NewHash`) should be used to hashs files or other kind of data.
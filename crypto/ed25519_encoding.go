@@ -0,0 +1,200 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// MarshalPEM encodes publicKey in a PEM "PUBLIC KEY" block (SubjectPublicKeyInfo)
+func (publicKey Ed25519PublicKey) MarshalPEM() ([]byte, error) {
+	return marshalPublicKeyPEM(ed25519.PublicKey(publicKey))
+}
+
+// MarshalJWK encodes publicKey as a JSON Web Key: {"kty":"OKP","crv":"Ed25519","x":"..."}
+func (publicKey Ed25519PublicKey) MarshalJWK() ([]byte, error) {
+	return json.Marshal(jwk{
+		Kty: jwkKtyOkp,
+		Crv: jwkCrvEd25519,
+		X:   base64.RawURLEncoding.EncodeToString(publicKey),
+	})
+}
+
+// MarshalOpenSSH encodes publicKey in the OpenSSH authorized_keys format: "ssh-ed25519 AAAA...\n"
+func (publicKey Ed25519PublicKey) MarshalOpenSSH() ([]byte, error) {
+	sshPublicKey, err := ssh.NewPublicKey(ed25519.PublicKey(publicKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.MarshalAuthorizedKey(sshPublicKey), nil
+}
+
+// ParseEd25519PublicKeyPEM decodes a public key encoded by Ed25519PublicKey.MarshalPEM
+func ParseEd25519PublicKeyPEM(pemData []byte) (publicKey Ed25519PublicKey, err error) {
+	key, err := parsePublicKeyPEM(pemData)
+	if err != nil {
+		return
+	}
+
+	ed25519PublicKey, isEd25519 := key.(ed25519.PublicKey)
+	if !isEd25519 {
+		err = ErrKeyTypeIsNotValid
+		return
+	}
+
+	publicKey = Ed25519PublicKey(ed25519PublicKey)
+	return
+}
+
+// ParseEd25519PublicKeyJWK decodes a public key encoded by Ed25519PublicKey.MarshalJWK
+func ParseEd25519PublicKeyJWK(jwkData []byte) (publicKey Ed25519PublicKey, err error) {
+	key, err := parseJWK(jwkData, jwkCrvEd25519)
+	if err != nil {
+		return
+	}
+
+	return NewEd25519PublicKeyFromBytes(key.x)
+}
+
+// ParseEd25519PublicKeyOpenSSH decodes a public key in the OpenSSH authorized_keys format
+func ParseEd25519PublicKeyOpenSSH(data []byte) (publicKey Ed25519PublicKey, err error) {
+	sshPublicKey, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return
+	}
+
+	cryptoPublicKey, isCryptoPublicKey := sshPublicKey.(ssh.CryptoPublicKey)
+	if !isCryptoPublicKey {
+		err = ErrKeyTypeIsNotValid
+		return
+	}
+
+	ed25519PublicKey, isEd25519 := cryptoPublicKey.CryptoPublicKey().(ed25519.PublicKey)
+	if !isEd25519 {
+		err = ErrKeyTypeIsNotValid
+		return
+	}
+
+	publicKey = Ed25519PublicKey(ed25519PublicKey)
+	return
+}
+
+// MarshalPEM encodes privateKey in a PEM "PRIVATE KEY" block (PKCS#8)
+func (privateKey Ed25519PrivateKey) MarshalPEM() ([]byte, error) {
+	return marshalPrivateKeyPEM(ed25519.PrivateKey(privateKey), nil)
+}
+
+// MarshalEncryptedPEM encodes privateKey in a PEM "ENCRYPTED PRIVATE KEY" block (PKCS#8), encrypted
+// with password using PBES2 (PBKDF2-HMAC-SHA256 and AES-256-CBC), which is supported by OpenSSL.
+func (privateKey Ed25519PrivateKey) MarshalEncryptedPEM(password []byte) ([]byte, error) {
+	if len(password) == 0 {
+		return nil, ErrPasswordIsEmpty
+	}
+
+	return marshalPrivateKeyPEM(ed25519.PrivateKey(privateKey), password)
+}
+
+// MarshalJWK encodes privateKey as a JSON Web Key: {"kty":"OKP","crv":"Ed25519","x":"...","d":"..."}
+func (privateKey Ed25519PrivateKey) MarshalJWK() ([]byte, error) {
+	return json.Marshal(jwk{
+		Kty: jwkKtyOkp,
+		Crv: jwkCrvEd25519,
+		X:   base64.RawURLEncoding.EncodeToString(privateKey.Public()),
+		D:   base64.RawURLEncoding.EncodeToString(privateKey.Seed()),
+	})
+}
+
+// MarshalOpenSSH encodes privateKey in the OpenSSH private key format.
+// If password is not empty, the key is encrypted with password (bcrypt-pbkdf and AES-256-CTR).
+func (privateKey Ed25519PrivateKey) MarshalOpenSSH(comment string, password []byte) (pemData []byte, err error) {
+	var block *pem.Block
+
+	if len(password) == 0 {
+		block, err = ssh.MarshalPrivateKey(ed25519.PrivateKey(privateKey), comment)
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(ed25519.PrivateKey(privateKey), comment, password)
+	}
+	if err != nil {
+		return
+	}
+
+	pemData = pem.EncodeToMemory(block)
+	return
+}
+
+// ParseEd25519PrivateKeyPEM decodes a private key encoded by Ed25519PrivateKey.MarshalPEM or
+// Ed25519PrivateKey.MarshalEncryptedPEM. password is only used if the key is encrypted.
+func ParseEd25519PrivateKeyPEM(pemData, password []byte) (privateKey Ed25519PrivateKey, err error) {
+	key, err := parsePrivateKeyPEM(pemData, password)
+	if err != nil {
+		return
+	}
+
+	ed25519PrivateKey, isEd25519 := key.(ed25519.PrivateKey)
+	if !isEd25519 {
+		err = ErrKeyTypeIsNotValid
+		return
+	}
+
+	privateKey = Ed25519PrivateKey(ed25519PrivateKey)
+	return
+}
+
+// ParseEd25519PrivateKeyJWK decodes a private key encoded by Ed25519PrivateKey.MarshalJWK
+func ParseEd25519PrivateKeyJWK(jwkData []byte) (privateKey Ed25519PrivateKey, err error) {
+	key, err := parseJWK(jwkData, jwkCrvEd25519)
+	if err != nil {
+		return
+	}
+	defer Zeroize(key.d)
+
+	privateKey, err = NewEd25519PrivateKeyFromSeed(key.d)
+	if err != nil {
+		return
+	}
+
+	if !ConstantTimeCompare(privateKey.Public(), key.x) {
+		err = ErrJWKIsNotValid
+		return
+	}
+
+	return
+}
+
+// ParseEd25519PrivateKeyOpenSSH decodes a private key in the OpenSSH private key format.
+// password is only used if the key is encrypted.
+func ParseEd25519PrivateKeyOpenSSH(pemData, password []byte) (privateKey Ed25519PrivateKey, err error) {
+	var key any
+
+	if len(password) == 0 {
+		key, err = ssh.ParseRawPrivateKey(pemData)
+		var passphraseMissingError *ssh.PassphraseMissingError
+		if errors.As(err, &passphraseMissingError) {
+			err = ErrPrivateKeyIsEncrypted
+		}
+	} else {
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase(pemData, password)
+		if errors.Is(err, x509.IncorrectPasswordError) {
+			err = ErrPrivateKeyPassword
+		}
+	}
+	if err != nil {
+		return
+	}
+
+	switch ed25519PrivateKey := key.(type) {
+	case ed25519.PrivateKey:
+		privateKey = Ed25519PrivateKey(ed25519PrivateKey)
+	case *ed25519.PrivateKey:
+		privateKey = Ed25519PrivateKey(*ed25519PrivateKey)
+	default:
+		err = ErrKeyTypeIsNotValid
+	}
+	return
+}
@@ -0,0 +1,117 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEd25519KeyEncoding(t *testing.T) {
+	publicKey, privateKey, err := GenerateEd25519KeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	password := []byte("pa$$word")
+
+	pemPublicKey, err := publicKey.MarshalPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodedPublicKey, err := ParseEd25519PublicKeyPEM(pemPublicKey)
+	if err != nil || !bytes.Equal(decodedPublicKey, publicKey) {
+		t.Errorf("PEM public key: %v", err)
+	}
+
+	jwkPublicKey, err := publicKey.MarshalJWK()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodedPublicKey, err = ParseEd25519PublicKeyJWK(jwkPublicKey)
+	if err != nil || !bytes.Equal(decodedPublicKey, publicKey) {
+		t.Errorf("JWK public key: %v", err)
+	}
+
+	sshPublicKey, err := publicKey.MarshalOpenSSH()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(sshPublicKey, []byte("ssh-ed25519 ")) {
+		t.Errorf("OpenSSH public key: %s", sshPublicKey)
+	}
+	decodedPublicKey, err = ParseEd25519PublicKeyOpenSSH(sshPublicKey)
+	if err != nil || !bytes.Equal(decodedPublicKey, publicKey) {
+		t.Errorf("OpenSSH public key: %v", err)
+	}
+
+	pemPrivateKey, err := privateKey.MarshalPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodedPrivateKey, err := ParseEd25519PrivateKeyPEM(pemPrivateKey, nil)
+	if err != nil || !bytes.Equal(decodedPrivateKey, privateKey) {
+		t.Errorf("PEM private key: %v", err)
+	}
+
+	encryptedPemPrivateKey, err := privateKey.MarshalEncryptedPEM(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodedPrivateKey, err = ParseEd25519PrivateKeyPEM(encryptedPemPrivateKey, password)
+	if err != nil || !bytes.Equal(decodedPrivateKey, privateKey) {
+		t.Errorf("encrypted PEM private key: %v", err)
+	}
+	if _, err = ParseEd25519PrivateKeyPEM(encryptedPemPrivateKey, nil); err != ErrPrivateKeyIsEncrypted {
+		t.Errorf("expected: %v | got: %v", ErrPrivateKeyIsEncrypted, err)
+	}
+	if _, err = ParseEd25519PrivateKeyPEM(encryptedPemPrivateKey, []byte("wrong")); err != ErrPrivateKeyPassword {
+		t.Errorf("expected: %v | got: %v", ErrPrivateKeyPassword, err)
+	}
+
+	jwkPrivateKey, err := privateKey.MarshalJWK()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodedPrivateKey, err = ParseEd25519PrivateKeyJWK(jwkPrivateKey)
+	if err != nil || !bytes.Equal(decodedPrivateKey, privateKey) {
+		t.Errorf("JWK private key: %v", err)
+	}
+
+	for _, sshPassword := range [][]byte{nil, password} {
+		sshPrivateKey, err := privateKey.MarshalOpenSSH("comment", sshPassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decodedPrivateKey, err = ParseEd25519PrivateKeyOpenSSH(sshPrivateKey, sshPassword)
+		if err != nil || !bytes.Equal(decodedPrivateKey, privateKey) {
+			t.Errorf("OpenSSH private key: %v", err)
+		}
+		if sshPassword != nil {
+			if _, err = ParseEd25519PrivateKeyOpenSSH(sshPrivateKey, nil); err != ErrPrivateKeyIsEncrypted {
+				t.Errorf("expected: %v | got: %v", ErrPrivateKeyIsEncrypted, err)
+			}
+			if _, err = ParseEd25519PrivateKeyOpenSSH(sshPrivateKey, []byte("wrong")); err != ErrPrivateKeyPassword {
+				t.Errorf("expected: %v | got: %v", ErrPrivateKeyPassword, err)
+			}
+		}
+	}
+}
+
+// RFC 8037 Appendix A.1 and A.2
+func TestEd25519JWKRFC8037(t *testing.T) {
+	jwkPrivateKey := []byte(`{"kty":"OKP","crv":"Ed25519",
+		"d":"nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A",
+		"x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}`)
+
+	privateKey, err := ParseEd25519PrivateKeyJWK(jwkPrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwkPublicKey, err := privateKey.Public().MarshalJWK()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"kty":"OKP","crv":"Ed25519","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}`
+	if string(jwkPublicKey) != expected {
+		t.Errorf("expected: %s | got: %s", expected, jwkPublicKey)
+	}
+}
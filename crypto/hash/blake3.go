@@ -0,0 +1,18 @@
+package hash
+
+import (
+	"hash"
+
+	"github.com/zeebo/blake3"
+)
+
+// NewBlake3 returns a new `hash.Hash` computing the BLAKE3-256 checksum.
+func NewBlake3() hash.Hash {
+	return blake3.New()
+}
+
+// Blake3 returns the BLAKE3-256 checksum of the data.
+func Blake3(data []byte) []byte {
+	sum := blake3.Sum256(data)
+	return sum[:]
+}
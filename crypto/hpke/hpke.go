@@ -0,0 +1,422 @@
+// Package hpke implements Hybrid Public Key Encryption (RFC 9180) with the DHKEM(X25519, HKDF-SHA256)
+// KEM, the HKDF-SHA256 KDF, and the ChaCha20-Poly1305 or AES-256-GCM AEADs, on top of the
+// crypto.Curve25519PublicKey and crypto.Curve25519PrivateKey types.
+//
+// Unlike crypto.Curve25519PublicKey.EncryptEphemeral, HPKE is a standard which is implemented in
+// many languages.
+//
+// The base, PSK, auth and auth-PSK modes are supported. Seal and Open are single-shot helpers for the
+// base mode. For the other modes, or to encrypt multiple messages with the same encapsulated key,
+// use the Setup* methods of Suite which return a Context.
+package hpke
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"github.com/bloom42/stdx/crypto"
+	"github.com/bloom42/stdx/crypto/kdf"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// AEAD identifies the AEAD used by a Suite, as registered in RFC 9180
+type AEAD uint16
+
+const (
+	AEADAes256Gcm        AEAD = 0x0002
+	AEADChaCha20Poly1305 AEAD = 0x0003
+)
+
+// Mode identifies the mode of HPKE
+type Mode uint8
+
+const (
+	ModeBase    Mode = 0x00
+	ModePsk     Mode = 0x01
+	ModeAuth    Mode = 0x02
+	ModeAuthPsk Mode = 0x03
+)
+
+const (
+	kemX25519HkdfSha256 uint16 = 0x0020
+	kdfHkdfSha256       uint16 = 0x0001
+
+	// EncapsulatedKeySize is the size, in bytes, of the encapsulated key (enc)
+	EncapsulatedKeySize = crypto.Curve25519PublicKeySize
+
+	kemSecretSize = 32
+	kdfHashSize   = 32
+	aeadKeySize   = 32
+	aeadNonceSize = 12
+)
+
+var (
+	ErrAEADIsNotValid       = errors.New("hpke: AEAD is not valid")
+	ErrPskIsNotValid        = errors.New("hpke: psk and psk ID must be both provided, or both empty")
+	ErrOpen                 = errors.New("hpke: message can't be decrypted")
+	ErrMessageLimitReached  = errors.New("hpke: message limit reached")
+	ErrEncapsulatedKeySize  = errors.New("hpke: encapsulated key is not valid")
+	ErrExportSizeIsNotValid = errors.New("hpke: export size is not valid")
+)
+
+// Suite is an HPKE ciphersuite: DHKEM(X25519, HKDF-SHA256), HKDF-SHA256 and an AEAD
+type Suite struct {
+	aead AEAD
+}
+
+// NewSuite returns a Suite using the given AEAD
+func NewSuite(aead AEAD) (suite Suite, err error) {
+	if aead != AEADAes256Gcm && aead != AEADChaCha20Poly1305 {
+		err = ErrAEADIsNotValid
+		return
+	}
+
+	suite = Suite{aead: aead}
+	return
+}
+
+// Seal encrypts plaintext to recipient using the base mode, and returns the encapsulated key
+// which must be sent along with the ciphertext.
+func (suite Suite) Seal(recipient crypto.Curve25519PublicKey, info, additionalData, plaintext []byte) (enc, ciphertext []byte, err error) {
+	enc, context, err := suite.SetupBaseSender(recipient, info)
+	if err != nil {
+		return
+	}
+
+	ciphertext, err = context.Seal(plaintext, additionalData)
+	return
+}
+
+// Open decrypts a ciphertext produced by Seal
+func (suite Suite) Open(enc []byte, recipient crypto.Curve25519PrivateKey, info, additionalData, ciphertext []byte) (plaintext []byte, err error) {
+	context, err := suite.SetupBaseRecipient(enc, recipient, info)
+	if err != nil {
+		return
+	}
+
+	return context.Open(ciphertext, additionalData)
+}
+
+// SetupBaseSender sets up a Context to encrypt messages to recipient
+func (suite Suite) SetupBaseSender(recipient crypto.Curve25519PublicKey, info []byte) (enc []byte, context *Context, err error) {
+	return suite.setupSender(ModeBase, recipient, nil, info, nil, nil, nil)
+}
+
+// SetupBaseRecipient sets up a Context to decrypt messages encrypted by a Context returned by SetupBaseSender
+func (suite Suite) SetupBaseRecipient(enc []byte, recipient crypto.Curve25519PrivateKey, info []byte) (context *Context, err error) {
+	return suite.setupRecipient(ModeBase, enc, recipient, nil, info, nil, nil)
+}
+
+// SetupPskSender sets up a Context to encrypt messages to recipient, authenticated by a pre-shared key
+func (suite Suite) SetupPskSender(recipient crypto.Curve25519PublicKey, info, psk, pskID []byte) (enc []byte, context *Context, err error) {
+	return suite.setupSender(ModePsk, recipient, nil, info, psk, pskID, nil)
+}
+
+// SetupPskRecipient sets up a Context to decrypt messages encrypted by a Context returned by SetupPskSender
+func (suite Suite) SetupPskRecipient(enc []byte, recipient crypto.Curve25519PrivateKey, info, psk, pskID []byte) (context *Context, err error) {
+	return suite.setupRecipient(ModePsk, enc, recipient, nil, info, psk, pskID)
+}
+
+// SetupAuthSender sets up a Context to encrypt messages to recipient, authenticated by the private key of the sender
+func (suite Suite) SetupAuthSender(recipient crypto.Curve25519PublicKey, sender crypto.Curve25519PrivateKey, info []byte) (enc []byte, context *Context, err error) {
+	return suite.setupSender(ModeAuth, recipient, sender, info, nil, nil, nil)
+}
+
+// SetupAuthRecipient sets up a Context to decrypt messages encrypted by a Context returned by SetupAuthSender.
+// sender is the public key of the sender.
+func (suite Suite) SetupAuthRecipient(enc []byte, recipient crypto.Curve25519PrivateKey, sender crypto.Curve25519PublicKey, info []byte) (context *Context, err error) {
+	return suite.setupRecipient(ModeAuth, enc, recipient, sender, info, nil, nil)
+}
+
+// SetupAuthPskSender combines SetupAuthSender and SetupPskSender
+func (suite Suite) SetupAuthPskSender(recipient crypto.Curve25519PublicKey, sender crypto.Curve25519PrivateKey, info, psk, pskID []byte) (enc []byte, context *Context, err error) {
+	return suite.setupSender(ModeAuthPsk, recipient, sender, info, psk, pskID, nil)
+}
+
+// SetupAuthPskRecipient sets up a Context to decrypt messages encrypted by a Context returned by SetupAuthPskSender
+func (suite Suite) SetupAuthPskRecipient(enc []byte, recipient crypto.Curve25519PrivateKey, sender crypto.Curve25519PublicKey, info, psk, pskID []byte) (context *Context, err error) {
+	return suite.setupRecipient(ModeAuthPsk, enc, recipient, sender, info, psk, pskID)
+}
+
+// DeriveKeyPair deterministically derives a key pair from ikm, which must be at least 32 bytes of
+// uniformly random data.
+func DeriveKeyPair(ikm []byte) (publicKey crypto.Curve25519PublicKey, privateKey crypto.Curve25519PrivateKey, err error) {
+	if len(ikm) < crypto.Curve25519PrivateKeySize {
+		err = errors.New("hpke: ikm is too short")
+		return
+	}
+
+	suiteID := kemSuiteID()
+	dkpPrk := labeledExtract(suiteID, nil, "dkp_prk", ikm)
+	sk, err := labeledExpand(suiteID, dkpPrk, "sk", nil, crypto.Curve25519PrivateKeySize)
+	if err != nil {
+		return
+	}
+
+	privateKey = crypto.Curve25519PrivateKey(sk)
+	publicKey, err = privateKey.Public()
+	return
+}
+
+// ephemeralPrivateKey is only used by tests, to make the encapsulation deterministic
+func (suite Suite) setupSender(mode Mode, recipient crypto.Curve25519PublicKey, sender crypto.Curve25519PrivateKey,
+	info, psk, pskID []byte, ephemeralPrivateKey crypto.Curve25519PrivateKey) (enc []byte, context *Context, err error) {
+	if ephemeralPrivateKey == nil {
+		_, ephemeralPrivateKey, err = crypto.GenerateCurve25519KeyPair()
+		if err != nil {
+			return
+		}
+		defer crypto.Zeroize(ephemeralPrivateKey)
+	}
+
+	ephemeralPublicKey, err := ephemeralPrivateKey.Public()
+	if err != nil {
+		return
+	}
+	enc = ephemeralPublicKey
+
+	dh, err := ephemeralPrivateKey.KeyExchange(recipient)
+	if err != nil {
+		return
+	}
+	kemContext := append(append([]byte(nil), enc...), recipient...)
+
+	if sender != nil {
+		var senderPublicKey crypto.Curve25519PublicKey
+		var senderDh []byte
+
+		senderDh, err = sender.KeyExchange(recipient)
+		if err != nil {
+			return
+		}
+		dh = append(dh, senderDh...)
+
+		senderPublicKey, err = sender.Public()
+		if err != nil {
+			return
+		}
+		kemContext = append(kemContext, senderPublicKey...)
+	}
+	defer crypto.Zeroize(dh)
+
+	sharedSecret, err := extractAndExpand(dh, kemContext)
+	if err != nil {
+		return
+	}
+	defer crypto.Zeroize(sharedSecret)
+
+	context, err = suite.keySchedule(mode, sharedSecret, info, psk, pskID)
+	return
+}
+
+func (suite Suite) setupRecipient(mode Mode, enc []byte, recipient crypto.Curve25519PrivateKey, sender crypto.Curve25519PublicKey,
+	info, psk, pskID []byte) (context *Context, err error) {
+	if len(enc) != EncapsulatedKeySize {
+		err = ErrEncapsulatedKeySize
+		return
+	}
+
+	recipientPublicKey, err := recipient.Public()
+	if err != nil {
+		return
+	}
+
+	dh, err := recipient.KeyExchange(enc)
+	if err != nil {
+		return
+	}
+	kemContext := append(append([]byte(nil), enc...), recipientPublicKey...)
+
+	if sender != nil {
+		var senderDh []byte
+
+		senderDh, err = recipient.KeyExchange(sender)
+		if err != nil {
+			return
+		}
+		dh = append(dh, senderDh...)
+		kemContext = append(kemContext, sender...)
+	}
+	defer crypto.Zeroize(dh)
+
+	sharedSecret, err := extractAndExpand(dh, kemContext)
+	if err != nil {
+		return
+	}
+	defer crypto.Zeroize(sharedSecret)
+
+	context, err = suite.keySchedule(mode, sharedSecret, info, psk, pskID)
+	return
+}
+
+func (suite Suite) keySchedule(mode Mode, sharedSecret, info, psk, pskID []byte) (context *Context, err error) {
+	usesPsk := mode == ModePsk || mode == ModeAuthPsk
+	if (len(psk) == 0) != (len(pskID) == 0) || usesPsk != (len(psk) != 0) {
+		err = ErrPskIsNotValid
+		return
+	}
+
+	suiteID := suite.id()
+	pskIDHash := labeledExtract(suiteID, nil, "psk_id_hash", pskID)
+	infoHash := labeledExtract(suiteID, nil, "info_hash", info)
+	keyScheduleContext := append(append([]byte{byte(mode)}, pskIDHash...), infoHash...)
+
+	secret := labeledExtract(suiteID, sharedSecret, "secret", psk)
+	defer crypto.Zeroize(secret)
+
+	key, err := labeledExpand(suiteID, secret, "key", keyScheduleContext, aeadKeySize)
+	if err != nil {
+		return
+	}
+	defer crypto.Zeroize(key)
+
+	baseNonce, err := labeledExpand(suiteID, secret, "base_nonce", keyScheduleContext, aeadNonceSize)
+	if err != nil {
+		return
+	}
+
+	exporterSecret, err := labeledExpand(suiteID, secret, "exp", keyScheduleContext, kdfHashSize)
+	if err != nil {
+		return
+	}
+
+	var aead cipher.AEAD
+	switch suite.aead {
+	case AEADAes256Gcm:
+		aead, err = crypto.NewAEAD(key)
+	case AEADChaCha20Poly1305:
+		aead, err = chacha20poly1305.New(key)
+	default:
+		err = ErrAEADIsNotValid
+	}
+	if err != nil {
+		return
+	}
+
+	context = &Context{
+		suiteID:        suiteID,
+		aead:           aead,
+		baseNonce:      baseNonce,
+		exporterSecret: exporterSecret,
+	}
+	return
+}
+
+// id returns the suite_id of the suite: "HPKE" || kem_id || kdf_id || aead_id
+func (suite Suite) id() []byte {
+	suiteID := []byte("HPKE")
+	suiteID = binary.BigEndian.AppendUint16(suiteID, kemX25519HkdfSha256)
+	suiteID = binary.BigEndian.AppendUint16(suiteID, kdfHkdfSha256)
+	suiteID = binary.BigEndian.AppendUint16(suiteID, uint16(suite.aead))
+	return suiteID
+}
+
+// Context encrypts (sender) or decrypts (recipient) multiple messages with the keys derived during setup.
+// Messages must be opened in the same order as they were sealed.
+// A Context is not safe for concurrent use.
+type Context struct {
+	suiteID        []byte
+	aead           cipher.AEAD
+	baseNonce      []byte
+	exporterSecret []byte
+	sequence       uint64
+}
+
+// Seal encrypts the next message
+func (context *Context) Seal(plaintext, additionalData []byte) (ciphertext []byte, err error) {
+	nonce, err := context.nextNonce()
+	if err != nil {
+		return
+	}
+
+	ciphertext = context.aead.Seal(nil, nonce, plaintext, additionalData)
+	return
+}
+
+// Open decrypts the next message
+func (context *Context) Open(ciphertext, additionalData []byte) (plaintext []byte, err error) {
+	if context.sequence == math.MaxUint64 {
+		err = ErrMessageLimitReached
+		return
+	}
+
+	plaintext, err = context.aead.Open(nil, context.nonce(), ciphertext, additionalData)
+	if err != nil {
+		err = ErrOpen
+		return
+	}
+
+	// the sequence number is only incremented if the message was successfully decrypted
+	context.sequence += 1
+	return
+}
+
+// Export derives a secret of size bytes from the context and exporterContext
+func (context *Context) Export(exporterContext []byte, size int) (secret []byte, err error) {
+	if size < 0 || size > 255*kdfHashSize {
+		err = ErrExportSizeIsNotValid
+		return
+	}
+
+	return labeledExpand(context.suiteID, context.exporterSecret, "sec", exporterContext, size)
+}
+
+func (context *Context) nextNonce() (nonce []byte, err error) {
+	if context.sequence == math.MaxUint64 {
+		err = ErrMessageLimitReached
+		return
+	}
+
+	nonce = context.nonce()
+	context.sequence += 1
+	return
+}
+
+// nonce returns base_nonce XOR sequence
+func (context *Context) nonce() []byte {
+	nonce := make([]byte, aeadNonceSize)
+	binary.BigEndian.PutUint64(nonce[aeadNonceSize-8:], context.sequence)
+	for i := range nonce {
+		nonce[i] ^= context.baseNonce[i]
+	}
+	return nonce
+}
+
+// kemSuiteID returns the suite_id of the KEM: "KEM" || kem_id
+func kemSuiteID() []byte {
+	return binary.BigEndian.AppendUint16([]byte("KEM"), kemX25519HkdfSha256)
+}
+
+func extractAndExpand(dh, kemContext []byte) (sharedSecret []byte, err error) {
+	suiteID := kemSuiteID()
+	eaePrk := labeledExtract(suiteID, nil, "eae_prk", dh)
+	defer crypto.Zeroize(eaePrk)
+
+	return labeledExpand(suiteID, eaePrk, "shared_secret", kemContext, kemSecretSize)
+}
+
+// labeledExtract returns Extract(salt, "HPKE-v1" || suite_id || label || ikm)
+func labeledExtract(suiteID, salt []byte, label string, ikm []byte) []byte {
+	labeledIkm := append([]byte("HPKE-v1"), suiteID...)
+	labeledIkm = append(labeledIkm, label...)
+	labeledIkm = append(labeledIkm, ikm...)
+
+	return kdf.HkdfSha256Extract(labeledIkm, salt)
+}
+
+// labeledExpand returns Expand(prk, size (2 bytes) || "HPKE-v1" || suite_id || label || info, size)
+func labeledExpand(suiteID, prk []byte, label string, info []byte, size int) ([]byte, error) {
+	if size == 0 {
+		return []byte{}, nil
+	}
+
+	labeledInfo := binary.BigEndian.AppendUint16(nil, uint16(size))
+	labeledInfo = append(labeledInfo, "HPKE-v1"...)
+	labeledInfo = append(labeledInfo, suiteID...)
+	labeledInfo = append(labeledInfo, label...)
+	labeledInfo = append(labeledInfo, info...)
+
+	return kdf.HkdfSha256Expand(prk, labeledInfo, size)
+}
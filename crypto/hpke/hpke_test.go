@@ -0,0 +1,434 @@
+package hpke
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/bloom42/stdx/crypto"
+	"golang.org/x/crypto/sha3"
+)
+
+// testdata/rfc9180.json contains the official RFC 9180 test vectors for the suites supported by this
+// package, where the encryptions and exports are accumulated with SHAKE128 (as done by the Go standard
+// library) instead of being listed one by one.
+type testVector struct {
+	Mode                   Mode   `json:"mode"`
+	KEM                    uint16 `json:"kem_id"`
+	KDF                    uint16 `json:"kdf_id"`
+	AEAD                   AEAD   `json:"aead_id"`
+	Info                   string `json:"info"`
+	IkmE                   string `json:"ikmE"`
+	IkmR                   string `json:"ikmR"`
+	SkRm                   string `json:"skRm"`
+	PkRm                   string `json:"pkRm"`
+	Enc                    string `json:"enc"`
+	EncryptionsAccumulated string `json:"encryptions_accumulated"`
+	ExportsAccumulated     string `json:"exports_accumulated"`
+}
+
+func TestRFCVectors(t *testing.T) {
+	data, err := os.ReadFile("testdata/rfc9180.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var vectors []testVector
+	err = json.Unmarshal(data, &vectors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, vector := range vectors {
+		t.Run(fmt.Sprintf("mode %d aead %04x", vector.Mode, vector.AEAD), func(t *testing.T) {
+			suite, err := NewSuite(vector.AEAD)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			info := mustDecodeHex(t, vector.Info)
+
+			publicKeyR, privateKeyR, err := DeriveKeyPair(mustDecodeHex(t, vector.IkmR))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(privateKeyR, mustDecodeHex(t, vector.SkRm)) {
+				t.Errorf("skRm. expected: %s | got: %x", vector.SkRm, privateKeyR)
+			}
+			if !bytes.Equal(publicKeyR, mustDecodeHex(t, vector.PkRm)) {
+				t.Errorf("pkRm. expected: %s | got: %x", vector.PkRm, publicKeyR)
+			}
+
+			_, privateKeyE, err := DeriveKeyPair(mustDecodeHex(t, vector.IkmE))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			enc, sender, err := suite.setupSender(vector.Mode, publicKeyR, nil, info, nil, nil, privateKeyE)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(enc, mustDecodeHex(t, vector.Enc)) {
+				t.Errorf("enc. expected: %s | got: %x", vector.Enc, enc)
+			}
+
+			recipient, err := suite.SetupBaseRecipient(enc, privateKeyR, info)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			source, sink := sha3.NewShake128(), sha3.NewShake128()
+			for i := 0; i < 1000; i += 1 {
+				additionalData, plaintext := drawRandomInput(t, source), drawRandomInput(t, source)
+				ciphertext, err := sender.Seal(plaintext, additionalData)
+				if err != nil {
+					t.Fatal(err)
+				}
+				sink.Write(ciphertext)
+
+				decrypted, err := recipient.Open(ciphertext, additionalData)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(decrypted, plaintext) {
+					t.Fatal("decrypted message doesn't match plaintext")
+				}
+			}
+			encryptions := make([]byte, 16)
+			sink.Read(encryptions)
+			if !bytes.Equal(encryptions, mustDecodeHex(t, vector.EncryptionsAccumulated)) {
+				t.Errorf("accumulated encryptions. expected: %s | got: %x", vector.EncryptionsAccumulated, encryptions)
+			}
+
+			source, sink = sha3.NewShake128(), sha3.NewShake128()
+			for size := 0; size < 1000; size += 1 {
+				exporterContext := drawRandomInput(t, source)
+				secret, err := sender.Export(exporterContext, size)
+				if err != nil {
+					t.Fatal(err)
+				}
+				sink.Write(secret)
+
+				recipientSecret, err := recipient.Export(exporterContext, size)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(secret, recipientSecret) {
+					t.Fatal("exported secrets don't match")
+				}
+			}
+			exports := make([]byte, 16)
+			sink.Read(exports)
+			if !bytes.Equal(exports, mustDecodeHex(t, vector.ExportsAccumulated)) {
+				t.Errorf("accumulated exports. expected: %s | got: %x", vector.ExportsAccumulated, exports)
+			}
+		})
+	}
+}
+
+// testdata/rfc9180_modes.json contains the official RFC 9180 test vectors of the PSK, Auth and AuthPSK modes
+// for the suites supported by this package (RFC 9180 A.2.2 to A.2.4 for ChaCha20Poly1305, and the same
+// vectors of the RFC's full test vector file for AES-256-GCM), with the encryptions of the sequence numbers
+// listed in the RFC.
+type modeTestVector struct {
+	Mode        Mode   `json:"mode"`
+	KEM         uint16 `json:"kem_id"`
+	KDF         uint16 `json:"kdf_id"`
+	AEAD        AEAD   `json:"aead_id"`
+	Info        string `json:"info"`
+	IkmE        string `json:"ikmE"`
+	IkmR        string `json:"ikmR"`
+	IkmS        string `json:"ikmS"`
+	SkRm        string `json:"skRm"`
+	PkRm        string `json:"pkRm"`
+	SkSm        string `json:"skSm"`
+	PkSm        string `json:"pkSm"`
+	Psk         string `json:"psk"`
+	PskID       string `json:"psk_id"`
+	Enc         string `json:"enc"`
+	Encryptions []struct {
+		SequenceNumber uint64 `json:"sequence_number"`
+		AdditionalData string `json:"aad"`
+		Plaintext      string `json:"pt"`
+		Nonce          string `json:"nonce"`
+		Ciphertext     string `json:"ct"`
+	} `json:"encryptions"`
+	Exports []struct {
+		ExporterContext string `json:"exporter_context"`
+		Size            int    `json:"L"`
+		ExportedValue   string `json:"exported_value"`
+	} `json:"exports"`
+}
+
+func TestRFCModeVectors(t *testing.T) {
+	data, err := os.ReadFile("testdata/rfc9180_modes.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var vectors []modeTestVector
+	err = json.Unmarshal(data, &vectors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, vector := range vectors {
+		t.Run(fmt.Sprintf("mode %d aead %04x", vector.Mode, vector.AEAD), func(t *testing.T) {
+			suite, err := NewSuite(vector.AEAD)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			info := mustDecodeHex(t, vector.Info)
+			psk := mustDecodeHex(t, vector.Psk)
+			pskID := mustDecodeHex(t, vector.PskID)
+
+			publicKeyR, privateKeyR, err := DeriveKeyPair(mustDecodeHex(t, vector.IkmR))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(privateKeyR, mustDecodeHex(t, vector.SkRm)) || !bytes.Equal(publicKeyR, mustDecodeHex(t, vector.PkRm)) {
+				t.Errorf("recipient key pair. expected: %s %s | got: %x %x", vector.SkRm, vector.PkRm, privateKeyR, publicKeyR)
+			}
+
+			var publicKeyS crypto.Curve25519PublicKey
+			var privateKeyS crypto.Curve25519PrivateKey
+			if vector.IkmS != "" {
+				publicKeyS, privateKeyS, err = DeriveKeyPair(mustDecodeHex(t, vector.IkmS))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(privateKeyS, mustDecodeHex(t, vector.SkSm)) || !bytes.Equal(publicKeyS, mustDecodeHex(t, vector.PkSm)) {
+					t.Errorf("sender key pair. expected: %s %s | got: %x %x", vector.SkSm, vector.PkSm, privateKeyS, publicKeyS)
+				}
+			}
+
+			_, privateKeyE, err := DeriveKeyPair(mustDecodeHex(t, vector.IkmE))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			enc, sender, err := suite.setupSender(vector.Mode, publicKeyR, privateKeyS, info, psk, pskID, privateKeyE)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(enc, mustDecodeHex(t, vector.Enc)) {
+				t.Errorf("enc. expected: %s | got: %x", vector.Enc, enc)
+			}
+
+			var recipient *Context
+			switch vector.Mode {
+			case ModePsk:
+				recipient, err = suite.SetupPskRecipient(enc, privateKeyR, info, psk, pskID)
+			case ModeAuth:
+				recipient, err = suite.SetupAuthRecipient(enc, privateKeyR, publicKeyS, info)
+			case ModeAuthPsk:
+				recipient, err = suite.SetupAuthPskRecipient(enc, privateKeyR, publicKeyS, info, psk, pskID)
+			default:
+				t.Fatalf("unexpected mode: %d", vector.Mode)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, encryption := range vector.Encryptions {
+				// the vectors only list some of the sequence numbers
+				sender.sequence = encryption.SequenceNumber
+				recipient.sequence = encryption.SequenceNumber
+
+				if nonce := sender.nonce(); !bytes.Equal(nonce, mustDecodeHex(t, encryption.Nonce)) {
+					t.Errorf("nonce %d. expected: %s | got: %x", encryption.SequenceNumber, encryption.Nonce, nonce)
+				}
+
+				additionalData := mustDecodeHex(t, encryption.AdditionalData)
+				plaintext := mustDecodeHex(t, encryption.Plaintext)
+				ciphertext, err := sender.Seal(plaintext, additionalData)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(ciphertext, mustDecodeHex(t, encryption.Ciphertext)) {
+					t.Errorf("ciphertext %d. expected: %s | got: %x", encryption.SequenceNumber, encryption.Ciphertext, ciphertext)
+				}
+
+				decrypted, err := recipient.Open(ciphertext, additionalData)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(decrypted, plaintext) {
+					t.Errorf("decrypted message %d doesn't match plaintext", encryption.SequenceNumber)
+				}
+			}
+
+			for _, export := range vector.Exports {
+				exporterContext := mustDecodeHex(t, export.ExporterContext)
+				for _, context := range []*Context{sender, recipient} {
+					secret, err := context.Export(exporterContext, export.Size)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(secret, mustDecodeHex(t, export.ExportedValue)) {
+						t.Errorf("export %q. expected: %s | got: %x", export.ExporterContext, export.ExportedValue, secret)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	for _, aead := range []AEAD{AEADAes256Gcm, AEADChaCha20Poly1305} {
+		suite, err := NewSuite(aead)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		publicKey, privateKey, err := crypto.GenerateCurve25519KeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		info := []byte("info")
+		plaintext := []byte("hello world")
+
+		enc, ciphertext, err := suite.Seal(publicKey, info, nil, plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decrypted, err := suite.Open(enc, privateKey, info, nil, ciphertext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Error("decrypted message doesn't match plaintext")
+		}
+
+		_, err = suite.Open(enc, privateKey, []byte("other info"), nil, ciphertext)
+		if err != ErrOpen {
+			t.Errorf("expected: %v | got: %v", ErrOpen, err)
+		}
+	}
+}
+
+func TestModes(t *testing.T) {
+	suite, _ := NewSuite(AEADChaCha20Poly1305)
+	publicKeyR, privateKeyR, _ := crypto.GenerateCurve25519KeyPair()
+	publicKeyS, privateKeyS, _ := crypto.GenerateCurve25519KeyPair()
+	otherPublicKey, _, _ := crypto.GenerateCurve25519KeyPair()
+	info := []byte("info")
+	psk := []byte("0123456789abcdef0123456789abcdef")
+	pskID := []byte("psk id")
+	plaintext := []byte("hello world")
+
+	testMode := func(name string, setupSender func() ([]byte, *Context, error), setupRecipient func(enc []byte) (*Context, error),
+		setupWrongRecipient func(enc []byte) (*Context, error)) {
+		enc, sender, err := setupSender()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		ciphertext, _ := sender.Seal(plaintext, nil)
+
+		recipient, err := setupRecipient(enc)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		decrypted, err := recipient.Open(ciphertext, nil)
+		if err != nil || !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("%s: decrypting: %v", name, err)
+		}
+
+		wrongRecipient, err := setupWrongRecipient(enc)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if _, err = wrongRecipient.Open(ciphertext, nil); err != ErrOpen {
+			t.Errorf("%s: expected: %v | got: %v", name, ErrOpen, err)
+		}
+	}
+
+	testMode("psk",
+		func() ([]byte, *Context, error) { return suite.SetupPskSender(publicKeyR, info, psk, pskID) },
+		func(enc []byte) (*Context, error) { return suite.SetupPskRecipient(enc, privateKeyR, info, psk, pskID) },
+		func(enc []byte) (*Context, error) {
+			return suite.SetupPskRecipient(enc, privateKeyR, info, []byte("other psk"), pskID)
+		},
+	)
+
+	testMode("auth",
+		func() ([]byte, *Context, error) { return suite.SetupAuthSender(publicKeyR, privateKeyS, info) },
+		func(enc []byte) (*Context, error) {
+			return suite.SetupAuthRecipient(enc, privateKeyR, publicKeyS, info)
+		},
+		func(enc []byte) (*Context, error) {
+			return suite.SetupAuthRecipient(enc, privateKeyR, otherPublicKey, info)
+		},
+	)
+
+	testMode("auth psk",
+		func() ([]byte, *Context, error) {
+			return suite.SetupAuthPskSender(publicKeyR, privateKeyS, info, psk, pskID)
+		},
+		func(enc []byte) (*Context, error) {
+			return suite.SetupAuthPskRecipient(enc, privateKeyR, publicKeyS, info, psk, pskID)
+		},
+		func(enc []byte) (*Context, error) {
+			return suite.SetupAuthRecipient(enc, privateKeyR, publicKeyS, info)
+		},
+	)
+
+	_, _, err := suite.SetupPskSender(publicKeyR, info, psk, nil)
+	if err != ErrPskIsNotValid {
+		t.Errorf("expected: %v | got: %v", ErrPskIsNotValid, err)
+	}
+}
+
+func TestContextMultipleMessages(t *testing.T) {
+	suite, _ := NewSuite(AEADAes256Gcm)
+	publicKey, privateKey, _ := crypto.GenerateCurve25519KeyPair()
+
+	enc, sender, err := suite.SetupBaseSender(publicKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient, err := suite.SetupBaseRecipient(enc, privateKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, _ := sender.Seal([]byte("first"), nil)
+	second, _ := sender.Seal([]byte("second"), nil)
+
+	// messages must be opened in order
+	if _, err = recipient.Open(second, nil); err != ErrOpen {
+		t.Errorf("expected: %v | got: %v", ErrOpen, err)
+	}
+	for _, ciphertext := range [][]byte{first, second} {
+		if _, err = recipient.Open(ciphertext, nil); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func drawRandomInput(t *testing.T, reader io.Reader) []byte {
+	size := make([]byte, 1)
+	if _, err := reader.Read(size); err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, int(size[0]))
+	if _, err := reader.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func mustDecodeHex(t *testing.T, input string) []byte {
+	data, err := hex.DecodeString(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -27,3 +27,21 @@ func HkdfSha256(secret, info, salt []byte, size int) (key []byte, err error) {
 	_, err = io.ReadFull(hkdf, key)
 	return
 }
+
+// HkdfSha256Extract performs the HKDF-Extract step and returns a pseudorandom key of 32 bytes.
+// A nil salt is equivalent to a salt of 32 zero bytes.
+func HkdfSha256Extract(secret, salt []byte) (prk []byte) {
+	return hkdf.Extract(sha256.New, secret, salt)
+}
+
+// HkdfSha256Expand performs the HKDF-Expand step on a pseudorandom key returned by HkdfSha256Extract
+func HkdfSha256Expand(prk, info []byte, size int) (key []byte, err error) {
+	if size < 1 || size > 255*sha256.Size {
+		err = errors.New("hkdf: size is not valid")
+		return
+	}
+
+	key = make([]byte, size)
+	_, err = io.ReadFull(hkdf.Expand(sha256.New, prk, info), key)
+	return
+}
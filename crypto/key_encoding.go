@@ -0,0 +1,365 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	gohash "hash"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	pemTypePublicKey           = "PUBLIC KEY"
+	pemTypePrivateKey          = "PRIVATE KEY"
+	pemTypeEncryptedPrivateKey = "ENCRYPTED PRIVATE KEY"
+
+	// pkcs8Pbkdf2Iterations is the number of PBKDF2-HMAC-SHA256 iterations used to encrypt PKCS#8 private keys
+	pkcs8Pbkdf2Iterations = 600_000
+	pkcs8SaltSize         = 16
+	pkcs8MaxIterations    = 10_000_000
+)
+
+var (
+	ErrPEMIsNotValid          = errors.New("crypto: PEM data is not valid")
+	ErrPrivateKeyIsEncrypted  = errors.New("crypto: private key is encrypted but no password was provided")
+	ErrPrivateKeyPassword     = errors.New("crypto: private key can't be decrypted: password is not valid")
+	ErrKeyTypeIsNotValid      = errors.New("crypto: key type is not valid")
+	ErrPasswordIsEmpty        = errors.New("crypto: password is empty")
+	errPkcs8EncryptionUnknown = errors.New("crypto: unsupported PKCS#8 encryption algorithm")
+)
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHmacWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHmacWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAes128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAes256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// PKCS#8 encrypted private keys (RFC 5958 and RFC 8018), as produced by
+// `openssl pkcs8 -topk8 -v2 aes-256-cbc -v2prf hmacWithSHA256`
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkixAlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pkixAlgorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkixAlgorithmIdentifier
+	EncryptionScheme  pkixAlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                     `asn1:"optional"`
+	PRF            pkixAlgorithmIdentifier `asn1:"optional"`
+}
+
+// marshalPublicKeyPEM encodes a public key supported by x509.MarshalPKIXPublicKey in a PEM SPKI block
+func marshalPublicKeyPEM(publicKey any) (pemData []byte, err error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return
+	}
+
+	pemData = pem.EncodeToMemory(&pem.Block{Type: pemTypePublicKey, Bytes: der})
+	return
+}
+
+func parsePublicKeyPEM(pemData []byte) (publicKey any, err error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil || block.Type != pemTypePublicKey {
+		err = ErrPEMIsNotValid
+		return
+	}
+
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// marshalPrivateKeyPEM encodes a private key supported by x509.MarshalPKCS8PrivateKey in a PEM PKCS#8 block.
+// If password is not empty, the key is encrypted with PBES2 (PBKDF2-HMAC-SHA256 and AES-256-CBC).
+func marshalPrivateKeyPEM(privateKey any, password []byte) (pemData []byte, err error) {
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return
+	}
+	defer Zeroize(der)
+
+	if len(password) == 0 {
+		pemData = pem.EncodeToMemory(&pem.Block{Type: pemTypePrivateKey, Bytes: der})
+		return
+	}
+
+	encryptedDer, err := encryptPkcs8(der, password)
+	if err != nil {
+		return
+	}
+
+	pemData = pem.EncodeToMemory(&pem.Block{Type: pemTypeEncryptedPrivateKey, Bytes: encryptedDer})
+	return
+}
+
+func parsePrivateKeyPEM(pemData, password []byte) (privateKey any, err error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		err = ErrPEMIsNotValid
+		return
+	}
+
+	switch block.Type {
+	case pemTypePrivateKey:
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case pemTypeEncryptedPrivateKey:
+		if len(password) == 0 {
+			err = ErrPrivateKeyIsEncrypted
+			return
+		}
+
+		var der []byte
+		der, err = decryptPkcs8(block.Bytes, password)
+		if err != nil {
+			return
+		}
+		defer Zeroize(der)
+
+		privateKey, err = x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			// with CBC, a wrong password may produce a valid padding
+			err = ErrPrivateKeyPassword
+		}
+		return
+	default:
+		err = ErrPEMIsNotValid
+		return
+	}
+}
+
+func encryptPkcs8(der, password []byte) (encryptedDer []byte, err error) {
+	salt, err := RandBytes(pkcs8SaltSize)
+	if err != nil {
+		return
+	}
+
+	iv, err := RandBytes(aes.BlockSize)
+	if err != nil {
+		return
+	}
+
+	key := pbkdf2.Key(password, salt, pkcs8Pbkdf2Iterations, KeySize256, sha256.New)
+	defer Zeroize(key)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return
+	}
+
+	padding := aes.BlockSize - len(der)%aes.BlockSize
+	ciphertext := append(append([]byte(nil), der...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+
+	prfParams, _ := asn1.Marshal(asn1.NullRawValue)
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:           salt,
+		IterationCount: pkcs8Pbkdf2Iterations,
+		PRF: pkixAlgorithmIdentifier{
+			Algorithm:  oidHmacWithSHA256,
+			Parameters: asn1.RawValue{FullBytes: prfParams},
+		},
+	})
+	if err != nil {
+		return
+	}
+
+	ivParams, err := asn1.Marshal(iv)
+	if err != nil {
+		return
+	}
+
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkixAlgorithmIdentifier{
+			Algorithm:  oidPBKDF2,
+			Parameters: asn1.RawValue{FullBytes: kdfParams},
+		},
+		EncryptionScheme: pkixAlgorithmIdentifier{
+			Algorithm:  oidAes256CBC,
+			Parameters: asn1.RawValue{FullBytes: ivParams},
+		},
+	})
+	if err != nil {
+		return
+	}
+
+	encryptedDer, err = asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm: pkixAlgorithmIdentifier{
+			Algorithm:  oidPBES2,
+			Parameters: asn1.RawValue{FullBytes: params},
+		},
+		EncryptedData: ciphertext,
+	})
+	return
+}
+
+// decryptPkcs8 decrypts PKCS#8 private keys encrypted with PBES2, PBKDF2 (HMAC-SHA1 or HMAC-SHA256)
+// and AES-CBC
+func decryptPkcs8(encryptedDer, password []byte) (der []byte, err error) {
+	var info encryptedPrivateKeyInfo
+	_, err = asn1.Unmarshal(encryptedDer, &info)
+	if err != nil {
+		err = fmt.Errorf("crypto: parsing encrypted private key: %w", err)
+		return
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		err = errPkcs8EncryptionUnknown
+		return
+	}
+
+	var params pbes2Params
+	_, err = asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params)
+	if err != nil {
+		err = fmt.Errorf("crypto: parsing PBES2 parameters: %w", err)
+		return
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		err = errPkcs8EncryptionUnknown
+		return
+	}
+
+	var kdfParams pbkdf2Params
+	_, err = asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams)
+	if err != nil {
+		err = fmt.Errorf("crypto: parsing PBKDF2 parameters: %w", err)
+		return
+	}
+	if kdfParams.IterationCount <= 0 || kdfParams.IterationCount > pkcs8MaxIterations {
+		err = errPkcs8EncryptionUnknown
+		return
+	}
+
+	var hashFunc func() gohash.Hash
+	switch {
+	case len(kdfParams.PRF.Algorithm) == 0, kdfParams.PRF.Algorithm.Equal(oidHmacWithSHA1):
+		hashFunc = sha1.New
+	case kdfParams.PRF.Algorithm.Equal(oidHmacWithSHA256):
+		hashFunc = sha256.New
+	default:
+		err = errPkcs8EncryptionUnknown
+		return
+	}
+
+	var keySize int
+	switch {
+	case params.EncryptionScheme.Algorithm.Equal(oidAes128CBC):
+		keySize = 16
+	case params.EncryptionScheme.Algorithm.Equal(oidAes256CBC):
+		keySize = 32
+	default:
+		err = errPkcs8EncryptionUnknown
+		return
+	}
+
+	var iv []byte
+	_, err = asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv)
+	if err != nil || len(iv) != aes.BlockSize {
+		err = errPkcs8EncryptionUnknown
+		return
+	}
+
+	ciphertext := info.EncryptedData
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		err = ErrPEMIsNotValid
+		return
+	}
+
+	key := pbkdf2.Key(password, kdfParams.Salt, kdfParams.IterationCount, keySize, hashFunc)
+	defer Zeroize(key)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return
+	}
+
+	der = make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(der, ciphertext)
+
+	padding := int(der[len(der)-1])
+	if padding == 0 || padding > aes.BlockSize ||
+		!hmac.Equal(der[len(der)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		Zeroize(der)
+		der = nil
+		err = ErrPrivateKeyPassword
+		return
+	}
+
+	der = der[:len(der)-padding]
+	return
+}
+
+// jwk is a JSON Web Key (RFC 7517) of the OKP key type (RFC 8037)
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	D   string `json:"d,omitempty"`
+}
+
+const (
+	jwkKtyOkp     = "OKP"
+	jwkCrvEd25519 = "Ed25519"
+	jwkCrvX25519  = "X25519"
+)
+
+var ErrJWKIsNotValid = errors.New("crypto: JWK is not valid")
+
+type decodedJWK struct {
+	x []byte
+	// d is nil for public keys
+	d []byte
+}
+
+func parseJWK(jwkData []byte, crv string) (key decodedJWK, err error) {
+	var input jwk
+
+	err = json.Unmarshal(jwkData, &input)
+	if err != nil {
+		err = ErrJWKIsNotValid
+		return
+	}
+
+	if input.Kty != jwkKtyOkp || input.Crv != crv {
+		err = ErrKeyTypeIsNotValid
+		return
+	}
+
+	key.x, err = base64.RawURLEncoding.DecodeString(input.X)
+	if err != nil {
+		err = ErrJWKIsNotValid
+		return
+	}
+
+	if input.D != "" {
+		key.d, err = base64.RawURLEncoding.DecodeString(input.D)
+		if err != nil {
+			err = ErrJWKIsNotValid
+			return
+		}
+	}
+
+	return
+}
@@ -0,0 +1,259 @@
+package crypto
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+// KeyID identifies a key in a Keyring
+type KeyID uint32
+
+const (
+	keyringVersion1      byte = 1
+	keyringHeaderSize         = 1 + 4
+	keyringMinCiphertext      = keyringHeaderSize + AEADNonceSize
+)
+
+var (
+	ErrKeyNotFound               = errors.New("crypto: key not found in keyring")
+	ErrKeyringCiphertextNotValid = errors.New("crypto: keyring ciphertext is not valid")
+	ErrPrimaryKeyCantBeRemoved   = errors.New("crypto: the primary key can't be removed from the keyring")
+)
+
+// Keyring holds multiple versions of an AEAD key, to allow keys to be rotated gradually.
+//
+// Data is always encrypted with the primary key, and the ID of the key is embedded in the ciphertext
+// so that data encrypted with older keys can still be decrypted:
+//
+//	version (1 byte) || key_id (4 bytes, big endian) || nonce || ciphertext
+//
+// To rotate a key, add the new key, make it the primary key, and then progressively `Rewrap` the
+// ciphertexts for which `NeedsRotation` returns true. Once all the ciphertexts have been rewrapped,
+// the old key can be removed.
+type Keyring struct {
+	mutex   sync.RWMutex
+	keys    map[KeyID][]byte
+	primary KeyID
+}
+
+// NewKeyring returns a keyring containing the given keys, with primary as primary key
+func NewKeyring(keys map[KeyID][]byte, primary KeyID) (keyring *Keyring, err error) {
+	keyring = &Keyring{
+		keys: make(map[KeyID][]byte, len(keys)),
+	}
+
+	for id, key := range keys {
+		err = keyring.AddKey(id, key)
+		if err != nil {
+			return
+		}
+	}
+
+	err = keyring.SetPrimary(primary)
+	return
+}
+
+// AddKey adds a key to the keyring. It does not change the primary key.
+func (keyring *Keyring) AddKey(id KeyID, key []byte) error {
+	if len(key) != AEADKeySize {
+		return errors.New("crypto: keyring keys must be 32 bytes")
+	}
+
+	keyring.mutex.Lock()
+	defer keyring.mutex.Unlock()
+
+	if _, exists := keyring.keys[id]; exists {
+		return errors.New("crypto: a key with the same ID already exists in the keyring")
+	}
+
+	keyring.keys[id] = append([]byte(nil), key...)
+	return nil
+}
+
+// SetPrimary sets the key used to encrypt data
+func (keyring *Keyring) SetPrimary(id KeyID) error {
+	keyring.mutex.Lock()
+	defer keyring.mutex.Unlock()
+
+	if _, exists := keyring.keys[id]; !exists {
+		return ErrKeyNotFound
+	}
+
+	keyring.primary = id
+	return nil
+}
+
+// RemoveKey removes a key from the keyring. Data encrypted with this key can no longer be decrypted.
+func (keyring *Keyring) RemoveKey(id KeyID) error {
+	keyring.mutex.Lock()
+	defer keyring.mutex.Unlock()
+
+	if id == keyring.primary {
+		return ErrPrimaryKeyCantBeRemoved
+	}
+
+	key, exists := keyring.keys[id]
+	if !exists {
+		return ErrKeyNotFound
+	}
+
+	Zeroize(key)
+	delete(keyring.keys, id)
+	return nil
+}
+
+// Primary returns the ID of the primary key
+func (keyring *Keyring) Primary() KeyID {
+	keyring.mutex.RLock()
+	defer keyring.mutex.RUnlock()
+
+	return keyring.primary
+}
+
+// DeriveKey derives a subkey of size bytes from the key id, with info as context. It allows to use the
+// keys of the keyring for other purposes than encryption, such as MACs, and to rotate them the same way.
+func (keyring *Keyring) DeriveKey(id KeyID, info []byte, size uint8) (key []byte, err error) {
+	parentKey, exists := keyring.copyKey(id)
+	if !exists {
+		err = ErrKeyNotFound
+		return
+	}
+	defer Zeroize(parentKey)
+
+	return DeriveKeyFromKey(parentKey, info, size)
+}
+
+// Encrypt encrypts plaintext with the primary key
+func (keyring *Keyring) Encrypt(plaintext, additionalData []byte) (ciphertext []byte, err error) {
+	keyring.mutex.RLock()
+	id := keyring.primary
+	key := append([]byte(nil), keyring.keys[id]...)
+	keyring.mutex.RUnlock()
+	defer Zeroize(key)
+
+	header := make([]byte, keyringHeaderSize)
+	header[0] = keyringVersion1
+	binary.BigEndian.PutUint32(header[1:], uint32(id))
+
+	// the header is authenticated so the key ID can't be tampered with
+	encrypted, err := Encrypt(key, plaintext, append(header, additionalData...))
+	if err != nil {
+		return
+	}
+
+	ciphertext = append(header, encrypted...)
+	return
+}
+
+// Decrypt decrypts a ciphertext produced by Encrypt, using the key whose ID is embedded in the ciphertext
+func (keyring *Keyring) Decrypt(ciphertext, additionalData []byte) (plaintext []byte, err error) {
+	id, err := keyring.KeyID(ciphertext)
+	if err != nil {
+		return
+	}
+
+	key, exists := keyring.copyKey(id)
+	if !exists {
+		err = ErrKeyNotFound
+		return
+	}
+	defer Zeroize(key)
+
+	header := ciphertext[:keyringHeaderSize]
+	plaintext, err = Decrypt(key, ciphertext[keyringHeaderSize:], append(append([]byte(nil), header...), additionalData...))
+	return
+}
+
+// copyKey returns a copy of the key id, so it can be used after the lock is released without being zeroized
+// by a concurrent RemoveKey. The copy should be zeroized after use.
+func (keyring *Keyring) copyKey(id KeyID) (key []byte, exists bool) {
+	keyring.mutex.RLock()
+	defer keyring.mutex.RUnlock()
+
+	storedKey, exists := keyring.keys[id]
+	if !exists {
+		return
+	}
+
+	key = append([]byte(nil), storedKey...)
+	return
+}
+
+// KeyID returns the ID of the key used to encrypt ciphertext
+func (keyring *Keyring) KeyID(ciphertext []byte) (id KeyID, err error) {
+	if len(ciphertext) < keyringMinCiphertext || ciphertext[0] != keyringVersion1 {
+		err = ErrKeyringCiphertextNotValid
+		return
+	}
+
+	id = KeyID(binary.BigEndian.Uint32(ciphertext[1:keyringHeaderSize]))
+	return
+}
+
+// NeedsRotation returns true if ciphertext was not encrypted with the primary key
+func (keyring *Keyring) NeedsRotation(ciphertext []byte) bool {
+	id, err := keyring.KeyID(ciphertext)
+	if err != nil {
+		return true
+	}
+
+	return id != keyring.Primary()
+}
+
+// Rewrap decrypts ciphertext and encrypts it again with the primary key.
+// ciphertext is returned as is if it's already encrypted with the primary key.
+func (keyring *Keyring) Rewrap(ciphertext, additionalData []byte) (newCiphertext []byte, err error) {
+	if !keyring.NeedsRotation(ciphertext) {
+		newCiphertext = ciphertext
+		return
+	}
+
+	plaintext, err := keyring.Decrypt(ciphertext, additionalData)
+	if err != nil {
+		return
+	}
+	defer Zeroize(plaintext)
+
+	return keyring.Encrypt(plaintext, additionalData)
+}
+
+// GenerateDataKey generates a new random data encryption key (DEK), and returns it along with its
+// wrapped version, encrypted with the primary key of the keyring (used as key encryption key).
+// Only the wrapped key should be stored, next to the data encrypted with the data key.
+// Wrapped keys can be rotated with `Rewrap`.
+func (keyring *Keyring) GenerateDataKey(additionalData []byte) (dataKey, wrappedDataKey []byte, err error) {
+	dataKey, err = NewAEADKey()
+	if err != nil {
+		return
+	}
+
+	wrappedDataKey, err = keyring.Encrypt(dataKey, additionalData)
+	return
+}
+
+// UnwrapDataKey decrypts a data key wrapped by GenerateDataKey
+func (keyring *Keyring) UnwrapDataKey(wrappedDataKey, additionalData []byte) (dataKey []byte, err error) {
+	dataKey, err = keyring.Decrypt(wrappedDataKey, additionalData)
+	if err != nil {
+		return
+	}
+
+	if len(dataKey) != AEADKeySize {
+		Zeroize(dataKey)
+		err = ErrKeyringCiphertextNotValid
+		return
+	}
+
+	return
+}
+
+// WrapKey encrypts key with the key encryption key kek
+func WrapKey(kek, key, additionalData []byte) (wrappedKey []byte, err error) {
+	return Encrypt(kek, key, additionalData)
+}
+
+// UnwrapKey decrypts a key wrapped with WrapKey
+func UnwrapKey(kek, wrappedKey, additionalData []byte) (key []byte, err error) {
+	return Decrypt(kek, wrappedKey, additionalData)
+}
@@ -0,0 +1,215 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func newTestKeyring(t *testing.T, ids ...KeyID) *Keyring {
+	keys := make(map[KeyID][]byte, len(ids))
+	for _, id := range ids {
+		key, err := NewAEADKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[id] = key
+	}
+
+	keyring, err := NewKeyring(keys, ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func TestKeyringEncryptDecrypt(t *testing.T) {
+	keyring := newTestKeyring(t, 1)
+	plaintext := []byte("this is a plaintext message")
+	ad := []byte("additional data")
+
+	ciphertext, err := keyring.Encrypt(plaintext, ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id, _ := keyring.KeyID(ciphertext); id != 1 {
+		t.Errorf("key ID. expected: 1 | got: %d", id)
+	}
+
+	decrypted, err := keyring.Decrypt(ciphertext, ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plaintext, decrypted) {
+		t.Error("decrypted data doesn't match plaintext")
+	}
+
+	if _, err = keyring.Decrypt(ciphertext, []byte("other")); err == nil {
+		t.Error("decrypting with wrong additional data should fail")
+	}
+
+	// tampering with the key ID
+	ciphertext[4] = 2
+	if _, err = keyring.Decrypt(ciphertext, ad); err != ErrKeyNotFound {
+		t.Errorf("expected: %v | got: %v", ErrKeyNotFound, err)
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	keyring := newTestKeyring(t, 1)
+	plaintext := []byte("this is a plaintext message")
+
+	oldCiphertext, err := keyring.Encrypt(plaintext, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newKey, _ := NewAEADKey()
+	if err = keyring.AddKey(2, newKey); err != nil {
+		t.Fatal(err)
+	}
+	if keyring.NeedsRotation(oldCiphertext) {
+		t.Error("ciphertext should not need rotation before the primary key changes")
+	}
+	if err = keyring.SetPrimary(2); err != nil {
+		t.Fatal(err)
+	}
+	if !keyring.NeedsRotation(oldCiphertext) {
+		t.Error("ciphertext should need rotation")
+	}
+
+	// data encrypted with the old key can still be decrypted
+	decrypted, err := keyring.Decrypt(oldCiphertext, nil)
+	if err != nil || !bytes.Equal(plaintext, decrypted) {
+		t.Errorf("decrypting old ciphertext: %v", err)
+	}
+
+	newCiphertext, err := keyring.Rewrap(oldCiphertext, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyring.NeedsRotation(newCiphertext) {
+		t.Error("rewrapped ciphertext should not need rotation")
+	}
+
+	if err = keyring.RemoveKey(2); err != ErrPrimaryKeyCantBeRemoved {
+		t.Errorf("expected: %v | got: %v", ErrPrimaryKeyCantBeRemoved, err)
+	}
+	if err = keyring.RemoveKey(1); err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err = keyring.Decrypt(newCiphertext, nil)
+	if err != nil || !bytes.Equal(plaintext, decrypted) {
+		t.Errorf("decrypting rewrapped ciphertext: %v", err)
+	}
+	if _, err = keyring.Decrypt(oldCiphertext, nil); err != ErrKeyNotFound {
+		t.Errorf("expected: %v | got: %v", ErrKeyNotFound, err)
+	}
+}
+
+func TestKeyringDataKey(t *testing.T) {
+	keyring := newTestKeyring(t, 1, 2)
+	ad := []byte("users.id=42")
+
+	dataKey, wrappedDataKey, err := keyring.GenerateDataKey(ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unwrapped, err := keyring.UnwrapDataKey(wrappedDataKey, ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dataKey, unwrapped) {
+		t.Error("unwrapped data key doesn't match data key")
+	}
+
+	kek, _ := NewAEADKey()
+	wrappedKey, err := WrapKey(kek, dataKey, ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unwrapped, err = UnwrapKey(kek, wrappedKey, ad)
+	if err != nil || !bytes.Equal(dataKey, unwrapped) {
+		t.Errorf("unwrapping key: %v", err)
+	}
+}
+
+func TestKeyringDeriveKey(t *testing.T) {
+	keyring := newTestKeyring(t, 1, 2)
+	info := []byte("test")
+
+	key1, err := keyring.DeriveKey(1, info, KeySize256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key1Again, err := keyring.DeriveKey(1, info, KeySize256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key1, key1Again) || len(key1) != KeySize256 {
+		t.Error("derived keys are not deterministic")
+	}
+
+	key2, err := keyring.DeriveKey(2, info, KeySize256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherInfoKey, err := keyring.DeriveKey(1, []byte("other"), KeySize256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(key1, key2) || bytes.Equal(key1, otherInfoKey) {
+		t.Error("derived keys should be different")
+	}
+
+	if _, err = keyring.DeriveKey(3, info, KeySize256); err != ErrKeyNotFound {
+		t.Errorf("expected: %v | got: %v", ErrKeyNotFound, err)
+	}
+}
+
+func TestKeyringConcurrentRotation(t *testing.T) {
+	keyring := newTestKeyring(t, 1)
+	for id := KeyID(2); id <= 50; id += 1 {
+		key, err := NewAEADKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = keyring.AddKey(id, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// keys are removed right after they stop being the primary key, while data is being encrypted
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		previous := KeyID(1)
+		for id := KeyID(2); id <= 50; id += 1 {
+			keyring.SetPrimary(id)
+			keyring.RemoveKey(previous)
+			previous = id
+		}
+	}()
+
+	zeroKey := make([]byte, AEADKeySize)
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		ciphertext, err := keyring.Encrypt([]byte("plaintext"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the data must never be encrypted with a zeroized key
+		_, err = Decrypt(zeroKey, ciphertext[keyringHeaderSize:], ciphertext[:keyringHeaderSize])
+		if err == nil {
+			t.Fatal("data has been encrypted with a zeroized key")
+		}
+	}
+}
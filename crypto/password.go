@@ -147,3 +147,44 @@ func decodePasswordHash(hash string) (params *HashPasswordParams, salt, key []by
 
 	return params, salt, key, nil
 }
+
+// PasswordHashNeedsRehash returns true if hash is not an Argon2id hash created with params, and thus
+// should be upgraded.
+func PasswordHashNeedsRehash(hash string, params *HashPasswordParams) bool {
+	hashParams, _, _, err := decodePasswordHash(hash)
+	if err != nil {
+		return true
+	}
+
+	return hashParams.Memory != params.Memory ||
+		hashParams.Iterations != params.Iterations ||
+		hashParams.Parallelism != params.Parallelism ||
+		hashParams.SaltLength != params.SaltLength ||
+		hashParams.KeyLength != params.KeyLength
+}
+
+// VerifyPasswordHashAndRehash verifies password against hash, which is either an Argon2id hash or a hash
+// supported by one of legacyVerifiers (e.g. BcryptVerifier for users migrated from another system).
+//
+// If the password is valid and hash needs to be upgraded (it's a legacy hash, or an Argon2id hash with
+// parameters different than DefaultHashPasswordParams), newHash is a new Argon2id hash of password created
+// with DefaultHashPasswordParams, which should replace hash in the database. Otherwise newHash is empty.
+func VerifyPasswordHashAndRehash(password []byte, hash string, legacyVerifiers ...PasswordHashVerifier) (valid bool, newHash string, err error) {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		valid = VerifyPasswordHash(password, hash)
+	} else {
+		for _, verifier := range legacyVerifiers {
+			if verifier.Supports(hash) {
+				valid = verifier.Verify(password, hash)
+				break
+			}
+		}
+	}
+
+	if !valid || !PasswordHashNeedsRehash(hash, DefaultHashPasswordParams) {
+		return
+	}
+
+	newHash, err = HashPassword(password, DefaultHashPasswordParams)
+	return
+}
@@ -0,0 +1,140 @@
+package crypto
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	gohash "hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// PasswordHashVerifier verifies password hashes produced by other algorithms than Argon2id, in order to
+// migrate users from other systems. See VerifyPasswordHashAndRehash.
+type PasswordHashVerifier interface {
+	// Supports returns true if the verifier can verify hash
+	Supports(hash string) bool
+	// Verify returns true if password matches hash
+	Verify(password []byte, hash string) bool
+}
+
+var (
+	// BcryptVerifier verifies bcrypt hashes ($2a$, $2b$ and $2y$)
+	BcryptVerifier PasswordHashVerifier = bcryptVerifier{}
+
+	// ScryptVerifier verifies scrypt hashes in the PHC string format:
+	//
+	//	$scrypt$ln=<log2(N)>,r=<r>,p=<p>$<base64 salt>$<base64 key>
+	ScryptVerifier PasswordHashVerifier = scryptVerifier{}
+
+	// Pbkdf2Verifier verifies PBKDF2 hashes in the Django format:
+	//
+	//	pbkdf2_<sha1|sha256|sha512>$<iterations>$<salt>$<base64 key>
+	Pbkdf2Verifier PasswordHashVerifier = pbkdf2Verifier{}
+)
+
+const (
+	// limits to avoid denial of service with hashes with huge parameters
+	scryptMaxLogN = 22
+	scryptMaxR    = 32
+	scryptMaxP    = 16
+	// scryptMaxMemory limits the memory used by scrypt, which is 128 * r * N bytes
+	scryptMaxMemory     = 1 << 30
+	legacyMaxKeyLength  = 1024
+	pbkdf2MaxIterations = 10_000_000
+)
+
+type bcryptVerifier struct{}
+
+func (bcryptVerifier) Supports(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (bcryptVerifier) Verify(password []byte, hash string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), password) == nil
+}
+
+type scryptVerifier struct{}
+
+func (scryptVerifier) Supports(hash string) bool {
+	return strings.HasPrefix(hash, "$scrypt$")
+}
+
+func (scryptVerifier) Verify(password []byte, hash string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 {
+		return false
+	}
+
+	var logN, r, p int
+	_, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &logN, &r, &p)
+	if err != nil || logN <= 0 || logN > scryptMaxLogN || r <= 0 || r > scryptMaxR || p <= 0 || p > scryptMaxP {
+		return false
+	}
+	if 128*int64(r)<<logN > scryptMaxMemory {
+		return false
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(parts[3], "="))
+	if err != nil {
+		return false
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(parts[4], "="))
+	if err != nil || len(key) == 0 || len(key) > legacyMaxKeyLength {
+		return false
+	}
+
+	otherKey, err := scrypt.Key(password, salt, 1<<logN, r, p, len(key))
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(key, otherKey) == 1
+}
+
+type pbkdf2Verifier struct{}
+
+func (pbkdf2Verifier) Supports(hash string) bool {
+	return strings.HasPrefix(hash, "pbkdf2_")
+}
+
+func (pbkdf2Verifier) Verify(password []byte, hash string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 {
+		return false
+	}
+
+	var hashFunc func() gohash.Hash
+	switch parts[0] {
+	case "pbkdf2_sha1":
+		hashFunc = sha1.New
+	case "pbkdf2_sha256":
+		hashFunc = sha256.New
+	case "pbkdf2_sha512":
+		hashFunc = sha512.New
+	default:
+		return false
+	}
+
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 || iterations > pbkdf2MaxIterations {
+		return false
+	}
+
+	key, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil || len(key) == 0 || len(key) > legacyMaxKeyLength {
+		return false
+	}
+
+	otherKey := pbkdf2.Key(password, []byte(parts[2]), iterations, len(key), hashFunc)
+
+	return subtle.ConstantTimeCompare(key, otherKey) == 1
+}
@@ -0,0 +1,134 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+func TestLegacyPasswordHashVerifiers(t *testing.T) {
+	password := []byte("pa$$word")
+
+	bcryptHash, err := bcrypt.GenerateFromPassword(password, bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scryptSalt := []byte("0123456789abcdef")
+	scryptKey, err := scrypt.Key(password, scryptSalt, 1<<10, 8, 1, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scryptHash := fmt.Sprintf("$scrypt$ln=10,r=8,p=1$%s$%s", base64.RawStdEncoding.EncodeToString(scryptSalt),
+		base64.RawStdEncoding.EncodeToString(scryptKey))
+
+	pbkdf2Key := pbkdf2.Key(password, []byte("somesalt"), 1000, 32, sha256.New)
+	pbkdf2Hash := "pbkdf2_sha256$1000$somesalt$" + base64.StdEncoding.EncodeToString(pbkdf2Key)
+
+	tests := []struct {
+		verifier PasswordHashVerifier
+		hash     string
+	}{
+		{BcryptVerifier, string(bcryptHash)},
+		{ScryptVerifier, scryptHash},
+		{Pbkdf2Verifier, pbkdf2Hash},
+	}
+
+	for _, test := range tests {
+		if !test.verifier.Supports(test.hash) {
+			t.Errorf("%T should support hash %s", test.verifier, test.hash)
+		}
+		if !test.verifier.Verify(password, test.hash) {
+			t.Errorf("%T: expected password and hash to match", test.verifier)
+		}
+		if test.verifier.Verify([]byte("otherPa$$word"), test.hash) {
+			t.Errorf("%T: expected password and hash to not match", test.verifier)
+		}
+
+		valid, newHash, err := VerifyPasswordHashAndRehash(password, test.hash, BcryptVerifier, ScryptVerifier, Pbkdf2Verifier)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !valid {
+			t.Errorf("%T: expected password to be valid", test.verifier)
+		}
+		if newHash == "" || !VerifyPasswordHash(password, newHash) {
+			t.Errorf("%T: legacy hash should be upgraded to argon2id", test.verifier)
+		}
+
+		valid, _, _ = VerifyPasswordHashAndRehash(password, test.hash)
+		if valid {
+			t.Errorf("%T: legacy hash should not be verified without verifier", test.verifier)
+		}
+	}
+}
+
+func TestLegacyPasswordHashVerifiersLimits(t *testing.T) {
+	password := []byte("pa$$word")
+	salt := base64.RawStdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	key := base64.RawStdEncoding.EncodeToString(make([]byte, 32))
+
+	// these hashes would require gigabytes to petabytes of memory, or a lot of CPU time, to be verified
+	tests := []struct {
+		verifier PasswordHashVerifier
+		hash     string
+	}{
+		{ScryptVerifier, fmt.Sprintf("$scrypt$ln=22,r=1048576,p=1$%s$%s", salt, key)},
+		{ScryptVerifier, fmt.Sprintf("$scrypt$ln=22,r=32,p=1$%s$%s", salt, key)},
+		{ScryptVerifier, fmt.Sprintf("$scrypt$ln=10,r=8,p=1000000$%s$%s", salt, key)},
+		{ScryptVerifier, fmt.Sprintf("$scrypt$ln=40,r=1,p=1$%s$%s", salt, key)},
+		{ScryptVerifier, fmt.Sprintf("$scrypt$ln=10,r=8,p=1$%s$%s", salt,
+			base64.RawStdEncoding.EncodeToString(make([]byte, 1<<20)))},
+		{Pbkdf2Verifier, "pbkdf2_sha256$1000000000$somesalt$" + base64.StdEncoding.EncodeToString(make([]byte, 32))},
+		{Pbkdf2Verifier, "pbkdf2_sha256$1000$somesalt$" + base64.StdEncoding.EncodeToString(make([]byte, 1<<20))},
+	}
+
+	for _, test := range tests {
+		if test.verifier.Verify(password, test.hash) {
+			t.Errorf("%T: hash with oversized parameters should be rejected: %.60s", test.verifier, test.hash)
+		}
+	}
+}
+
+func TestVerifyPasswordHashAndRehash(t *testing.T) {
+	password := []byte("pa$$word")
+
+	hash, err := HashPassword(password, DefaultHashPasswordParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid, newHash, err := VerifyPasswordHashAndRehash(password, hash)
+	if err != nil || !valid || newHash != "" {
+		t.Errorf("expected valid hash without rehash. valid: %v | newHash: %s | err: %v", valid, newHash, err)
+	}
+
+	weakParams := *DefaultHashPasswordParams
+	weakParams.Memory = 1024
+	weakParams.Iterations = 1
+	weakHash, err := HashPassword(password, &weakParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !PasswordHashNeedsRehash(weakHash, DefaultHashPasswordParams) {
+		t.Error("hash with weak params should need rehash")
+	}
+
+	valid, newHash, err = VerifyPasswordHashAndRehash(password, weakHash)
+	if err != nil || !valid || newHash == "" {
+		t.Errorf("expected valid hash with rehash. valid: %v | newHash: %s | err: %v", valid, newHash, err)
+	}
+	if PasswordHashNeedsRehash(newHash, DefaultHashPasswordParams) {
+		t.Error("new hash should not need rehash")
+	}
+
+	valid, newHash, _ = VerifyPasswordHashAndRehash([]byte("otherPa$$word"), weakHash)
+	if valid || newHash != "" {
+		t.Error("invalid password should not be rehashed")
+	}
+}
@@ -0,0 +1,241 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"errors"
+	"strings"
+
+	"github.com/bloom42/stdx/base32"
+)
+
+// Shamir's secret sharing over GF(256): each byte of the secret is the constant term of a random polynomial
+// of degree threshold - 1, and each share contains the evaluation of these polynomials at the index of
+// the share. Any threshold shares allow to recover the secret, while fewer shares reveal nothing about it.
+//
+// Shares are encoded as:
+//
+//	version (1 byte) || split_id (4 bytes) || threshold (1 byte) || index (1 byte) || value || checksum (4 bytes)
+//
+// where split_id is random and identifies the shares produced by the same call to SplitSecret, and
+// checksum is the truncated SHA-256 hash of the preceding bytes, to detect mistyped shares.
+
+const (
+	// SecretSharesMax is the maximum number of shares of a secret
+	SecretSharesMax = 255
+
+	secretShareVersion1     byte = 1
+	secretShareSplitIDSize       = 4
+	secretShareChecksumSize      = 4
+	secretShareHeaderSize        = 1 + secretShareSplitIDSize + 1 + 1
+	secretShareOverhead          = secretShareHeaderSize + secretShareChecksumSize
+)
+
+var (
+	ErrSecretShareIsNotValid  = errors.New("crypto: secret share is not valid")
+	ErrSecretSharesMismatch   = errors.New("crypto: secret shares don't belong to the same secret")
+	ErrNotEnoughSecretShares  = errors.New("crypto: not enough secret shares to recover the secret")
+	ErrDuplicateSecretShare   = errors.New("crypto: duplicate secret share")
+	errSecretSharesParameters = errors.New("crypto: threshold must be between 2 and the number of shares, which must be at most 255")
+)
+
+// SecretShare is a share of a secret produced by SplitSecret
+type SecretShare []byte
+
+// Index returns the index of the share, between 1 and 255
+func (share SecretShare) Index() byte {
+	return share[secretShareHeaderSize-1]
+}
+
+// Threshold returns the number of shares needed to recover the secret
+func (share SecretShare) Threshold() byte {
+	return share[secretShareHeaderSize-2]
+}
+
+// String returns the share encoded in base32, for printing
+func (share SecretShare) String() string {
+	return base32.EncodeToString(share)
+}
+
+// ParseSecretShare decodes a share encoded by SecretShare.String and verifies its checksum.
+// Whitespaces and dashes are ignored.
+func ParseSecretShare(input string) (share SecretShare, err error) {
+	input = strings.Map(func(char rune) rune {
+		if char == '-' || char == ' ' || char == '\t' || char == '\n' || char == '\r' {
+			return -1
+		}
+		return char
+	}, strings.ToLower(input))
+
+	data, err := base32.DecodeString(input)
+	if err != nil {
+		err = ErrSecretShareIsNotValid
+		return
+	}
+
+	share = SecretShare(data)
+	err = share.verify()
+	if err != nil {
+		share = nil
+	}
+	return
+}
+
+func (share SecretShare) verify() error {
+	if len(share) <= secretShareOverhead || share[0] != secretShareVersion1 {
+		return ErrSecretShareIsNotValid
+	}
+
+	checksum := secretShareChecksum(share[:len(share)-secretShareChecksumSize])
+	if !ConstantTimeCompare(checksum, share[len(share)-secretShareChecksumSize:]) {
+		return ErrSecretShareIsNotValid
+	}
+
+	if share.Index() == 0 || share.Threshold() < 2 {
+		return ErrSecretShareIsNotValid
+	}
+
+	return nil
+}
+
+func (share SecretShare) splitID() []byte {
+	return share[1 : 1+secretShareSplitIDSize]
+}
+
+func (share SecretShare) value() []byte {
+	return share[secretShareHeaderSize : len(share)-secretShareChecksumSize]
+}
+
+// SplitSecret splits secret into n shares, any threshold of which are needed to recover the secret
+// with CombineShares.
+func SplitSecret(secret []byte, n, threshold int) (shares []SecretShare, err error) {
+	if threshold < 2 || threshold > n || n > SecretSharesMax {
+		err = errSecretSharesParameters
+		return
+	}
+	if len(secret) == 0 {
+		err = errors.New("crypto: secret is empty")
+		return
+	}
+
+	splitID, err := RandBytes(secretShareSplitIDSize)
+	if err != nil {
+		return
+	}
+
+	// the coefficients of the polynomials, except the constant terms which are the bytes of the secret
+	coefficients, err := RandBytes(uint64(len(secret) * (threshold - 1)))
+	if err != nil {
+		return
+	}
+	defer Zeroize(coefficients)
+
+	shares = make([]SecretShare, n)
+	for i := range shares {
+		index := byte(i + 1)
+
+		share := make(SecretShare, 0, len(secret)+secretShareOverhead)
+		share = append(share, secretShareVersion1)
+		share = append(share, splitID...)
+		share = append(share, byte(threshold), index)
+
+		for byteIndex, secretByte := range secret {
+			// Horner's method
+			polynomial := coefficients[byteIndex*(threshold-1) : (byteIndex+1)*(threshold-1)]
+			value := byte(0)
+			for degree := len(polynomial) - 1; degree >= 0; degree -= 1 {
+				value = gf256Mul(value, index) ^ polynomial[degree]
+			}
+			value = gf256Mul(value, index) ^ secretByte
+			share = append(share, value)
+		}
+
+		share = append(share, secretShareChecksum(share)...)
+		shares[i] = share
+	}
+
+	return
+}
+
+// CombineShares recovers a secret from shares produced by SplitSecret.
+// At least Threshold() shares from the same call to SplitSecret are needed.
+func CombineShares(shares []SecretShare) (secret []byte, err error) {
+	if len(shares) == 0 {
+		err = ErrNotEnoughSecretShares
+		return
+	}
+
+	first := shares[0]
+	indexes := make(map[byte]bool, len(shares))
+	for _, share := range shares {
+		err = share.verify()
+		if err != nil {
+			return
+		}
+
+		if !ConstantTimeCompare(share.splitID(), first.splitID()) || share.Threshold() != first.Threshold() ||
+			len(share) != len(first) {
+			err = ErrSecretSharesMismatch
+			return
+		}
+
+		if indexes[share.Index()] {
+			err = ErrDuplicateSecretShare
+			return
+		}
+		indexes[share.Index()] = true
+	}
+
+	if len(shares) < int(first.Threshold()) {
+		err = ErrNotEnoughSecretShares
+		return
+	}
+	shares = shares[:first.Threshold()]
+
+	// Lagrange interpolation at x = 0:
+	// secret = sum(y_j * prod(x_m / (x_m - x_j))) for m != j
+	secret = make([]byte, len(first.value()))
+	for j, share := range shares {
+		basis := byte(1)
+		for m, otherShare := range shares {
+			if m == j {
+				continue
+			}
+			// subtraction is xor in GF(256)
+			basis = gf256Mul(basis, gf256Div(otherShare.Index(), otherShare.Index()^share.Index()))
+		}
+
+		for i, value := range share.value() {
+			secret[i] ^= gf256Mul(value, basis)
+		}
+	}
+
+	return
+}
+
+func secretShareChecksum(data []byte) []byte {
+	hash := sha256.Sum256(data)
+	return hash[:secretShareChecksumSize]
+}
+
+// gf256Mul multiplies a and b in GF(2^8) with the AES polynomial (x^8 + x^4 + x^3 + x + 1), in constant time
+func gf256Mul(a, b byte) (product byte) {
+	for i := 0; i < 8; i += 1 {
+		product ^= a & -(b & 1)
+		a = a<<1 ^ (0x1b & -(a >> 7))
+		b >>= 1
+	}
+	return
+}
+
+// gf256Div divides a by b (b != 0) in GF(2^8): a * b^254
+func gf256Div(a, b byte) byte {
+	inverse := byte(1)
+	square := b
+	for exponent := 254; exponent > 0; exponent >>= 1 {
+		if exponent&1 == 1 {
+			inverse = gf256Mul(inverse, square)
+		}
+		square = gf256Mul(square, square)
+	}
+	return gf256Mul(a, inverse)
+}
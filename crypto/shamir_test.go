@@ -0,0 +1,96 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGF256(t *testing.T) {
+	// FIPS 197 section 4.2
+	if product := gf256Mul(0x57, 0x83); product != 0xc1 {
+		t.Errorf("0x57 * 0x83. expected: 0xc1 | got: %#x", product)
+	}
+
+	for a := 1; a < 256; a += 1 {
+		if quotient := gf256Div(byte(a), byte(a)); quotient != 1 {
+			t.Fatalf("%#x / %#x. expected: 1 | got: %#x", a, a, quotient)
+		}
+	}
+}
+
+func TestSplitAndCombineSecret(t *testing.T) {
+	secret, _ := NewAEADKey()
+
+	shares, err := SplitSecret(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shares) != 5 {
+		t.Fatalf("expected 5 shares, got %d", len(shares))
+	}
+
+	// any 3 shares recover the secret
+	for i := 0; i < 5; i += 1 {
+		for j := i + 1; j < 5; j += 1 {
+			for k := j + 1; k < 5; k += 1 {
+				combined, err := CombineShares([]SecretShare{shares[k], shares[i], shares[j]})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(combined, secret) {
+					t.Errorf("shares %d, %d, %d: combined secret doesn't match secret", i, j, k)
+				}
+			}
+		}
+	}
+
+	if _, err = CombineShares(shares[:2]); err != ErrNotEnoughSecretShares {
+		t.Errorf("expected: %v | got: %v", ErrNotEnoughSecretShares, err)
+	}
+	if _, err = CombineShares([]SecretShare{shares[0], shares[0], shares[1]}); err != ErrDuplicateSecretShare {
+		t.Errorf("expected: %v | got: %v", ErrDuplicateSecretShare, err)
+	}
+
+	otherShares, _ := SplitSecret(secret, 5, 3)
+	if _, err = CombineShares([]SecretShare{shares[0], shares[1], otherShares[2]}); err != ErrSecretSharesMismatch {
+		t.Errorf("expected: %v | got: %v", ErrSecretSharesMismatch, err)
+	}
+
+	for _, params := range [][2]int{{1, 1}, {3, 4}, {256, 2}} {
+		if _, err = SplitSecret(secret, params[0], params[1]); err == nil {
+			t.Errorf("n = %d, threshold = %d should be rejected", params[0], params[1])
+		}
+	}
+}
+
+func TestSecretShareEncoding(t *testing.T) {
+	shares, err := SplitSecret([]byte("this is a secret"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded := shares[1].String()
+	decoded, err := ParseSecretShare(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, shares[1]) || decoded.Index() != 2 || decoded.Threshold() != 2 {
+		t.Errorf("decoded share doesn't match share")
+	}
+
+	// a typo is detected by the checksum
+	typo := []byte(encoded)
+	if typo[10] == 'a' {
+		typo[10] = 'b'
+	} else {
+		typo[10] = 'a'
+	}
+	if _, err = ParseSecretShare(string(typo)); err != ErrSecretShareIsNotValid {
+		t.Errorf("expected: %v | got: %v", ErrSecretShareIsNotValid, err)
+	}
+
+	combined, err := CombineShares([]SecretShare{decoded, shares[2]})
+	if err != nil || string(combined) != "this is a secret" {
+		t.Errorf("combining decoded share: %v", err)
+	}
+}
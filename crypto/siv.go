@@ -0,0 +1,185 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+)
+
+// AES-SIV (RFC 5297) is a deterministic AEAD: the same plaintext encrypted with the same key and additional
+// data always produces the same ciphertext. It allows to run equality queries on encrypted columns, at the
+// cost of leaking which rows have the same plaintext. Use Encrypt when this is not needed.
+//
+// The synthetic IV is prepended to the ciphertext:
+//
+//	siv (16 bytes) || ciphertext
+
+const (
+	// DeterministicKeySize is the size, in bytes, of the keys used by EncryptDeterministic (AES-256-SIV:
+	// 32 bytes for S2V and 32 bytes for AES-256-CTR)
+	DeterministicKeySize = KeySize512
+
+	// DeterministicOverhead is the size difference, in bytes, between a ciphertext and its plaintext
+	DeterministicOverhead = aes.BlockSize
+)
+
+var (
+	ErrDeterministicKeySize       = errors.New("crypto: deterministic encryption key must be 64 bytes")
+	ErrDeterministicDecryptFailed = errors.New("crypto: message authentication failed")
+)
+
+// NewDeterministicKey generates a new random key for EncryptDeterministic
+func NewDeterministicKey() ([]byte, error) {
+	return RandBytes(DeterministicKeySize)
+}
+
+// EncryptDeterministic encrypts plaintext with AES-256-SIV. Empty additionalData is equivalent to no
+// additional data.
+func EncryptDeterministic(key, plaintext, additionalData []byte) (ciphertext []byte, err error) {
+	if len(key) != DeterministicKeySize {
+		err = ErrDeterministicKeySize
+		return
+	}
+
+	return sivEncrypt(key, plaintext, additionalData)
+}
+
+// DecryptDeterministic decrypts a ciphertext produced by EncryptDeterministic
+func DecryptDeterministic(key, ciphertext, additionalData []byte) (plaintext []byte, err error) {
+	if len(key) != DeterministicKeySize {
+		err = ErrDeterministicKeySize
+		return
+	}
+
+	return sivDecrypt(key, ciphertext, additionalData)
+}
+
+// sivEncrypt implements AES-SIV for any key size supported by AES (32, 48 or 64 bytes)
+func sivEncrypt(key, plaintext, additionalData []byte) (ciphertext []byte, err error) {
+	macBlock, ctrBlock, err := newSivCiphers(key)
+	if err != nil {
+		return
+	}
+
+	siv := s2v(macBlock, additionalData, plaintext)
+
+	ciphertext = make([]byte, aes.BlockSize+len(plaintext))
+	copy(ciphertext, siv)
+	sivCtr(ctrBlock, siv, ciphertext[aes.BlockSize:], plaintext)
+	return
+}
+
+func sivDecrypt(key, ciphertext, additionalData []byte) (plaintext []byte, err error) {
+	if len(ciphertext) < aes.BlockSize {
+		err = ErrDeterministicDecryptFailed
+		return
+	}
+
+	macBlock, ctrBlock, err := newSivCiphers(key)
+	if err != nil {
+		return
+	}
+
+	siv := ciphertext[:aes.BlockSize]
+	plaintext = make([]byte, len(ciphertext)-aes.BlockSize)
+	sivCtr(ctrBlock, siv, plaintext, ciphertext[aes.BlockSize:])
+
+	expectedSiv := s2v(macBlock, additionalData, plaintext)
+	if subtle.ConstantTimeCompare(siv, expectedSiv) != 1 {
+		Zeroize(plaintext)
+		plaintext = nil
+		err = ErrDeterministicDecryptFailed
+		return
+	}
+
+	return
+}
+
+func newSivCiphers(key []byte) (macBlock, ctrBlock cipher.Block, err error) {
+	macBlock, err = aes.NewCipher(key[:len(key)/2])
+	if err != nil {
+		return
+	}
+
+	ctrBlock, err = aes.NewCipher(key[len(key)/2:])
+	return
+}
+
+// sivCtr encrypts src with AES-CTR, using siv with the 31st and 63rd bits cleared as initial counter
+func sivCtr(block cipher.Block, siv, dst, src []byte) {
+	counter := make([]byte, aes.BlockSize)
+	copy(counter, siv)
+	counter[8] &= 0x7f
+	counter[12] &= 0x7f
+
+	cipher.NewCTR(block, counter).XORKeyStream(dst, src)
+}
+
+// s2v computes the synthetic IV of plaintext. additionalData is ignored if empty.
+func s2v(block cipher.Block, additionalData, plaintext []byte) []byte {
+	d := cmac(block, make([]byte, aes.BlockSize))
+
+	if len(additionalData) != 0 {
+		sivDouble(d)
+		subtle.XORBytes(d, d, cmac(block, additionalData))
+	}
+
+	var t []byte
+	if len(plaintext) >= aes.BlockSize {
+		// t = plaintext xorend d
+		t = append([]byte(nil), plaintext...)
+		end := t[len(t)-aes.BlockSize:]
+		subtle.XORBytes(end, end, d)
+	} else {
+		// t = dbl(d) xor pad(plaintext)
+		sivDouble(d)
+		t = make([]byte, aes.BlockSize)
+		copy(t, plaintext)
+		t[len(plaintext)] = 0x80
+		subtle.XORBytes(t, t, d)
+	}
+
+	return cmac(block, t)
+}
+
+// cmac computes the AES-CMAC (RFC 4493) of message
+func cmac(block cipher.Block, message []byte) []byte {
+	subkey := make([]byte, aes.BlockSize)
+	block.Encrypt(subkey, subkey)
+	sivDouble(subkey)
+
+	blocks := (len(message) + aes.BlockSize - 1) / aes.BlockSize
+	lastBlock := make([]byte, aes.BlockSize)
+	if blocks > 0 && len(message)%aes.BlockSize == 0 {
+		copy(lastBlock, message[(blocks-1)*aes.BlockSize:])
+	} else {
+		// the last block is incomplete: it's padded and xored with the second subkey
+		if blocks == 0 {
+			blocks = 1
+		}
+		copy(lastBlock, message[(blocks-1)*aes.BlockSize:])
+		lastBlock[len(message)-(blocks-1)*aes.BlockSize] = 0x80
+		sivDouble(subkey)
+	}
+	subtle.XORBytes(lastBlock, lastBlock, subkey)
+
+	mac := make([]byte, aes.BlockSize)
+	for i := 0; i < blocks-1; i += 1 {
+		subtle.XORBytes(mac, mac, message[i*aes.BlockSize:(i+1)*aes.BlockSize])
+		block.Encrypt(mac, mac)
+	}
+	subtle.XORBytes(mac, mac, lastBlock)
+	block.Encrypt(mac, mac)
+
+	return mac
+}
+
+// sivDouble multiplies block by x in GF(2^128), in place
+func sivDouble(block []byte) {
+	carry := block[0] >> 7
+	for i := 0; i < len(block)-1; i += 1 {
+		block[i] = block[i]<<1 | block[i+1]>>7
+	}
+	block[len(block)-1] = block[len(block)-1]<<1 ^ (0x87 * carry)
+}
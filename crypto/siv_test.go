@@ -0,0 +1,71 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// RFC 5297 Appendix A.1 (AES-SIV with 2 AES-128 keys)
+func TestSivRFC5297(t *testing.T) {
+	key, _ := hex.DecodeString("fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff")
+	additionalData, _ := hex.DecodeString("101112131415161718191a1b1c1d1e1f2021222324252627")
+	plaintext, _ := hex.DecodeString("112233445566778899aabbccddee")
+	expected := "85632d07c6e8f37f950acd320a2ecc9340c02b9690c4dc04daef7f6afe5c"
+
+	ciphertext, err := sivEncrypt(key, plaintext, additionalData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(ciphertext) != expected {
+		t.Errorf("expected: %s | got: %x", expected, ciphertext)
+	}
+
+	decrypted, err := sivDecrypt(key, ciphertext, additionalData)
+	if err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("decrypting: %v", err)
+	}
+}
+
+func TestEncryptDeterministic(t *testing.T) {
+	key := make([]byte, DeterministicKeySize)
+	for i := range key {
+		key[i] = byte(i)
+	}
+
+	// generated with the AESSIV implementation of the Python cryptography package
+	tests := []struct {
+		plaintext      string
+		additionalData string
+		ciphertext     string
+	}{
+		{"a", "", "ba092609c39c78a53203bb6c759c7396d9"},
+		{"hello@example.com", "users.email", "3f8557fa0f213ef0ce5914d0daccfb4a691e26b0b6cc19353cce56c82700d18e62"},
+		{"0123456789abcdef", "", "df101a0c5f8eb90a0fe9891adaaf16a8c4df02080e7fa1603eb826a453f1bf63"},
+		{"0123456789abcdef0123456789abcdef!", "ad", "dacb19891d84f5a3b4b9879318c4e377da428ff2b7b0e92aeab624a5ed74e853944f0efd7ed79dadd79fa2bbc9374bf0e1"},
+	}
+
+	for _, test := range tests {
+		ciphertext, err := EncryptDeterministic(key, []byte(test.plaintext), []byte(test.additionalData))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(ciphertext) != test.ciphertext {
+			t.Errorf("%s: expected: %s | got: %x", test.plaintext, test.ciphertext, ciphertext)
+		}
+
+		plaintext, err := DecryptDeterministic(key, ciphertext, []byte(test.additionalData))
+		if err != nil || string(plaintext) != test.plaintext {
+			t.Errorf("%s: decrypting: %v", test.plaintext, err)
+		}
+
+		ciphertext[len(ciphertext)-1] ^= 1
+		if _, err = DecryptDeterministic(key, ciphertext, []byte(test.additionalData)); err != ErrDeterministicDecryptFailed {
+			t.Errorf("%s: expected: %v | got: %v", test.plaintext, ErrDeterministicDecryptFailed, err)
+		}
+	}
+
+	if _, err := EncryptDeterministic(key[:32], []byte("a"), nil); err != ErrDeterministicKeySize {
+		t.Errorf("expected: %v | got: %v", ErrDeterministicKeySize, err)
+	}
+}
@@ -0,0 +1,387 @@
+package crypto
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// The stream format is a STREAM construction (https://eprint.iacr.org/2015/189.pdf) on top of
+// XChaCha20-Poly1305:
+//
+//	version (1 byte) || salt (32 bytes) || chunk_0 || chunk_1 || ... || chunk_n
+//
+// Each chunk is StreamChunkSize bytes of plaintext (the last chunk may be shorter) encrypted with a
+// key derived from the key and the random salt of the stream. The nonce of each chunk is derived from
+// the index of the chunk and a flag indicating if it's the last chunk, so chunks can't be reordered,
+// and a truncated stream is detected because its last chunk can't be decrypted as the last chunk.
+
+const (
+	// StreamChunkSize is the size of the plaintext of each chunk of a stream, in bytes.
+	StreamChunkSize = 64 * 1024
+
+	streamVersion1           byte = 1
+	streamSaltSize                = 32
+	streamHeaderSize              = 1 + streamSaltSize
+	streamEncryptedChunkSize      = StreamChunkSize + chacha20poly1305.Overhead
+)
+
+var (
+	// ErrStreamIsNotValid is returned when a stream can't be decrypted, either because it was encrypted
+	// with another key or additional data, or because it was modified or truncated.
+	ErrStreamIsNotValid = errors.New("crypto: stream is not valid")
+
+	errStreamWriterIsClosed = errors.New("crypto: EncryptWriter is closed")
+)
+
+// EncryptWriter encrypts the data written to it. Close must be called to write the last chunk of the stream.
+type EncryptWriter struct {
+	dst            io.Writer
+	aead           cipher.AEAD
+	nonceKey       []byte
+	additionalData []byte
+	buffer         []byte
+	counter        uint64
+	closed         bool
+	err            error
+}
+
+// NewEncryptWriter returns a WriteCloser which encrypts the data written to it using XChaCha20-Poly1305 with
+// a key derived from key, and writes the encrypted stream to w.
+// additionalData is authenticated with each chunk of the stream.
+//
+// Close must be called once all the data has been written. It does not close w.
+func NewEncryptWriter(w io.Writer, key, additionalData []byte) (writer *EncryptWriter, err error) {
+	salt, err := RandBytes(streamSaltSize)
+	if err != nil {
+		return
+	}
+
+	aead, nonceKey, err := newStreamCipher(key, salt)
+	if err != nil {
+		return
+	}
+
+	header := append([]byte{streamVersion1}, salt...)
+	_, err = w.Write(header)
+	if err != nil {
+		return
+	}
+
+	writer = &EncryptWriter{
+		dst:            w,
+		aead:           aead,
+		nonceKey:       nonceKey,
+		additionalData: additionalData,
+		buffer:         make([]byte, 0, streamEncryptedChunkSize),
+	}
+	return
+}
+
+// Write implements io.Writer
+func (writer *EncryptWriter) Write(data []byte) (n int, err error) {
+	if writer.err != nil {
+		return 0, writer.err
+	}
+	if writer.closed {
+		return 0, errStreamWriterIsClosed
+	}
+
+	for len(data) > 0 {
+		// we only seal a full chunk once we know that more data follows, as the last chunk is sealed
+		// differently in Close
+		if len(writer.buffer) == StreamChunkSize {
+			err = writer.flushChunk(false)
+			if err != nil {
+				writer.err = err
+				return
+			}
+		}
+
+		copied := copy(writer.buffer[len(writer.buffer):StreamChunkSize], data)
+		writer.buffer = writer.buffer[:len(writer.buffer)+copied]
+		data = data[copied:]
+		n += copied
+	}
+
+	return
+}
+
+// Close writes the last chunk of the stream. It does not close the underlying writer.
+func (writer *EncryptWriter) Close() (err error) {
+	if writer.err != nil {
+		return writer.err
+	}
+	if writer.closed {
+		return nil
+	}
+
+	err = writer.flushChunk(true)
+	if err != nil {
+		writer.err = err
+		return
+	}
+
+	writer.closed = true
+	return
+}
+
+func (writer *EncryptWriter) flushChunk(last bool) (err error) {
+	nonce, err := streamNonce(writer.nonceKey, writer.counter, last)
+	if err != nil {
+		return
+	}
+
+	ciphertext := writer.aead.Seal(writer.buffer[:0], nonce, writer.buffer, writer.additionalData)
+	_, err = writer.dst.Write(ciphertext)
+	if err != nil {
+		return
+	}
+
+	writer.counter += 1
+	writer.buffer = writer.buffer[:0]
+	return
+}
+
+// DecryptReader decrypts a stream encrypted by an EncryptWriter.
+// It implements io.Seeker if the underlying reader implements io.Seeker.
+type DecryptReader struct {
+	src            io.Reader
+	bufferedSrc    *bufio.Reader
+	aead           cipher.AEAD
+	nonceKey       []byte
+	additionalData []byte
+
+	buffer []byte
+	// plaintext is the unread part of the current chunk
+	plaintext []byte
+	// counter is the index of the next chunk to read
+	counter  uint64
+	lastRead bool
+	// position is the position in the plaintext
+	position int64
+	err      error
+}
+
+// NewDecryptReader returns a Reader which decrypts the stream read from r.
+// key and additionalData must be the same as the ones used to encrypt the stream.
+//
+// ErrStreamIsNotValid is returned by Read if the stream was modified or truncated. Data returned by Read
+// before an error is authentic, but the stream should only be trusted once Read returns io.EOF.
+func NewDecryptReader(r io.Reader, key, additionalData []byte) (reader *DecryptReader, err error) {
+	header := make([]byte, streamHeaderSize)
+	_, err = io.ReadFull(r, header)
+	if err != nil {
+		err = ErrStreamIsNotValid
+		return
+	}
+
+	if header[0] != streamVersion1 {
+		err = ErrStreamIsNotValid
+		return
+	}
+
+	aead, nonceKey, err := newStreamCipher(key, header[1:])
+	if err != nil {
+		return
+	}
+
+	reader = &DecryptReader{
+		src:            r,
+		bufferedSrc:    bufio.NewReaderSize(r, streamEncryptedChunkSize),
+		aead:           aead,
+		nonceKey:       nonceKey,
+		additionalData: additionalData,
+		buffer:         make([]byte, streamEncryptedChunkSize),
+	}
+	return
+}
+
+// Read implements io.Reader
+func (reader *DecryptReader) Read(data []byte) (n int, err error) {
+	if reader.err != nil {
+		return 0, reader.err
+	}
+
+	for len(reader.plaintext) == 0 {
+		if reader.lastRead {
+			return 0, io.EOF
+		}
+
+		err = reader.readChunk()
+		if err != nil {
+			reader.err = err
+			return
+		}
+	}
+
+	n = copy(data, reader.plaintext)
+	reader.plaintext = reader.plaintext[n:]
+	reader.position += int64(n)
+	return
+}
+
+func (reader *DecryptReader) readChunk() (err error) {
+	n, err := io.ReadFull(reader.bufferedSrc, reader.buffer)
+	last := false
+	switch err {
+	case nil:
+		// the chunk is full, it's the last one only if there is no more data
+		if _, peekErr := reader.bufferedSrc.Peek(1); peekErr == io.EOF {
+			last = true
+		}
+	case io.ErrUnexpectedEOF:
+		last = true
+	case io.EOF:
+		// the last chunk is missing
+		return ErrStreamIsNotValid
+	default:
+		return err
+	}
+
+	nonce, err := streamNonce(reader.nonceKey, reader.counter, last)
+	if err != nil {
+		return
+	}
+
+	reader.plaintext, err = reader.aead.Open(reader.buffer[:0], nonce, reader.buffer[:n], reader.additionalData)
+	if err != nil {
+		return ErrStreamIsNotValid
+	}
+
+	// only the stream of an empty plaintext can have an empty chunk
+	if len(reader.plaintext) == 0 && reader.counter != 0 {
+		return ErrStreamIsNotValid
+	}
+
+	reader.counter += 1
+	reader.lastRead = last
+	return
+}
+
+// Seek implements io.Seeker. It returns an error if the underlying reader doesn't implement io.Seeker.
+// Seeking to a position decrypts the chunk containing this position.
+func (reader *DecryptReader) Seek(offset int64, whence int) (position int64, err error) {
+	seeker, isSeeker := reader.src.(io.Seeker)
+	if !isSeeker {
+		return 0, errors.New("crypto: DecryptReader: underlying reader doesn't implement io.Seeker")
+	}
+
+	size, err := reader.size(seeker)
+	if err != nil {
+		return
+	}
+
+	switch whence {
+	case io.SeekStart:
+		position = offset
+	case io.SeekCurrent:
+		position = reader.position + offset
+	case io.SeekEnd:
+		position = size + offset
+	default:
+		return 0, errors.New("crypto: DecryptReader.Seek: invalid whence")
+	}
+	if position < 0 {
+		return 0, errors.New("crypto: DecryptReader.Seek: negative position")
+	}
+
+	reader.err = nil
+	reader.plaintext = nil
+	reader.position = position
+	if position >= size {
+		// reads after the end of the stream return io.EOF
+		reader.lastRead = true
+		return
+	}
+
+	chunkIndex := position / StreamChunkSize
+	_, err = seeker.Seek(streamHeaderSize+chunkIndex*streamEncryptedChunkSize, io.SeekStart)
+	if err != nil {
+		return
+	}
+	reader.bufferedSrc.Reset(reader.src)
+	reader.counter = uint64(chunkIndex)
+	reader.lastRead = false
+
+	err = reader.readChunk()
+	if err != nil {
+		reader.err = err
+		return
+	}
+	reader.plaintext = reader.plaintext[position-chunkIndex*StreamChunkSize:]
+
+	return
+}
+
+// size returns the size of the plaintext of the stream
+func (reader *DecryptReader) size(seeker io.Seeker) (size int64, err error) {
+	currentOffset, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return
+	}
+
+	streamSize, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return
+	}
+
+	_, err = seeker.Seek(currentOffset, io.SeekStart)
+	if err != nil {
+		return
+	}
+
+	encryptedSize := streamSize - streamHeaderSize
+	fullChunks := encryptedSize / streamEncryptedChunkSize
+	lastChunkSize := encryptedSize % streamEncryptedChunkSize
+	if lastChunkSize == 0 && fullChunks > 0 {
+		// the last chunk is full
+		fullChunks -= 1
+		lastChunkSize = streamEncryptedChunkSize
+	}
+	if lastChunkSize < chacha20poly1305.Overhead {
+		return 0, ErrStreamIsNotValid
+	}
+
+	size = fullChunks*StreamChunkSize + lastChunkSize - chacha20poly1305.Overhead
+	return
+}
+
+func newStreamCipher(key, salt []byte) (aead cipher.AEAD, nonceKey []byte, err error) {
+	if len(key) != chacha20poly1305.KeySize {
+		err = errors.New("crypto: stream key must be 32 bytes")
+		return
+	}
+
+	info := append([]byte("com.bloom42.stdx.crypto.stream.v1.key"), salt...)
+	streamKey, err := DeriveKeyFromKey(key, info, chacha20poly1305.KeySize)
+	if err != nil {
+		return
+	}
+	defer Zeroize(streamKey)
+
+	info = append([]byte("com.bloom42.stdx.crypto.stream.v1.nonce"), salt...)
+	nonceKey, err = DeriveKeyFromKey(key, info, KeySize256)
+	if err != nil {
+		return
+	}
+
+	aead, err = chacha20poly1305.NewX(streamKey)
+	return
+}
+
+// streamNonce returns the nonce of the chunk with the given index:
+// DeriveKeyFromKey(nonceKey, counter (big endian) || last (1 byte))
+func streamNonce(nonceKey []byte, counter uint64, last bool) ([]byte, error) {
+	info := make([]byte, 9)
+	binary.BigEndian.PutUint64(info, counter)
+	if last {
+		info[8] = 1
+	}
+
+	return DeriveKeyFromKey(nonceKey, info, chacha20poly1305.NonceSizeX)
+}
@@ -0,0 +1,153 @@
+package crypto
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func encryptStream(t *testing.T, key, plaintext, additionalData []byte) []byte {
+	var ciphertext bytes.Buffer
+
+	writer, err := NewEncryptWriter(&ciphertext, key, additionalData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// write in small pieces to test buffering
+	for data := plaintext; len(data) > 0; {
+		n := min(len(data), 10_000)
+		_, err = writer.Write(data[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = data[n:]
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ciphertext.Bytes()
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	key, _ := NewAEADKey()
+	additionalData := []byte("additional data")
+	sizes := []int{0, 1, StreamChunkSize - 1, StreamChunkSize, StreamChunkSize + 1, 3 * StreamChunkSize, 3*StreamChunkSize + 42}
+
+	for _, size := range sizes {
+		plaintext, _ := RandBytes(uint64(size))
+		ciphertext := encryptStream(t, key, plaintext, additionalData)
+
+		reader, err := NewDecryptReader(bytes.NewReader(ciphertext), key, additionalData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := io.ReadAll(reader)
+		if err != nil {
+			t.Errorf("size %d: decrypting: %v", size, err)
+		}
+		if !bytes.Equal(plaintext, decrypted) {
+			t.Errorf("size %d: decrypted data doesn't match plaintext", size)
+		}
+	}
+}
+
+func TestStreamTruncation(t *testing.T) {
+	key, _ := NewAEADKey()
+	plaintext, _ := RandBytes(2*StreamChunkSize + 100)
+	ciphertext := encryptStream(t, key, plaintext, nil)
+
+	truncatedStreams := map[string][]byte{
+		"last chunk removed":   ciphertext[:streamHeaderSize+2*streamEncryptedChunkSize],
+		"last chunk truncated": ciphertext[:len(ciphertext)-1],
+		"header only":          ciphertext[:streamHeaderSize],
+	}
+
+	for name, truncated := range truncatedStreams {
+		reader, err := NewDecryptReader(bytes.NewReader(truncated), key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.ReadAll(reader)
+		if err != ErrStreamIsNotValid {
+			t.Errorf("%s: expected: %v | got: %v", name, ErrStreamIsNotValid, err)
+		}
+	}
+
+	// a stream whose plaintext size is a multiple of the chunk size, with the last chunk removed
+	plaintext, _ = RandBytes(2 * StreamChunkSize)
+	ciphertext = encryptStream(t, key, plaintext, nil)
+	reader, _ := NewDecryptReader(bytes.NewReader(ciphertext[:streamHeaderSize+streamEncryptedChunkSize]), key, nil)
+	_, err := io.ReadAll(reader)
+	if err != ErrStreamIsNotValid {
+		t.Errorf("full chunks: expected: %v | got: %v", ErrStreamIsNotValid, err)
+	}
+}
+
+func TestStreamTamperingAndWrongParameters(t *testing.T) {
+	key, _ := NewAEADKey()
+	otherKey, _ := NewAEADKey()
+	plaintext, _ := RandBytes(StreamChunkSize + 100)
+	ciphertext := encryptStream(t, key, plaintext, []byte("ad"))
+
+	tampered := bytes.Clone(ciphertext)
+	tampered[streamHeaderSize+10] ^= 1
+	reader, _ := NewDecryptReader(bytes.NewReader(tampered), key, []byte("ad"))
+	if _, err := io.ReadAll(reader); err != ErrStreamIsNotValid {
+		t.Errorf("tampered: expected: %v | got: %v", ErrStreamIsNotValid, err)
+	}
+
+	reader, _ = NewDecryptReader(bytes.NewReader(ciphertext), otherKey, []byte("ad"))
+	if _, err := io.ReadAll(reader); err != ErrStreamIsNotValid {
+		t.Errorf("wrong key: expected: %v | got: %v", ErrStreamIsNotValid, err)
+	}
+
+	reader, _ = NewDecryptReader(bytes.NewReader(ciphertext), key, []byte("other ad"))
+	if _, err := io.ReadAll(reader); err != ErrStreamIsNotValid {
+		t.Errorf("wrong additional data: expected: %v | got: %v", ErrStreamIsNotValid, err)
+	}
+}
+
+func TestStreamSeek(t *testing.T) {
+	key, _ := NewAEADKey()
+	plaintext, _ := RandBytes(3*StreamChunkSize + 1000)
+	ciphertext := encryptStream(t, key, plaintext, nil)
+
+	reader, err := NewDecryptReader(bytes.NewReader(ciphertext), key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	positions := []int64{0, 10, StreamChunkSize - 1, StreamChunkSize, 2*StreamChunkSize + 500, 3 * StreamChunkSize, int64(len(plaintext)) - 1}
+	for _, position := range positions {
+		newPosition, err := reader.Seek(position, io.SeekStart)
+		if err != nil {
+			t.Fatalf("seeking to %d: %v", position, err)
+		}
+		if newPosition != position {
+			t.Errorf("seek position. expected: %d | got: %d", position, newPosition)
+		}
+
+		data := make([]byte, 100)
+		n, err := io.ReadFull(reader, data)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			t.Fatalf("reading at %d: %v", position, err)
+		}
+		if !bytes.Equal(data[:n], plaintext[position:position+int64(n)]) {
+			t.Errorf("data at %d doesn't match plaintext", position)
+		}
+	}
+
+	position, err := reader.Seek(-10, io.SeekEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rest, plaintext[position:]) {
+		t.Error("data at the end doesn't match plaintext")
+	}
+}
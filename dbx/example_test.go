@@ -0,0 +1,69 @@
+package dbx_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/bloom42/stdx/crypto"
+	"github.com/bloom42/stdx/dbx"
+)
+
+// This example shows how to run equality queries on encrypted columns.
+//
+//	CREATE TABLE users (
+//		id BIGINT PRIMARY KEY,
+//		-- crypto.Encrypt(key, email, "users.email:{id}"): randomized encryption
+//		encrypted_email BYTEA NOT NULL,
+//		-- crypto.BlindIndex(key, email, 16)
+//		email_index BYTEA NOT NULL
+//	);
+//	CREATE INDEX index_users_on_email_index ON users (email_index);
+//
+// The blind index is truncated to 16 bits, so a lookup returns a few candidate rows which are then decrypted
+// and filtered. Users with the same email have the same index, but so do many users with different emails:
+// the database can't tell if two users with the same index have the same email.
+// If revealing which users have the same email is acceptable, the email can instead be encrypted with
+// crypto.EncryptDeterministic (and a key from crypto.NewDeterministicKey) and directly compared:
+// WHERE encrypted_email = $1. The additional data must then be the same for all the rows (e.g. "users.email"),
+// as a per-row additional data like the ID of the user would make the ciphertexts of the same email different.
+func Example_encryptedColumns() {
+	ctx := context.Background()
+	key, _ := crypto.NewAEADKey()
+
+	db, err := dbx.Connect("postgres://localhost/example", 10)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	type user struct {
+		ID             int64  `db:"id"`
+		EncryptedEmail []byte `db:"encrypted_email"`
+		EmailIndex     []byte `db:"email_index"`
+	}
+
+	email := []byte(strings.ToLower("Hello@Example.com"))
+	emailIndex, err := crypto.BlindIndex(key, email, 16)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	candidates, err := dbx.Select[user](ctx, db, "SELECT * FROM users WHERE email_index = $1", emailIndex)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, candidate := range candidates {
+		additionalData := []byte(fmt.Sprintf("users.email:%d", candidate.ID))
+		candidateEmail, err := crypto.Decrypt(key, candidate.EncryptedEmail, additionalData)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if bytes.Equal(candidateEmail, email) {
+			fmt.Println("found user:", candidate.ID)
+		}
+	}
+}
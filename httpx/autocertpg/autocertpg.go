@@ -12,8 +12,11 @@ import (
 )
 
 type Cache struct {
-	key []byte
-	db  db.DB
+	key     []byte
+	keyring *crypto.Keyring
+	// legacyKey decrypts the certs encrypted by a Cache created with NewCache, before the migration to a keyring
+	legacyKey []byte
+	db        db.DB
 }
 
 type cert struct {
@@ -28,6 +31,20 @@ func NewCache(db db.DB, key []byte) *Cache {
 	}
 }
 
+// NewCacheWithKeyring returns a Cache encrypting certs with the primary key of keyring.
+// Certs encrypted with older keys of the keyring can be rotated with RotateKeys.
+//
+// legacyKey is the key previously passed to NewCache, if any: the certs encrypted with it can still be
+// decrypted, and are re-encrypted with the keyring by RotateKeys. It can be nil once all the certs have
+// been rotated.
+func NewCacheWithKeyring(db db.DB, keyring *crypto.Keyring, legacyKey []byte) *Cache {
+	return &Cache{
+		db:        db,
+		keyring:   keyring,
+		legacyKey: legacyKey,
+	}
+}
+
 func (cache *Cache) Get(ctx context.Context, key string) (data []byte, err error) {
 	var cert cert
 	query := "SELECT * FROM certs WHERE key = $1"
@@ -42,7 +59,7 @@ func (cache *Cache) Get(ctx context.Context, key string) (data []byte, err error
 		return
 	}
 
-	data, err = crypto.Decrypt(cache.key, cert.EncryptedData, []byte(cert.Key))
+	data, err = cache.decrypt(cert)
 	if err != nil {
 		logger.Warn("autocertpg.Get: decrypting data", slogx.Err(err))
 		err = fmt.Errorf("autocertpg: decrypting data: %w", err)
@@ -61,7 +78,7 @@ func (cache *Cache) Put(ctx context.Context, key string, data []byte) (err error
 	`
 	logger := slogx.FromCtx(ctx)
 
-	encryptedData, err := crypto.Encrypt(cache.key, data, []byte(key))
+	encryptedData, err := cache.encrypt(key, data)
 	if err != nil {
 		logger.Warn("autocertpg.Put: encrypting data", slogx.Err(err))
 		err = fmt.Errorf("autocertpg: encrypting data: %w", err)
@@ -89,3 +106,90 @@ func (cache *Cache) Delete(ctx context.Context, key string) (err error) {
 
 	return
 }
+
+// RotateKeys re-encrypts with the primary key of the keyring the certs encrypted with older keys or with the
+// legacy key. It does nothing if the cache was not created with NewCacheWithKeyring.
+func (cache *Cache) RotateKeys(ctx context.Context) (rotated int64, err error) {
+	if cache.keyring == nil {
+		return
+	}
+
+	var certs []cert
+	err = cache.db.Select(ctx, &certs, "SELECT * FROM certs")
+	if err != nil {
+		err = fmt.Errorf("autocertpg: getting certs: %w", err)
+		return
+	}
+
+	for _, cert := range certs {
+		if !cache.keyring.NeedsRotation(cert.EncryptedData) {
+			continue
+		}
+
+		var encryptedData []byte
+		encryptedData, err = cache.rewrap(cert)
+		if err != nil {
+			err = fmt.Errorf("autocertpg: rewrapping cert (%s): %w", cert.Key, err)
+			return
+		}
+
+		// the cert is only updated if it was not modified in the meantime
+		var result sql.Result
+		result, err = cache.db.Exec(ctx, "UPDATE certs SET encrypted_data = $1 WHERE key = $2 AND encrypted_data = $3",
+			encryptedData, cert.Key, cert.EncryptedData)
+		if err != nil {
+			err = fmt.Errorf("autocertpg: updating cert (%s): %w", cert.Key, err)
+			return
+		}
+
+		var rowsAffected int64
+		rowsAffected, err = result.RowsAffected()
+		if err != nil {
+			err = fmt.Errorf("autocertpg: updating cert (%s): %w", cert.Key, err)
+			return
+		}
+		rotated += rowsAffected
+	}
+
+	return
+}
+
+func (cache *Cache) encrypt(key string, data []byte) ([]byte, error) {
+	if cache.keyring != nil {
+		return cache.keyring.Encrypt(data, []byte(key))
+	}
+	return crypto.Encrypt(cache.key, data, []byte(key))
+}
+
+func (cache *Cache) decrypt(cert cert) (data []byte, err error) {
+	if cache.keyring == nil {
+		return crypto.Decrypt(cache.key, cert.EncryptedData, []byte(cert.Key))
+	}
+
+	data, err = cache.keyring.Decrypt(cert.EncryptedData, []byte(cert.Key))
+	if err != nil && cache.legacyKey != nil {
+		var legacyErr error
+		data, legacyErr = crypto.Decrypt(cache.legacyKey, cert.EncryptedData, []byte(cert.Key))
+		if legacyErr == nil {
+			err = nil
+		}
+	}
+	return
+}
+
+// rewrap re-encrypts a cert with the primary key of the keyring
+func (cache *Cache) rewrap(cert cert) (encryptedData []byte, err error) {
+	encryptedData, err = cache.keyring.Rewrap(cert.EncryptedData, []byte(cert.Key))
+	if err == nil || cache.legacyKey == nil {
+		return
+	}
+
+	// the cert may have been encrypted with the legacy key
+	data, legacyErr := crypto.Decrypt(cache.legacyKey, cert.EncryptedData, []byte(cert.Key))
+	if legacyErr != nil {
+		return
+	}
+	defer crypto.Zeroize(data)
+
+	return cache.keyring.Encrypt(data, []byte(cert.Key))
+}
@@ -20,6 +20,7 @@ const (
 	HeaderReferer                 = "Referer"
 	HeaderDoNotTrack              = "Dnt"
 	HeaderAcceptLanguage          = "Accept-Language"
+	HeaderRetryAfter              = "Retry-After"
 )
 
 // https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Cache-Control
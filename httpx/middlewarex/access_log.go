@@ -0,0 +1,118 @@
+package middlewarex
+
+import (
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/bloom42/stdx/guid"
+	"github.com/bloom42/stdx/log/slogx"
+	"github.com/go-chi/chi/v5"
+)
+
+type AccessLogConfig struct {
+	// Logger is the logger used to log requests. If nil, the logger of the request context (see SetLogger)
+	// is used, and the method, path and request ID, which are already attributes of this logger, are not
+	// added to the access logs.
+	// default: the logger of the request context
+	Logger *slog.Logger
+	// SampleRate is the fraction of requests which are logged, between 0 and 1. Server errors and slow
+	// requests are always logged.
+	// default: 1
+	SampleRate float64
+	// Skip returns true for the requests which must not be logged, e.g. SkipPaths("/health")
+	Skip func(req *http.Request) bool
+	// SlowThreshold is the duration after which requests are logged with the Warn level. 0 disables it.
+	SlowThreshold time.Duration
+	// ClientIP returns the IP address of the client of a request.
+	// default: ClientIP
+	ClientIP func(req *http.Request) netip.Addr
+}
+
+// AccessLog logs the requests once they are served, with their status code, response size, duration, chi
+// route pattern, client IP, user agent and request ID (see RequestID).
+// Requests are logged with the Info level, slow requests with the Warn level and requests resulting in server
+// errors with the Error level.
+func AccessLog(config AccessLogConfig) func(next http.Handler) http.Handler {
+	if config.SampleRate <= 0 || config.SampleRate > 1 {
+		config.SampleRate = 1
+	}
+	if config.ClientIP == nil {
+		config.ClientIP = ClientIP
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, req *http.Request) {
+			if config.Skip != nil && config.Skip(req) {
+				next.ServeHTTP(w, req)
+				return
+			}
+
+			start := time.Now()
+			recorder := NewResponseRecorder(w)
+			next.ServeHTTP(recorder, req)
+			duration := time.Since(start)
+
+			statusCode := recorder.StatusCode()
+
+			level := slog.LevelInfo
+			if statusCode >= 500 {
+				level = slog.LevelError
+			} else if config.SlowThreshold > 0 && duration >= config.SlowThreshold {
+				level = slog.LevelWarn
+			} else if config.SampleRate < 1 && rand.Float64() >= config.SampleRate {
+				return
+			}
+
+			ctx := req.Context()
+			logger := config.Logger
+			isContextLogger := logger == nil
+			if isContextLogger {
+				logger = slogx.FromCtx(ctx)
+			}
+			if !logger.Enabled(ctx, level) {
+				return
+			}
+
+			// the route pattern is only complete once the request has been routed
+			route := ""
+			if routeCtx := chi.RouteContext(ctx); routeCtx != nil {
+				route = routeCtx.RoutePattern()
+			}
+
+			clientIP := ""
+			if ip := config.ClientIP(req); ip.IsValid() {
+				clientIP = ip.String()
+			}
+
+			attrs := make([]any, 0, 9)
+			if !isContextLogger {
+				attrs = append(attrs, slog.String("method", req.Method), slog.String("path", req.URL.Path))
+			}
+			attrs = append(attrs,
+				slog.String("route", route),
+				slog.Int("status", statusCode),
+				slog.Int64("bytes", recorder.BytesWritten()),
+				slog.Duration("duration", duration),
+				slog.String("client_ip", clientIP),
+				slog.String("user_agent", req.UserAgent()),
+			)
+			if requestID, ok := ctx.Value(RequestIDCtxKey).(guid.GUID); ok && !isContextLogger {
+				attrs = append(attrs, slog.String("request_id", requestID.String()))
+			}
+
+			logger.Log(ctx, level, "http request", slog.Group("access", attrs...))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// SkipPaths returns an AccessLogConfig.Skip function skipping the requests to paths, e.g. health checks
+func SkipPaths(paths ...string) func(req *http.Request) bool {
+	return func(req *http.Request) bool {
+		return slices.Contains(paths, req.URL.Path)
+	}
+}
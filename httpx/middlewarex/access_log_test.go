@@ -0,0 +1,126 @@
+package middlewarex
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type accessLogEntry struct {
+	Level  string `json:"level"`
+	Access struct {
+		Method    string `json:"method"`
+		Route     string `json:"route"`
+		Status    int    `json:"status"`
+		Bytes     int64  `json:"bytes"`
+		ClientIP  string `json:"client_ip"`
+		UserAgent string `json:"user_agent"`
+		RequestID string `json:"request_id"`
+	} `json:"access"`
+}
+
+func TestAccessLog(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	router := chi.NewRouter()
+	router.Use(RequestID("X-Request-Id"))
+	router.Use(AccessLog(AccessLogConfig{
+		Logger:        logger,
+		Skip:          SkipPaths("/health"),
+		SlowThreshold: 50 * time.Millisecond,
+	}))
+	router.Get("/health", func(w http.ResponseWriter, req *http.Request) {})
+	router.Get("/users/{id}", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	router.Get("/slow", func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(60 * time.Millisecond)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	router.Get("/error", func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	serve := func(path string) (entry accessLogEntry, logged bool) {
+		logs.Reset()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("User-Agent", "test")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		if logs.Len() == 0 {
+			return
+		}
+		err := json.Unmarshal(logs.Bytes(), &entry)
+		if err != nil {
+			t.Fatalf("%s: decoding log entry: %v", path, err)
+		}
+		logged = true
+		return
+	}
+
+	entry, logged := serve("/users/123")
+	if !logged {
+		t.Fatal("request is not logged")
+	}
+	if entry.Level != "INFO" || entry.Access.Method != http.MethodGet || entry.Access.Route != "/users/{id}" ||
+		entry.Access.Status != http.StatusOK || entry.Access.Bytes != 5 || entry.Access.ClientIP != "192.0.2.1" ||
+		entry.Access.UserAgent != "test" || entry.Access.RequestID == "" {
+		t.Errorf("log entry is not valid: %+v", entry)
+	}
+
+	if _, logged = serve("/health"); logged {
+		t.Error("skipped request is logged")
+	}
+
+	entry, _ = serve("/slow")
+	if entry.Level != "WARN" || entry.Access.Status != http.StatusNoContent {
+		t.Errorf("slow request. expected: WARN 204 | got: %s %d", entry.Level, entry.Access.Status)
+	}
+
+	entry, _ = serve("/error")
+	if entry.Level != "ERROR" || entry.Access.Status != http.StatusInternalServerError {
+		t.Errorf("server error. expected: ERROR 500 | got: %s %d", entry.Level, entry.Access.Status)
+	}
+}
+
+func TestAccessLogWithContextLogger(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	router := chi.NewRouter()
+	router.Use(RequestID("X-Request-Id"))
+	router.Use(SetLogger(logger))
+	router.Use(AccessLog(AccessLogConfig{}))
+	router.Get("/users/{id}", func(w http.ResponseWriter, req *http.Request) {})
+
+	req := httptest.NewRequest(http.MethodGet, "/users/123", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	var entry map[string]any
+	err := json.Unmarshal(logs.Bytes(), &entry)
+	if err != nil {
+		t.Fatalf("decoding log entry: %v", err)
+	}
+
+	// the method, path and request ID are attributes of the logger of the context, so they are not repeated
+	httpAttrs, _ := entry["http"].(map[string]any)
+	if httpAttrs["method"] != http.MethodGet || httpAttrs["path"] != "/users/123" || entry["request_id"] == nil {
+		t.Errorf("attributes of the context logger are missing: %v", entry)
+	}
+	access, _ := entry["access"].(map[string]any)
+	for _, attr := range []string{"method", "path", "request_id"} {
+		if _, exists := access[attr]; exists {
+			t.Errorf("access.%s is duplicated: %v", attr, access)
+		}
+	}
+	if access["route"] != "/users/{id}" {
+		t.Errorf("access.route. expected: /users/{id} | got: %v", access["route"])
+	}
+}
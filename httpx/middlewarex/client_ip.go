@@ -0,0 +1,19 @@
+package middlewarex
+
+import (
+	"net/http"
+	"net/netip"
+)
+
+// ClientIP returns the IP address of the client, from req.RemoteAddr, or an invalid netip.Addr if
+// req.RemoteAddr is not valid. Use chi's RealIP middleware behind a reverse proxy.
+// It's used by default by the middlewares of this package and of the auth packages.
+func ClientIP(req *http.Request) netip.Addr {
+	addrPort, err := netip.ParseAddrPort(req.RemoteAddr)
+	if err == nil {
+		return addrPort.Addr()
+	}
+	// chi's RealIP middleware sets RemoteAddr without port
+	ip, _ := netip.ParseAddr(req.RemoteAddr)
+	return ip
+}
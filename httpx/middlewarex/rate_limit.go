@@ -0,0 +1,318 @@
+package middlewarex
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bloom42/stdx/concurrentmap"
+	"github.com/bloom42/stdx/httpx"
+	"github.com/bloom42/stdx/log/slogx"
+)
+
+const (
+	HeaderRateLimitLimit     = "RateLimit-Limit"
+	HeaderRateLimitRemaining = "RateLimit-Remaining"
+	HeaderRateLimitReset     = "RateLimit-Reset"
+	HeaderRateLimitPolicy    = "RateLimit-Policy"
+)
+
+// RateLimitAlgorithm is the algorithm used to limit the rate of requests
+type RateLimitAlgorithm int
+
+const (
+	// TokenBucket allows bursts of up to Limit requests, and refills the bucket continuously at a rate of
+	// Limit requests per Period
+	TokenBucket RateLimitAlgorithm = iota
+	// SlidingWindow allows Limit requests in any Period, approximated by weighting the count of the previous
+	// window with the elapsed time of the current one
+	SlidingWindow
+)
+
+// RateLimitStore stores the state of rate limits. Stores must apply Take atomically.
+type RateLimitStore interface {
+	// Take consumes one request for key and returns the result
+	Take(ctx context.Context, key string, policy RateLimitPolicy, now time.Time) (RateLimitResult, error)
+}
+
+// RateLimitPolicy is a limit of Limit requests per Period
+type RateLimitPolicy struct {
+	Algorithm RateLimitAlgorithm
+	Limit     int64
+	Period    time.Duration
+}
+
+// RateLimitResult is the result of RateLimitStore.Take
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int64
+	Remaining int64
+	// Reset is the time until the quota is fully available again
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed, if the request is not allowed
+	RetryAfter time.Duration
+}
+
+type RateLimitConfig struct {
+	// default: TokenBucket
+	Algorithm RateLimitAlgorithm
+	// Limit is the number of requests allowed per Period. Required.
+	Limit int64
+	// Required.
+	Period time.Duration
+	// Key returns the key which is rate limited for a request, e.g. RateLimitByHeader("Authorization"). An
+	// empty key disables the rate limit for the request.
+	// default: the IP address of the client (see ClientIP)
+	Key func(req *http.Request) string
+	// Name is prepended to the keys, so different limits (e.g. per route) can share the same store. The rate
+	// limits sharing a store MUST have different names.
+	Name string
+	// Store is shared by the instances of a service to apply the limits globally, e.g. a PostgresRateLimitStore.
+	// default: a new MemoryRateLimitStore
+	Store RateLimitStore
+	// LimitedHandler handles the requests which are rate limited, after the headers are set.
+	// default: a 429 Too Many Requests plaintext response
+	LimitedHandler http.Handler
+}
+
+// rateLimitState is the state of a key, for both algorithms
+type rateLimitState struct {
+	// Tokens is the number of tokens in the bucket (TokenBucket)
+	Tokens    float64   `db:"tokens"`
+	UpdatedAt time.Time `db:"updated_at"`
+	// WindowStart, Count and PreviousCount are the current window and the counts of requests of the current
+	// and previous windows (SlidingWindow)
+	WindowStart   time.Time `db:"window_start"`
+	Count         int64     `db:"count"`
+	PreviousCount int64     `db:"previous_count"`
+	// ExpiresAt is the time after which the state is equivalent to no state
+	ExpiresAt time.Time `db:"expires_at"`
+}
+
+// RateLimit limits the rate of requests per key, and sets the RateLimit-* headers
+// (https://datatracker.ietf.org/doc/draft-ietf-httpapi-ratelimit-headers) on responses and the Retry-After
+// header on rate limited responses. If the store returns an error, the error is logged and the request is
+// allowed.
+//
+//	router.With(middlewarex.RateLimit(middlewarex.RateLimitConfig{
+//		Name:   "login",
+//		Limit:  10,
+//		Period: time.Minute,
+//	})).Post("/login", login)
+func RateLimit(config RateLimitConfig) func(next http.Handler) http.Handler {
+	if config.Limit <= 0 || config.Period <= 0 {
+		panic("middlewarex.RateLimit: Limit and Period must be positive")
+	}
+	if config.Key == nil {
+		config.Key = rateLimitByIP
+	}
+	if config.Store == nil {
+		config.Store = NewMemoryRateLimitStore()
+	}
+	if config.LimitedHandler == nil {
+		config.LimitedHandler = http.HandlerFunc(rateLimitedHandler)
+	}
+
+	policy := RateLimitPolicy{Algorithm: config.Algorithm, Limit: config.Limit, Period: config.Period}
+	policyHeader := strconv.FormatInt(config.Limit, 10) + ";w=" + strconv.FormatInt(int64(math.Ceil(config.Period.Seconds())), 10)
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, req *http.Request) {
+			key := config.Key(req)
+			if key == "" {
+				next.ServeHTTP(w, req)
+				return
+			}
+
+			result, err := config.Store.Take(req.Context(), config.Name+":"+key, policy, time.Now())
+			if err != nil {
+				slogx.FromCtx(req.Context()).Error("middlewarex.RateLimit: taking from store", slogx.Err(err))
+				next.ServeHTTP(w, req)
+				return
+			}
+
+			headers := w.Header()
+			headers.Set(HeaderRateLimitLimit, strconv.FormatInt(result.Limit, 10))
+			headers.Set(HeaderRateLimitRemaining, strconv.FormatInt(result.Remaining, 10))
+			headers.Set(HeaderRateLimitReset, strconv.FormatInt(ceilSeconds(result.Reset), 10))
+			headers.Set(HeaderRateLimitPolicy, policyHeader)
+
+			if !result.Allowed {
+				headers.Set(httpx.HeaderRetryAfter, strconv.FormatInt(ceilSeconds(result.RetryAfter), 10))
+				config.LimitedHandler.ServeHTTP(w, req)
+				return
+			}
+
+			next.ServeHTTP(w, req)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// rateLimitByIP limits requests per IP address of the client
+func rateLimitByIP(req *http.Request) string {
+	return ClientIP(req).String()
+}
+
+// RateLimitByHeader returns a function limiting requests per value of header, e.g. "Authorization" to limit
+// requests per API key, and per IP address for the requests without the header. Values are hashed so that
+// secrets are not stored in rate limit stores.
+func RateLimitByHeader(header string) func(req *http.Request) string {
+	return func(req *http.Request) string {
+		value := req.Header.Get(header)
+		if value == "" {
+			return "ip:" + rateLimitByIP(req)
+		}
+		hash := sha256.Sum256([]byte(value))
+		return "header:" + hex.EncodeToString(hash[:16])
+	}
+}
+
+func rateLimitedHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set(httpx.HeaderContentType, httpx.MediaTypeTextUtf8)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write([]byte("Too many requests. Please retry later.\n"))
+}
+
+// take consumes one request from state and returns the result
+func (policy RateLimitPolicy) take(state *rateLimitState, exists bool, now time.Time) (result RateLimitResult) {
+	if exists && !now.Before(state.ExpiresAt) {
+		exists = false
+	}
+
+	switch policy.Algorithm {
+	case SlidingWindow:
+		result = policy.takeSlidingWindow(state, exists, now)
+	default:
+		result = policy.takeTokenBucket(state, exists, now)
+	}
+	return
+}
+
+func (policy RateLimitPolicy) takeTokenBucket(state *rateLimitState, exists bool, now time.Time) (result RateLimitResult) {
+	limit := float64(policy.Limit)
+	// tokens per nanosecond
+	rate := limit / float64(policy.Period)
+
+	if !exists {
+		state.Tokens = limit
+	} else if elapsed := now.Sub(state.UpdatedAt); elapsed > 0 {
+		state.Tokens = math.Min(limit, state.Tokens+float64(elapsed)*rate)
+	}
+	state.UpdatedAt = now
+
+	if state.Tokens >= 1 {
+		state.Tokens -= 1
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration(math.Ceil((1 - state.Tokens) / rate))
+	}
+
+	result.Limit = policy.Limit
+	result.Remaining = int64(math.Floor(state.Tokens))
+	result.Reset = time.Duration(math.Ceil((limit - state.Tokens) / rate))
+	state.ExpiresAt = now.Add(result.Reset)
+	return
+}
+
+func (policy RateLimitPolicy) takeSlidingWindow(state *rateLimitState, exists bool, now time.Time) (result RateLimitResult) {
+	windowStart := now.Truncate(policy.Period)
+	if !exists {
+		*state = rateLimitState{WindowStart: windowStart}
+	} else if !state.WindowStart.Equal(windowStart) {
+		if state.WindowStart.Add(policy.Period).Equal(windowStart) {
+			state.PreviousCount = state.Count
+		} else {
+			state.PreviousCount = 0
+		}
+		state.WindowStart = windowStart
+		state.Count = 0
+	}
+	state.UpdatedAt = now
+
+	elapsed := now.Sub(windowStart)
+	previousWeight := 1 - float64(elapsed)/float64(policy.Period)
+	estimated := float64(state.PreviousCount)*previousWeight + float64(state.Count)
+
+	if estimated+1 <= float64(policy.Limit) {
+		state.Count += 1
+		estimated += 1
+		result.Allowed = true
+	} else {
+		result.RetryAfter = policy.slidingWindowRetryAfter(state, elapsed)
+	}
+
+	result.Limit = policy.Limit
+	result.Remaining = policy.Limit - int64(math.Ceil(estimated))
+	if result.Remaining < 0 {
+		result.Remaining = 0
+	}
+	// the counts of the current window are forgotten at the end of the next window
+	result.Reset = policy.Period - elapsed
+	if state.Count > 0 {
+		result.Reset += policy.Period
+	}
+	state.ExpiresAt = windowStart.Add(2 * policy.Period)
+	return
+}
+
+// slidingWindowRetryAfter returns the time until the estimated count allows one more request
+func (policy RateLimitPolicy) slidingWindowRetryAfter(state *rateLimitState, elapsed time.Duration) time.Duration {
+	period := float64(policy.Period)
+	available := float64(policy.Limit - 1)
+
+	// in the current window: previous * (1 - (elapsed + t) / period) + count <= limit - 1
+	if state.PreviousCount > 0 && float64(state.Count) <= available {
+		retryAfter := period*(1-(available-float64(state.Count))/float64(state.PreviousCount)) - float64(elapsed)
+		return time.Duration(math.Max(0, math.Ceil(retryAfter)))
+	}
+
+	// in the next window, where the current count becomes the previous one: count * (1 - t / period) <= limit - 1
+	retryAfter := float64(policy.Period - elapsed)
+	if state.Count > 0 {
+		retryAfter += period * math.Max(0, 1-available/float64(state.Count))
+	}
+	return time.Duration(math.Ceil(retryAfter))
+}
+
+func ceilSeconds(duration time.Duration) int64 {
+	return int64(math.Ceil(duration.Seconds()))
+}
+
+// MemoryRateLimitStore is a RateLimitStore for single-instance deployments
+type MemoryRateLimitStore struct {
+	states concurrentmap.ConcurrentMap[string, rateLimitState]
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		states: concurrentmap.New[rateLimitState](),
+	}
+}
+
+func (store *MemoryRateLimitStore) Take(ctx context.Context, key string, policy RateLimitPolicy, now time.Time) (result RateLimitResult, err error) {
+	// the state is updated while the shard of the key is locked
+	store.states.Upsert(key, rateLimitState{}, func(exists bool, state, _ rateLimitState) rateLimitState {
+		result = policy.take(&state, exists, now)
+		return state
+	})
+	return
+}
+
+// DeleteExpired deletes the states which have expired. It should be called periodically.
+func (store *MemoryRateLimitStore) DeleteExpired(now time.Time) (deleted int64) {
+	for _, key := range store.states.Keys() {
+		removed := store.states.RemoveCb(key, func(key string, state rateLimitState, exists bool) bool {
+			return exists && !now.Before(state.ExpiresAt)
+		})
+		if removed {
+			deleted += 1
+		}
+	}
+	return
+}
@@ -0,0 +1,101 @@
+package middlewarex
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bloom42/stdx/db"
+	"github.com/bloom42/stdx/migrate"
+)
+
+// PostgresRateLimitStore is a RateLimitStore shared by all the instances of a service, storing the states
+// of the rate limits in the http_rate_limits table.
+// See PostgresRateLimitMigration to create the table.
+type PostgresRateLimitStore struct {
+	db db.DB
+}
+
+func NewPostgresRateLimitStore(db db.DB) *PostgresRateLimitStore {
+	return &PostgresRateLimitStore{
+		db: db,
+	}
+}
+
+// PostgresRateLimitMigration returns the migration creating the table used by PostgresRateLimitStore
+func PostgresRateLimitMigration(id int64) migrate.Migration {
+	return migrate.Migration{
+		ID: id,
+		Up: func(ctx context.Context, tx db.Queryer) (err error) {
+			_, err = tx.Exec(ctx, `CREATE TABLE http_rate_limits (
+				key TEXT PRIMARY KEY,
+				tokens DOUBLE PRECISION NOT NULL,
+				updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+				window_start TIMESTAMP WITH TIME ZONE NOT NULL,
+				count BIGINT NOT NULL,
+				previous_count BIGINT NOT NULL,
+				expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+			)`)
+			if err != nil {
+				return
+			}
+			_, err = tx.Exec(ctx, "CREATE INDEX index_http_rate_limits_on_expires_at ON http_rate_limits (expires_at)")
+			return
+		},
+		Down: func(ctx context.Context, tx db.Queryer) (err error) {
+			_, err = tx.Exec(ctx, "DROP TABLE http_rate_limits")
+			return
+		},
+	}
+}
+
+func (store *PostgresRateLimitStore) Take(ctx context.Context, key string, policy RateLimitPolicy, now time.Time) (result RateLimitResult, err error) {
+	now = now.UTC()
+
+	err = store.db.Transaction(ctx, func(tx db.Tx) (txErr error) {
+		var state rateLimitState
+
+		// The row is created or locked, and returned, by a single statement, so it can't be deleted by
+		// DeleteExpired in the meantime. It's locked until the end of the transaction so concurrent requests
+		// are applied one after the other.
+		txErr = tx.Get(ctx, &state, `INSERT INTO http_rate_limits (key, tokens, updated_at, window_start, count, previous_count, expires_at)
+			VALUES ($1, 0, $2, $2, 0, 0, $2)
+			ON CONFLICT (key) DO UPDATE SET key = EXCLUDED.key
+			RETURNING tokens, updated_at, window_start, count, previous_count, expires_at`, key, now)
+		if txErr != nil {
+			return
+		}
+
+		// new rows are created expired, so they are treated as if they didn't exist
+		result = policy.take(&state, true, now)
+
+		_, txErr = tx.Exec(ctx, `UPDATE http_rate_limits
+			SET tokens = $1, updated_at = $2, window_start = $3, count = $4, previous_count = $5, expires_at = $6
+			WHERE key = $7`,
+			state.Tokens, state.UpdatedAt, state.WindowStart, state.Count, state.PreviousCount, state.ExpiresAt, key)
+		return
+	})
+	if err != nil {
+		err = fmt.Errorf("middlewarex: taking from rate limit: %w", err)
+		return
+	}
+
+	return
+}
+
+// DeleteExpired deletes the states which have expired. It should be called periodically.
+func (store *PostgresRateLimitStore) DeleteExpired(ctx context.Context, now time.Time) (deleted int64, err error) {
+	result, err := store.db.Exec(ctx, "DELETE FROM http_rate_limits WHERE expires_at <= $1", now.UTC())
+	if err != nil {
+		err = fmt.Errorf("middlewarex: deleting expired rate limits: %w", err)
+		return
+	}
+
+	deleted, err = result.RowsAffected()
+	if err != nil {
+		err = fmt.Errorf("middlewarex: deleting expired rate limits: %w", err)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,92 @@
+package middlewarex
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bloom42/stdx/db/dbtest"
+	"github.com/bloom42/stdx/migrate"
+)
+
+func TestPostgresRateLimitStore(t *testing.T) {
+	database := dbtest.New(t, []migrate.Migration{PostgresRateLimitMigration(1)})
+	store := NewPostgresRateLimitStore(database)
+	policy := RateLimitPolicy{Algorithm: TokenBucket, Limit: 10, Period: time.Minute}
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// concurrent requests are applied one after the other
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for i := int64(0); i < 2*policy.Limit; i += 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := store.Take(ctx, "key", policy, now)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if result.Allowed {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed.Load() != policy.Limit {
+		t.Errorf("allowed requests. expected: %d | got: %d", policy.Limit, allowed.Load())
+	}
+
+	result, err := store.Take(ctx, "key", policy, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed || result.RetryAfter != 6*time.Second {
+		t.Errorf("empty bucket. expected: denied, retry after 6s | got: %+v", result)
+	}
+
+	// new states are created expired, so they are deleted by concurrent calls of DeleteExpired while
+	// requests are taken from them
+	deleteCtx, stopDeleting := context.WithCancel(ctx)
+	deleted := make(chan struct{})
+	go func() {
+		defer close(deleted)
+		for deleteCtx.Err() == nil {
+			_, err := store.DeleteExpired(deleteCtx, now)
+			if err != nil && deleteCtx.Err() == nil {
+				t.Error(err)
+			}
+		}
+	}()
+	for i := 0; i < 50; i += 1 {
+		result, err = store.Take(ctx, "new:"+strconv.Itoa(i), policy, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Allowed {
+			t.Errorf("request %d. expected: allowed | got: %+v", i, result)
+		}
+	}
+	stopDeleting()
+	<-deleted
+
+	deletedStates, err := store.DeleteExpired(ctx, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deletedStates < 1 {
+		t.Errorf("deleting expired states. expected: at least 1 | got: %d", deletedStates)
+	}
+
+	result, err = store.Take(ctx, "key", policy, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Allowed || result.Remaining != policy.Limit-1 {
+		t.Errorf("after expiration. expected: allowed with %d remaining | got: %+v", policy.Limit-1, result)
+	}
+}
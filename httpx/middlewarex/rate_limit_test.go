@@ -0,0 +1,143 @@
+package middlewarex
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	policy := RateLimitPolicy{Algorithm: TokenBucket, Limit: 10, Period: time.Minute}
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := int64(0); i < policy.Limit; i += 1 {
+		result, err := store.Take(ctx, "key", policy, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Allowed || result.Remaining != policy.Limit-i-1 {
+			t.Fatalf("request %d. expected: allowed with %d remaining | got: %+v", i, policy.Limit-i-1, result)
+		}
+	}
+
+	result, _ := store.Take(ctx, "key", policy, now)
+	if result.Allowed || result.RetryAfter != 6*time.Second || result.Reset != time.Minute {
+		t.Errorf("empty bucket. expected: denied, retry after 6s, reset 1m | got: %+v", result)
+	}
+
+	// one token is refilled every 6 seconds
+	result, _ = store.Take(ctx, "key", policy, now.Add(6*time.Second))
+	if !result.Allowed || result.Remaining != 0 {
+		t.Errorf("after refill. expected: allowed with 0 remaining | got: %+v", result)
+	}
+
+	result, _ = store.Take(ctx, "other", policy, now)
+	if !result.Allowed || result.Remaining != policy.Limit-1 {
+		t.Errorf("other key. expected: allowed with %d remaining | got: %+v", policy.Limit-1, result)
+	}
+
+	if deleted := store.DeleteExpired(now.Add(time.Hour)); deleted != 2 {
+		t.Errorf("deleting expired states. expected: 2 | got: %d", deleted)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	policy := RateLimitPolicy{Algorithm: SlidingWindow, Limit: 10, Period: time.Minute}
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := int64(0); i < policy.Limit; i += 1 {
+		result, _ := store.Take(ctx, "key", policy, now)
+		if !result.Allowed {
+			t.Fatalf("request %d is not allowed: %+v", i, result)
+		}
+	}
+
+	result, _ := store.Take(ctx, "key", policy, now.Add(30*time.Second))
+	if result.Allowed || result.RetryAfter != 36*time.Second {
+		t.Errorf("full window. expected: denied, retry after 36s | got: %+v", result)
+	}
+
+	// 10% into the next window, the weighted count of the previous window is 9
+	result, _ = store.Take(ctx, "key", policy, now.Add(66*time.Second))
+	if !result.Allowed || result.Remaining != 0 {
+		t.Errorf("next window. expected: allowed with 0 remaining | got: %+v", result)
+	}
+
+	result, _ = store.Take(ctx, "key", policy, now.Add(66*time.Second))
+	if result.Allowed || result.RetryAfter != 6*time.Second {
+		t.Errorf("next window. expected: denied, retry after 6s | got: %+v", result)
+	}
+
+	// the counts are forgotten after 2 periods
+	result, _ = store.Take(ctx, "key", policy, now.Add(3*time.Minute))
+	if !result.Allowed || result.Remaining != policy.Limit-1 {
+		t.Errorf("after 2 periods. expected: allowed with %d remaining | got: %+v", policy.Limit-1, result)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	login := RateLimit(RateLimitConfig{Name: "login", Limit: 2, Period: time.Minute, Store: store})(next)
+	api := RateLimit(RateLimitConfig{Name: "api", Limit: 5, Period: time.Minute, Store: store,
+		Key: RateLimitByHeader("Authorization")})(next)
+
+	serve := func(handler http.Handler, remoteAddr, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		return res
+	}
+
+	for i := 0; i < 2; i += 1 {
+		res := serve(login, "192.0.2.1:1234", "")
+		if res.Code != http.StatusNoContent {
+			t.Fatalf("request %d. expected: %d | got: %d", i, http.StatusNoContent, res.Code)
+		}
+	}
+
+	res := serve(login, "192.0.2.1:5678", "")
+	if res.Code != http.StatusTooManyRequests {
+		t.Errorf("rate limited. expected: %d | got: %d", http.StatusTooManyRequests, res.Code)
+	}
+	expectedHeaders := map[string]string{
+		HeaderRateLimitLimit:     "2",
+		HeaderRateLimitRemaining: "0",
+		HeaderRateLimitReset:     "60",
+		HeaderRateLimitPolicy:    "2;w=60",
+		"Retry-After":            "30",
+	}
+	for header, expected := range expectedHeaders {
+		if value := res.Header().Get(header); value != expected {
+			t.Errorf("header %s. expected: %s | got: %s", header, expected, value)
+		}
+	}
+
+	res = serve(login, "192.0.2.2:1234", "")
+	if res.Code != http.StatusNoContent {
+		t.Errorf("other IP. expected: %d | got: %d", http.StatusNoContent, res.Code)
+	}
+
+	// limits with different names don't share their quotas
+	res = serve(api, "192.0.2.1:1234", "Bearer key_1")
+	if res.Code != http.StatusNoContent || res.Header().Get(HeaderRateLimitRemaining) != "4" {
+		t.Errorf("other route. expected: %d with 4 remaining | got: %d with %s remaining", http.StatusNoContent,
+			res.Code, res.Header().Get(HeaderRateLimitRemaining))
+	}
+	res = serve(api, "192.0.2.1:1234", "Bearer key_2")
+	if res.Header().Get(HeaderRateLimitRemaining) != "4" {
+		t.Errorf("other API key. expected: 4 remaining | got: %s", res.Header().Get(HeaderRateLimitRemaining))
+	}
+}
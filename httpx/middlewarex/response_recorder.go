@@ -0,0 +1,81 @@
+package middlewarex
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+// we do this to have a compile-time error if ResponseRecorder no longer satisfies the http.Flusher and
+// http.Hijacker interfaces
+var _ http.Flusher = (*ResponseRecorder)(nil)
+var _ http.Hijacker = (*ResponseRecorder)(nil)
+
+// ResponseRecorder is an http.ResponseWriter which records the status code and the size of a response, for
+// the middlewares which act on the response of the next handler.
+type ResponseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	bytes      int64
+}
+
+func NewResponseRecorder(w http.ResponseWriter) *ResponseRecorder {
+	return &ResponseRecorder{ResponseWriter: w}
+}
+
+// StatusCode returns the status code of the response. As with http.ResponseWriter, it's http.StatusOK if
+// the handler didn't write the status code.
+func (recorder *ResponseRecorder) StatusCode() int {
+	if recorder.statusCode == 0 {
+		return http.StatusOK
+	}
+	return recorder.statusCode
+}
+
+// BytesWritten returns the size of the body of the response
+func (recorder *ResponseRecorder) BytesWritten() int64 {
+	return recorder.bytes
+}
+
+func (recorder *ResponseRecorder) WriteHeader(statusCode int) {
+	if recorder.statusCode == 0 {
+		recorder.statusCode = statusCode
+	}
+	recorder.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (recorder *ResponseRecorder) Write(data []byte) (n int, err error) {
+	if recorder.statusCode == 0 {
+		recorder.statusCode = http.StatusOK
+	}
+	n, err = recorder.ResponseWriter.Write(data)
+	recorder.bytes += int64(n)
+	return
+}
+
+// Flush implements http.Flusher for the handlers streaming responses
+func (recorder *ResponseRecorder) Flush() {
+	if recorder.statusCode == 0 {
+		recorder.statusCode = http.StatusOK
+	}
+	http.NewResponseController(recorder.ResponseWriter).Flush()
+}
+
+// Hijack implements http.Hijacker for the handlers taking over the connection, e.g. WebSockets.
+// If the status code has not been written, it's recorded as http.StatusSwitchingProtocols.
+func (recorder *ResponseRecorder) Hijack() (conn net.Conn, rw *bufio.ReadWriter, err error) {
+	conn, rw, err = http.NewResponseController(recorder.ResponseWriter).Hijack()
+	if err != nil {
+		return
+	}
+
+	if recorder.statusCode == 0 {
+		recorder.statusCode = http.StatusSwitchingProtocols
+	}
+	return
+}
+
+// Unwrap allows http.ResponseController to access the underlying ResponseWriter
+func (recorder *ResponseRecorder) Unwrap() http.ResponseWriter {
+	return recorder.ResponseWriter
+}
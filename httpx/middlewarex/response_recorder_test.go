@@ -0,0 +1,49 @@
+package middlewarex
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResponseRecorderHijack(t *testing.T) {
+	recorded := make(chan int, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		recorder := NewResponseRecorder(w)
+		defer func() { recorded <- recorder.StatusCode() }()
+
+		// the recorder is hijacked through http.ResponseController, as the handlers of WebSockets do
+		conn, rw, err := http.NewResponseController(recorder).Hijack()
+		if err != nil {
+			t.Errorf("hijacking connection: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: test\r\nConnection: Upgrade\r\n\r\nhello")
+		rw.Flush()
+	}))
+	defer server.Close()
+
+	res, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("status code. expected: %d | got: %d", http.StatusSwitchingProtocols, res.StatusCode)
+	}
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("data. expected: hello | got: %s", string(data))
+	}
+
+	if statusCode := <-recorded; statusCode != http.StatusSwitchingProtocols {
+		t.Errorf("recorded status code. expected: %d | got: %d", http.StatusSwitchingProtocols, statusCode)
+	}
+}
@@ -4,8 +4,8 @@ import (
 	"log/slog"
 	"net/http"
 
+	"github.com/bloom42/stdx/guid"
 	"github.com/bloom42/stdx/log/slogx"
-	"github.com/bloom42/stdx/ulid"
 )
 
 // SetLogger injects `logger` in the context of requests
@@ -18,7 +18,7 @@ func SetLogger(logger *slog.Logger) func(next http.Handler) http.Handler {
 			)
 
 			reqIDContextValue := ctx.Value(RequestIDCtxKey)
-			if requestID, ok := reqIDContextValue.(ulid.ULID); ok {
+			if requestID, ok := reqIDContextValue.(guid.GUID); ok {
 				routeLogger = routeLogger.With(slog.String("request_id", requestID.String()))
 			}
 
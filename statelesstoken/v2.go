@@ -0,0 +1,314 @@
+package statelesstoken
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bloom42/stdx/crypto"
+	"github.com/bloom42/stdx/uuid"
+)
+
+// v2 tokens have the form "v2.<payload>.<mac>" where payload is base64url-encoded:
+//
+//	key_id (4 bytes) || id (16 bytes) || issued_at (8 bytes) || not_before (8 bytes) || expire (8 bytes)
+//	|| audience_length (1 byte) || audience || data
+//
+// Integers are big endian and timestamps are Unix seconds. The claims are encoded in binary to keep tokens
+// short (e.g. in the URLs of links), but data is encoded with encoding/json: it's the only encoding of the
+// standard library which supports any type without requiring it to implement an interface, and the types
+// of the data can use short JSON field names to keep tokens short.
+//
+// mac is computed with a key derived from the key key_id of a crypto.Keyring, so keys can be rotated
+// without invalidating the tokens in flight.
+//
+// The audience prevents a token minted for a purpose (e.g. "password_reset") from being accepted for
+// another one (e.g. "email_verification").
+
+const (
+	// DefaultClockSkew is the clock skew tolerated by default when checking the "nbf" and "exp" claims
+	DefaultClockSkew = time.Minute
+
+	// MaxTokenSize is the maximum size of the string representation of a v2 token
+	MaxTokenSize = 4096
+
+	versionPrefixV2 = "v2."
+	macKeyInfoV2    = "com.bloom42.stdx.statelesstoken.v2"
+
+	maxAudienceSizeV2 = 255
+	// claimsSizeV2 is the size of the encoded claims of a v2 token, without the audience
+	claimsSizeV2 = 4 + uuid.Size + 8 + 8 + 8 + 1
+	macSizeV2    = crypto.KeySize256
+	// encodedMacSizeV2 is the size of the separator and of the base64url-encoded MAC which follow the payload
+	encodedMacSizeV2 = 1 + (macSizeV2*8+5)/6
+	// maxPayloadSizeV2 is the maximum size of the version prefix and of the encoded payload of a v2 token
+	maxPayloadSizeV2 = MaxTokenSize - encodedMacSizeV2
+)
+
+var (
+	ErrTokenIsTooLong          = errors.New("token is too long")
+	ErrTokenExpired            = errors.New("token has expired")
+	ErrTokenNotYetValid        = errors.New("token is not yet valid")
+	ErrAudienceIsNotValid      = errors.New("token audience is not valid")
+	errAudienceIsEmpty         = errors.New("audience can't be empty")
+	errAudienceIsTooLong       = errors.New("audience is too long")
+	errExpireIsBeforeNotBefore = errors.New("expire must be after not before")
+)
+
+// Claims are the standard claims of a v2 token
+type Claims struct {
+	// Audience is the intended use of the token. Required.
+	Audience string
+	// Expire is the time after which the token is no longer valid. Required.
+	Expire time.Time
+	// NotBefore is the time before which the token is not yet valid.
+	// default: issued at
+	NotBefore time.Time
+	// ID uniquely identifies the token, e.g. to revoke it.
+	// default: a random UUID
+	ID uuid.UUID
+}
+
+// Token is a v2 stateless token, carrying standard claims and a typed payload of type T, which is
+// serialized with encoding/json.
+type Token[T any] struct {
+	payload   tokenPayloadV2[T]
+	signature []byte
+	str       string
+}
+
+type tokenPayloadV2[T any] struct {
+	KeyID     crypto.KeyID
+	ID        uuid.UUID
+	Audience  string
+	IssuedAt  int64
+	NotBefore int64
+	Expire    int64
+	Data      T
+}
+
+func (payload *tokenPayloadV2[T]) marshal() (encoded []byte, err error) {
+	data, err := json.Marshal(payload.Data)
+	if err != nil {
+		return
+	}
+
+	encoded = make([]byte, 0, claimsSizeV2+len(payload.Audience)+len(data))
+	encoded = binary.BigEndian.AppendUint32(encoded, uint32(payload.KeyID))
+	encoded = append(encoded, payload.ID[:]...)
+	encoded = binary.BigEndian.AppendUint64(encoded, uint64(payload.IssuedAt))
+	encoded = binary.BigEndian.AppendUint64(encoded, uint64(payload.NotBefore))
+	encoded = binary.BigEndian.AppendUint64(encoded, uint64(payload.Expire))
+	encoded = append(encoded, byte(len(payload.Audience)))
+	encoded = append(encoded, payload.Audience...)
+	encoded = append(encoded, data...)
+	return
+}
+
+func (payload *tokenPayloadV2[T]) unmarshal(encoded []byte) (err error) {
+	if len(encoded) < claimsSizeV2 {
+		return ErrTokenIsNotValid
+	}
+
+	payload.KeyID = crypto.KeyID(binary.BigEndian.Uint32(encoded[0:4]))
+	copy(payload.ID[:], encoded[4:20])
+	payload.IssuedAt = int64(binary.BigEndian.Uint64(encoded[20:28]))
+	payload.NotBefore = int64(binary.BigEndian.Uint64(encoded[28:36]))
+	payload.Expire = int64(binary.BigEndian.Uint64(encoded[36:44]))
+
+	audienceSize := int(encoded[44])
+	encoded = encoded[claimsSizeV2:]
+	if len(encoded) < audienceSize {
+		return ErrTokenIsNotValid
+	}
+	payload.Audience = string(encoded[:audienceSize])
+
+	err = json.Unmarshal(encoded[audienceSize:], &payload.Data)
+	if err != nil {
+		return ErrTokenIsNotValid
+	}
+	return
+}
+
+// NewV2 creates a new v2 token carrying data, signed with the primary key of keyring
+func NewV2[T any](keyring *crypto.Keyring, claims Claims, data T) (token Token[T], err error) {
+	if claims.Audience == "" {
+		err = errAudienceIsEmpty
+		return
+	}
+	if len(claims.Audience) > maxAudienceSizeV2 {
+		err = errAudienceIsTooLong
+		return
+	}
+
+	now := time.Now()
+	if claims.NotBefore.IsZero() {
+		claims.NotBefore = now
+	}
+	if !claims.Expire.After(claims.NotBefore) {
+		err = errExpireIsBeforeNotBefore
+		return
+	}
+	if claims.ID == uuid.Nil {
+		claims.ID, err = uuid.NewRandom()
+		if err != nil {
+			return
+		}
+	}
+
+	token.payload = tokenPayloadV2[T]{
+		KeyID:     keyring.Primary(),
+		ID:        claims.ID,
+		Audience:  claims.Audience,
+		IssuedAt:  now.Unix(),
+		NotBefore: claims.NotBefore.Unix(),
+		Expire:    claims.Expire.Unix(),
+		Data:      data,
+	}
+
+	payload, err := token.payload.marshal()
+	if err != nil {
+		return
+	}
+
+	token.str = versionPrefixV2 + base64.RawURLEncoding.EncodeToString(payload)
+	if len(token.str) > maxPayloadSizeV2 {
+		err = ErrTokenIsTooLong
+		return
+	}
+
+	token.signature, err = macV2(keyring, token.payload.KeyID, token.str)
+	if err != nil {
+		return
+	}
+
+	token.str += "." + base64.RawURLEncoding.EncodeToString(token.signature)
+	return
+}
+
+// ParseV2 parses a v2 token. The token MUST then be verified with Verify before trusting its content.
+func ParseV2[T any](tokenStr string) (token Token[T], err error) {
+	if len(tokenStr) > MaxTokenSize {
+		err = ErrTokenIsTooLong
+		return
+	}
+
+	token.str = tokenStr
+	parts := strings.Split(tokenStr, ".")
+	if len(parts) != 3 || parts[0]+"." != versionPrefixV2 {
+		err = ErrTokenIsNotValid
+		return
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		err = ErrTokenIsNotValid
+		return
+	}
+
+	err = token.payload.unmarshal(payload)
+	if err != nil {
+		return
+	}
+
+	token.signature, err = base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(token.signature) != macSizeV2 {
+		err = ErrTokenIsNotValid
+		return
+	}
+
+	return
+}
+
+// Verify verifies the signature of the token with the key of keyring it was signed with, and checks
+// that the token is intended for audience and is valid at the current time, with clockSkew of tolerance.
+// DefaultClockSkew is a sensible value for clockSkew.
+func (token *Token[T]) Verify(keyring *crypto.Keyring, audience string, clockSkew time.Duration) (err error) {
+	separator := strings.LastIndexByte(token.str, '.')
+	if separator == -1 || token.signature == nil {
+		err = ErrTokenIsNotValid
+		return
+	}
+
+	signature, err := macV2(keyring, token.payload.KeyID, token.str[:separator])
+	if errors.Is(err, crypto.ErrKeyNotFound) {
+		err = fmt.Errorf("%w: %w", ErrTokenIsNotValid, err)
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	if !crypto.ConstantTimeCompare(signature, token.signature) {
+		err = ErrTokenIsNotValid
+		return
+	}
+
+	if token.payload.Audience != audience {
+		err = ErrAudienceIsNotValid
+		return
+	}
+
+	now := time.Now()
+	if now.Add(clockSkew).Before(token.NotBefore()) {
+		err = ErrTokenNotYetValid
+		return
+	}
+	if !now.Add(-clockSkew).Before(token.Expire()) {
+		err = ErrTokenExpired
+		return
+	}
+
+	return
+}
+
+func (token *Token[T]) String() string {
+	return token.str
+}
+
+func (token *Token[T]) Version() uint8 {
+	return 2
+}
+
+// KeyID returns the ID of the key of the keyring the token is signed with
+func (token *Token[T]) KeyID() crypto.KeyID {
+	return token.payload.KeyID
+}
+
+func (token *Token[T]) ID() uuid.UUID {
+	return token.payload.ID
+}
+
+func (token *Token[T]) Audience() string {
+	return token.payload.Audience
+}
+
+func (token *Token[T]) IssuedAt() time.Time {
+	return time.Unix(token.payload.IssuedAt, 0).UTC()
+}
+
+func (token *Token[T]) NotBefore() time.Time {
+	return time.Unix(token.payload.NotBefore, 0).UTC()
+}
+
+func (token *Token[T]) Expire() time.Time {
+	return time.Unix(token.payload.Expire, 0).UTC()
+}
+
+func (token *Token[T]) Data() T {
+	return token.payload.Data
+}
+
+func macV2(keyring *crypto.Keyring, keyID crypto.KeyID, versionAndPayload string) (signature []byte, err error) {
+	macKey, err := keyring.DeriveKey(keyID, []byte(macKeyInfoV2), crypto.KeySize256)
+	if err != nil {
+		return
+	}
+	defer crypto.Zeroize(macKey)
+
+	return crypto.Mac(macKey, []byte(versionAndPayload), macSizeV2)
+}
@@ -0,0 +1,250 @@
+package statelesstoken_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bloom42/stdx/crypto"
+	"github.com/bloom42/stdx/statelesstoken"
+)
+
+type testPayload struct {
+	AccountID int64  `json:"a"`
+	Email     string `json:"e"`
+}
+
+func newTestKeyring(t *testing.T, ids ...crypto.KeyID) *crypto.Keyring {
+	keys := make(map[crypto.KeyID][]byte, len(ids))
+	for _, id := range ids {
+		key, err := crypto.NewAEADKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[id] = key
+	}
+
+	keyring, err := crypto.NewKeyring(keys, ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func TestV2(t *testing.T) {
+	keyring := newTestKeyring(t, 1)
+	data := testPayload{AccountID: 42, Email: "hello@example.com"}
+	claims := statelesstoken.Claims{
+		Audience: "email_verification",
+		Expire:   time.Now().Add(time.Hour),
+	}
+
+	newToken, err := statelesstoken.NewV2(keyring, claims, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsedToken, err := statelesstoken.ParseV2[testPayload](newToken.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = parsedToken.Verify(keyring, "email_verification", statelesstoken.DefaultClockSkew)
+	if err != nil {
+		t.Errorf("verifying token: %v", err)
+	}
+
+	if parsedToken.Data() != data {
+		t.Errorf("token.Data() (%v) != data (%v)", parsedToken.Data(), data)
+	}
+	if !parsedToken.ID().Equal(newToken.ID()) {
+		t.Errorf("token.ID (%v) != parsedToken.ID (%v)", newToken.ID(), parsedToken.ID())
+	}
+	if parsedToken.KeyID() != 1 || parsedToken.Version() != 2 || parsedToken.Audience() != "email_verification" {
+		t.Errorf("parsed token has unexpected claims: %d %d %s", parsedToken.KeyID(), parsedToken.Version(),
+			parsedToken.Audience())
+	}
+	if !parsedToken.Expire().Equal(claims.Expire.Truncate(time.Second)) {
+		t.Errorf("token.Expire() (%v) != expire (%v)", parsedToken.Expire(), claims.Expire)
+	}
+
+	// a token minted for an audience can't be used for another one
+	err = parsedToken.Verify(keyring, "password_reset", statelesstoken.DefaultClockSkew)
+	if !errors.Is(err, statelesstoken.ErrAudienceIsNotValid) {
+		t.Errorf("expected ErrAudienceIsNotValid, got: %v", err)
+	}
+
+	// the signature must cover the payload
+	otherToken, err := statelesstoken.NewV2(keyring, claims, testPayload{AccountID: 43})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// payload of otherToken with the signature of newToken
+	forgedTokenStr := otherToken.String()[:strings.LastIndexByte(otherToken.String(), '.')] +
+		newToken.String()[strings.LastIndexByte(newToken.String(), '.'):]
+	forgedToken, err := statelesstoken.ParseV2[testPayload](forgedTokenStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = forgedToken.Verify(keyring, "email_verification", statelesstoken.DefaultClockSkew)
+	if !errors.Is(err, statelesstoken.ErrTokenIsNotValid) {
+		t.Errorf("expected ErrTokenIsNotValid, got: %v", err)
+	}
+
+	// v1 tokens are not v2 tokens
+	_, err = statelesstoken.ParseV2[testPayload]("v1" + newToken.String()[2:])
+	if !errors.Is(err, statelesstoken.ErrTokenIsNotValid) {
+		t.Errorf("expected ErrTokenIsNotValid, got: %v", err)
+	}
+}
+
+func TestV2KeyRotation(t *testing.T) {
+	keyring := newTestKeyring(t, 1)
+	claims := statelesstoken.Claims{
+		Audience: "test",
+		Expire:   time.Now().Add(time.Hour),
+	}
+
+	oldToken, err := statelesstoken.NewV2(keyring, claims, "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newKey, err := crypto.NewAEADKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = keyring.AddKey(2, newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = keyring.SetPrimary(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newToken, err := statelesstoken.NewV2(keyring, claims, "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newToken.KeyID() != 2 {
+		t.Errorf("expected token to be signed with key 2, got: %d", newToken.KeyID())
+	}
+
+	// tokens signed with the old key are still valid until the key is removed
+	for _, token := range []statelesstoken.Token[string]{oldToken, newToken} {
+		parsedToken, err := statelesstoken.ParseV2[string](token.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = parsedToken.Verify(keyring, "test", statelesstoken.DefaultClockSkew)
+		if err != nil {
+			t.Errorf("verifying token signed with key %d: %v", token.KeyID(), err)
+		}
+	}
+
+	err = keyring.RemoveKey(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsedToken, err := statelesstoken.ParseV2[string](oldToken.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = parsedToken.Verify(keyring, "test", statelesstoken.DefaultClockSkew)
+	if !errors.Is(err, statelesstoken.ErrTokenIsNotValid) || !errors.Is(err, crypto.ErrKeyNotFound) {
+		t.Errorf("expected ErrTokenIsNotValid and ErrKeyNotFound, got: %v", err)
+	}
+}
+
+func TestV2ClockSkew(t *testing.T) {
+	keyring := newTestKeyring(t, 1)
+	now := time.Now()
+
+	expiredToken, err := statelesstoken.NewV2(keyring, statelesstoken.Claims{
+		Audience:  "test",
+		NotBefore: now.Add(-2 * time.Hour),
+		Expire:    now.Add(-30 * time.Second),
+	}, struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = expiredToken.Verify(keyring, "test", 0)
+	if !errors.Is(err, statelesstoken.ErrTokenExpired) {
+		t.Errorf("expected ErrTokenExpired, got: %v", err)
+	}
+	err = expiredToken.Verify(keyring, "test", statelesstoken.DefaultClockSkew)
+	if err != nil {
+		t.Errorf("expired token should be accepted with the clock skew: %v", err)
+	}
+
+	notYetValidToken, err := statelesstoken.NewV2(keyring, statelesstoken.Claims{
+		Audience:  "test",
+		NotBefore: now.Add(30 * time.Second),
+		Expire:    now.Add(time.Hour),
+	}, struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = notYetValidToken.Verify(keyring, "test", 0)
+	if !errors.Is(err, statelesstoken.ErrTokenNotYetValid) {
+		t.Errorf("expected ErrTokenNotYetValid, got: %v", err)
+	}
+	err = notYetValidToken.Verify(keyring, "test", statelesstoken.DefaultClockSkew)
+	if err != nil {
+		t.Errorf("not yet valid token should be accepted with the clock skew: %v", err)
+	}
+
+	_, err = statelesstoken.NewV2(keyring, statelesstoken.Claims{Expire: now.Add(time.Hour)}, struct{}{})
+	if err == nil {
+		t.Error("expected an error for an empty audience")
+	}
+}
+
+func TestV2Size(t *testing.T) {
+	keyring := newTestKeyring(t, 1)
+	claims := statelesstoken.Claims{
+		Audience: "email_verification",
+		Expire:   time.Now().Add(time.Hour),
+	}
+
+	// the largest data accepted produces a token of at most MaxTokenSize
+	largestToken := ""
+	for size := 2900; size < statelesstoken.MaxTokenSize; size += 1 {
+		token, err := statelesstoken.NewV2(keyring, claims, strings.Repeat("a", size))
+		if errors.Is(err, statelesstoken.ErrTokenIsTooLong) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		largestToken = token.String()
+	}
+	if largestToken == "" || len(largestToken) > statelesstoken.MaxTokenSize || len(largestToken) < statelesstoken.MaxTokenSize-4 {
+		t.Fatalf("largest token. expected: about %d bytes | got: %d bytes", statelesstoken.MaxTokenSize, len(largestToken))
+	}
+	parsedToken, err := statelesstoken.ParseV2[string](largestToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = parsedToken.Verify(keyring, "email_verification", statelesstoken.DefaultClockSkew)
+	if err != nil {
+		t.Errorf("verifying largest token: %v", err)
+	}
+
+	_, err = statelesstoken.NewV2(keyring, statelesstoken.Claims{Audience: strings.Repeat("a", 256), Expire: claims.Expire}, "")
+	if err == nil {
+		t.Error("expected an error for a too long audience")
+	}
+
+	// truncated payloads
+	for _, payload := range []string{"", "AAAA", strings.Repeat("A", 60)} {
+		_, err = statelesstoken.ParseV2[string]("v2." + payload + "." + strings.Repeat("A", 43))
+		if !errors.Is(err, statelesstoken.ErrTokenIsNotValid) {
+			t.Errorf("payload %q. expected: %v | got: %v", payload, statelesstoken.ErrTokenIsNotValid, err)
+		}
+	}
+}
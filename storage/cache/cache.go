@@ -0,0 +1,394 @@
+// Package cache provides a storage.ListingStorage which caches the objects of another storage on the local disk.
+//
+// Objects are cached on the first GetObject and evicted in LRU order when the size of the cache
+// exceeds Config.MaxSize. Cached objects older than Config.RevalidateAfter are revalidated against the
+// ETag of the object in the backend storage before being served.
+// Writes and deletes through the CacheStorage invalidate the cached objects once they are applied to the
+// backend storage, including the objects being fetched at that time.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bloom42/stdx/storage"
+	"github.com/bloom42/stdx/storage/filesystem"
+)
+
+// we do this to have a compile-time error if CacheStorage no longer satisfies the ListingStorage interface
+var _ storage.ListingStorage = (*CacheStorage)(nil)
+
+const (
+	DefaultMaxSize         int64 = 1024 * 1024 * 1024
+	DefaultRevalidateAfter       = time.Minute
+)
+
+type Config struct {
+	// Directory is the local directory where objects are cached.
+	// It must be dedicated to the cache as its content is deleted by NewCacheStorage.
+	Directory string
+
+	// MaxSize is the maximum size of the cache, in bytes
+	// default: 1 GiB
+	MaxSize int64
+
+	// MaxObjectSize is the size, in bytes, above which objects are not cached
+	// default: MaxSize / 10
+	MaxObjectSize int64
+
+	// RevalidateAfter is the duration after which cached objects are revalidated with the backend storage.
+	// A negative value disables revalidation.
+	// default: 1 minute
+	RevalidateAfter time.Duration
+}
+
+// Stats are the statistics of a CacheStorage
+type Stats struct {
+	Hits          uint64
+	Misses        uint64
+	Revalidations uint64
+	Evictions     uint64
+	// Objects is the number of objects currently cached
+	Objects int64
+	// Size is the size, in bytes, of the objects currently cached
+	Size int64
+}
+
+type CacheStorage struct {
+	backend storage.ListingStorage
+	local   *filesystem.FilesystemStorage
+	config  Config
+
+	mutex   sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+	// inflight tracks the objects being fetched from the backend so concurrent misses of the same
+	// object only fetch it once
+	inflight map[string]*inflightFetch
+
+	hits          atomic.Uint64
+	misses        atomic.Uint64
+	revalidations atomic.Uint64
+	evictions     atomic.Uint64
+}
+
+type entry struct {
+	key         string
+	localKey    string
+	info        storage.ObjectInfo
+	validatedAt time.Time
+}
+
+type inflightFetch struct {
+	done chan struct{}
+	// invalidated is set when the object is written or deleted while it's being fetched, in which case the
+	// fetched version of the object is not cached
+	invalidated bool
+}
+
+func NewCacheStorage(ctx context.Context, backend storage.ListingStorage, config Config) (cacheStorage *CacheStorage, err error) {
+	if config.Directory == "" {
+		err = errors.New("storage.cache: Directory is required")
+		return
+	}
+	if config.MaxSize <= 0 {
+		config.MaxSize = DefaultMaxSize
+	}
+	if config.MaxObjectSize <= 0 {
+		config.MaxObjectSize = config.MaxSize / 10
+	}
+	if config.RevalidateAfter == 0 {
+		config.RevalidateAfter = DefaultRevalidateAfter
+	}
+
+	local := filesystem.NewFilesystemStorage(filesystem.Config{BaseDirectory: config.Directory})
+
+	// we don't know the ETags of the objects cached by a previous process so we start with an empty cache
+	err = local.DeleteObjectsWithPrefix(ctx, "")
+	if err != nil {
+		err = fmt.Errorf("storage.cache: cleaning cache directory: %w", err)
+		return
+	}
+
+	cacheStorage = &CacheStorage{
+		backend:  backend,
+		local:    local,
+		config:   config,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+		inflight: make(map[string]*inflightFetch),
+	}
+	return
+}
+
+// Stats returns the statistics of the cache
+func (cache *CacheStorage) Stats() Stats {
+	cache.mutex.Lock()
+	objects := int64(cache.lru.Len())
+	size := cache.size
+	cache.mutex.Unlock()
+
+	return Stats{
+		Hits:          cache.hits.Load(),
+		Misses:        cache.misses.Load(),
+		Revalidations: cache.revalidations.Load(),
+		Evictions:     cache.evictions.Load(),
+		Objects:       objects,
+		Size:          size,
+	}
+}
+
+func (cache *CacheStorage) BasePath() string {
+	return cache.backend.BasePath()
+}
+
+// The objects are invalidated after the writes so that the fetches started before a write can't cache the
+// previous version of an object.
+
+func (cache *CacheStorage) CopyObject(ctx context.Context, from, to string) (err error) {
+	err = cache.backend.CopyObject(ctx, from, to)
+	cache.invalidate(to)
+	return
+}
+
+func (cache *CacheStorage) DeleteObject(ctx context.Context, key string) (err error) {
+	err = cache.backend.DeleteObject(ctx, key)
+	cache.invalidate(key)
+	return
+}
+
+func (cache *CacheStorage) GetObject(ctx context.Context, key string) (object io.ReadCloser, err error) {
+	object, _, err = cache.GetObjectWithInfo(ctx, key)
+	return
+}
+
+func (cache *CacheStorage) GetObjectWithInfo(ctx context.Context, key string) (object io.ReadCloser, info storage.ObjectInfo, err error) {
+	for {
+		cache.mutex.Lock()
+		if element, isCached := cache.entries[key]; isCached {
+			cachedEntry := *element.Value.(*entry)
+			cache.lru.MoveToFront(element)
+			cache.mutex.Unlock()
+
+			if cache.isFresh(cachedEntry) || cache.revalidate(ctx, cachedEntry) {
+				object, err = cache.local.GetObject(ctx, cachedEntry.localKey)
+				if err == nil {
+					cache.hits.Add(1)
+					info = cachedEntry.info
+					return
+				}
+			}
+
+			// the cached object is stale or missing
+			cache.invalidate(key)
+			continue
+		}
+
+		if inflight, isInflight := cache.inflight[key]; isInflight {
+			cache.mutex.Unlock()
+			select {
+			case <-inflight.done:
+				// the object may now be cached
+				continue
+			case <-ctx.Done():
+				err = ctx.Err()
+				return
+			}
+		}
+
+		inflight := &inflightFetch{done: make(chan struct{})}
+		cache.inflight[key] = inflight
+		cache.mutex.Unlock()
+
+		cache.misses.Add(1)
+		object, info, err = cache.fetch(ctx, key, inflight)
+
+		cache.mutex.Lock()
+		delete(cache.inflight, key)
+		cache.mutex.Unlock()
+		close(inflight.done)
+		return
+	}
+}
+
+func (cache *CacheStorage) GetObjectSize(ctx context.Context, key string) (int64, error) {
+	return cache.backend.GetObjectSize(ctx, key)
+}
+
+func (cache *CacheStorage) StatObject(ctx context.Context, key string) (storage.ObjectInfo, error) {
+	return cache.backend.StatObject(ctx, key)
+}
+
+func (cache *CacheStorage) ListObjects(ctx context.Context, prefix string, recursive bool) ([]storage.ObjectInfo, error) {
+	return cache.backend.ListObjects(ctx, prefix, recursive)
+}
+
+func (cache *CacheStorage) PutObject(ctx context.Context, key, contentType string, size int64, object io.Reader) (err error) {
+	err = cache.backend.PutObject(ctx, key, contentType, size, object)
+	cache.invalidate(key)
+	return
+}
+
+func (cache *CacheStorage) DeleteObjectsWithPrefix(ctx context.Context, prefix string) (err error) {
+	err = cache.backend.DeleteObjectsWithPrefix(ctx, prefix)
+
+	cache.mutex.Lock()
+	keys := make([]string, 0)
+	for key := range cache.entries {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range cache.inflight {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	cache.mutex.Unlock()
+
+	for _, key := range keys {
+		cache.invalidate(key)
+	}
+
+	return
+}
+
+// fetch gets an object from the backend storage, and caches it if it's not too large and if it has not been
+// invalidated in the meantime. The metadata of the object are those of the fetched version of the object.
+func (cache *CacheStorage) fetch(ctx context.Context, key string, inflight *inflightFetch) (object io.ReadCloser, info storage.ObjectInfo, err error) {
+	backendObject, info, err := cache.backend.GetObjectWithInfo(ctx, key)
+	if err != nil {
+		return
+	}
+
+	if info.Size > cache.config.MaxObjectSize {
+		object = backendObject
+		return
+	}
+	defer backendObject.Close()
+
+	localKey := localKey(key)
+	// the local file is deleted before being written so readers of a previous version of the object
+	// keep reading their own version
+	_ = cache.local.DeleteObject(ctx, localKey)
+	err = cache.local.PutObject(ctx, localKey, "", info.Size, backendObject)
+	if err != nil {
+		err = fmt.Errorf("storage.cache: caching object: %w", err)
+		return
+	}
+
+	object, err = cache.local.GetObject(ctx, localKey)
+	if err != nil {
+		err = fmt.Errorf("storage.cache: opening cached object: %w", err)
+		return
+	}
+
+	added := cache.add(&entry{
+		key:         key,
+		localKey:    localKey,
+		info:        info,
+		validatedAt: time.Now(),
+	}, inflight)
+	if !added {
+		// the fetched version is still served to this reader, which has already opened it
+		_ = cache.local.DeleteObject(ctx, localKey)
+	}
+
+	return
+}
+
+func (cache *CacheStorage) isFresh(cachedEntry entry) bool {
+	return cache.config.RevalidateAfter < 0 || time.Since(cachedEntry.validatedAt) < cache.config.RevalidateAfter
+}
+
+// revalidate returns true if the ETag of the cached object still matches the one of the object in the backend
+func (cache *CacheStorage) revalidate(ctx context.Context, cachedEntry entry) bool {
+	cache.revalidations.Add(1)
+
+	info, err := cache.backend.StatObject(ctx, cachedEntry.key)
+	if err != nil || cachedEntry.info.ETag == "" || info.ETag != cachedEntry.info.ETag {
+		return false
+	}
+
+	cache.mutex.Lock()
+	if element, isCached := cache.entries[cachedEntry.key]; isCached {
+		element.Value.(*entry).validatedAt = time.Now()
+	}
+	cache.mutex.Unlock()
+
+	return true
+}
+
+// add adds newEntry to the cache unless the object has been invalidated while being fetched
+func (cache *CacheStorage) add(newEntry *entry, inflight *inflightFetch) (added bool) {
+	var evicted []*entry
+
+	cache.mutex.Lock()
+	if inflight.invalidated {
+		cache.mutex.Unlock()
+		return
+	}
+	added = true
+
+	if element, isCached := cache.entries[newEntry.key]; isCached {
+		cache.size -= element.Value.(*entry).info.Size
+		cache.lru.Remove(element)
+	}
+	cache.entries[newEntry.key] = cache.lru.PushFront(newEntry)
+	cache.size += newEntry.info.Size
+
+	for cache.size > cache.config.MaxSize && cache.lru.Len() > 1 {
+		oldest := cache.lru.Back()
+		oldestEntry := oldest.Value.(*entry)
+		cache.lru.Remove(oldest)
+		delete(cache.entries, oldestEntry.key)
+		cache.size -= oldestEntry.info.Size
+		evicted = append(evicted, oldestEntry)
+	}
+	cache.mutex.Unlock()
+
+	for _, evictedEntry := range evicted {
+		cache.evictions.Add(1)
+		_ = cache.local.DeleteObject(context.Background(), evictedEntry.localKey)
+	}
+	return
+}
+
+// invalidate removes key from the cache, and prevents the fetch of key in progress, if any, from caching it
+func (cache *CacheStorage) invalidate(key string) {
+	cache.mutex.Lock()
+	if inflight, isInflight := cache.inflight[key]; isInflight {
+		inflight.invalidated = true
+	}
+	element, isCached := cache.entries[key]
+	if !isCached {
+		cache.mutex.Unlock()
+		return
+	}
+	cachedEntry := element.Value.(*entry)
+	cache.lru.Remove(element)
+	delete(cache.entries, key)
+	cache.size -= cachedEntry.info.Size
+	cache.mutex.Unlock()
+
+	_ = cache.local.DeleteObject(context.Background(), cachedEntry.localKey)
+}
+
+// localKey returns the key of the cached object in the local storage. Keys are hashed so that any
+// backend key can be stored on the local filesystem.
+func localKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	hexHash := hex.EncodeToString(hash[:])
+	return path.Join(hexHash[:2], hexHash)
+}
@@ -0,0 +1,218 @@
+package cache_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bloom42/stdx/storage"
+	"github.com/bloom42/stdx/storage/cache"
+	"github.com/bloom42/stdx/storage/filesystem"
+)
+
+func readObject(t *testing.T, storage storage.Storage, key string) string {
+	object, err := storage.GetObject(context.Background(), key)
+	if err != nil {
+		t.Fatalf("getting object %s: %v", key, err)
+	}
+	defer object.Close()
+
+	data, err := io.ReadAll(object)
+	if err != nil {
+		t.Fatalf("reading object %s: %v", key, err)
+	}
+	return string(data)
+}
+
+func putObject(t *testing.T, storage storage.Storage, key, content string) {
+	err := storage.PutObject(context.Background(), key, "text/plain", int64(len(content)), strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("putting object %s: %v", key, err)
+	}
+}
+
+func TestCacheStorage(t *testing.T) {
+	ctx := context.Background()
+	backend := filesystem.NewFilesystemStorage(filesystem.Config{BaseDirectory: t.TempDir()})
+	cacheStorage, err := cache.NewCacheStorage(ctx, backend, cache.Config{Directory: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	putObject(t, cacheStorage, "thumbnails/1.png", "hello")
+
+	for i := 0; i < 3; i += 1 {
+		if content := readObject(t, cacheStorage, "thumbnails/1.png"); content != "hello" {
+			t.Errorf("content. expected: hello | got: %s", content)
+		}
+	}
+
+	stats := cacheStorage.Stats()
+	if stats.Misses != 1 || stats.Hits != 2 || stats.Objects != 1 || stats.Size != 5 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// writes invalidate the cache
+	putObject(t, cacheStorage, "thumbnails/1.png", "world")
+	if content := readObject(t, cacheStorage, "thumbnails/1.png"); content != "world" {
+		t.Errorf("content after write. expected: world | got: %s", content)
+	}
+
+	err = cacheStorage.DeleteObject(ctx, "thumbnails/1.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cacheStorage.GetObject(ctx, "thumbnails/1.png"); err == nil {
+		t.Error("expected error for deleted object")
+	}
+}
+
+func TestCacheStorageEviction(t *testing.T) {
+	ctx := context.Background()
+	backend := filesystem.NewFilesystemStorage(filesystem.Config{BaseDirectory: t.TempDir()})
+	cacheStorage, err := cache.NewCacheStorage(ctx, backend, cache.Config{
+		Directory:     t.TempDir(),
+		MaxSize:       10,
+		MaxObjectSize: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	putObject(t, backend, "a", "aaaaa")
+	putObject(t, backend, "b", "bbbbb")
+	putObject(t, backend, "c", "ccccc")
+
+	readObject(t, cacheStorage, "a")
+	readObject(t, cacheStorage, "b")
+	readObject(t, cacheStorage, "a")
+	// a was used more recently than b so b is evicted
+	readObject(t, cacheStorage, "c")
+
+	stats := cacheStorage.Stats()
+	if stats.Evictions != 1 || stats.Objects != 2 || stats.Size != 10 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	readObject(t, cacheStorage, "a")
+	if hits := cacheStorage.Stats().Hits; hits != 2 {
+		t.Errorf("hits. expected: 2 | got: %d", hits)
+	}
+}
+
+func TestCacheStorageRevalidation(t *testing.T) {
+	ctx := context.Background()
+	backend := filesystem.NewFilesystemStorage(filesystem.Config{BaseDirectory: t.TempDir()})
+	cacheStorage, err := cache.NewCacheStorage(ctx, backend, cache.Config{
+		Directory:       t.TempDir(),
+		RevalidateAfter: time.Nanosecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	putObject(t, backend, "key", "hello")
+	readObject(t, cacheStorage, "key")
+
+	// the object is modified without going through the cache
+	putObject(t, backend, "key", "hello world")
+	if content := readObject(t, cacheStorage, "key"); content != "hello world" {
+		t.Errorf("content. expected: hello world | got: %s", content)
+	}
+
+	stats := cacheStorage.Stats()
+	if stats.Revalidations != 1 || stats.Misses != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+// slowBackend is a backend where GetObjectWithInfo reads the object, then waits until resume is closed before
+// returning it, like a slow download
+type slowBackend struct {
+	*filesystem.FilesystemStorage
+	fetched chan struct{}
+	resume  chan struct{}
+}
+
+func (backend *slowBackend) GetObjectWithInfo(ctx context.Context, key string) (io.ReadCloser, storage.ObjectInfo, error) {
+	object, info, err := backend.FilesystemStorage.GetObjectWithInfo(ctx, key)
+	if err != nil {
+		return nil, info, err
+	}
+	defer object.Close()
+
+	data, err := io.ReadAll(object)
+	if err != nil {
+		return nil, info, err
+	}
+
+	backend.fetched <- struct{}{}
+	<-backend.resume
+	return io.NopCloser(bytes.NewReader(data)), info, nil
+}
+
+func TestCacheStorageWriteDuringFetch(t *testing.T) {
+	ctx := context.Background()
+	backend := &slowBackend{
+		FilesystemStorage: filesystem.NewFilesystemStorage(filesystem.Config{BaseDirectory: t.TempDir()}),
+		fetched:           make(chan struct{}),
+		resume:            make(chan struct{}),
+	}
+	cacheStorage, err := cache.NewCacheStorage(ctx, backend, cache.Config{
+		Directory:       t.TempDir(),
+		RevalidateAfter: -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	putObject(t, backend.FilesystemStorage, "key", "hello")
+
+	contentBeforeWrite := make(chan string)
+	go func() {
+		object, err := cacheStorage.GetObject(ctx, "key")
+		if err != nil {
+			contentBeforeWrite <- err.Error()
+			return
+		}
+		defer object.Close()
+		data, _ := io.ReadAll(object)
+		contentBeforeWrite <- string(data)
+	}()
+
+	// the object is written while the previous version is being fetched
+	<-backend.fetched
+	putObject(t, cacheStorage, "key", "world")
+	close(backend.resume)
+
+	if content := <-contentBeforeWrite; content != "hello" {
+		t.Errorf("content of the fetch started before the write. expected: hello | got: %s", content)
+	}
+
+	go func() {
+		for range backend.fetched {
+		}
+	}()
+	defer close(backend.fetched)
+
+	// the previous version must not have been cached
+	if content := readObject(t, cacheStorage, "key"); content != "world" {
+		t.Errorf("content after write. expected: world | got: %s", content)
+	}
+
+	object, info, err := cacheStorage.GetObjectWithInfo(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	object.Close()
+	backendInfo, err := backend.StatObject(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ETag != backendInfo.ETag || info.Size != 5 {
+		t.Errorf("info. expected: %s 5 | got: %s %d", backendInfo.ETag, info.ETag, info.Size)
+	}
+}
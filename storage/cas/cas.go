@@ -0,0 +1,387 @@
+// Package cas implements a content-addressed store on top of a storage.ListingStorage: blobs are stored by
+// the hash of their content so identical blobs are only stored once, and are deleted once they are
+// no longer referenced.
+//
+// Put, AddRef and Release can be called concurrently for the same content: a blob is only deleted
+// by RefCounter.DeleteIfUnreferenced, which rechecks that the blob has no references and blocks the
+// concurrent increments until the blob is deleted.
+package cas
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"sync"
+
+	cryptohash "github.com/bloom42/stdx/crypto/hash"
+	"github.com/bloom42/stdx/db"
+	"github.com/bloom42/stdx/guid"
+	"github.com/bloom42/stdx/migrate"
+	"github.com/bloom42/stdx/storage"
+)
+
+type Algorithm string
+
+const (
+	AlgorithmBlake3 Algorithm = "blake3"
+	AlgorithmSha256 Algorithm = "sha256"
+)
+
+var (
+	ErrBlobNotFound       = errors.New("cas: blob not found")
+	ErrHashIsNotValid     = errors.New("cas: hash is not valid")
+	ErrAlgorithmIsInvalid = errors.New("cas: algorithm is not valid")
+)
+
+// RefCounter tracks the number of references to blobs
+type RefCounter interface {
+	// Increment increments the number of references to hash and returns the new number of references
+	Increment(ctx context.Context, hash string) (refs int64, err error)
+	// Decrement decrements the number of references to hash and returns the new number of references.
+	// hash is kept with 0 references until DeleteIfUnreferenced is called.
+	// ErrBlobNotFound is returned if hash has no references.
+	Decrement(ctx context.Context, hash string) (refs int64, err error)
+	// DeleteIfUnreferenced calls deleteBlob and removes hash if hash still has 0 references.
+	// Increment must block until DeleteIfUnreferenced returns, so a blob can't be deleted after having been
+	// referenced again. hash is not removed if deleteBlob returns an error.
+	DeleteIfUnreferenced(ctx context.Context, hash string, deleteBlob func() error) (err error)
+}
+
+type Config struct {
+	// default: blake3
+	Algorithm Algorithm
+	// Prefix is the storage prefix under which blobs are stored
+	// default: cas
+	Prefix string
+	// RefCounter is required
+	RefCounter RefCounter
+}
+
+type Store struct {
+	storage    storage.ListingStorage
+	algorithm  Algorithm
+	prefix     string
+	refCounter RefCounter
+}
+
+func NewStore(storage storage.ListingStorage, config Config) (store *Store, err error) {
+	if config.Algorithm == "" {
+		config.Algorithm = AlgorithmBlake3
+	}
+	if config.Algorithm != AlgorithmBlake3 && config.Algorithm != AlgorithmSha256 {
+		err = ErrAlgorithmIsInvalid
+		return
+	}
+	if config.Prefix == "" {
+		config.Prefix = "cas"
+	}
+	if config.RefCounter == nil {
+		err = errors.New("cas: RefCounter is required")
+		return
+	}
+
+	store = &Store{
+		storage:    storage,
+		algorithm:  config.Algorithm,
+		prefix:     config.Prefix,
+		refCounter: config.RefCounter,
+	}
+	return
+}
+
+// Put stores data and returns the hex-encoded hash of its content. If a blob with the same content
+// already exists, data is not stored again and a reference is added to the existing blob.
+func (store *Store) Put(ctx context.Context, contentType string, size int64, data io.Reader) (hash string, err error) {
+	hasher := store.newHasher()
+
+	// we don't know the hash of data before reading it, so data is first uploaded to a temporary object
+	tmpKey := path.Join(store.prefix, "tmp", guid.NewRandom().String())
+	err = store.storage.PutObject(ctx, tmpKey, contentType, size, io.TeeReader(data, hasher))
+	if err != nil {
+		err = fmt.Errorf("cas: uploading blob: %w", err)
+		return
+	}
+	defer store.storage.DeleteObject(ctx, tmpKey)
+
+	hash = hex.EncodeToString(hasher.Sum(nil))
+
+	refs, err := store.refCounter.Increment(ctx, hash)
+	if err != nil {
+		err = fmt.Errorf("cas: incrementing references: %w", err)
+		return
+	}
+
+	// If the blob is already referenced, it may still be being copied by a concurrent Put, whose copy may fail.
+	// In this case the blob is also copied here: the content is the same, so concurrent copies are harmless,
+	// and the hash is only returned once the blob is known to exist.
+	copyBlob := refs == 1
+	if !copyBlob {
+		_, err = store.storage.StatObject(ctx, store.blobKey(hash))
+		if err != nil {
+			if !errors.Is(err, storage.ErrObjectNotFound) {
+				err = fmt.Errorf("cas: checking blob: %w", err)
+				_, _ = store.refCounter.Decrement(ctx, hash)
+				return
+			}
+			err = nil
+			copyBlob = true
+		}
+	}
+
+	if copyBlob {
+		err = store.storage.CopyObject(ctx, tmpKey, store.blobKey(hash))
+		if err != nil {
+			err = fmt.Errorf("cas: copying blob: %w", err)
+			_, _ = store.refCounter.Decrement(ctx, hash)
+			return
+		}
+	}
+
+	return
+}
+
+// AddRef adds a reference to an existing blob
+func (store *Store) AddRef(ctx context.Context, hash string) (err error) {
+	err = store.checkHash(hash)
+	if err != nil {
+		return
+	}
+
+	// the reference is added before checking that the blob exists, so a concurrent Release can't delete
+	// the blob after it has been checked
+	_, err = store.refCounter.Increment(ctx, hash)
+	if err != nil {
+		err = fmt.Errorf("cas: incrementing references: %w", err)
+		return
+	}
+
+	_, err = store.storage.StatObject(ctx, store.blobKey(hash))
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			err = ErrBlobNotFound
+		} else {
+			err = fmt.Errorf("cas: checking blob: %w", err)
+		}
+		_, _ = store.refCounter.Decrement(ctx, hash)
+		return
+	}
+
+	return
+}
+
+// Release removes a reference to a blob and deletes the blob if it's no longer referenced
+func (store *Store) Release(ctx context.Context, hash string) (err error) {
+	err = store.checkHash(hash)
+	if err != nil {
+		return
+	}
+
+	refs, err := store.refCounter.Decrement(ctx, hash)
+	if err != nil {
+		return
+	}
+
+	if refs <= 0 {
+		err = store.refCounter.DeleteIfUnreferenced(ctx, hash, func() error {
+			return store.storage.DeleteObject(ctx, store.blobKey(hash))
+		})
+		if err != nil {
+			err = fmt.Errorf("cas: deleting blob: %w", err)
+			return
+		}
+	}
+
+	return
+}
+
+func (store *Store) Get(ctx context.Context, hash string) (blob io.ReadCloser, err error) {
+	err = store.checkHash(hash)
+	if err != nil {
+		return
+	}
+
+	return store.storage.GetObject(ctx, store.blobKey(hash))
+}
+
+func (store *Store) Stat(ctx context.Context, hash string) (info storage.ObjectInfo, err error) {
+	err = store.checkHash(hash)
+	if err != nil {
+		return
+	}
+
+	info, err = store.storage.StatObject(ctx, store.blobKey(hash))
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		err = ErrBlobNotFound
+	}
+	return
+}
+
+// blobKey returns the storage key of the blob with the given hash: {prefix}/{algorithm}/{hash[:2]}/{hash}
+// hash must have been validated with checkHash.
+func (store *Store) blobKey(hash string) string {
+	return path.Join(store.prefix, string(store.algorithm), hash[:2], hash)
+}
+
+func (store *Store) newHasher() hash.Hash {
+	switch store.algorithm {
+	case AlgorithmSha256:
+		return cryptohash.NewSha256()
+	default:
+		return cryptohash.NewBlake3()
+	}
+}
+
+// hashes are used to build storage keys so we need to make sure that they are valid
+func (store *Store) checkHash(hash string) error {
+	decoded, err := hex.DecodeString(hash)
+	if err != nil || len(decoded) != 32 {
+		return ErrHashIsNotValid
+	}
+	return nil
+}
+
+// MemoryRefCounter is an in-memory RefCounter, for tests and single-process applications
+type MemoryRefCounter struct {
+	mutex sync.Mutex
+	refs  map[string]int64
+}
+
+func NewMemoryRefCounter() *MemoryRefCounter {
+	return &MemoryRefCounter{
+		refs: make(map[string]int64),
+	}
+}
+
+func (counter *MemoryRefCounter) Increment(ctx context.Context, hash string) (refs int64, err error) {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+
+	counter.refs[hash] += 1
+	return counter.refs[hash], nil
+}
+
+func (counter *MemoryRefCounter) Decrement(ctx context.Context, hash string) (refs int64, err error) {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+
+	refs, exists := counter.refs[hash]
+	if !exists {
+		err = ErrBlobNotFound
+		return
+	}
+
+	if refs <= 0 {
+		err = ErrBlobNotFound
+		return
+	}
+
+	refs -= 1
+	counter.refs[hash] = refs
+	return
+}
+
+func (counter *MemoryRefCounter) DeleteIfUnreferenced(ctx context.Context, hash string, deleteBlob func() error) (err error) {
+	// the mutex is held while the blob is deleted so concurrent increments wait for the deletion
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+
+	refs, exists := counter.refs[hash]
+	if !exists || refs > 0 {
+		return
+	}
+
+	err = deleteBlob()
+	if err != nil {
+		return
+	}
+
+	delete(counter.refs, hash)
+	return
+}
+
+// PostgresRefCounter is a RefCounter storing references in the cas_refs table.
+// See PostgresMigration to create the table.
+type PostgresRefCounter struct {
+	db db.DB
+}
+
+func NewPostgresRefCounter(db db.DB) *PostgresRefCounter {
+	return &PostgresRefCounter{
+		db: db,
+	}
+}
+
+// PostgresMigration returns the migration creating the table used by PostgresRefCounter
+func PostgresMigration(id int64) migrate.Migration {
+	return migrate.Migration{
+		ID: id,
+		Up: func(ctx context.Context, tx db.Queryer) (err error) {
+			_, err = tx.Exec(ctx, `CREATE TABLE cas_refs (
+				hash TEXT PRIMARY KEY,
+				refs BIGINT NOT NULL
+			)`)
+			return
+		},
+		Down: func(ctx context.Context, tx db.Queryer) (err error) {
+			_, err = tx.Exec(ctx, "DROP TABLE cas_refs")
+			return
+		},
+	}
+}
+
+func (counter *PostgresRefCounter) Increment(ctx context.Context, hash string) (refs int64, err error) {
+	query := `INSERT INTO cas_refs (hash, refs) VALUES ($1, 1)
+		ON CONFLICT (hash) DO UPDATE SET refs = cas_refs.refs + 1
+		RETURNING refs`
+
+	err = counter.db.Get(ctx, &refs, query, hash)
+	return
+}
+
+func (counter *PostgresRefCounter) Decrement(ctx context.Context, hash string) (refs int64, err error) {
+	query := "UPDATE cas_refs SET refs = refs - 1 WHERE hash = $1 AND refs > 0 RETURNING refs"
+
+	err = counter.db.Get(ctx, &refs, query, hash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrBlobNotFound
+		}
+		return
+	}
+
+	return
+}
+
+func (counter *PostgresRefCounter) DeleteIfUnreferenced(ctx context.Context, hash string, deleteBlob func() error) (err error) {
+	err = counter.db.Transaction(ctx, func(tx db.Tx) (txErr error) {
+		var refs int64
+
+		// the row stays locked until the end of the transaction, so concurrent increments
+		// (INSERT ... ON CONFLICT DO UPDATE) wait until the blob is deleted
+		txErr = tx.Get(ctx, &refs, "SELECT refs FROM cas_refs WHERE hash = $1 FOR UPDATE", hash)
+		if txErr != nil {
+			if errors.Is(txErr, sql.ErrNoRows) {
+				txErr = nil
+			}
+			return
+		}
+
+		if refs > 0 {
+			return
+		}
+
+		txErr = deleteBlob()
+		if txErr != nil {
+			return
+		}
+
+		_, txErr = tx.Exec(ctx, "DELETE FROM cas_refs WHERE hash = $1", hash)
+		return
+	})
+	return
+}
@@ -0,0 +1,269 @@
+package cas_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bloom42/stdx/db/dbtest"
+	"github.com/bloom42/stdx/migrate"
+	"github.com/bloom42/stdx/storage"
+	"github.com/bloom42/stdx/storage/cas"
+	"github.com/bloom42/stdx/storage/filesystem"
+)
+
+func TestPutAndRelease(t *testing.T) {
+	ctx := context.Background()
+	fsStorage := filesystem.NewFilesystemStorage(filesystem.Config{BaseDirectory: t.TempDir()})
+
+	for _, algorithm := range []cas.Algorithm{cas.AlgorithmBlake3, cas.AlgorithmSha256} {
+		store, err := cas.NewStore(fsStorage, cas.Config{
+			Algorithm:  algorithm,
+			RefCounter: cas.NewMemoryRefCounter(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		content := "hello world"
+		hash1, err := store.Put(ctx, "text/plain", int64(len(content)), strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash2, err := store.Put(ctx, "text/plain", int64(len(content)), strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hash1 != hash2 {
+			t.Errorf("[%s] hashes of the same content don't match: %s != %s", algorithm, hash1, hash2)
+		}
+
+		blob, err := store.Get(ctx, hash1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(blob)
+		blob.Close()
+		if string(data) != content {
+			t.Errorf("[%s] content. expected: %s | got: %s", algorithm, content, string(data))
+		}
+
+		// the blob is still referenced once
+		err = store.Release(ctx, hash1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = store.Stat(ctx, hash1)
+		if err != nil {
+			t.Errorf("[%s] blob should still exist: %v", algorithm, err)
+		}
+
+		err = store.Release(ctx, hash1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = store.Stat(ctx, hash1)
+		if !errors.Is(err, cas.ErrBlobNotFound) {
+			t.Errorf("[%s] expected: %v | got: %v", algorithm, cas.ErrBlobNotFound, err)
+		}
+	}
+}
+
+func TestInvalidHash(t *testing.T) {
+	fsStorage := filesystem.NewFilesystemStorage(filesystem.Config{BaseDirectory: t.TempDir()})
+	store, err := cas.NewStore(fsStorage, cas.Config{RefCounter: cas.NewMemoryRefCounter()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = store.Get(context.Background(), "../../etc/passwd")
+	if !errors.Is(err, cas.ErrHashIsNotValid) {
+		t.Errorf("expected: %v | got: %v", cas.ErrHashIsNotValid, err)
+	}
+}
+
+func TestPutWhenBlobIsMissing(t *testing.T) {
+	ctx := context.Background()
+	fsStorage := filesystem.NewFilesystemStorage(filesystem.Config{BaseDirectory: t.TempDir()})
+	refCounter := cas.NewMemoryRefCounter()
+	store, err := cas.NewStore(fsStorage, cas.Config{Algorithm: cas.AlgorithmSha256, RefCounter: refCounter})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a concurrent Put has incremented the references, but its blob has not been copied (yet)
+	content := "hello world"
+	hash := "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+	_, err = refCounter.Increment(ctx, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	putHash, err := store.Put(ctx, "text/plain", int64(len(content)), strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if putHash != hash {
+		t.Fatalf("hash. expected: %s | got: %s", hash, putHash)
+	}
+
+	_, err = store.Stat(ctx, hash)
+	if err != nil {
+		t.Errorf("blob should exist once Put returns: %v", err)
+	}
+}
+
+func TestConcurrentPutAndRelease(t *testing.T) {
+	testConcurrentPutAndRelease(t, cas.NewMemoryRefCounter())
+}
+
+func TestPostgresRefCounter(t *testing.T) {
+	ctx := context.Background()
+	database := dbtest.New(t, []migrate.Migration{cas.PostgresMigration(1)})
+	refCounter := cas.NewPostgresRefCounter(database)
+	hash := "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+
+	for expected := int64(1); expected <= 2; expected += 1 {
+		refs, err := refCounter.Increment(ctx, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if refs != expected {
+			t.Errorf("Increment. expected: %d | got: %d", expected, refs)
+		}
+	}
+
+	deleteCalls := 0
+	deleteBlob := func() error {
+		deleteCalls += 1
+		return nil
+	}
+
+	refs, err := refCounter.Decrement(ctx, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refs != 1 {
+		t.Errorf("Decrement. expected: 1 | got: %d", refs)
+	}
+
+	// the blob is still referenced
+	err = refCounter.DeleteIfUnreferenced(ctx, hash, deleteBlob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleteCalls != 0 {
+		t.Errorf("deleteBlob should not be called for a referenced blob")
+	}
+
+	refs, err = refCounter.Decrement(ctx, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refs != 0 {
+		t.Errorf("Decrement. expected: 0 | got: %d", refs)
+	}
+
+	_, err = refCounter.Decrement(ctx, hash)
+	if !errors.Is(err, cas.ErrBlobNotFound) {
+		t.Errorf("Decrement of an unreferenced blob. expected: %v | got: %v", cas.ErrBlobNotFound, err)
+	}
+
+	// the row is kept if the blob can't be deleted
+	err = refCounter.DeleteIfUnreferenced(ctx, hash, func() error { return errors.New("storage is unavailable") })
+	if err == nil {
+		t.Error("expected the error of deleteBlob")
+	}
+
+	err = refCounter.DeleteIfUnreferenced(ctx, hash, deleteBlob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleteCalls != 1 {
+		t.Errorf("deleteBlob calls. expected: 1 | got: %d", deleteCalls)
+	}
+
+	var rows int64
+	err = database.Get(ctx, &rows, "SELECT COUNT(*) FROM cas_refs WHERE hash = $1", hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 0 {
+		t.Errorf("rows of unreferenced blob. expected: 0 | got: %d", rows)
+	}
+
+	testConcurrentPutAndRelease(t, refCounter)
+}
+
+// blockingDeleteStorage blocks the deletion of blobs until unblock is closed
+type blockingDeleteStorage struct {
+	storage.ListingStorage
+	deleteStarted chan struct{}
+	unblock       chan struct{}
+}
+
+func (blockingStorage blockingDeleteStorage) DeleteObject(ctx context.Context, key string) error {
+	// temporary objects are deleted by Put
+	if !strings.Contains(key, "/tmp/") {
+		blockingStorage.deleteStarted <- struct{}{}
+		<-blockingStorage.unblock
+	}
+	return blockingStorage.ListingStorage.DeleteObject(ctx, key)
+}
+
+// testConcurrentPutAndRelease puts a blob while its last reference is being released, and checks that the blob
+// still exists once Put returns
+func testConcurrentPutAndRelease(t *testing.T, refCounter cas.RefCounter) {
+	t.Helper()
+
+	ctx := context.Background()
+	blockingStorage := blockingDeleteStorage{
+		ListingStorage: filesystem.NewFilesystemStorage(filesystem.Config{BaseDirectory: t.TempDir()}),
+		deleteStarted:  make(chan struct{}, 1),
+		unblock:        make(chan struct{}),
+	}
+	store, err := cas.NewStore(blockingStorage, cas.Config{RefCounter: refCounter})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := "hello world"
+	hash, err := store.Put(ctx, "text/plain", int64(len(content)), strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	releaseErr := make(chan error, 1)
+	go func() {
+		releaseErr <- store.Release(ctx, hash)
+	}()
+	<-blockingStorage.deleteStarted
+
+	// the blob is being deleted when it's put again
+	putErr := make(chan error, 1)
+	go func() {
+		_, err := store.Put(ctx, "text/plain", int64(len(content)), strings.NewReader(content))
+		putErr <- err
+	}()
+
+	// leaves the time to Put to (wrongly) complete before the blob is deleted
+	time.Sleep(20 * time.Millisecond)
+	close(blockingStorage.unblock)
+
+	err = <-releaseErr
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = <-putErr
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = store.Stat(ctx, hash)
+	if err != nil {
+		t.Errorf("blob should exist while it's referenced: %v", err)
+	}
+}
@@ -3,12 +3,19 @@ package filesystem
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
+
+	stdxstorage "github.com/bloom42/stdx/storage"
 )
 
+// we do this to have a compile-time error if FilesystemStorage no longer satisfies the ListingStorage interface
+var _ stdxstorage.ListingStorage = (*FilesystemStorage)(nil)
+
 type FilesystemStorage struct {
 	basePath string
 }
@@ -46,6 +53,11 @@ func (storage *FilesystemStorage) CopyObject(ctx context.Context, from string, t
 	defer source.Close()
 
 	to = filepath.Join(storage.basePath, to)
+	err = os.MkdirAll(filepath.Dir(to), os.ModePerm)
+	if err != nil {
+		return
+	}
+
 	destination, err := os.Create(to)
 	if err != nil {
 		return
@@ -74,6 +86,13 @@ func (storage *FilesystemStorage) GetObject(ctx context.Context, key string) (fi
 
 	filePath := filepath.Join(storage.basePath, key)
 	file, err = os.OpenFile(filePath, os.O_RDONLY, os.ModePerm)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = stdxstorage.ErrObjectNotFound
+		}
+		return
+	}
+
 	return
 }
 
@@ -94,6 +113,130 @@ func (storage *FilesystemStorage) GetObjectSize(ctx context.Context, key string)
 	return
 }
 
+func (storage *FilesystemStorage) StatObject(ctx context.Context, key string) (info stdxstorage.ObjectInfo, err error) {
+	if strings.Contains(key, "..") {
+		err = ErrKeyIsNotValid
+		return
+	}
+
+	filePath := filepath.Join(storage.basePath, key)
+
+	fileStat, err := os.Stat(filePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = stdxstorage.ErrObjectNotFound
+		}
+		return
+	}
+
+	if fileStat.IsDir() {
+		err = stdxstorage.ErrObjectNotFound
+		return
+	}
+
+	info = fileInfoToObjectInfo(key, fileStat)
+	return
+}
+
+func (storage *FilesystemStorage) GetObjectWithInfo(ctx context.Context, key string) (object io.ReadCloser, info stdxstorage.ObjectInfo, err error) {
+	if strings.Contains(key, "..") {
+		err = ErrKeyIsNotValid
+		return
+	}
+
+	filePath := filepath.Join(storage.basePath, key)
+	file, err := os.OpenFile(filePath, os.O_RDONLY, os.ModePerm)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = stdxstorage.ErrObjectNotFound
+		}
+		return
+	}
+
+	// the metadata are read from the opened file as the file at filePath may be replaced in the meantime
+	fileStat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return
+	}
+
+	if fileStat.IsDir() {
+		file.Close()
+		err = stdxstorage.ErrObjectNotFound
+		return
+	}
+
+	object = file
+	info = fileInfoToObjectInfo(key, fileStat)
+	return
+}
+
+func (storage *FilesystemStorage) ListObjects(ctx context.Context, prefix string, recursive bool) (objects []stdxstorage.ObjectInfo, err error) {
+	if strings.Contains(prefix, "..") {
+		err = ErrPrefixIsNotValid
+		return
+	}
+
+	directory := filepath.Join(storage.basePath, prefix)
+	objects = []stdxstorage.ObjectInfo{}
+
+	err = filepath.WalkDir(directory, func(path string, entry fs.DirEntry, errWalk error) error {
+		if errWalk != nil {
+			if path == directory && errors.Is(errWalk, os.ErrNotExist) {
+				return fs.SkipAll
+			}
+			return errWalk
+		}
+
+		if path == directory {
+			return nil
+		}
+
+		relativePath, errWalk := filepath.Rel(storage.basePath, path)
+		if errWalk != nil {
+			return errWalk
+		}
+		key := filepath.ToSlash(relativePath)
+
+		if entry.IsDir() {
+			// multipart uploads are not objects
+			if key == MultipartDirectory {
+				return fs.SkipDir
+			}
+
+			if !recursive {
+				objects = append(objects, stdxstorage.ObjectInfo{Key: key + "/", IsDir: true})
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		fileInfo, errWalk := entry.Info()
+		if errWalk != nil {
+			return errWalk
+		}
+		objects = append(objects, fileInfoToObjectInfo(key, fileInfo))
+		return nil
+	})
+	if err != nil {
+		err = fmt.Errorf("storage.filesystem: listing objects: %w", err)
+		return
+	}
+
+	return
+}
+
+// fileInfoToObjectInfo converts fileInfo to an ObjectInfo, with an ETag computed from the modification
+// time and the size of the file, like nginx does.
+func fileInfoToObjectInfo(key string, fileInfo fs.FileInfo) stdxstorage.ObjectInfo {
+	return stdxstorage.ObjectInfo{
+		Key:        key,
+		Size:       fileInfo.Size(),
+		ModifiedAt: fileInfo.ModTime().UTC(),
+		ETag:       fmt.Sprintf(`"%x-%x"`, fileInfo.ModTime().UnixNano(), fileInfo.Size()),
+	}
+}
+
 // func (storage *FilesystemStorage) GetPresignedUploadUrl(ctx context.Context, key string, size uint64) (string, error) {
 // 	panic("not implemented") // TODO: Implement
 // }
@@ -0,0 +1,123 @@
+package filesystem
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	stdxstorage "github.com/bloom42/stdx/storage"
+)
+
+func TestCopyObject(t *testing.T) {
+	ctx := context.Background()
+	storage := NewFilesystemStorage(Config{BaseDirectory: t.TempDir()})
+
+	content := "hello world"
+	err := storage.PutObject(ctx, "from/object.txt", "", int64(len(content)), strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the directory of the destination doesn't exist yet
+	err = storage.CopyObject(ctx, "from/object.txt", "to/sub/object.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"from/object.txt", "to/sub/object.txt"} {
+		object, err := storage.GetObject(ctx, key)
+		if err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+		data, err := io.ReadAll(object)
+		object.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("%s. expected: %s | got: %s", key, content, string(data))
+		}
+	}
+}
+
+func TestMultipartUpload(t *testing.T) {
+	ctx := context.Background()
+	storage := NewFilesystemStorage(Config{BaseDirectory: t.TempDir()})
+	key := "multipart/object.bin"
+
+	uploadID, err := storage.CreateMultipartUpload(ctx, key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	firstPartData := bytes.Repeat([]byte{1}, int(stdxstorage.MinPartSize))
+	smallPartData := []byte("small part")
+
+	firstPart, err := storage.UploadPart(ctx, key, uploadID, 1, int64(len(firstPartData)), bytes.NewReader(firstPartData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	smallPart, err := storage.UploadPart(ctx, key, uploadID, 2, int64(len(smallPartData)), bytes.NewReader(smallPartData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastPart, err := storage.UploadPart(ctx, key, uploadID, 3, int64(len(smallPartData)), bytes.NewReader(smallPartData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts, err := storage.ListParts(ctx, key, uploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedParts := []stdxstorage.Part{firstPart, smallPart, lastPart}
+	if len(parts) != len(expectedParts) {
+		t.Fatalf("ListParts. expected: %d parts | got: %d", len(expectedParts), len(parts))
+	}
+	for i := range parts {
+		if parts[i] != expectedParts[i] {
+			t.Errorf("ListParts[%d]. expected: %+v | got: %+v", i, expectedParts[i], parts[i])
+		}
+	}
+
+	// only the last part can be smaller than MinPartSize
+	err = storage.CompleteMultipartUpload(ctx, key, uploadID, expectedParts)
+	if !errors.Is(err, stdxstorage.ErrPartsAreNotValid) {
+		t.Errorf("completing upload with a small part. expected: %v | got: %v", stdxstorage.ErrPartsAreNotValid, err)
+	}
+
+	err = storage.CompleteMultipartUpload(ctx, key, uploadID, []stdxstorage.Part{firstPart, lastPart})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	object, err := storage.GetObject(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer object.Close()
+	data, err := io.ReadAll(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, append(firstPartData, smallPartData...)) {
+		t.Error("completed object doesn't match the parts")
+	}
+
+	_, err = storage.ListParts(ctx, key, uploadID)
+	if !errors.Is(err, stdxstorage.ErrUploadNotFound) {
+		t.Errorf("ListParts after completion. expected: %v | got: %v", stdxstorage.ErrUploadNotFound, err)
+	}
+}
+
+func TestGetObjectNotFound(t *testing.T) {
+	storage := NewFilesystemStorage(Config{BaseDirectory: t.TempDir()})
+
+	_, err := storage.GetObject(context.Background(), "missing.txt")
+	if !errors.Is(err, stdxstorage.ErrObjectNotFound) {
+		t.Errorf("expected: %v | got: %v", stdxstorage.ErrObjectNotFound, err)
+	}
+}
@@ -0,0 +1,329 @@
+package filesystem
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bloom42/stdx/guid"
+	stdxstorage "github.com/bloom42/stdx/storage"
+)
+
+// we do this to have a compile-time error if FilesystemStorage no longer satisfies the MultipartStorage interface
+var _ stdxstorage.MultipartStorage = (*FilesystemStorage)(nil)
+
+// MultipartDirectory is the directory, relative to BaseDirectory, where the parts of multipart uploads
+// are stored until the uploads are completed or aborted.
+const MultipartDirectory = ".multipart"
+
+const (
+	multipartKeyFile    = "key"
+	multipartPartPrefix = "part_"
+	// the ETag of each part is stored in a file next to the part so parts don't need to be hashed again
+	// when they are listed or assembled
+	multipartETagPrefix = "etag_"
+)
+
+func (storage *FilesystemStorage) CreateMultipartUpload(ctx context.Context, key, contentType string) (uploadID string, err error) {
+	if strings.Contains(key, "..") {
+		err = ErrKeyIsNotValid
+		return
+	}
+
+	uploadID = guid.NewRandom().String()
+	uploadDirectory := storage.uploadDirectory(uploadID)
+
+	err = os.MkdirAll(uploadDirectory, os.ModePerm)
+	if err != nil {
+		return
+	}
+
+	err = os.WriteFile(filepath.Join(uploadDirectory, multipartKeyFile), []byte(key), 0600)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (storage *FilesystemStorage) UploadPart(ctx context.Context, key, uploadID string, partNumber int64, size int64, data io.Reader) (part stdxstorage.Part, err error) {
+	if partNumber < stdxstorage.MinPartNumber || partNumber > stdxstorage.MaxPartNumber {
+		err = stdxstorage.ErrPartNumberNotValid
+		return
+	}
+
+	uploadDirectory, err := storage.checkUpload(key, uploadID)
+	if err != nil {
+		return
+	}
+
+	// parts are first written to a temporary file so an interrupted upload never leaves a truncated part
+	partPath := filepath.Join(uploadDirectory, partFileName(partNumber))
+	tmpFile, err := os.CreateTemp(uploadDirectory, "tmp_*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	hasher := md5.New()
+	written, err := io.Copy(io.MultiWriter(tmpFile, hasher), data)
+	if err != nil {
+		return
+	}
+
+	if written != size {
+		err = fmt.Errorf("storage.filesystem: part size (%d) doesn't match the size of the data (%d)", size, written)
+		return
+	}
+
+	err = tmpFile.Close()
+	if err != nil {
+		return
+	}
+
+	etag := `"` + hex.EncodeToString(hasher.Sum(nil)) + `"`
+	err = writeFileAtomically(uploadDirectory, filepath.Join(uploadDirectory, etagFileName(partNumber)), []byte(etag))
+	if err != nil {
+		return
+	}
+
+	err = os.Rename(tmpFile.Name(), partPath)
+	if err != nil {
+		return
+	}
+
+	part = stdxstorage.Part{
+		Number: partNumber,
+		ETag:   etag,
+		Size:   written,
+	}
+	return
+}
+
+func (storage *FilesystemStorage) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []stdxstorage.Part) (err error) {
+	if len(parts) == 0 {
+		err = stdxstorage.ErrPartsAreNotValid
+		return
+	}
+
+	uploadDirectory, err := storage.checkUpload(key, uploadID)
+	if err != nil {
+		return
+	}
+
+	uploadedParts, err := storage.ListParts(ctx, key, uploadID)
+	if err != nil {
+		return
+	}
+
+	uploadedPartsByNumber := make(map[int64]stdxstorage.Part, len(uploadedParts))
+	for _, part := range uploadedParts {
+		uploadedPartsByNumber[part.Number] = part
+	}
+
+	for i, part := range parts {
+		if i != 0 && parts[i-1].Number >= part.Number {
+			err = stdxstorage.ErrPartsAreNotValid
+			return
+		}
+		uploadedPart, exists := uploadedPartsByNumber[part.Number]
+		if !exists || uploadedPart.ETag != part.ETag {
+			err = stdxstorage.ErrPartsAreNotValid
+			return
+		}
+		// like S3, all the parts except the last one must be at least MinPartSize bytes
+		if i != len(parts)-1 && uploadedPart.Size < stdxstorage.MinPartSize {
+			err = stdxstorage.ErrPartsAreNotValid
+			return
+		}
+	}
+
+	filePath := filepath.Join(storage.basePath, key)
+	err = os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+	if err != nil {
+		return
+	}
+
+	tmpFile, err := os.CreateTemp(uploadDirectory, "tmp_*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	for _, part := range parts {
+		err = appendFile(tmpFile, filepath.Join(uploadDirectory, partFileName(part.Number)))
+		if err != nil {
+			return
+		}
+	}
+
+	err = tmpFile.Close()
+	if err != nil {
+		return
+	}
+
+	err = os.Rename(tmpFile.Name(), filePath)
+	if err != nil {
+		return
+	}
+
+	err = os.RemoveAll(uploadDirectory)
+	return
+}
+
+func (storage *FilesystemStorage) AbortMultipartUpload(ctx context.Context, key, uploadID string) (err error) {
+	uploadDirectory, err := storage.checkUpload(key, uploadID)
+	if err != nil {
+		return
+	}
+
+	err = os.RemoveAll(uploadDirectory)
+	return
+}
+
+func (storage *FilesystemStorage) ListParts(ctx context.Context, key, uploadID string) (parts []stdxstorage.Part, err error) {
+	uploadDirectory, err := storage.checkUpload(key, uploadID)
+	if err != nil {
+		return
+	}
+
+	entries, err := os.ReadDir(uploadDirectory)
+	if err != nil {
+		return
+	}
+
+	parts = []stdxstorage.Part{}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), multipartPartPrefix) {
+			continue
+		}
+
+		var partNumber int64
+		var part stdxstorage.Part
+
+		partNumber, err = strconv.ParseInt(strings.TrimPrefix(entry.Name(), multipartPartPrefix), 10, 64)
+		if err != nil {
+			err = fmt.Errorf("storage.filesystem: part file name is not valid (%s): %w", entry.Name(), err)
+			return
+		}
+
+		part, err = readPart(uploadDirectory, partNumber)
+		if err != nil {
+			return
+		}
+		parts = append(parts, part)
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].Number < parts[j].Number
+	})
+
+	return
+}
+
+func (storage *FilesystemStorage) uploadDirectory(uploadID string) string {
+	return filepath.Join(storage.basePath, MultipartDirectory, uploadID)
+}
+
+// checkUpload verifies that the upload exists and belongs to key, and returns its directory
+func (storage *FilesystemStorage) checkUpload(key, uploadID string) (uploadDirectory string, err error) {
+	if strings.Contains(key, "..") {
+		err = ErrKeyIsNotValid
+		return
+	}
+
+	// uploadID is used as a directory name so we need to make sure that it's a valid GUID
+	if _, parseErr := guid.Parse(uploadID); parseErr != nil {
+		err = stdxstorage.ErrUploadNotFound
+		return
+	}
+
+	uploadDirectory = storage.uploadDirectory(uploadID)
+	uploadKey, err := os.ReadFile(filepath.Join(uploadDirectory, multipartKeyFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = stdxstorage.ErrUploadNotFound
+		}
+		return
+	}
+
+	if string(uploadKey) != key {
+		err = stdxstorage.ErrUploadNotFound
+		return
+	}
+
+	return
+}
+
+func partFileName(partNumber int64) string {
+	return fmt.Sprintf("%s%05d", multipartPartPrefix, partNumber)
+}
+
+func etagFileName(partNumber int64) string {
+	return fmt.Sprintf("%s%05d", multipartETagPrefix, partNumber)
+}
+
+func readPart(uploadDirectory string, partNumber int64) (part stdxstorage.Part, err error) {
+	partInfo, err := os.Stat(filepath.Join(uploadDirectory, partFileName(partNumber)))
+	if err != nil {
+		return
+	}
+
+	etag, err := os.ReadFile(filepath.Join(uploadDirectory, etagFileName(partNumber)))
+	if err != nil {
+		err = fmt.Errorf("storage.filesystem: reading ETag of part %d: %w", partNumber, err)
+		return
+	}
+
+	part = stdxstorage.Part{
+		Number: partNumber,
+		ETag:   string(etag),
+		Size:   partInfo.Size(),
+	}
+	return
+}
+
+// writeFileAtomically writes data to a temporary file in directory which is then renamed to filePath
+// so filePath never contains partial data.
+func writeFileAtomically(directory, filePath string, data []byte) (err error) {
+	tmpFile, err := os.CreateTemp(directory, "tmp_*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	_, err = tmpFile.Write(data)
+	if err != nil {
+		return
+	}
+
+	err = tmpFile.Close()
+	if err != nil {
+		return
+	}
+
+	err = os.Rename(tmpFile.Name(), filePath)
+	return
+}
+
+func appendFile(destination io.Writer, sourcePath string) (err error) {
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return
+	}
+	defer source.Close()
+
+	_, err = io.Copy(destination, source)
+	return
+}
@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// we do this to have a compile-time error if FS no longer satisfies the fs interfaces
+var (
+	_ fs.FS        = (*FS)(nil)
+	_ fs.StatFS    = (*FS)(nil)
+	_ fs.ReadDirFS = (*FS)(nil)
+)
+
+// FS is a read-only fs.FS backed by the objects under a prefix of a ListingStorage, so that a storage
+// can be used with packages working with fs.FS such as html/template or httpx.WebappHandler.
+//
+// As fs.FS doesn't support contexts, the context given to NewFS is used for all the operations.
+type FS struct {
+	ctx     context.Context
+	storage ListingStorage
+	prefix  string
+}
+
+// NewFS returns an FS serving the objects under prefix (e.g. webapp/)
+func NewFS(ctx context.Context, storage ListingStorage, prefix string) *FS {
+	return &FS{
+		ctx:     ctx,
+		storage: storage,
+		prefix:  strings.Trim(prefix, "/"),
+	}
+}
+
+// Open implements fs.FS
+func (fsys *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	info, err := fsys.stat(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	if info.IsDir() {
+		return &dir{fsys: fsys, name: name, info: info}, nil
+	}
+
+	return &file{fsys: fsys, name: name, info: info}, nil
+}
+
+// Stat implements fs.StatFS
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+
+	info, err := fsys.stat(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+
+	return info, nil
+}
+
+// ReadDir implements fs.ReadDirFS
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	entries, err := fsys.readDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	if len(entries) == 0 && name != "." {
+		// "directories" only exist if they contain objects
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return entries, nil
+}
+
+func (fsys *FS) key(name string) string {
+	if name == "." {
+		return fsys.prefix
+	}
+	return path.Join(fsys.prefix, name)
+}
+
+func (fsys *FS) stat(name string) (info fileInfo, err error) {
+	if name == "." {
+		info = fileInfo{name: ".", isDir: true}
+		return
+	}
+
+	objectInfo, err := fsys.storage.StatObject(fsys.ctx, fsys.key(name))
+	if err == nil {
+		info = objectInfoToFileInfo(objectInfo)
+		return
+	}
+	if !errors.Is(err, ErrObjectNotFound) {
+		return
+	}
+
+	// name may be a "directory"
+	objects, err := fsys.storage.ListObjects(fsys.ctx, fsys.key(name), false)
+	if err != nil {
+		return
+	}
+	if len(objects) == 0 {
+		err = fs.ErrNotExist
+		return
+	}
+
+	info = fileInfo{name: path.Base(name), isDir: true}
+	return
+}
+
+func (fsys *FS) readDir(name string) (entries []fs.DirEntry, err error) {
+	objects, err := fsys.storage.ListObjects(fsys.ctx, fsys.key(name), false)
+	if err != nil {
+		return
+	}
+
+	entries = make([]fs.DirEntry, 0, len(objects))
+	for _, object := range objects {
+		entries = append(entries, fs.FileInfoToDirEntry(objectInfoToFileInfo(object)))
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return
+}
+
+func objectInfoToFileInfo(object ObjectInfo) fileInfo {
+	return fileInfo{
+		name:    path.Base(strings.TrimSuffix(object.Key, "/")),
+		size:    object.Size,
+		modTime: object.ModifiedAt,
+		isDir:   object.IsDir,
+	}
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func (info fileInfo) Name() string       { return info.name }
+func (info fileInfo) Size() int64        { return info.size }
+func (info fileInfo) ModTime() time.Time { return info.modTime }
+func (info fileInfo) IsDir() bool        { return info.isDir }
+func (info fileInfo) Sys() any           { return nil }
+
+func (info fileInfo) Mode() fs.FileMode {
+	if info.isDir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// file is an object. Its content is only fetched on the first call to Read.
+type file struct {
+	fsys   *FS
+	name   string
+	info   fileInfo
+	reader io.ReadCloser
+}
+
+func (file *file) Stat() (fs.FileInfo, error) {
+	return file.info, nil
+}
+
+func (file *file) Read(buffer []byte) (n int, err error) {
+	if file.reader == nil {
+		file.reader, err = file.fsys.storage.GetObject(file.fsys.ctx, file.fsys.key(file.name))
+		if err != nil {
+			err = &fs.PathError{Op: "read", Path: file.name, Err: err}
+			return
+		}
+	}
+
+	return file.reader.Read(buffer)
+}
+
+func (file *file) Close() error {
+	if file.reader == nil {
+		return nil
+	}
+	return file.reader.Close()
+}
+
+type dir struct {
+	fsys    *FS
+	name    string
+	info    fileInfo
+	entries []fs.DirEntry
+	loaded  bool
+}
+
+func (dir *dir) Stat() (fs.FileInfo, error) {
+	return dir.info, nil
+}
+
+func (dir *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: dir.name, Err: errors.New("is a directory")}
+}
+
+func (dir *dir) Close() error {
+	return nil
+}
+
+// ReadDir implements fs.ReadDirFile
+func (dir *dir) ReadDir(count int) (entries []fs.DirEntry, err error) {
+	if !dir.loaded {
+		dir.entries, err = dir.fsys.readDir(dir.name)
+		if err != nil {
+			err = &fs.PathError{Op: "readdir", Path: dir.name, Err: err}
+			return
+		}
+		dir.loaded = true
+	}
+
+	if count <= 0 {
+		entries = dir.entries
+		dir.entries = nil
+		return
+	}
+
+	if len(dir.entries) == 0 {
+		err = io.EOF
+		return
+	}
+
+	if count > len(dir.entries) {
+		count = len(dir.entries)
+	}
+	entries = dir.entries[:count]
+	dir.entries = dir.entries[count:]
+	return
+}
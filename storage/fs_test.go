@@ -0,0 +1,57 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/bloom42/stdx/storage"
+	"github.com/bloom42/stdx/storage/filesystem"
+)
+
+func TestFS(t *testing.T) {
+	ctx := context.Background()
+	fsStorage := filesystem.NewFilesystemStorage(filesystem.Config{BaseDirectory: t.TempDir()})
+
+	files := map[string]string{
+		"webapp/index.html":          "<html></html>",
+		"webapp/assets/app.js":       "console.log('hello')",
+		"webapp/assets/app.css":      "body {}",
+		"webapp/assets/img/logo.svg": "<svg></svg>",
+		"other/secret.txt":           "secret",
+	}
+	for key, content := range files {
+		err := fsStorage.PutObject(ctx, key, "", int64(len(content)), strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fsys := storage.NewFS(ctx, fsStorage, "webapp")
+
+	err := fstest.TestFS(fsys, "index.html", "assets/app.js", "assets/app.css", "assets/img/logo.svg")
+	if err != nil {
+		t.Error(err)
+	}
+
+	content, err := fs.ReadFile(fsys, "assets/app.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != files["webapp/assets/app.js"] {
+		t.Errorf("content. expected: %s | got: %s", files["webapp/assets/app.js"], string(content))
+	}
+
+	_, err = fs.Stat(fsys, "../other/secret.txt")
+	if err == nil {
+		t.Error("expected error for invalid path")
+	}
+
+	_, err = fs.Stat(fsys, "missing.txt")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected: fs.ErrNotExist | got: %v", err)
+	}
+}
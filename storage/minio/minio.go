@@ -6,11 +6,17 @@ import (
 	"io"
 	"net/http"
 	"path/filepath"
+	"strings"
 
+	stdxstorage "github.com/bloom42/stdx/storage"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 )
 
+// we do this to have a compile-time error if MinioStorage no longer satisfies the MultipartStorage and ListingStorage interfaces
+var _ stdxstorage.MultipartStorage = (*MinioStorage)(nil)
+var _ stdxstorage.ListingStorage = (*MinioStorage)(nil)
+
 type MinioStorage struct {
 	basePath    string
 	minioClient *minio.Client
@@ -55,7 +61,7 @@ func (storage *MinioStorage) BasePath() string {
 
 func (storage *MinioStorage) CopyObject(ctx context.Context, from string, to string) error {
 	from = filepath.Join(storage.basePath, from)
-	to = filepath.Join(storage.basePath, from)
+	to = filepath.Join(storage.basePath, to)
 
 	fromOptions := minio.CopySrcOptions{
 		Bucket: storage.bucket,
@@ -93,6 +99,17 @@ func (storage *MinioStorage) GetObject(ctx context.Context, key string) (io.Read
 		return nil, err
 	}
 
+	// minio's objects are lazy: Stat sends the GET request so a missing object is detected here
+	// and not at the first Read. The response is reused by the following Reads.
+	_, err = object.Stat()
+	if err != nil {
+		object.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			err = stdxstorage.ErrObjectNotFound
+		}
+		return nil, err
+	}
+
 	return object, nil
 }
 
@@ -107,6 +124,97 @@ func (storage *MinioStorage) GetObjectSize(ctx context.Context, key string) (int
 	return info.Size, nil
 }
 
+func (storage *MinioStorage) StatObject(ctx context.Context, key string) (info stdxstorage.ObjectInfo, err error) {
+	objectKey := filepath.Join(storage.basePath, key)
+
+	objectInfo, err := storage.minioClient.StatObject(ctx, storage.bucket, objectKey, minio.GetObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			err = stdxstorage.ErrObjectNotFound
+		}
+		return
+	}
+
+	info = stdxstorage.ObjectInfo{
+		Key:        key,
+		Size:       objectInfo.Size,
+		ModifiedAt: objectInfo.LastModified,
+		ETag:       objectInfo.ETag,
+	}
+	return
+}
+
+func (storage *MinioStorage) GetObjectWithInfo(ctx context.Context, key string) (object io.ReadCloser, info stdxstorage.ObjectInfo, err error) {
+	objectKey := filepath.Join(storage.basePath, key)
+
+	minioObject, err := storage.minioClient.GetObject(ctx, storage.bucket, objectKey, minio.GetObjectOptions{})
+	if err != nil {
+		return
+	}
+
+	// Stat returns the metadata of the response of the GET request of the object
+	objectInfo, err := minioObject.Stat()
+	if err != nil {
+		minioObject.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			err = stdxstorage.ErrObjectNotFound
+		}
+		return
+	}
+
+	object = minioObject
+	info = stdxstorage.ObjectInfo{
+		Key:        key,
+		Size:       objectInfo.Size,
+		ModifiedAt: objectInfo.LastModified,
+		ETag:       objectInfo.ETag,
+	}
+	return
+}
+
+func (storage *MinioStorage) ListObjects(ctx context.Context, prefix string, recursive bool) (objects []stdxstorage.ObjectInfo, err error) {
+	s3Prefix := filepath.Join(storage.basePath, prefix)
+	if s3Prefix == "." {
+		s3Prefix = ""
+	} else {
+		s3Prefix += "/"
+	}
+	basePath := filepath.Clean(storage.basePath)
+	objects = []stdxstorage.ObjectInfo{}
+
+	objectsChan := storage.minioClient.ListObjects(ctx, storage.bucket, minio.ListObjectsOptions{
+		Prefix:    s3Prefix,
+		Recursive: recursive,
+	})
+
+	for object := range objectsChan {
+		if object.Err != nil {
+			err = object.Err
+			return
+		}
+
+		key := object.Key
+		if basePath != "." {
+			key = strings.TrimPrefix(key, basePath+"/")
+		}
+
+		// "directories" are returned as objects with a key ending with /
+		if strings.HasSuffix(key, "/") {
+			objects = append(objects, stdxstorage.ObjectInfo{Key: key, IsDir: true})
+			continue
+		}
+
+		objects = append(objects, stdxstorage.ObjectInfo{
+			Key:        key,
+			Size:       object.Size,
+			ModifiedAt: object.LastModified,
+			ETag:       object.ETag,
+		})
+	}
+
+	return
+}
+
 // func (storage *S3Storage) GetPresignedUploadUrl(ctx context.Context, key string, size uint64) (string, error) {
 // 	objectKey := filepath.Join(storage.basePath, key)
 
@@ -155,3 +263,118 @@ func (storage *MinioStorage) DeleteObjectsWithPrefix(ctx context.Context, prefix
 
 	return
 }
+
+func (storage *MinioStorage) CreateMultipartUpload(ctx context.Context, key, contentType string) (uploadID string, err error) {
+	objectKey := filepath.Join(storage.basePath, key)
+	core := minio.Core{Client: storage.minioClient}
+
+	uploadID, err = core.NewMultipartUpload(ctx, storage.bucket, objectKey, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (storage *MinioStorage) UploadPart(ctx context.Context, key, uploadID string, partNumber int64, size int64, data io.Reader) (part stdxstorage.Part, err error) {
+	if partNumber < stdxstorage.MinPartNumber || partNumber > stdxstorage.MaxPartNumber {
+		err = stdxstorage.ErrPartNumberNotValid
+		return
+	}
+
+	objectKey := filepath.Join(storage.basePath, key)
+	core := minio.Core{Client: storage.minioClient}
+
+	objectPart, err := core.PutObjectPart(ctx, storage.bucket, objectKey, uploadID, int(partNumber), data, size, minio.PutObjectPartOptions{})
+	if err != nil {
+		err = convertMultipartError(err)
+		return
+	}
+
+	part = stdxstorage.Part{
+		Number: partNumber,
+		ETag:   objectPart.ETag,
+		Size:   objectPart.Size,
+	}
+	return
+}
+
+func (storage *MinioStorage) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []stdxstorage.Part) (err error) {
+	if len(parts) == 0 {
+		err = stdxstorage.ErrPartsAreNotValid
+		return
+	}
+
+	objectKey := filepath.Join(storage.basePath, key)
+	core := minio.Core{Client: storage.minioClient}
+
+	completeParts := make([]minio.CompletePart, 0, len(parts))
+	for _, part := range parts {
+		completeParts = append(completeParts, minio.CompletePart{
+			PartNumber: int(part.Number),
+			ETag:       part.ETag,
+		})
+	}
+
+	_, err = core.CompleteMultipartUpload(ctx, storage.bucket, objectKey, uploadID, completeParts, minio.PutObjectOptions{})
+	if err != nil {
+		err = convertMultipartError(err)
+		return
+	}
+
+	return
+}
+
+func (storage *MinioStorage) AbortMultipartUpload(ctx context.Context, key, uploadID string) (err error) {
+	objectKey := filepath.Join(storage.basePath, key)
+	core := minio.Core{Client: storage.minioClient}
+
+	err = core.AbortMultipartUpload(ctx, storage.bucket, objectKey, uploadID)
+	if err != nil {
+		err = convertMultipartError(err)
+		return
+	}
+
+	return
+}
+
+func (storage *MinioStorage) ListParts(ctx context.Context, key, uploadID string) (parts []stdxstorage.Part, err error) {
+	objectKey := filepath.Join(storage.basePath, key)
+	core := minio.Core{Client: storage.minioClient}
+	partNumberMarker := 0
+	parts = []stdxstorage.Part{}
+
+	for {
+		var res minio.ListObjectPartsResult
+
+		res, err = core.ListObjectParts(ctx, storage.bucket, objectKey, uploadID, partNumberMarker, 0)
+		if err != nil {
+			err = convertMultipartError(err)
+			return
+		}
+
+		for _, part := range res.ObjectParts {
+			parts = append(parts, stdxstorage.Part{
+				Number: int64(part.PartNumber),
+				ETag:   part.ETag,
+				Size:   part.Size,
+			})
+		}
+
+		if !res.IsTruncated {
+			break
+		}
+		partNumberMarker = res.NextPartNumberMarker
+	}
+
+	return
+}
+
+func convertMultipartError(err error) error {
+	if minio.ToErrorResponse(err).Code == "NoSuchUpload" {
+		return stdxstorage.ErrUploadNotFound
+	}
+	return err
+}
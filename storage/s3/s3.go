@@ -6,14 +6,21 @@ import (
 	"io"
 	"net/http"
 	"path/filepath"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	stdxstorage "github.com/bloom42/stdx/storage"
 )
 
+// we do this to have a compile-time error if S3Storage no longer satisfies the MultipartStorage and ListingStorage interfaces
+var _ stdxstorage.MultipartStorage = (*S3Storage)(nil)
+var _ stdxstorage.ListingStorage = (*S3Storage)(nil)
+
 type S3Storage struct {
 	basePath string
 	s3Client *s3.S3
@@ -72,7 +79,7 @@ func (storage *S3Storage) BasePath() string {
 
 func (storage *S3Storage) CopyObject(ctx context.Context, from string, to string) error {
 	from = filepath.Join(storage.bucket, storage.basePath, from)
-	to = filepath.Join(storage.basePath, from)
+	to = filepath.Join(storage.basePath, to)
 
 	_, err := storage.s3Client.CopyObject(&s3.CopyObjectInput{
 		Bucket:     aws.String(storage.bucket),
@@ -108,7 +115,7 @@ func (storage *S3Storage) GetObject(ctx context.Context, key string) (io.ReadClo
 		Key:    aws.String(objectKey),
 	})
 	if err != nil {
-		return nil, err
+		return nil, convertObjectError(err)
 	}
 
 	return result.Body, nil
@@ -132,6 +139,115 @@ func (storage *S3Storage) GetObjectSize(ctx context.Context, key string) (int64,
 	return *result.ContentLength, nil
 }
 
+func (storage *S3Storage) StatObject(ctx context.Context, key string) (info stdxstorage.ObjectInfo, err error) {
+	objectKey := filepath.Join(storage.basePath, key)
+
+	result, err := storage.s3Client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(storage.bucket),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		err = convertObjectError(err)
+		return
+	}
+
+	info = stdxstorage.ObjectInfo{
+		Key:        key,
+		Size:       aws.Int64Value(result.ContentLength),
+		ModifiedAt: aws.TimeValue(result.LastModified),
+		ETag:       aws.StringValue(result.ETag),
+	}
+	return
+}
+
+func (storage *S3Storage) GetObjectWithInfo(ctx context.Context, key string) (object io.ReadCloser, info stdxstorage.ObjectInfo, err error) {
+	objectKey := filepath.Join(storage.basePath, key)
+
+	result, err := storage.s3Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(storage.bucket),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		err = convertObjectError(err)
+		return
+	}
+
+	object = result.Body
+	info = stdxstorage.ObjectInfo{
+		Key:        key,
+		Size:       aws.Int64Value(result.ContentLength),
+		ModifiedAt: aws.TimeValue(result.LastModified),
+		ETag:       aws.StringValue(result.ETag),
+	}
+	return
+}
+
+func (storage *S3Storage) ListObjects(ctx context.Context, prefix string, recursive bool) (objects []stdxstorage.ObjectInfo, err error) {
+	s3Prefix := storage.directoryPrefix(prefix)
+	var continuationToken *string
+	var delimiter *string
+	objects = []stdxstorage.ObjectInfo{}
+
+	if !recursive {
+		delimiter = aws.String("/")
+	}
+
+	for {
+		var res *s3.ListObjectsV2Output
+
+		res, err = storage.s3Client.ListObjectsV2WithContext(ctx, &s3.ListObjectsV2Input{
+			Bucket:            aws.String(storage.bucket),
+			Prefix:            aws.String(s3Prefix),
+			Delimiter:         delimiter,
+			ContinuationToken: continuationToken,
+		})
+		if err != nil {
+			return
+		}
+
+		for _, commonPrefix := range res.CommonPrefixes {
+			objects = append(objects, stdxstorage.ObjectInfo{
+				Key:   storage.relativeKey(aws.StringValue(commonPrefix.Prefix)),
+				IsDir: true,
+			})
+		}
+
+		for _, object := range res.Contents {
+			objects = append(objects, stdxstorage.ObjectInfo{
+				Key:        storage.relativeKey(aws.StringValue(object.Key)),
+				Size:       aws.Int64Value(object.Size),
+				ModifiedAt: aws.TimeValue(object.LastModified),
+				ETag:       aws.StringValue(object.ETag),
+			})
+		}
+
+		if !aws.BoolValue(res.IsTruncated) {
+			break
+		}
+		continuationToken = res.NextContinuationToken
+	}
+
+	return
+}
+
+// directoryPrefix returns the S3 prefix of the "directory" prefix
+func (storage *S3Storage) directoryPrefix(prefix string) string {
+	s3Prefix := filepath.Join(storage.basePath, prefix)
+	if s3Prefix == "." || s3Prefix == "" {
+		return ""
+	}
+	return s3Prefix + "/"
+}
+
+// relativeKey returns objectKey relative to basePath
+func (storage *S3Storage) relativeKey(objectKey string) string {
+	basePath := filepath.Clean(storage.basePath)
+	if basePath == "." || basePath == "" {
+		return objectKey
+	}
+	return strings.TrimPrefix(objectKey, basePath+"/")
+}
+
 // func (storage *S3Storage) GetPresignedUploadUrl(ctx context.Context, key string, size uint64) (string, error) {
 // 	objectKey := filepath.Join(storage.basePath, key)
 
@@ -196,3 +312,154 @@ func (storage *S3Storage) DeleteObjectsWithPrefix(ctx context.Context, prefix st
 
 	return
 }
+
+func (storage *S3Storage) CreateMultipartUpload(ctx context.Context, key, contentType string) (uploadID string, err error) {
+	objectKey := filepath.Join(storage.basePath, key)
+
+	result, err := storage.s3Client.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(storage.bucket),
+		Key:         aws.String(objectKey),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return
+	}
+
+	if result.UploadId == nil {
+		err = errors.New("s3: upload id is null")
+		return
+	}
+
+	uploadID = *result.UploadId
+	return
+}
+
+func (storage *S3Storage) UploadPart(ctx context.Context, key, uploadID string, partNumber int64, size int64, data io.Reader) (part stdxstorage.Part, err error) {
+	if partNumber < stdxstorage.MinPartNumber || partNumber > stdxstorage.MaxPartNumber {
+		err = stdxstorage.ErrPartNumberNotValid
+		return
+	}
+
+	objectKey := filepath.Join(storage.basePath, key)
+
+	result, err := storage.s3Client.UploadPartWithContext(ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(storage.bucket),
+		Key:           aws.String(objectKey),
+		UploadId:      aws.String(uploadID),
+		PartNumber:    aws.Int64(partNumber),
+		Body:          aws.ReadSeekCloser(data),
+		ContentLength: aws.Int64(size),
+	})
+	if err != nil {
+		err = convertMultipartError(err)
+		return
+	}
+
+	part = stdxstorage.Part{
+		Number: partNumber,
+		ETag:   aws.StringValue(result.ETag),
+		Size:   size,
+	}
+	return
+}
+
+func (storage *S3Storage) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []stdxstorage.Part) (err error) {
+	if len(parts) == 0 {
+		err = stdxstorage.ErrPartsAreNotValid
+		return
+	}
+
+	objectKey := filepath.Join(storage.basePath, key)
+
+	completedParts := make([]*s3.CompletedPart, 0, len(parts))
+	for _, part := range parts {
+		completedParts = append(completedParts, &s3.CompletedPart{
+			ETag:       aws.String(part.ETag),
+			PartNumber: aws.Int64(part.Number),
+		})
+	}
+
+	_, err = storage.s3Client.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:   aws.String(storage.bucket),
+		Key:      aws.String(objectKey),
+		UploadId: aws.String(uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{
+			Parts: completedParts,
+		},
+	})
+	if err != nil {
+		err = convertMultipartError(err)
+		return
+	}
+
+	return
+}
+
+func (storage *S3Storage) AbortMultipartUpload(ctx context.Context, key, uploadID string) (err error) {
+	objectKey := filepath.Join(storage.basePath, key)
+
+	_, err = storage.s3Client.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(storage.bucket),
+		Key:      aws.String(objectKey),
+		UploadId: aws.String(uploadID),
+	})
+	if err != nil {
+		err = convertMultipartError(err)
+		return
+	}
+
+	return
+}
+
+func (storage *S3Storage) ListParts(ctx context.Context, key, uploadID string) (parts []stdxstorage.Part, err error) {
+	objectKey := filepath.Join(storage.basePath, key)
+	var partNumberMarker *int64
+	parts = []stdxstorage.Part{}
+
+	for {
+		var res *s3.ListPartsOutput
+
+		res, err = storage.s3Client.ListPartsWithContext(ctx, &s3.ListPartsInput{
+			Bucket:           aws.String(storage.bucket),
+			Key:              aws.String(objectKey),
+			UploadId:         aws.String(uploadID),
+			PartNumberMarker: partNumberMarker,
+		})
+		if err != nil {
+			err = convertMultipartError(err)
+			return
+		}
+
+		for _, part := range res.Parts {
+			parts = append(parts, stdxstorage.Part{
+				Number: aws.Int64Value(part.PartNumber),
+				ETag:   aws.StringValue(part.ETag),
+				Size:   aws.Int64Value(part.Size),
+			})
+		}
+
+		if !aws.BoolValue(res.IsTruncated) {
+			break
+		}
+		partNumberMarker = res.NextPartNumberMarker
+	}
+
+	return
+}
+
+func convertObjectError(err error) error {
+	var awsErr awserr.Error
+	// HeadObject returns the NotFound code
+	if errors.As(err, &awsErr) && (awsErr.Code() == s3.ErrCodeNoSuchKey || awsErr.Code() == "NotFound") {
+		return stdxstorage.ErrObjectNotFound
+	}
+	return err
+}
+
+func convertMultipartError(err error) error {
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchUpload {
+		return stdxstorage.ErrUploadNotFound
+	}
+	return err
+}
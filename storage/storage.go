@@ -2,16 +2,82 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"io"
+	"time"
+)
+
+var (
+	ErrObjectNotFound     = errors.New("storage: object not found")
+	ErrUploadNotFound     = errors.New("storage: multipart upload not found")
+	ErrPartNumberNotValid = errors.New("storage: part number is not valid")
+	ErrPartsAreNotValid   = errors.New("storage: parts are not valid")
+)
+
+const (
+	// MinPartNumber and MaxPartNumber are the bounds of part numbers, as defined by S3
+	MinPartNumber int64 = 1
+	MaxPartNumber int64 = 10_000
+
+	// MinPartSize is the minimum size of all the parts of a multipart upload, except the last one, as defined by S3
+	MinPartSize int64 = 5 * 1024 * 1024
 )
 
 type Storage interface {
 	BasePath() string
 	CopyObject(ctx context.Context, from, to string) error
 	DeleteObject(ctx context.Context, key string) error
+	// GetObject returns the content of an object. ErrObjectNotFound is returned if the object doesn't exist.
 	GetObject(ctx context.Context, key string) (io.ReadCloser, error)
 	GetObjectSize(ctx context.Context, key string) (int64, error)
 	// GetPresignedUploadUrl(ctx context.Context, key string, size uint64) (string, error)
 	PutObject(ctx context.Context, key, contentType string, size int64, object io.Reader) error
 	DeleteObjectsWithPrefix(ctx context.Context, prefix string) (err error)
 }
+
+// ListingStorage is a Storage which can return the metadata of objects and list them
+type ListingStorage interface {
+	Storage
+	// StatObject returns the metadata of an object. ErrObjectNotFound is returned if the object doesn't exist.
+	StatObject(ctx context.Context, key string) (ObjectInfo, error)
+	// GetObjectWithInfo returns an object with its metadata, which describe the returned version of the object
+	// even if it's modified concurrently.
+	GetObjectWithInfo(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error)
+	// ListObjects returns the objects in the "directory" prefix (e.g. assets/).
+	// If recursive is false, only the direct children of prefix are returned, and "sub-directories"
+	// are returned with IsDir = true.
+	ListObjects(ctx context.Context, prefix string, recursive bool) ([]ObjectInfo, error)
+}
+
+// ObjectInfo describes an object. The key is relative to the BasePath of the storage.
+type ObjectInfo struct {
+	Key        string
+	Size       int64
+	ModifiedAt time.Time
+	ETag       string
+	IsDir      bool
+}
+
+// MultipartStorage is a Storage which supports uploading large objects in multiple parts.
+// Parts can be uploaded independently (and retried) and the object is only created once
+// CompleteMultipartUpload is called.
+type MultipartStorage interface {
+	Storage
+	CreateMultipartUpload(ctx context.Context, key, contentType string) (uploadID string, err error)
+	// UploadPart uploads the part partNumber (between MinPartNumber and MaxPartNumber) of the upload.
+	// Uploading a part with the same partNumber twice overwrites the previous part.
+	UploadPart(ctx context.Context, key, uploadID string, partNumber int64, size int64, data io.Reader) (part Part, err error)
+	// CompleteMultipartUpload assembles the given parts, in order, into the final object.
+	// All the parts except the last one must be at least MinPartSize bytes, otherwise ErrPartsAreNotValid is returned.
+	CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []Part) error
+	AbortMultipartUpload(ctx context.Context, key, uploadID string) error
+	// ListParts returns the parts already uploaded, ordered by part number.
+	ListParts(ctx context.Context, key, uploadID string) (parts []Part, err error)
+}
+
+// Part is an uploaded part of a multipart upload
+type Part struct {
+	Number int64
+	ETag   string
+	Size   int64
+}
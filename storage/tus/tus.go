@@ -0,0 +1,528 @@
+// Package tus implements a server for the tus resumable upload protocol (https://tus.io/protocols/resumable-upload)
+// on top of the multipart uploads of a storage.MultipartStorage.
+//
+// The core protocol and the creation and termination extensions are supported.
+//
+// Data received through PATCH requests is buffered into parts of Config.PartSize bytes which are uploaded
+// with UploadPart. The remaining bytes which are not enough to form a full part are kept as a pending object
+// until the next PATCH request, so an interrupted request never loses the data that was received.
+// The state of uploads is stored as JSON objects under Config.StatePrefix so uploads can be resumed
+// from any replica.
+//
+// Clients must not send concurrent PATCH requests for the same upload.
+package tus
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bloom42/stdx/guid"
+	"github.com/bloom42/stdx/httpx"
+	"github.com/bloom42/stdx/log/slogx"
+	"github.com/bloom42/stdx/storage"
+)
+
+const (
+	Version    = "1.0.0"
+	Extensions = "creation,termination"
+
+	HeaderTusResumable   = "Tus-Resumable"
+	HeaderTusVersion     = "Tus-Version"
+	HeaderTusExtension   = "Tus-Extension"
+	HeaderTusMaxSize     = "Tus-Max-Size"
+	HeaderUploadLength   = "Upload-Length"
+	HeaderUploadOffset   = "Upload-Offset"
+	HeaderUploadMetadata = "Upload-Metadata"
+	HeaderLocation       = "Location"
+
+	MediaTypeOffsetOctetStream = "application/offset+octet-stream"
+
+	defaultContentType = "application/octet-stream"
+)
+
+var (
+	ErrUploadNotFound       = errors.New("tus: upload not found")
+	ErrMetadataIsNotValid   = errors.New("tus: Upload-Metadata is not valid")
+	ErrUploadLengthNotValid = errors.New("tus: Upload-Length is not valid")
+)
+
+type Config struct {
+	// BasePath is the URL path where the handler is mounted. It's used to build the URLs of uploads
+	// default: /
+	BasePath string
+
+	// MaxSize is the maximum size of an upload, in bytes. 0 means no limit.
+	MaxSize int64
+
+	// PartSize is the size of the parts uploaded to the storage.
+	// default: storage.MinPartSize
+	PartSize int64
+
+	// StatePrefix is the storage prefix where the state of uploads is stored.
+	// default: .tus
+	StatePrefix string
+
+	// ObjectKey returns the storage key of the object being uploaded.
+	// default: uploads/{uploadID}
+	ObjectKey func(req *http.Request, uploadID string, metadata map[string]string) (key string, err error)
+
+	// OnUploadComplete, if not nil, is called once the object of an upload has been assembled.
+	OnUploadComplete func(ctx context.Context, upload Upload)
+}
+
+// Upload is the state of an upload
+type Upload struct {
+	ID                string            `json:"id"`
+	Key               string            `json:"key"`
+	ContentType       string            `json:"content_type"`
+	Length            int64             `json:"length"`
+	Offset            int64             `json:"offset"`
+	Metadata          map[string]string `json:"metadata"`
+	CreatedAt         time.Time         `json:"created_at"`
+	Completed         bool              `json:"completed"`
+	MultipartUploadID string            `json:"multipart_upload_id"`
+	Parts             []storage.Part    `json:"parts"`
+	PendingSize       int64             `json:"pending_size"`
+}
+
+type Handler struct {
+	storage storage.MultipartStorage
+	config  Config
+}
+
+func NewHandler(multipartStorage storage.MultipartStorage, config Config) *Handler {
+	if config.BasePath == "" {
+		config.BasePath = "/"
+	}
+	if !strings.HasSuffix(config.BasePath, "/") {
+		config.BasePath += "/"
+	}
+	if config.PartSize < storage.MinPartSize {
+		config.PartSize = storage.MinPartSize
+	}
+	if config.StatePrefix == "" {
+		config.StatePrefix = ".tus"
+	}
+	if config.ObjectKey == nil {
+		config.ObjectKey = func(req *http.Request, uploadID string, metadata map[string]string) (string, error) {
+			return path.Join("uploads", uploadID), nil
+		}
+	}
+
+	return &Handler{
+		storage: multipartStorage,
+		config:  config,
+	}
+}
+
+func (handler *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set(HeaderTusResumable, Version)
+
+	if req.Method == http.MethodOptions {
+		handler.options(w)
+		return
+	}
+
+	if req.Header.Get(HeaderTusResumable) != Version {
+		w.Header().Set(HeaderTusVersion, Version)
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	uploadID := strings.Trim(strings.TrimPrefix(req.URL.Path, handler.config.BasePath), "/")
+
+	switch {
+	case req.Method == http.MethodPost && uploadID == "":
+		handler.create(w, req)
+	case req.Method == http.MethodHead && uploadID != "":
+		handler.head(w, req, uploadID)
+	case req.Method == http.MethodPatch && uploadID != "":
+		handler.patch(w, req, uploadID)
+	case req.Method == http.MethodDelete && uploadID != "":
+		handler.delete(w, req, uploadID)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (handler *Handler) options(w http.ResponseWriter) {
+	w.Header().Set(HeaderTusVersion, Version)
+	w.Header().Set(HeaderTusExtension, Extensions)
+	if handler.config.MaxSize > 0 {
+		w.Header().Set(HeaderTusMaxSize, strconv.FormatInt(handler.config.MaxSize, 10))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (handler *Handler) create(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	logger := slogx.FromCtx(ctx)
+
+	length, err := strconv.ParseInt(req.Header.Get(HeaderUploadLength), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, ErrUploadLengthNotValid.Error(), http.StatusBadRequest)
+		return
+	}
+	if handler.config.MaxSize > 0 && length > handler.config.MaxSize {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	metadata, err := ParseMetadata(req.Header.Get(HeaderUploadMetadata))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	upload := Upload{
+		ID:          guid.NewRandom().String(),
+		ContentType: defaultContentType,
+		Length:      length,
+		Offset:      0,
+		Metadata:    metadata,
+		CreatedAt:   time.Now().UTC(),
+		Parts:       []storage.Part{},
+	}
+	if fileType := metadata["filetype"]; fileType != "" {
+		upload.ContentType = fileType
+	}
+
+	upload.Key, err = handler.config.ObjectKey(req, upload.ID, metadata)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if length == 0 {
+		// multipart uploads need at least 1 part so we directly create empty objects
+		err = handler.storage.PutObject(ctx, upload.Key, upload.ContentType, 0, bytes.NewReader(nil))
+		if err != nil {
+			logger.Error("tus: creating empty object", slogx.Err(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		upload.Completed = true
+	} else {
+		upload.MultipartUploadID, err = handler.storage.CreateMultipartUpload(ctx, upload.Key, upload.ContentType)
+		if err != nil {
+			logger.Error("tus: creating multipart upload", slogx.Err(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = handler.saveUpload(ctx, upload)
+	if err != nil {
+		logger.Error("tus: saving upload", slogx.Err(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if upload.Completed && handler.config.OnUploadComplete != nil {
+		handler.config.OnUploadComplete(ctx, upload)
+	}
+
+	w.Header().Set(HeaderLocation, handler.config.BasePath+upload.ID)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (handler *Handler) head(w http.ResponseWriter, req *http.Request, uploadID string) {
+	ctx := req.Context()
+
+	upload, err := handler.loadUpload(ctx, uploadID)
+	if err != nil {
+		handler.handleError(ctx, w, err)
+		return
+	}
+
+	w.Header().Set(httpx.HeaderCacheControl, "no-store")
+	w.Header().Set(HeaderUploadOffset, strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set(HeaderUploadLength, strconv.FormatInt(upload.Length, 10))
+	if len(upload.Metadata) != 0 {
+		w.Header().Set(HeaderUploadMetadata, EncodeMetadata(upload.Metadata))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (handler *Handler) patch(w http.ResponseWriter, req *http.Request, uploadID string) {
+	ctx := req.Context()
+	logger := slogx.FromCtx(ctx)
+
+	if req.Header.Get(httpx.HeaderContentType) != MediaTypeOffsetOctetStream {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(req.Header.Get(HeaderUploadOffset), 10, 64)
+	if err != nil || offset < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	upload, err := handler.loadUpload(ctx, uploadID)
+	if err != nil {
+		handler.handleError(ctx, w, err)
+		return
+	}
+
+	if upload.Offset != offset || upload.Completed {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	readErr, err := handler.writeData(ctx, &upload, io.LimitReader(req.Body, upload.Length-upload.Offset))
+	if err != nil {
+		handler.handleError(ctx, w, err)
+		return
+	}
+
+	if upload.Offset == upload.Length {
+		err = handler.storage.CompleteMultipartUpload(ctx, upload.Key, upload.MultipartUploadID, upload.Parts)
+		if err != nil {
+			handler.handleError(ctx, w, err)
+			return
+		}
+
+		upload.Completed = true
+		err = handler.saveUpload(ctx, upload)
+		if err != nil {
+			handler.handleError(ctx, w, err)
+			return
+		}
+
+		if handler.config.OnUploadComplete != nil {
+			handler.config.OnUploadComplete(ctx, upload)
+		}
+	}
+
+	if readErr != nil {
+		// the data that was received has been saved, so the client can resume the upload from the new offset
+		logger.Warn("tus: reading request body", slogx.Err(readErr))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set(HeaderUploadOffset, strconv.FormatInt(upload.Offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeData uploads data in parts of PartSize bytes and saves the remaining bytes as the pending object.
+// readErr is the error encountered while reading data, if any, and is not fatal: upload is saved with
+// the data that has been read.
+func (handler *Handler) writeData(ctx context.Context, upload *Upload, data io.Reader) (readErr, err error) {
+	pendingKey := handler.pendingKey(upload.ID)
+	hadPending := upload.PendingSize > 0
+
+	if hadPending {
+		var pending io.ReadCloser
+
+		pending, err = handler.storage.GetObject(ctx, pendingKey)
+		if err != nil {
+			err = fmt.Errorf("tus: getting pending data: %w", err)
+			return
+		}
+		defer pending.Close()
+
+		data = io.MultiReader(io.LimitReader(pending, upload.PendingSize), data)
+	}
+
+	// uploadedSize is the size of the data already stored in parts
+	uploadedSize := upload.Offset - upload.PendingSize
+	upload.PendingSize = 0
+	buffer := make([]byte, handler.config.PartSize)
+
+	for readErr == nil {
+		var n int
+
+		n, readErr = io.ReadFull(data, buffer)
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			readErr = nil
+			if n == 0 {
+				break
+			}
+		} else if readErr != nil && n == 0 {
+			break
+		}
+
+		if int64(n) == handler.config.PartSize || uploadedSize+int64(n) == upload.Length {
+			var part storage.Part
+
+			part, err = handler.storage.UploadPart(ctx, upload.Key, upload.MultipartUploadID, int64(len(upload.Parts))+1,
+				int64(n), bytes.NewReader(buffer[:n]))
+			if err != nil {
+				err = fmt.Errorf("tus: uploading part: %w", err)
+				return
+			}
+			upload.Parts = append(upload.Parts, part)
+			uploadedSize += int64(n)
+		} else {
+			err = handler.storage.PutObject(ctx, pendingKey, defaultContentType, int64(n), bytes.NewReader(buffer[:n]))
+			if err != nil {
+				err = fmt.Errorf("tus: saving pending data: %w", err)
+				return
+			}
+			upload.PendingSize = int64(n)
+			break
+		}
+	}
+
+	upload.Offset = uploadedSize + upload.PendingSize
+	err = handler.saveUpload(ctx, *upload)
+	if err != nil {
+		return
+	}
+
+	if hadPending && upload.PendingSize == 0 {
+		// best effort: the pending object is ignored once PendingSize is 0
+		_ = handler.storage.DeleteObject(ctx, pendingKey)
+	}
+
+	return
+}
+
+func (handler *Handler) delete(w http.ResponseWriter, req *http.Request, uploadID string) {
+	ctx := req.Context()
+
+	upload, err := handler.loadUpload(ctx, uploadID)
+	if err != nil {
+		handler.handleError(ctx, w, err)
+		return
+	}
+
+	if !upload.Completed {
+		err = handler.storage.AbortMultipartUpload(ctx, upload.Key, upload.MultipartUploadID)
+		if err != nil && !errors.Is(err, storage.ErrUploadNotFound) {
+			handler.handleError(ctx, w, err)
+			return
+		}
+	}
+
+	if upload.PendingSize > 0 {
+		_ = handler.storage.DeleteObject(ctx, handler.pendingKey(upload.ID))
+	}
+
+	err = handler.storage.DeleteObject(ctx, handler.stateKey(upload.ID))
+	if err != nil {
+		handler.handleError(ctx, w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (handler *Handler) handleError(ctx context.Context, w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrUploadNotFound) || errors.Is(err, storage.ErrUploadNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	slogx.FromCtx(ctx).Error("tus: handling request", slogx.Err(err))
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+func (handler *Handler) stateKey(uploadID string) string {
+	return path.Join(handler.config.StatePrefix, uploadID+".json")
+}
+
+func (handler *Handler) pendingKey(uploadID string) string {
+	return path.Join(handler.config.StatePrefix, uploadID+".pending")
+}
+
+func (handler *Handler) loadUpload(ctx context.Context, uploadID string) (upload Upload, err error) {
+	// uploadID is used to build storage keys so we need to make sure that it's a valid GUID
+	_, err = guid.Parse(uploadID)
+	if err != nil {
+		err = ErrUploadNotFound
+		return
+	}
+
+	stateFile, err := handler.storage.GetObject(ctx, handler.stateKey(uploadID))
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			err = ErrUploadNotFound
+		} else {
+			err = fmt.Errorf("tus: getting upload state: %w", err)
+		}
+		return
+	}
+	defer stateFile.Close()
+
+	err = json.NewDecoder(stateFile).Decode(&upload)
+	if err != nil {
+		err = fmt.Errorf("tus: decoding upload state: %w", err)
+		return
+	}
+
+	return
+}
+
+func (handler *Handler) saveUpload(ctx context.Context, upload Upload) (err error) {
+	state, err := json.Marshal(upload)
+	if err != nil {
+		err = fmt.Errorf("tus: encoding upload state: %w", err)
+		return
+	}
+
+	err = handler.storage.PutObject(ctx, handler.stateKey(upload.ID), httpx.MediaTypeJson, int64(len(state)), bytes.NewReader(state))
+	if err != nil {
+		err = fmt.Errorf("tus: saving upload state: %w", err)
+		return
+	}
+
+	return
+}
+
+// ParseMetadata parses the value of an Upload-Metadata header: a comma-separated list of
+// key-value pairs where the key and the base64-encoded value are separated by a space.
+func ParseMetadata(header string) (metadata map[string]string, err error) {
+	metadata = map[string]string{}
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		var value []byte
+
+		parts := strings.Fields(pair)
+		switch len(parts) {
+		case 1:
+			metadata[parts[0]] = ""
+		case 2:
+			value, err = base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				err = ErrMetadataIsNotValid
+				return
+			}
+			metadata[parts[0]] = string(value)
+		default:
+			err = ErrMetadataIsNotValid
+			return
+		}
+	}
+
+	return
+}
+
+// EncodeMetadata encodes metadata to the format of the Upload-Metadata header
+func EncodeMetadata(metadata map[string]string) string {
+	pairs := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		if value == "" {
+			pairs = append(pairs, key)
+		} else {
+			pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(value)))
+		}
+	}
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,164 @@
+package tus_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/bloom42/stdx/crypto"
+	"github.com/bloom42/stdx/guid"
+	"github.com/bloom42/stdx/storage"
+	"github.com/bloom42/stdx/storage/filesystem"
+	"github.com/bloom42/stdx/storage/tus"
+)
+
+func TestUpload(t *testing.T) {
+	baseDirectory := t.TempDir()
+	fsStorage := filesystem.NewFilesystemStorage(filesystem.Config{BaseDirectory: baseDirectory})
+	var completedUpload *tus.Upload
+	handler := tus.NewHandler(fsStorage, tus.Config{
+		BasePath: "/files/",
+		OnUploadComplete: func(ctx context.Context, upload tus.Upload) {
+			completedUpload = &upload
+		},
+	})
+
+	data, err := crypto.RandBytes(uint64(2*storage.MinPartSize + 1234))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/files/", nil)
+	req.Header.Set(tus.HeaderTusResumable, tus.Version)
+	req.Header.Set(tus.HeaderUploadLength, strconv.Itoa(len(data)))
+	req.Header.Set(tus.HeaderUploadMetadata, tus.EncodeMetadata(map[string]string{"filename": "test.bin"}))
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("creating upload: expected status %d | got %d", http.StatusCreated, res.Code)
+	}
+	location := res.Header().Get(tus.HeaderLocation)
+
+	// chunks are not aligned with parts to test pending data
+	chunks := [][]byte{data[:3*1024*1024], data[3*1024*1024 : 9*1024*1024], data[9*1024*1024:]}
+	offset := 0
+	for _, chunk := range chunks {
+		req = httptest.NewRequest(http.MethodPatch, location, bytes.NewReader(chunk))
+		req.Header.Set(tus.HeaderTusResumable, tus.Version)
+		req.Header.Set("Content-Type", tus.MediaTypeOffsetOctetStream)
+		req.Header.Set(tus.HeaderUploadOffset, strconv.Itoa(offset))
+		res = httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		if res.Code != http.StatusNoContent {
+			t.Fatalf("patching upload: expected status %d | got %d", http.StatusNoContent, res.Code)
+		}
+		offset += len(chunk)
+
+		req = httptest.NewRequest(http.MethodHead, location, nil)
+		req.Header.Set(tus.HeaderTusResumable, tus.Version)
+		res = httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		if res.Header().Get(tus.HeaderUploadOffset) != strconv.Itoa(offset) {
+			t.Errorf("Upload-Offset. expected: %d | got: %s", offset, res.Header().Get(tus.HeaderUploadOffset))
+		}
+	}
+
+	if completedUpload == nil {
+		t.Fatal("OnUploadComplete was not called")
+	}
+	if completedUpload.Metadata["filename"] != "test.bin" {
+		t.Errorf("metadata. expected: test.bin | got: %s", completedUpload.Metadata["filename"])
+	}
+
+	object, err := os.ReadFile(filepath.Join(baseDirectory, completedUpload.Key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(object, data) {
+		t.Error("uploaded object doesn't match data")
+	}
+}
+
+func TestPatchWithWrongOffset(t *testing.T) {
+	fsStorage := filesystem.NewFilesystemStorage(filesystem.Config{BaseDirectory: t.TempDir()})
+	handler := tus.NewHandler(fsStorage, tus.Config{})
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set(tus.HeaderTusResumable, tus.Version)
+	req.Header.Set(tus.HeaderUploadLength, "10")
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	location := res.Header().Get(tus.HeaderLocation)
+
+	req = httptest.NewRequest(http.MethodPatch, location, bytes.NewReader(make([]byte, 10)))
+	req.Header.Set(tus.HeaderTusResumable, tus.Version)
+	req.Header.Set("Content-Type", tus.MediaTypeOffsetOctetStream)
+	req.Header.Set(tus.HeaderUploadOffset, "5")
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	if res.Code != http.StatusConflict {
+		t.Errorf("expected status %d | got %d", http.StatusConflict, res.Code)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	metadata := map[string]string{"filename": "hello world.txt", "is_confidential": ""}
+
+	parsed, err := tus.ParseMetadata(tus.EncodeMetadata(metadata))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key, value := range metadata {
+		if parsed[key] != value {
+			t.Errorf("metadata[%s]. expected: %s | got: %s", key, value, parsed[key])
+		}
+	}
+
+	_, err = tus.ParseMetadata("filename not_base64!")
+	if err == nil {
+		t.Error("expected error for invalid metadata")
+	}
+}
+
+// failingStorage is a storage which fails to return objects
+type failingStorage struct {
+	storage.MultipartStorage
+}
+
+func (failingStorage) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	return nil, errors.New("storage is unavailable")
+}
+
+func TestLoadUploadErrors(t *testing.T) {
+	fsStorage := filesystem.NewFilesystemStorage(filesystem.Config{BaseDirectory: t.TempDir()})
+	uploadID := guid.NewRandom().String()
+
+	// a missing upload is a 404, but other storage errors must not be hidden as missing uploads
+	tests := []struct {
+		storage        storage.MultipartStorage
+		expectedStatus int
+	}{
+		{storage: fsStorage, expectedStatus: http.StatusNotFound},
+		{storage: failingStorage{MultipartStorage: fsStorage}, expectedStatus: http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		handler := tus.NewHandler(test.storage, tus.Config{})
+
+		req := httptest.NewRequest(http.MethodHead, "/"+uploadID, nil)
+		req.Header.Set(tus.HeaderTusResumable, tus.Version)
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		if res.Code != test.expectedStatus {
+			t.Errorf("%T: expected status %d | got %d", test.storage, test.expectedStatus, res.Code)
+		}
+	}
+}
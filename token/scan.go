@@ -0,0 +1,28 @@
+package token
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const (
+	base32MinLength = (legacySize*8 + 4) / 5
+	base32MaxLength = (maxSize*8 + 4) / 5
+	// base32Charset is the character class of the base32 alphabet used to encode tokens
+	base32Charset = "[0-9a-hjkmnp-rt-z]"
+)
+
+// Pattern returns a regular expression matching the tokens with the given prefix, to detect leaked
+// tokens, e.g. in logs or by secret scanning tools. Use IsLikelyToken to filter out false positives.
+func Pattern(prefix string) *regexp.Regexp {
+	return regexp.MustCompile(fmt.Sprintf(`\b%s%s{%d,%d}\b`, regexp.QuoteMeta(prefix), base32Charset,
+		base32MinLength, base32MaxLength))
+}
+
+// IsLikelyToken returns true if input has the shape of a token with the given prefix.
+// For tokens with a checksum, the checksum must be valid, which makes false positives very unlikely.
+// Expired tokens are still reported as tokens.
+func IsLikelyToken(prefix, input string) bool {
+	_, err := parse(prefix, input)
+	return err == nil
+}
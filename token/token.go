@@ -1,9 +1,12 @@
 package token
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"strings"
+	"time"
 
 	"crypto/sha256"
 
@@ -12,14 +15,38 @@ import (
 	"github.com/bloom42/stdx/guid"
 )
 
+// Tokens created with New and NewWithID are encoded as prefix + base32(id || secret).
+//
+// Tokens created with NewWithOptions carry metadata after the secret:
+//
+//	id (16 bytes) || secret (32 bytes) || flags (1 byte) || [expires_at (8 bytes)] || [scope (1 byte)] || [checksum (4 bytes)]
+//
+// where flags indicates which of the optional fields are present, expires_at is a big endian Unix
+// timestamp, and checksum is the CRC32 (IEEE) of the prefix and of the preceding bytes, so typos and
+// random strings can be detected offline, without a database lookup.
+// The metadata is covered by the hash of the token, so it can't be tampered with.
+
 const (
 	SecretSize = crypto.KeySize256
 	HashSize   = crypto.KeySize256
 )
 
+const (
+	flagChecksum  byte = 1 << 0
+	flagExpiresAt byte = 1 << 1
+	flagScope     byte = 1 << 2
+	flagsAll           = flagChecksum | flagExpiresAt | flagScope
+
+	legacySize    = guid.Size + SecretSize
+	checksumSize  = 4
+	expiresAtSize = 8
+	maxSize       = legacySize + 1 + expiresAtSize + 1 + checksumSize
+)
+
 var (
 	ErrTokenIsNotValid = errors.New("token is not valid")
 	ErrDataIsTooLong   = errors.New("data is too long")
+	ErrTokenExpired    = errors.New("token has expired")
 )
 
 type Token struct {
@@ -28,6 +55,27 @@ type Token struct {
 	hash   []byte
 	str    string
 	prefix string
+	// metadata is nil for tokens created with New and NewWithID
+	metadata *metadata
+}
+
+type metadata struct {
+	flags     byte
+	expiresAt int64
+	scope     byte
+}
+
+// Options are the options of NewWithOptions
+type Options struct {
+	// ID of the token.
+	// default: a random GUID
+	ID guid.GUID
+	// Checksum appends a CRC32 checksum to the token, which is validated by Parse
+	Checksum bool
+	// ExpiresAt is embedded in the token if not zero. Parse returns ErrTokenExpired for expired tokens.
+	ExpiresAt time.Time
+	// Scope is embedded in the token if not nil
+	Scope *byte
 }
 
 func New(prefix string) (token Token, err error) {
@@ -36,7 +84,7 @@ func New(prefix string) (token Token, err error) {
 		return
 	}
 
-	return newToken(prefix, guid.NewRandom(), secret), nil
+	return newToken(prefix, guid.NewRandom(), secret, nil), nil
 }
 
 func NewWithID(prefix string, id guid.GUID) (token Token, err error) {
@@ -45,7 +93,35 @@ func NewWithID(prefix string, id guid.GUID) (token Token, err error) {
 		return
 	}
 
-	return newToken(prefix, id, secret), nil
+	return newToken(prefix, id, secret, nil), nil
+}
+
+// NewWithOptions creates a new token with an optional checksum, expiry and scope
+func NewWithOptions(prefix string, options Options) (token Token, err error) {
+	secret, err := newSecret()
+	if err != nil {
+		return
+	}
+
+	id := options.ID
+	if id.Equal(guid.GUID{}) {
+		id = guid.NewRandom()
+	}
+
+	tokenMetadata := &metadata{}
+	if options.Checksum {
+		tokenMetadata.flags |= flagChecksum
+	}
+	if !options.ExpiresAt.IsZero() {
+		tokenMetadata.flags |= flagExpiresAt
+		tokenMetadata.expiresAt = options.ExpiresAt.Unix()
+	}
+	if options.Scope != nil {
+		tokenMetadata.flags |= flagScope
+		tokenMetadata.scope = *options.Scope
+	}
+
+	return newToken(prefix, id, secret, tokenMetadata), nil
 }
 
 // func NewWithSecret(secret []byte) (token Token, err error) {
@@ -69,23 +145,33 @@ func newSecret() (secret []byte, err error) {
 	return
 }
 
-func newToken(prefix string, id guid.GUID, secret []byte) (token Token) {
-	idBytes, _ := id.MarshalBinary()
+func newToken(prefix string, id guid.GUID, secret []byte, tokenMetadata *metadata) (token Token) {
+	token = Token{
+		id:       id,
+		secret:   secret,
+		prefix:   prefix,
+		metadata: tokenMetadata,
+	}
+	token.encode()
+	return
+}
 
-	hash := generateHash(idBytes, secret)
+// encode computes the hash and the string representation of the token from its id, secret and metadata
+func (token *Token) encode() {
+	idBytes, _ := token.id.MarshalBinary()
+	metadataBytes := token.metadata.marshal()
 
-	data := append(idBytes, secret...)
-	str := base32.EncodeToString(data)
-	str = prefix + str
+	token.hash = generateHash(idBytes, token.secret, metadataBytes)
 
-	token = Token{
-		id,
-		secret,
-		hash,
-		str,
-		prefix,
+	data := make([]byte, 0, maxSize)
+	data = append(data, idBytes...)
+	data = append(data, token.secret...)
+	data = append(data, metadataBytes...)
+	if token.metadata != nil && token.metadata.flags&flagChecksum != 0 {
+		data = binary.BigEndian.AppendUint32(data, checksum(token.prefix, data))
 	}
-	return
+
+	token.str = token.prefix + base32.EncodeToString(data)
 }
 
 func (token *Token) String() string {
@@ -104,7 +190,46 @@ func (token *Token) Hash() []byte {
 	return token.hash
 }
 
+// HasChecksum returns true if the token has a checksum
+func (token *Token) HasChecksum() bool {
+	return token.metadata != nil && token.metadata.flags&flagChecksum != 0
+}
+
+// ExpiresAt returns the expiry embedded in the token, if any
+func (token *Token) ExpiresAt() (expiresAt time.Time, ok bool) {
+	if token.metadata == nil || token.metadata.flags&flagExpiresAt == 0 {
+		return
+	}
+
+	return time.Unix(token.metadata.expiresAt, 0).UTC(), true
+}
+
+// Scope returns the scope embedded in the token, if any
+func (token *Token) Scope() (scope byte, ok bool) {
+	if token.metadata == nil || token.metadata.flags&flagScope == 0 {
+		return
+	}
+
+	return token.metadata.scope, true
+}
+
+// Parse parses a token. If the token has a checksum, it is validated, and if the token has an embedded
+// expiry, ErrTokenExpired is returned if the token has expired.
 func Parse(prefix, input string) (token Token, err error) {
+	token, err = parse(prefix, input)
+	if err != nil {
+		return
+	}
+
+	if expiresAt, hasExpiry := token.ExpiresAt(); hasExpiry && !time.Now().Before(expiresAt) {
+		err = ErrTokenExpired
+		return
+	}
+
+	return
+}
+
+func parse(prefix, input string) (token Token, err error) {
 	var tokenBytes []byte
 
 	token.str = input
@@ -118,19 +243,24 @@ func Parse(prefix, input string) (token Token, err error) {
 		token.prefix = prefix
 	}
 
+	if len(input) > base32MaxLength {
+		err = ErrTokenIsNotValid
+		return
+	}
+
 	tokenBytes, err = base32.DecodeString(input)
 	if err != nil {
 		err = ErrTokenIsNotValid
 		return
 	}
 
-	if len(tokenBytes) != guid.Size+SecretSize {
+	if len(tokenBytes) < legacySize {
 		err = ErrTokenIsNotValid
 		return
 	}
 
 	tokenIDBytes := tokenBytes[:guid.Size]
-	token.secret = tokenBytes[guid.Size:]
+	token.secret = tokenBytes[guid.Size:legacySize]
 
 	token.id, err = guid.FromBytes(tokenIDBytes)
 	if err != nil {
@@ -138,8 +268,78 @@ func Parse(prefix, input string) (token Token, err error) {
 		return
 	}
 
-	token.hash = generateHash(tokenIDBytes, token.secret)
+	if len(tokenBytes) > legacySize {
+		token.metadata, err = parseMetadata(prefix, tokenBytes)
+		if err != nil {
+			return
+		}
+	}
+
+	token.hash = generateHash(tokenIDBytes, token.secret, token.metadata.marshal())
+
+	return
+}
+
+// parseMetadata parses the metadata of tokenBytes and verifies its checksum, if any
+func parseMetadata(prefix string, tokenBytes []byte) (tokenMetadata *metadata, err error) {
+	tokenMetadata = &metadata{flags: tokenBytes[legacySize]}
+	if tokenMetadata.flags&^flagsAll != 0 {
+		err = ErrTokenIsNotValid
+		return
+	}
+
+	if tokenMetadata.flags&flagChecksum != 0 {
+		if len(tokenBytes) < legacySize+1+checksumSize {
+			err = ErrTokenIsNotValid
+			return
+		}
+
+		checksumOffset := len(tokenBytes) - checksumSize
+		if binary.BigEndian.Uint32(tokenBytes[checksumOffset:]) != checksum(prefix, tokenBytes[:checksumOffset]) {
+			err = ErrTokenIsNotValid
+			return
+		}
+		tokenBytes = tokenBytes[:checksumOffset]
+	}
+
+	fields := tokenBytes[legacySize+1:]
+	if tokenMetadata.flags&flagExpiresAt != 0 {
+		if len(fields) < expiresAtSize {
+			err = ErrTokenIsNotValid
+			return
+		}
+		tokenMetadata.expiresAt = int64(binary.BigEndian.Uint64(fields[:expiresAtSize]))
+		fields = fields[expiresAtSize:]
+	}
+	if tokenMetadata.flags&flagScope != 0 {
+		if len(fields) < 1 {
+			err = ErrTokenIsNotValid
+			return
+		}
+		tokenMetadata.scope = fields[0]
+		fields = fields[1:]
+	}
+
+	if len(fields) != 0 {
+		err = ErrTokenIsNotValid
+		return
+	}
+
+	return
+}
+
+func (tokenMetadata *metadata) marshal() (data []byte) {
+	if tokenMetadata == nil {
+		return nil
+	}
 
+	data = append(data, tokenMetadata.flags)
+	if tokenMetadata.flags&flagExpiresAt != 0 {
+		data = binary.BigEndian.AppendUint64(data, uint64(tokenMetadata.expiresAt))
+	}
+	if tokenMetadata.flags&flagScope != 0 {
+		data = append(data, tokenMetadata.scope)
+	}
 	return
 }
 
@@ -176,26 +376,31 @@ func (token *Token) Verify(hash []byte) (err error) {
 	return
 }
 
+// Refresh generates a new secret for the token. The metadata of the token, if any, is kept.
 func (token *Token) Refresh() (err error) {
-	idBytes, _ := token.id.MarshalBinary()
 	token.secret, err = newSecret()
 	if err != nil {
 		return
 	}
 
-	token.hash = generateHash(idBytes, token.secret)
-
-	data := append(idBytes, token.secret...)
-	str := base32.EncodeToString(data)
-	token.str = token.prefix + str
-
+	token.encode()
 	return
 }
 
-func generateHash(tokenID, secret []byte) (hash []byte) {
+// generateHash returns SHA-256(id || secret || metadata). metadata is empty for tokens created with
+// New and NewWithID, so their hash is unchanged.
+func generateHash(tokenID, secret, metadata []byte) (hash []byte) {
 	hasher := sha256.New()
 	hasher.Write(tokenID)
 	hasher.Write(secret)
+	hasher.Write(metadata)
 	hash = hasher.Sum(nil)
 	return
 }
+
+func checksum(prefix string, data []byte) uint32 {
+	hasher := crc32.NewIEEE()
+	hasher.Write([]byte(prefix))
+	hasher.Write(data)
+	return hasher.Sum32()
+}
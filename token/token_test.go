@@ -3,8 +3,11 @@ package token_test
 import (
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/bloom42/stdx/base32"
 	"github.com/bloom42/stdx/crypto"
+	"github.com/bloom42/stdx/guid"
 	"github.com/bloom42/stdx/token"
 	"github.com/bloom42/stdx/uuid"
 )
@@ -89,3 +92,156 @@ func TestVerify(t *testing.T) {
 		t.Errorf("verifying token against empty slice.  expected: %v | got: %v", token.ErrTokenIsNotValid, err)
 	}
 }
+
+func TestNewWithOptions(t *testing.T) {
+	prefix := "test_"
+	scope := byte(7)
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	newToken, err := token.NewWithOptions(prefix, token.Options{
+		Checksum:  true,
+		ExpiresAt: expiresAt,
+		Scope:     &scope,
+	})
+	if err != nil {
+		t.Fatalf("Generating token: %v", err)
+	}
+
+	parsedToken, err := token.Parse(prefix, newToken.String())
+	if err != nil {
+		t.Fatalf("parsing token: %v", err)
+	}
+
+	if err = parsedToken.Verify(newToken.Hash()); err != nil {
+		t.Errorf("verifying token. expected: nil | got: %v", err)
+	}
+	if !parsedToken.ID().Equal(newToken.ID()) {
+		t.Errorf("token.ID. expected: %s | got: %s", newToken.ID(), parsedToken.ID())
+	}
+	if !parsedToken.HasChecksum() {
+		t.Error("token should have a checksum")
+	}
+	if parsedExpiresAt, ok := parsedToken.ExpiresAt(); !ok || !parsedExpiresAt.Equal(expiresAt) {
+		t.Errorf("token.ExpiresAt. expected: %v | got: %v (%v)", expiresAt, parsedExpiresAt, ok)
+	}
+	if parsedScope, ok := parsedToken.Scope(); !ok || parsedScope != scope {
+		t.Errorf("token.Scope. expected: %d | got: %d (%v)", scope, parsedScope, ok)
+	}
+
+	err = parsedToken.Refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshedScope, _ := parsedToken.Scope(); refreshedScope != scope || !parsedToken.HasChecksum() {
+		t.Error("refreshing the token should keep its metadata")
+	}
+
+	legacyToken, err := token.New(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := legacyToken.Scope(); ok || legacyToken.HasChecksum() {
+		t.Error("tokens created with New should not have metadata")
+	}
+}
+
+func TestParseChecksum(t *testing.T) {
+	prefix := "test_"
+	newToken, err := token.NewWithOptions(prefix, token.Options{Checksum: true})
+	if err != nil {
+		t.Fatalf("Generating token: %v", err)
+	}
+	tokenStr := newToken.String()
+
+	// typo
+	lastChar := tokenStr[len(tokenStr)-10]
+	typoChar := byte('a')
+	if lastChar == 'a' {
+		typoChar = 'b'
+	}
+	typo := tokenStr[:len(tokenStr)-10] + string(typoChar) + tokenStr[len(tokenStr)-9:]
+	if _, err = token.Parse(prefix, typo); err != token.ErrTokenIsNotValid {
+		t.Errorf("parsing token with a typo. expected: %v | got: %v", token.ErrTokenIsNotValid, err)
+	}
+
+	// the checksum covers the prefix
+	if _, err = token.Parse("other_", "other_"+strings.TrimPrefix(tokenStr, prefix)); err != token.ErrTokenIsNotValid {
+		t.Errorf("parsing token with another prefix. expected: %v | got: %v", token.ErrTokenIsNotValid, err)
+	}
+}
+
+func TestParseExpired(t *testing.T) {
+	newToken, err := token.NewWithOptions("", token.Options{ExpiresAt: time.Now().Add(-time.Minute)})
+	if err != nil {
+		t.Fatalf("Generating token: %v", err)
+	}
+
+	if _, err = token.Parse("", newToken.String()); err != token.ErrTokenExpired {
+		t.Errorf("parsing expired token. expected: %v | got: %v", token.ErrTokenExpired, err)
+	}
+}
+
+func TestMetadataIsAuthenticated(t *testing.T) {
+	scope := byte(1)
+	newToken, err := token.NewWithOptions("", token.Options{Scope: &scope})
+	if err != nil {
+		t.Fatalf("Generating token: %v", err)
+	}
+
+	// same id and secret, but another scope
+	otherScope := byte(2)
+	tokenBytes, err := base32.DecodeString(newToken.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenBytes[len(tokenBytes)-1] = otherScope
+
+	tamperedToken, err := token.Parse("", base32.EncodeToString(tokenBytes))
+	if err != nil {
+		t.Fatalf("parsing token: %v", err)
+	}
+	if err = tamperedToken.Verify(newToken.Hash()); err != token.ErrTokenIsNotValid {
+		t.Errorf("verifying tampered token. expected: %v | got: %v", token.ErrTokenIsNotValid, err)
+	}
+
+	// stripping the metadata
+	strippedToken, err := token.Parse("", base32.EncodeToString(tokenBytes[:guid.Size+token.SecretSize]))
+	if err != nil {
+		t.Fatalf("parsing token: %v", err)
+	}
+	if err = strippedToken.Verify(newToken.Hash()); err != token.ErrTokenIsNotValid {
+		t.Errorf("verifying stripped token. expected: %v | got: %v", token.ErrTokenIsNotValid, err)
+	}
+}
+
+func TestIsLikelyToken(t *testing.T) {
+	prefix := "test_"
+	checksumToken, err := token.NewWithOptions(prefix, token.Options{Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyToken, err := token.New(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logLine := "GET /api?token=" + checksumToken.String() + " 200 - Authorization: Bearer " + legacyToken.String()
+	matches := token.Pattern(prefix).FindAllString(logLine, -1)
+	if len(matches) != 2 || matches[0] != checksumToken.String() || matches[1] != legacyToken.String() {
+		t.Errorf("token.Pattern matches. expected: 2 tokens | got: %v", matches)
+	}
+
+	for _, match := range matches {
+		if !token.IsLikelyToken(prefix, match) {
+			t.Errorf("IsLikelyToken(%s). expected: true | got: false", match)
+		}
+	}
+
+	randomString := prefix + strings.Repeat("0", len(checksumToken.String())-len(prefix))
+	if token.IsLikelyToken(prefix, randomString) {
+		t.Errorf("IsLikelyToken(%s). expected: false | got: true", randomString)
+	}
+	if token.IsLikelyToken(prefix, "hello world") {
+		t.Error("IsLikelyToken(hello world). expected: false | got: true")
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/bloom42/stdx/cobra"
+	"github.com/bloom42/stdx/crypto"
+	"github.com/bloom42/stdx/crypto/age"
+	"golang.org/x/term"
+)
+
+const (
+	version = "1.0.0"
+)
+
+var (
+	keygenOutput string
+
+	encryptRecipients     []string
+	encryptRecipientFiles []string
+	encryptPassphrase     bool
+	encryptArmor          bool
+	encryptOutput         string
+
+	decryptIdentityFiles []string
+	decryptOutput        string
+)
+
+func init() {
+	keygenCmd.Flags().StringVarP(&keygenOutput, "output", "o", "", "Write the identity to this file instead of stdout")
+	rootCmd.AddCommand(keygenCmd)
+
+	encryptCmd.Flags().StringArrayVarP(&encryptRecipients, "recipient", "r", nil, "Encrypt to this recipient (age1...). Can be repeated")
+	encryptCmd.Flags().StringArrayVarP(&encryptRecipientFiles, "recipients-file", "R", nil, "Encrypt to the recipients listed in this file. Can be repeated")
+	encryptCmd.Flags().BoolVarP(&encryptPassphrase, "passphrase", "p", false, "Encrypt with a passphrase")
+	encryptCmd.Flags().BoolVarP(&encryptArmor, "armor", "a", false, "Write the armored (PEM-like) format")
+	encryptCmd.Flags().StringVarP(&encryptOutput, "output", "o", "", "Write the encrypted file to this file instead of stdout")
+	rootCmd.AddCommand(encryptCmd)
+
+	decryptCmd.Flags().StringArrayVarP(&decryptIdentityFiles, "identity", "i", nil, "Decrypt with the identities listed in this file. Can be repeated. If not set, a passphrase is asked")
+	decryptCmd.Flags().StringVarP(&decryptOutput, "output", "o", "", "Write the decrypted file to this file instead of stdout")
+	rootCmd.AddCommand(decryptCmd)
+}
+
+func main() {
+	err := rootCmd.Execute()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+var rootCmd = &cobra.Command{
+	Use:           "age",
+	Short:         "Encrypt and decrypt files with the age v1 format, compatible with age and rage",
+	Version:       version,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		return cmd.Help()
+	},
+}
+
+var keygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "Generate a new X25519 identity",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		identity, err := age.GenerateX25519Identity()
+		if err != nil {
+			return
+		}
+
+		publicKey := identity.Recipient().String()
+		identityFile := fmt.Sprintf("# created: %s\n# public key: %s\n%s\n",
+			time.Now().UTC().Format(time.RFC3339), publicKey, identity.String())
+
+		if keygenOutput == "" {
+			fmt.Print(identityFile)
+			return
+		}
+
+		err = os.WriteFile(keygenOutput, []byte(identityFile), 0600)
+		if err != nil {
+			err = fmt.Errorf("age: writing identity file: %w", err)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "Public key: %s\n", publicKey)
+
+		return
+	},
+}
+
+var encryptCmd = &cobra.Command{
+	Use:   "encrypt [input]",
+	Short: "Encrypt the input file (default: stdin) to the given recipients or with a passphrase",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		recipients := make([]age.Recipient, 0, len(encryptRecipients))
+
+		for _, encodedRecipient := range encryptRecipients {
+			var recipient *age.X25519Recipient
+			recipient, err = age.ParseX25519Recipient(encodedRecipient)
+			if err != nil {
+				err = fmt.Errorf("age: %s: %w", encodedRecipient, err)
+				return
+			}
+			recipients = append(recipients, recipient)
+		}
+
+		for _, recipientsFile := range encryptRecipientFiles {
+			var fileRecipients []age.Recipient
+			fileRecipients, err = parseKeyFile(recipientsFile, age.ParseRecipients)
+			if err != nil {
+				return
+			}
+			recipients = append(recipients, fileRecipients...)
+		}
+
+		if encryptPassphrase {
+			if len(recipients) != 0 {
+				err = errors.New("age: --passphrase can't be used with recipients")
+				return
+			}
+
+			var passphrase []byte
+			passphrase, err = readPassphrase(true)
+			if err != nil {
+				return
+			}
+			defer crypto.Zeroize(passphrase)
+
+			var recipient *age.ScryptRecipient
+			recipient, err = age.NewScryptRecipient(passphrase)
+			if err != nil {
+				return
+			}
+			recipients = append(recipients, recipient)
+		}
+
+		if len(recipients) == 0 {
+			err = errors.New("age: at least one recipient (-r, -R) or a passphrase (-p) is required")
+			return
+		}
+
+		input, err := openInput(args)
+		if err != nil {
+			return
+		}
+		defer input.Close()
+
+		if encryptOutput == "" && !encryptArmor && term.IsTerminal(int(os.Stdout.Fd())) {
+			err = errors.New("age: refusing to write binary data to a terminal. Use -o or -a")
+			return
+		}
+
+		output, err := createOutput(encryptOutput)
+		if err != nil {
+			return
+		}
+
+		var dst io.Writer = output
+		var armorWriter io.WriteCloser
+		if encryptArmor {
+			armorWriter = age.NewArmorWriter(output)
+			dst = armorWriter
+		}
+
+		writer, err := age.Encrypt(dst, recipients...)
+		if err != nil {
+			output.Close()
+			return
+		}
+
+		_, err = io.Copy(writer, input)
+		if err == nil {
+			err = writer.Close()
+		}
+		if err == nil && armorWriter != nil {
+			err = armorWriter.Close()
+		}
+		closeErr := output.Close()
+		if err != nil {
+			err = fmt.Errorf("age: encrypting: %w", err)
+			return
+		}
+		if closeErr != nil {
+			err = fmt.Errorf("age: writing output: %w", closeErr)
+			return
+		}
+
+		return
+	},
+}
+
+var decryptCmd = &cobra.Command{
+	Use:   "decrypt [input]",
+	Short: "Decrypt the input file (default: stdin) with the given identities or with a passphrase",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		identities := make([]age.Identity, 0, len(decryptIdentityFiles))
+
+		for _, identityFile := range decryptIdentityFiles {
+			var fileIdentities []age.Identity
+			fileIdentities, err = parseKeyFile(identityFile, age.ParseIdentities)
+			if err != nil {
+				return
+			}
+			identities = append(identities, fileIdentities...)
+		}
+
+		input, err := openInput(args)
+		if err != nil {
+			return
+		}
+		defer input.Close()
+
+		var src io.Reader = bufio.NewReader(input)
+		start, _ := src.(*bufio.Reader).Peek(len("-----BEGIN"))
+		if bytes.Equal(start, []byte("-----BEGIN")) {
+			src = age.NewArmorReader(src)
+		}
+
+		if len(identities) == 0 {
+			var passphrase []byte
+			passphrase, err = readPassphrase(false)
+			if err != nil {
+				return
+			}
+			defer crypto.Zeroize(passphrase)
+
+			var identity *age.ScryptIdentity
+			identity, err = age.NewScryptIdentity(passphrase)
+			if err != nil {
+				return
+			}
+			identities = append(identities, identity)
+		}
+
+		reader, err := age.Decrypt(src, identities...)
+		if err != nil {
+			return
+		}
+
+		output, err := createOutput(decryptOutput)
+		if err != nil {
+			return
+		}
+
+		_, err = io.Copy(output, reader)
+		closeErr := output.Close()
+		if err != nil {
+			err = fmt.Errorf("age: decrypting: %w", err)
+			if decryptOutput != "" {
+				// don't leave a partially decrypted file behind
+				os.Remove(decryptOutput)
+			}
+			return
+		}
+		if closeErr != nil {
+			err = fmt.Errorf("age: writing output: %w", closeErr)
+			return
+		}
+
+		return
+	},
+}
+
+func parseKeyFile[T any](path string, parse func(io.Reader) ([]T, error)) (keys []T, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		err = fmt.Errorf("age: opening %s: %w", path, err)
+		return
+	}
+	defer file.Close()
+
+	keys, err = parse(file)
+	if err != nil {
+		err = fmt.Errorf("age: %s: %w", path, err)
+		return
+	}
+	return
+}
+
+func openInput(args []string) (input io.ReadCloser, err error) {
+	if len(args) == 0 || args[0] == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+
+	input, err = os.Open(args[0])
+	if err != nil {
+		err = fmt.Errorf("age: opening input: %w", err)
+		return
+	}
+	return
+}
+
+func createOutput(path string) (output io.WriteCloser, err error) {
+	if path == "" || path == "-" {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+
+	output, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		err = fmt.Errorf("age: creating output: %w", err)
+		return
+	}
+	return
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// readPassphrase reads a passphrase from the terminal, even if stdin is used for the input file
+func readPassphrase(confirm bool) (passphrase []byte, err error) {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		tty = os.Stdin
+	} else {
+		defer tty.Close()
+	}
+
+	fmt.Fprint(os.Stderr, "passphrase: ")
+	passphrase, err = term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(os.Stderr, "")
+	if err != nil {
+		err = fmt.Errorf("age: reading passphrase: %w", err)
+		return
+	}
+	if len(passphrase) == 0 {
+		err = errors.New("age: passphrase is empty")
+		return
+	}
+
+	if confirm {
+		fmt.Fprint(os.Stderr, "confirm passphrase: ")
+		var confirmation []byte
+		confirmation, err = term.ReadPassword(int(tty.Fd()))
+		fmt.Fprintln(os.Stderr, "")
+		if err != nil {
+			err = fmt.Errorf("age: reading passphrase: %w", err)
+			return
+		}
+		defer crypto.Zeroize(confirmation)
+
+		if !crypto.ConstantTimeCompare(passphrase, confirmation) {
+			crypto.Zeroize(passphrase)
+			passphrase = nil
+			err = errors.New("age: passphrases don't match")
+			return
+		}
+	}
+
+	return
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bloom42/stdx/cobra"
+	"github.com/bloom42/stdx/crypto"
+)
+
+const (
+	version = "1.0.0"
+)
+
+var (
+	splitShares       int
+	splitThreshold    int
+	splitTrimNewlines bool
+)
+
+func init() {
+	splitCmd.Flags().IntVarP(&splitShares, "shares", "n", 5, "Number of shares to generate (max: 255)")
+	splitCmd.Flags().IntVarP(&splitThreshold, "threshold", "k", 3, "Number of shares needed to recover the secret")
+	splitCmd.Flags().BoolVar(&splitTrimNewlines, "trim-newlines", false, "Remove the trailing newlines of the secret, e.g. when it's typed in a terminal. Don't use it with binary secrets")
+	rootCmd.AddCommand(splitCmd)
+
+	rootCmd.AddCommand(combineCmd)
+}
+
+func main() {
+	err := rootCmd.Execute()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+var rootCmd = &cobra.Command{
+	Use:           "shamir",
+	Short:         "Split secrets into shares and recombine them (Shamir's secret sharing)",
+	Version:       version,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		return cmd.Help()
+	},
+}
+
+var splitCmd = &cobra.Command{
+	Use:   "split",
+	Short: "Split the secret read from stdin into shares, printed one per line. The secret is read as is, so it can be binary",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		secret, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			err = fmt.Errorf("shamir: reading secret: %w", err)
+			return
+		}
+		defer crypto.Zeroize(secret)
+
+		if splitTrimNewlines {
+			secret = bytes.TrimRight(secret, "\r\n")
+		}
+		if len(secret) == 0 {
+			err = errors.New("shamir: secret is empty")
+			return
+		}
+
+		shares, err := crypto.SplitSecret(secret, splitShares, splitThreshold)
+		if err != nil {
+			return
+		}
+
+		for _, share := range shares {
+			fmt.Println(share.String())
+		}
+
+		return
+	},
+}
+
+var combineCmd = &cobra.Command{
+	Use:   "combine",
+	Short: "Recover a secret from the shares read from stdin, one per line. The secret is written as is to stdout",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		shares := make([]crypto.SecretShare, 0)
+
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+
+			var share crypto.SecretShare
+			share, err = crypto.ParseSecretShare(line)
+			if err != nil {
+				err = fmt.Errorf("shamir: share #%d: %w", len(shares)+1, err)
+				return
+			}
+			shares = append(shares, share)
+		}
+		err = scanner.Err()
+		if err != nil {
+			err = fmt.Errorf("shamir: reading shares: %w", err)
+			return
+		}
+
+		secret, err := crypto.CombineShares(shares)
+		if err != nil {
+			return
+		}
+		defer crypto.Zeroize(secret)
+
+		_, err = os.Stdout.Write(secret)
+		return
+	},
+}
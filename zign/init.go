@@ -2,6 +2,7 @@ package zign
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
 
 	"github.com/bloom42/stdx/crypto"
@@ -41,3 +42,44 @@ func Init(password []byte) (encryptedAndEncodedPrivateKey string, encodedPublicK
 
 	return
 }
+
+// DecryptPrivateKey decrypts a private key returned by Init.
+// The private key can then be exported to standard formats with crypto.Ed25519PrivateKey.MarshalEncryptedPEM
+// or crypto.Ed25519PrivateKey.MarshalOpenSSH.
+func DecryptPrivateKey(encryptedBase64PrivateKey string, password string) (privateKey crypto.Ed25519PrivateKey, err error) {
+	privateKeyAndSalt, err := base64.StdEncoding.DecodeString(encryptedBase64PrivateKey)
+	if err != nil {
+		err = fmt.Errorf("zign.DecryptPrivateKey: decoding encrypted private key: %w", err)
+		return
+	}
+
+	privateKeyAndSaltLen := len(privateKeyAndSalt)
+	if privateKeyAndSaltLen < SaltSize+crypto.Ed25519PrivateKeySize {
+		err = errors.New("zign.DecryptPrivateKey: private key is not valid")
+		return
+	}
+
+	encryptedPrivateKey := privateKeyAndSalt[:len(privateKeyAndSalt)-SaltSize]
+	salt := privateKeyAndSalt[len(encryptedPrivateKey):]
+
+	encryptionKey, err := crypto.DeriveKeyFromPassword([]byte(password), salt, crypto.DefaultDeriveKeyFromPasswordParams)
+	if err != nil {
+		err = fmt.Errorf("zign.DecryptPrivateKey: deriving encryption key from password: %w", err)
+		return
+	}
+
+	privateKeyBytes, err := crypto.Decrypt(encryptionKey, encryptedPrivateKey, salt)
+	if err != nil {
+		err = fmt.Errorf("zign.DecryptPrivateKey: decrypting private key: %w", err)
+		return
+	}
+
+	privateKey, err = crypto.NewEd25519PrivateKeyFromBytes(privateKeyBytes)
+	if err != nil {
+		crypto.Zeroize(privateKeyBytes)
+		err = fmt.Errorf("zign.DecryptPrivateKey: parsing private key: %w", err)
+		return
+	}
+
+	return
+}
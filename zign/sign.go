@@ -2,10 +2,8 @@ package zign
 
 import (
 	"bytes"
-	"encoding/base64"
 	"encoding/binary"
 	"encoding/hex"
-	"errors"
 	"fmt"
 	"io"
 
@@ -37,37 +35,8 @@ func Sign(encryptedBase64PrivateKey string, password string, input SignInput) (o
 func SignMany(encryptedBase64PrivateKey string, password string, input []SignInput) (output []SignOutput, err error) {
 	output = make([]SignOutput, len(input))
 
-	privateKeyAndSalt, err := base64.StdEncoding.DecodeString(encryptedBase64PrivateKey)
+	privateKey, err := DecryptPrivateKey(encryptedBase64PrivateKey, password)
 	if err != nil {
-		err = fmt.Errorf("zign.Sign: decoding encrypted private key: %w", err)
-		return
-	}
-
-	privateKeyAndSaltLen := len(privateKeyAndSalt)
-	if privateKeyAndSaltLen < SaltSize+crypto.Ed25519PrivateKeySize {
-		err = errors.New("zign.Sign: private key is not valid")
-		return
-	}
-
-	encryptedPrivateKey := privateKeyAndSalt[:len(privateKeyAndSalt)-SaltSize]
-	salt := privateKeyAndSalt[len(encryptedPrivateKey):]
-
-	encryptionKey, err := crypto.DeriveKeyFromPassword([]byte(password), salt, crypto.DefaultDeriveKeyFromPasswordParams)
-	if err != nil {
-		err = fmt.Errorf("zign.Sign: deriving encryption key from password: %w", err)
-		return
-	}
-
-	privateKeyBytes, err := crypto.Decrypt(encryptionKey, encryptedPrivateKey, salt)
-	if err != nil {
-		err = fmt.Errorf("zign.Sign: decrypting private key: %w", err)
-		return
-	}
-	defer crypto.Zeroize(privateKeyBytes)
-
-	privateKey, err := crypto.NewEd25519PrivateKeyFromBytes(privateKeyBytes)
-	if err != nil {
-		err = fmt.Errorf("zign.Sign: parsing private key: %w", err)
 		return
 	}
 	defer crypto.Zeroize(privateKey)